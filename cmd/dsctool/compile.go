@@ -0,0 +1,75 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/compile"
+	dscconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	localconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/local"
+)
+
+// publisher is implemented by the repositories that configurations can be
+// published to.
+type publisher interface {
+	PublishConfiguration(ctx context.Context, name string, content []byte) error
+}
+
+func compileConfigs(args []string) error {
+	fs := newFlagSet("compile")
+	dir := fs.String("dir", "", "directory of a local ConfigurationRepository to write to")
+	bucket := fs.String("bucket", "", "S3 bucket of a ConfigurationRepository to upload to")
+	msLayout := fs.Bool("microsoft-layout", false, "the repository uses the layout of the Microsoft pull server")
+	fs.Parse(args)
+
+	if (*dir == "") == (*bucket == "") || fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var opts []dscconfig.Option
+	if *msLayout {
+		opts = append(opts, dscconfig.WithLayout(dscconfig.MicrosoftLayout))
+	}
+
+	var repo publisher
+	if *dir != "" {
+		repo = localconfig.New(*dir, opts...)
+	} else {
+		s3repo, err := newS3Repository(*bucket, opts...)
+		if err != nil {
+			return err
+		}
+		repo = s3repo
+	}
+
+	ctx := context.Background()
+	for _, path := range fs.Args() {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		c, err := compile.Parse(data)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+		outputs, err := compile.Compile(c)
+		if err != nil {
+			return fmt.Errorf("%s: %s", path, err)
+		}
+
+		for _, out := range outputs {
+			content, err := out.Marshal()
+			if err != nil {
+				return fmt.Errorf("%s: %s", path, err)
+			}
+			if err := repo.PublishConfiguration(ctx, out.Node, content); err != nil {
+				return err
+			}
+			fmt.Printf("%s: published %s\n", path, out.Node)
+		}
+	}
+	return nil
+}
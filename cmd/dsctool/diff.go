@@ -0,0 +1,104 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	dscconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	localconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/local"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/diff"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+func diffConfigs(args []string) error {
+	fs := newFlagSet("diff")
+	dir := fs.String("dir", "", "directory of a local ConfigurationRepository to read OLD from")
+	bucket := fs.String("bucket", "", "S3 bucket of a ConfigurationRepository to read OLD from")
+	msLayout := fs.Bool("microsoft-layout", false, "the repository uses the layout of the Microsoft pull server")
+	ignore := fs.String("ignore", strings.Join(diff.DefaultIgnore, ","), "comma-separated properties to ignore")
+	fs.Parse(args)
+
+	if *dir != "" && *bucket != "" || fs.NArg() != 2 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var opts []dscconfig.Option
+	if *msLayout {
+		opts = append(opts, dscconfig.WithLayout(dscconfig.MicrosoftLayout))
+	}
+
+	// With a repository, OLD is the name of a published configuration.
+	var repo dsc.ConfigurationRepository
+	if *dir != "" {
+		repo = localconfig.New(*dir, opts...)
+	} else if *bucket != "" {
+		s3repo, err := newS3Repository(*bucket, opts...)
+		if err != nil {
+			return err
+		}
+		repo = s3repo
+	}
+
+	var from *mof.Document
+	var err error
+	if repo != nil {
+		from, err = readPublished(repo, fs.Arg(0))
+	} else {
+		from, err = readMOF(fs.Arg(0))
+	}
+	if err != nil {
+		return err
+	}
+	to, err := readMOF(fs.Arg(1))
+	if err != nil {
+		return err
+	}
+
+	var names []string
+	for _, name := range strings.Split(*ignore, ",") {
+		if name = strings.TrimSpace(name); name != "" {
+			names = append(names, name)
+		}
+	}
+
+	result := diff.Diff(from, to, diff.WithIgnore(names...))
+	fmt.Print(result)
+	fmt.Println(result.Summary())
+	return nil
+}
+
+func readMOF(path string) (*mof.Document, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	doc, err := mof.ParseReader(f)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", path, err)
+	}
+	return doc, nil
+}
+
+func readPublished(repo dsc.ConfigurationRepository, name string) (*mof.Document, error) {
+	resp, err := repo.GetConfiguration(context.Background(), types.GetConfigurationRequest{ConfigurationName: name})
+	if err != nil {
+		return nil, err
+	}
+	if cl, ok := resp.Content.(io.Closer); ok {
+		defer cl.Close()
+	}
+
+	doc, err := mof.ParseReader(resp.Content)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %s", name, err)
+	}
+	return doc, nil
+}
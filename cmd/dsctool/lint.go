@@ -0,0 +1,64 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	dscconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	localconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/local"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/lint"
+)
+
+func lintConfigs(args []string) error {
+	fs := newFlagSet("lint")
+	dir := fs.String("dir", "", "directory of a local ConfigurationRepository")
+	bucket := fs.String("bucket", "", "S3 bucket of the ConfigurationRepository")
+	msLayout := fs.Bool("microsoft-layout", false, "the repository uses the layout of the Microsoft pull server")
+	fs.Parse(args)
+
+	if (*dir == "") == (*bucket == "") {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	var opts []dscconfig.Option
+	if *msLayout {
+		opts = append(opts, dscconfig.WithLayout(dscconfig.MicrosoftLayout))
+	}
+
+	var repo dsc.ConfigurationRepository
+	if *dir != "" {
+		repo = localconfig.New(*dir, opts...)
+	} else {
+		s3repo, err := newS3Repository(*bucket, opts...)
+		if err != nil {
+			return err
+		}
+		repo = s3repo
+	}
+
+	results, err := lint.Lint(context.Background(), repo, fs.Args()...)
+	if err != nil {
+		return err
+	}
+
+	failed := false
+	for _, result := range results {
+		if result.OK() {
+			fmt.Printf("%s: ok (%d modules)\n", result.Configuration, len(result.Modules))
+			continue
+		}
+		failed = true
+		for _, problem := range result.Problems {
+			fmt.Printf("%s: %s\n", result.Configuration, problem)
+		}
+	}
+
+	if failed {
+		return errors.New("some configurations have problems")
+	}
+	return nil
+}
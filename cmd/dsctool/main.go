@@ -0,0 +1,105 @@
+// Command dsctool contains tools for managing the contents of a DSC pull
+// server's ConfigurationRepository.
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+)
+
+type command struct {
+	Usage string
+	Help  string
+	Run   func(args []string) error
+}
+
+// Populated in init, since commands refer to the table for their usage.
+var commands map[string]command
+
+func init() {
+	commands = map[string]command{
+		"validate-module": {
+			"[-name NAME] [-version VERSION] ZIP...",
+			"check that module zips are valid modules",
+			validateModule,
+		},
+		"publish-module": {
+			"-bucket BUCKET [-name NAME] [-version VERSION] ZIP",
+			"validate a module zip and upload it to an S3 ConfigurationRepository",
+			publishModule,
+		},
+		"diff": {
+			"[-dir DIR | -bucket BUCKET] [-microsoft-layout] [-ignore PROPS] OLD NEW",
+			"show the resources that changed between two versions of a configuration",
+			diffConfigs,
+		},
+		"lint": {
+			"(-dir DIR | -bucket BUCKET) [-microsoft-layout] [CONFIG...]",
+			"check that the modules used by configurations are in the repository",
+			lintConfigs,
+		},
+		"compile": {
+			"(-dir DIR | -bucket BUCKET) [-microsoft-layout] FILE...",
+			"compile YAML or JSON configurations to MOF and publish them",
+			compileConfigs,
+		},
+		"publish-config": {
+			"-bucket BUCKET [-name NAME] MOF",
+			"upload a configuration to an S3 ConfigurationRepository",
+			publishConfig,
+		},
+		"sign-config": {
+			"-cert CERT -key KEY [-o OUTPUT] MOF",
+			"sign a configuration for nodes that validate signatures",
+			signConfig,
+		},
+		"sign-module": {
+			"-cert CERT -key KEY [-name NAME] [-o OUTPUT] ZIP",
+			"add a signed catalog to a module zip",
+			signModule,
+		},
+	}
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: %s COMMAND [ARGS...]\n\ncommands:\n", os.Args[0])
+
+	var names []string
+	for name := range commands {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(os.Stderr, "  %-16s %s\n", name, commands[name].Help)
+	}
+}
+
+// newFlagSet returns a FlagSet for a command, with usage information.
+func newFlagSet(name string) *flag.FlagSet {
+	fs := flag.NewFlagSet(name, flag.ExitOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: %s %s %s\n", os.Args[0], name, commands[name].Usage)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+func main() {
+	if len(os.Args) < 2 {
+		usage()
+		os.Exit(2)
+	}
+
+	cmd, ok := commands[os.Args[1]]
+	if !ok {
+		usage()
+		os.Exit(2)
+	}
+
+	if err := cmd.Run(os.Args[2:]); err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %s\n", os.Args[1], err)
+		os.Exit(1)
+	}
+}
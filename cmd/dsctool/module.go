@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/s3"
+
+	dscconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	s3config "github.com/stripe-archive/simple-powershell-dsc/dsc/config/s3"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/module"
+)
+
+// moduleNameVersion returns the name and version of a module, using the
+// values from flags if given, and the repository layout of
+// "<name>/<version>.zip" otherwise.
+func moduleNameVersion(path, name, version string) (string, string) {
+	if version == "" {
+		version = strings.TrimSuffix(filepath.Base(path), filepath.Ext(path))
+	}
+	if name == "" {
+		name = filepath.Base(filepath.Dir(path))
+	}
+	return name, version
+}
+
+func validateModule(args []string) error {
+	fs := newFlagSet("validate-module")
+	name := fs.String("name", "", "module name (default: the name of the zip's directory)")
+	version := fs.String("version", "", "module version (default: the name of the zip, without \".zip\")")
+	fs.Parse(args)
+
+	if fs.NArg() == 0 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	failed := false
+	for _, path := range fs.Args() {
+		modName, modVersion := moduleNameVersion(path, *name, *version)
+		if err := validateFile(path, modName, modVersion); err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %s\n", path, err)
+			failed = true
+			continue
+		}
+		fmt.Printf("%s: ok (%s %s)\n", path, modName, modVersion)
+	}
+
+	if failed {
+		return errors.New("some modules are invalid")
+	}
+	return nil
+}
+
+func validateFile(path, name, version string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	return module.Validate(f, info.Size(), name, version)
+}
+
+func publishModule(args []string) error {
+	fs := newFlagSet("publish-module")
+	bucket := fs.String("bucket", "", "S3 bucket of the ConfigurationRepository")
+	name := fs.String("name", "", "module name (default: the name of the zip's directory)")
+	version := fs.String("version", "", "module version (default: the name of the zip, without \".zip\")")
+	fs.Parse(args)
+
+	if *bucket == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	repo, err := newS3Repository(*bucket)
+	if err != nil {
+		return err
+	}
+
+	modName, modVersion := moduleNameVersion(path, *name, *version)
+	if err := repo.PublishModule(context.Background(), modName, modVersion, content); err != nil {
+		return err
+	}
+	fmt.Printf("published %s %s\n", modName, modVersion)
+	return nil
+}
+
+func publishConfig(args []string) error {
+	fs := newFlagSet("publish-config")
+	bucket := fs.String("bucket", "", "S3 bucket of the ConfigurationRepository")
+	name := fs.String("name", "", "configuration name (default: the name of the file, without \".mof\")")
+	fs.Parse(args)
+
+	if *bucket == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	configName := *name
+	if configName == "" {
+		configName = strings.TrimSuffix(filepath.Base(path), ".mof")
+	}
+
+	repo, err := newS3Repository(*bucket)
+	if err != nil {
+		return err
+	}
+	if err := repo.PublishConfiguration(context.Background(), configName, content); err != nil {
+		return err
+	}
+	fmt.Printf("published %s\n", configName)
+	return nil
+}
+
+func newS3Repository(bucket string, opts ...dscconfig.Option) (*s3config.ConfigurationRepository, error) {
+	sess, err := session.NewSession()
+	if err != nil {
+		return nil, fmt.Errorf("error creating AWS session: %s", err)
+	}
+	return s3config.New(bucket, s3.New(sess), opts...), nil
+}
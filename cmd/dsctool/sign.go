@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/sign"
+)
+
+// signFlags are the flags shared by the signing commands.
+type signFlags struct {
+	cert   *string
+	key    *string
+	output *string
+}
+
+func addSignFlags(fs *flag.FlagSet) signFlags {
+	return signFlags{
+		cert:   fs.String("cert", "", "path to the PEM code signing certificate, optionally followed by its intermediate CAs"),
+		key:    fs.String("key", "", "path to the certificate's PEM private key"),
+		output: fs.String("o", "", "path to write the signed file to (default: sign the file in place)"),
+	}
+}
+
+// signFile signs the file at path with signFn, and writes the result to the
+// output path.
+func (f signFlags) signFile(path string, signFn func(*sign.Signer, []byte) ([]byte, error)) (string, error) {
+	signer, err := sign.Load(*f.cert, *f.key)
+	if err != nil {
+		return "", err
+	}
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+
+	signed, err := signFn(signer, content)
+	if err != nil {
+		return "", err
+	}
+
+	out := *f.output
+	if out == "" {
+		out = path
+	}
+	return out, ioutil.WriteFile(out, signed, 0644)
+}
+
+func signConfig(args []string) error {
+	fs := newFlagSet("sign-config")
+	f := addSignFlags(fs)
+	fs.Parse(args)
+
+	if *f.cert == "" || *f.key == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	out, err := f.signFile(fs.Arg(0), (*sign.Signer).SignMOF)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("signed %s\n", out)
+	return nil
+}
+
+func signModule(args []string) error {
+	fs := newFlagSet("sign-module")
+	f := addSignFlags(fs)
+	name := fs.String("name", "", "module name (default: the name of the zip's directory)")
+	fs.Parse(args)
+
+	if *f.cert == "" || *f.key == "" || fs.NArg() != 1 {
+		fs.Usage()
+		os.Exit(2)
+	}
+
+	path := fs.Arg(0)
+	modName, _ := moduleNameVersion(path, *name, "")
+	out, err := f.signFile(path, func(signer *sign.Signer, content []byte) ([]byte, error) {
+		return signer.SignModule(content, modName)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("signed %s\n", out)
+	return nil
+}
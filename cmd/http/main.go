@@ -1,23 +1,90 @@
 package main
 
 import (
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/json"
 	"flag"
+	"fmt"
+	"io/ioutil"
 	"net/http"
+	"os"
+	"time"
 
 	"github.com/sirupsen/logrus"
 
 	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	localassign "github.com/stripe-archive/simple-powershell-dsc/dsc/assign/local"
+	dscconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	compositeconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/composite"
+	encryptedconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/encrypted"
+	gitconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/git"
 	localconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/local"
+	signedconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/signed"
+	templateconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config/template"
+	localkeys "github.com/stripe-archive/simple-powershell-dsc/dsc/keys/local"
+	memoryreplay "github.com/stripe-archive/simple-powershell-dsc/dsc/replay/memory"
 	localreport "github.com/stripe-archive/simple-powershell-dsc/dsc/report/local"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/sign"
+	dscstatus "github.com/stripe-archive/simple-powershell-dsc/dsc/status"
 	localstatus "github.com/stripe-archive/simple-powershell-dsc/dsc/status/local"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
+// The number of request signatures to remember for replay protection.
+const replayCacheSize = 100000
+
 var (
 	listenAddress string
+	protocolV1    bool
+	configIDs     string
+	insecureV1    bool
+	assignments   string
+	pushMeta      bool
+	keys          string
+	tlsCert       string
+	tlsKey        string
+	bindCerts     bool
+	gitRepo       string
+	gitRef        string
+	gitFetch      time.Duration
+	overlay       string
+	msLayout      bool
+	templates     bool
+	templateVars  string
+	signCert      string
+	signKey       string
+	presigned     bool
+	encryptCreds  bool
+	encryptCerts  string
+	encryptKey    string
 )
 
 func init() {
 	flag.StringVar(&listenAddress, "addr", "localhost:8000", "listen address for the server")
+	flag.StringVar(&keys, "keys", "", "path to a JSON file of registration keys; if unset, requests aren't authenticated")
+	flag.StringVar(&assignments, "assignments", "", "path to a JSON file of server-side configuration assignments")
+	flag.BoolVar(&pushMeta, "push-meta", false, "push meta-configurations from the \"meta\" directory to agents")
+	flag.BoolVar(&protocolV1, "protocol-v1", false, "serve ConfigurationId-based protocol v1.0/1.1 clients")
+	flag.StringVar(&configIDs, "configuration-ids", "", "path to a JSON object mapping the ConfigurationIds of -protocol-v1 clients to configuration names; if unset, the ConfigurationId is the configuration name")
+	flag.BoolVar(&insecureV1, "insecure-protocol-v1", false, "allow -protocol-v1 with -keys, even though v1.0/1.1 clients can't sign their requests")
+	flag.StringVar(&gitRepo, "git-repo", "", "serve configurations and modules from this git repository instead of \"test/config\"")
+	flag.StringVar(&gitRef, "git-ref", "master", "the branch, tag or commit to serve from -git-repo")
+	flag.DurationVar(&gitFetch, "git-fetch-interval", 0, "if non-zero, how often to fetch -git-repo from its remote")
+	flag.StringVar(&overlay, "overlay", "", "directory of configurations and modules that override the ones being served")
+	flag.BoolVar(&msLayout, "microsoft-layout", false, "read directories of configurations and modules in the layout used by the Microsoft pull server")
+	flag.BoolVar(&templates, "templates", false, "render configurations as templates for each agent, using facts from its registration")
+	flag.StringVar(&templateVars, "template-vars", "", "directory of JSON files of variables for -templates, named default.json, <node name>.json or <agent ID>.json; node names aren't verified, so only agent ID files may hold secrets")
+	flag.BoolVar(&encryptCreds, "encrypt-credentials", false, "encrypt the credentials in configurations to each agent's registered certificate")
+	flag.StringVar(&encryptCerts, "encryption-certs", "", "directory of agents' certificates for -encrypt-credentials, named <thumbprint>.cer; practically required, since the LCM doesn't send its certificate when it registers")
+	flag.StringVar(&encryptKey, "encryption-key", "", "path to the secret key that -encrypt-credentials derives encryption keys from (required), which is generated if the file doesn't exist")
+	flag.StringVar(&signCert, "sign-cert", "", "path to a code signing certificate; if set, configurations and modules are signed as they are served")
+	flag.StringVar(&signKey, "sign-key", "", "path to the code signing certificate's private key")
+	flag.BoolVar(&presigned, "presigned-modules", false, "with -sign-cert, serve modules as they are, since they were signed with \"dsctool sign-module\" when published")
+	flag.StringVar(&tlsCert, "tls-cert", "", "path to a TLS certificate; if set, the server listens with TLS")
+	flag.StringVar(&tlsKey, "tls-key", "", "path to the TLS certificate's private key")
+	flag.BoolVar(&bindCerts, "bind-client-certs", false, "require agents to present their registered client certificate (needs -tls-cert)")
 }
 
 func main() {
@@ -26,16 +93,200 @@ func main() {
 	log := logrus.New()
 	log.Level = logrus.DebugLevel
 
-	config := localconfig.New("test/config")
+	var configOpts []dscconfig.Option
+	if msLayout {
+		configOpts = append(configOpts, dscconfig.WithLayout(dscconfig.MicrosoftLayout))
+	}
+
+	var config interface {
+		dsc.ConfigurationRepository
+		dsc.MetaConfigurationRepository
+	}
+	if gitRepo != "" {
+		repo := gitconfig.New(gitRepo, gitRef)
+		if gitFetch > 0 {
+			go fetchPeriodically(log, repo, gitFetch)
+		}
+		config = repo
+	} else {
+		config = localconfig.New("test/config", configOpts...)
+	}
+	if overlay != "" {
+		config = compositeconfig.New(
+			compositeconfig.Layer{Name: "overlay", Repo: localconfig.New(overlay, configOpts...)},
+			compositeconfig.Layer{Name: "base", Repo: config},
+		)
+	}
+
+	// The template and encrypted repositories read registrations from the
+	// NodeStatus, which itself needs the (rendered) configurations, so
+	// it's looked up when a configuration is served.
+	var status *localstatus.NodeStatus
+	registrations := dsc.RegistrationGetterFunc(func(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+		return status.GetRegistration(ctx, agentId)
+	})
+	if templates {
+		var templateOpts []templateconfig.Option
+		if templateVars != "" {
+			templateOpts = append(templateOpts, templateconfig.WithVars(templateconfig.NewDirVars(templateVars)))
+		}
+		config = templateconfig.New(config, registrations, templateOpts...)
+	}
+	if encryptCreds {
+		var encryptOpts []encryptedconfig.Option
+		if encryptCerts != "" {
+			encryptOpts = append(encryptOpts, encryptedconfig.WithCertificates(encryptedconfig.NewDirCertificates(encryptCerts)))
+		} else {
+			log.Warn("-encrypt-credentials without -encryption-certs can only encrypt for agents that send their certificate, which the LCM doesn't")
+		}
+		if encryptKey == "" {
+			log.Fatal("-encrypt-credentials requires -encryption-key")
+		}
+		key, err := loadEncryptionKey(encryptKey)
+		if err != nil {
+			log.WithError(err).Fatal("error loading encryption key")
+		}
+		encryptOpts = append(encryptOpts, encryptedconfig.WithKey(key))
+		config = encryptedconfig.New(config, registrations, encryptOpts...)
+	}
+
+	// Signing wraps the other repositories, so that each agent's rendered
+	// and encrypted configuration is signed.
+	if signCert != "" {
+		signer, err := sign.Load(signCert, signKey)
+		if err != nil {
+			log.WithError(err).Fatal("error loading signing certificate")
+		}
+		var signOpts []signedconfig.Option
+		if presigned {
+			signOpts = append(signOpts, signedconfig.WithPresignedModules())
+		}
+		config = signedconfig.New(config, signer, signOpts...)
+	} else if presigned {
+		log.Fatal("-presigned-modules requires -sign-cert")
+	}
+
 	report := localreport.New("test/reports")
-	status, err := localstatus.New(config, "test/status")
+	var statusOpts []dscstatus.Option
+	if assignments != "" {
+		statusOpts = append(statusOpts, dscstatus.WithAssignments(localassign.New(assignments)))
+	}
+
+	status, err := localstatus.New(config, "test/status", statusOpts...)
 	if err != nil {
 		log.WithError(err).Fatal("error creating NodeStatus")
 	}
 
-	mgr := dsc.NewManager(config, report, status, dsc.WithLogger(log))
+	opts := []dsc.Option{dsc.WithLogger(log)}
+	if keys != "" {
+		opts = append(opts,
+			dsc.WithKeyStore(localkeys.New(keys)),
+			dsc.WithReplayCache(memoryreplay.New(replayCacheSize)),
+		)
+	}
+	if pushMeta {
+		opts = append(opts, dsc.WithMetaConfiguration(config))
+	}
+	if protocolV1 {
+		// Version 1.0 and 1.1 clients can't sign their requests, so
+		// serving them bypasses the registration keys.
+		if keys != "" && !insecureV1 {
+			log.Fatal("-protocol-v1 endpoints aren't authenticated by -keys; pass -insecure-protocol-v1 to serve them anyway")
+		}
+
+		// Nor do they register, so there are no registrations to
+		// render templates with.
+		if templates {
+			log.Fatal("-templates can't be used with -protocol-v1, since v1.0/1.1 clients don't register")
+		}
+		if encryptCreds {
+			log.Fatal("-encrypt-credentials can't be used with -protocol-v1, since v1.0/1.1 clients don't register a certificate")
+		}
+
+		var resolver dsc.ConfigurationIDResolver
+		if configIDs != "" {
+			ids, err := loadConfigurationIDs(configIDs)
+			if err != nil {
+				log.WithError(err).Fatal("error loading ConfigurationIds")
+			}
+			resolver = ids
+		}
+		opts = append(opts, dsc.WithProtocolV1(resolver))
+	} else if configIDs != "" {
+		log.Fatal("-configuration-ids requires -protocol-v1")
+	}
+
+	if bindCerts {
+		if tlsCert == "" {
+			log.Fatal("-bind-client-certs requires -tls-cert and -tls-key")
+		}
+		opts = append(opts, dsc.WithClientCertificateBinding())
+	}
+
+	mgr := dsc.NewManager(config, report, status, opts...)
+	server := &http.Server{
+		Addr:    listenAddress,
+		Handler: mgr,
+		TLSConfig: &tls.Config{
+			// Agents use self-signed certificates, so we only ask for one
+			// here; the Manager checks it against the agent's registration.
+			ClientAuth: tls.RequestClientCert,
+		},
+	}
+
 	log.WithField("address", listenAddress).Info("server started")
-	if err := http.ListenAndServe(listenAddress, mgr); err != nil {
+	if tlsCert != "" {
+		err = server.ListenAndServeTLS(tlsCert, tlsKey)
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil {
 		log.WithError(err).Fatal("error in server")
 	}
 }
+
+// loadConfigurationIDs reads a JSON object mapping ConfigurationIds to
+// configuration names.
+func loadConfigurationIDs(path string) (dsc.ConfigurationIDMap, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var ret dsc.ConfigurationIDMap
+	if err := json.Unmarshal(data, &ret); err != nil {
+		return nil, fmt.Errorf("error parsing %s: %w", path, err)
+	}
+	return ret, nil
+}
+
+// loadEncryptionKey reads the key for encrypted configurations, or generates
+// and writes a new one if the file doesn't exist.
+func loadEncryptionKey(path string) ([]byte, error) {
+	key, err := ioutil.ReadFile(path)
+	if err == nil {
+		if len(key) < 32 {
+			return nil, fmt.Errorf("%s: key must be at least 32 bytes", path)
+		}
+		return key, nil
+	} else if !os.IsNotExist(err) {
+		return nil, err
+	}
+
+	key = make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := ioutil.WriteFile(path, key, 0600); err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func fetchPeriodically(log logrus.FieldLogger, repo *gitconfig.ConfigurationRepository, interval time.Duration) {
+	for range time.Tick(interval) {
+		if err := repo.Fetch(context.Background()); err != nil {
+			log.WithError(err).Error("error fetching git repository")
+		}
+	}
+}
@@ -4,13 +4,19 @@ import (
 	"os"
 
 	"github.com/aws/aws-sdk-go/aws/session"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/sirupsen/logrus"
 
 	"github.com/stripe-archive/simple-powershell-dsc/cmd/lambda/internal/gateway"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	s3assign "github.com/stripe-archive/simple-powershell-dsc/dsc/assign/s3"
+	dscconfig "github.com/stripe-archive/simple-powershell-dsc/dsc/config"
 	s3config "github.com/stripe-archive/simple-powershell-dsc/dsc/config/s3"
+	s3keys "github.com/stripe-archive/simple-powershell-dsc/dsc/keys/s3"
+	dynamoreplay "github.com/stripe-archive/simple-powershell-dsc/dsc/replay/dynamodb"
 	s3report "github.com/stripe-archive/simple-powershell-dsc/dsc/report/s3"
+	dscstatus "github.com/stripe-archive/simple-powershell-dsc/dsc/status"
 	s3status "github.com/stripe-archive/simple-powershell-dsc/dsc/status/s3"
 )
 
@@ -46,11 +52,42 @@ func main() {
 	}
 	s3api := s3.New(sess)
 
-	config := s3config.New(configBucket, s3api)
+	var configOpts []dscconfig.Option
+	if _, found := os.LookupEnv("MICROSOFT_LAYOUT"); found {
+		configOpts = append(configOpts, dscconfig.WithLayout(dscconfig.MicrosoftLayout))
+	}
+
+	config := s3config.New(configBucket, s3api, configOpts...)
 	report := s3report.New(reportsBucket, s3api)
-	status := s3status.New(config, statusBucket, s3api)
 
-	mgr := dsc.NewManager(config, report, status, dsc.WithLogger(log))
+	// Server-side configuration assignments are optional, and are stored
+	// in the configuration bucket.
+	var statusOpts []dscstatus.Option
+	if key, found := os.LookupEnv("ASSIGNMENTS_S3_KEY"); found {
+		statusOpts = append(statusOpts, dscstatus.WithAssignments(s3assign.New(configBucket, key, s3api)))
+	}
+
+	status := s3status.New(config, statusBucket, s3api, statusOpts...)
+
+	opts := []dsc.Option{dsc.WithLogger(log)}
+	if key, found := os.LookupEnv("KEYS_S3_KEY"); found {
+		// Requests may be replayed against any instance of this
+		// function, so the signatures they've seen are shared in a
+		// DynamoDB table.
+		table, found := os.LookupEnv("REPLAY_DYNAMODB_TABLE")
+		if !found {
+			log.Fatal("KEYS_S3_KEY requires REPLAY_DYNAMODB_TABLE, for replay protection")
+		}
+		opts = append(opts,
+			dsc.WithKeyStore(s3keys.New(configBucket, key, s3api)),
+			dsc.WithReplayCache(dynamoreplay.New(table, dynamodb.New(sess))),
+		)
+	}
+	if _, found := os.LookupEnv("PUSH_META_CONFIGURATION"); found {
+		opts = append(opts, dsc.WithMetaConfiguration(config))
+	}
+
+	mgr := dsc.NewManager(config, report, status, opts...)
 
 	log.Info("server started")
 	if err := gateway.ListenAndServe(":3000", mgr); err != nil {
@@ -0,0 +1,63 @@
+package assign
+
+import (
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Assignments is a set of server-side configuration assignments. A node is
+// matched against each map in turn, from most to least specific: first by
+// agent ID, then by node name, and finally by the ID of the registration key
+// that it signed its request with. Keys in all maps are matched case-insensitively.
+//
+// The JSON form of this type is the document format read by the local and S3
+// assignment stores, e.g.:
+//
+//     {
+//       "Agents": {"B1F28971-2CEB-46D5-9DCB-79C044395F81": ["Canary"]},
+//       "Nodes": {"WEB01": ["WebServer"]},
+//       "RegistrationKeys": {"windows-team": ["Baseline"]}
+//     }
+type Assignments struct {
+	Agents           map[string][]string `json:"Agents,omitempty"`
+	Nodes            map[string][]string `json:"Nodes,omitempty"`
+	RegistrationKeys map[string][]string `json:"RegistrationKeys,omitempty"`
+}
+
+// Lookup returns the configuration names assigned to the given node, or nil
+// if there is no applicable assignment.
+func (a *Assignments) Lookup(req types.GetAssignmentRequest) *types.GetAssignmentResponse {
+	candidates := []struct {
+		m   map[string][]string
+		key string
+	}{
+		{a.Agents, req.AgentID},
+		{a.Nodes, req.NodeName},
+		{a.RegistrationKeys, req.RegistrationKeyID},
+	}
+
+	for _, c := range candidates {
+		if c.key == "" {
+			continue
+		}
+		if names, ok := lookupFold(c.m, c.key); ok {
+			return &types.GetAssignmentResponse{ConfigurationNames: names}
+		}
+	}
+	return nil
+}
+
+func lookupFold(m map[string][]string, key string) ([]string, bool) {
+	// Fast path: exact match
+	if names, ok := m[key]; ok {
+		return names, true
+	}
+
+	for k, names := range m {
+		if strings.EqualFold(k, key) {
+			return names, true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,48 @@
+package assign
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+func TestLookup(t *testing.T) {
+	a := &Assignments{
+		Agents:           map[string][]string{"B1F28971-2CEB-46D5-9DCB-79C044395F81": {"Canary"}},
+		Nodes:            map[string][]string{"WEB01": {"WebServer"}, "web02": {"WebServer", "Logging"}},
+		RegistrationKeys: map[string][]string{"windows-team": {"Baseline"}},
+	}
+
+	testCases := []struct {
+		Name     string
+		Req      types.GetAssignmentRequest
+		Expected []string
+	}{
+		{"agent", types.GetAssignmentRequest{AgentID: "b1f28971-2ceb-46d5-9dcb-79c044395f81"}, []string{"Canary"}},
+		{"agent over node", types.GetAssignmentRequest{AgentID: "B1F28971-2CEB-46D5-9DCB-79C044395F81", NodeName: "WEB01"}, []string{"Canary"}},
+		{"node", types.GetAssignmentRequest{AgentID: "other", NodeName: "web01"}, []string{"WebServer"}},
+		{"node over key", types.GetAssignmentRequest{NodeName: "WEB02", RegistrationKeyID: "windows-team"}, []string{"WebServer", "Logging"}},
+		{"key", types.GetAssignmentRequest{NodeName: "db01", RegistrationKeyID: "Windows-Team"}, []string{"Baseline"}},
+		{"none", types.GetAssignmentRequest{AgentID: "other", NodeName: "db01", RegistrationKeyID: "other"}, nil},
+		{"empty", types.GetAssignmentRequest{}, nil},
+	}
+
+	for _, tc := range testCases {
+		resp := a.Lookup(tc.Req)
+		if tc.Expected == nil {
+			assert.Nil(t, resp, "test case %q", tc.Name)
+			continue
+		}
+		if assert.NotNil(t, resp, "test case %q", tc.Name) {
+			assert.Equal(t, tc.Expected, resp.ConfigurationNames, "test case %q", tc.Name)
+		}
+	}
+}
+
+// Empty keys never match, even if a map contains one.
+func TestLookupEmptyKey(t *testing.T) {
+	a := &Assignments{Nodes: map[string][]string{"": {"Everything"}}}
+	assert.Nil(t, a.Lookup(types.GetAssignmentRequest{AgentID: "agent"}))
+}
@@ -0,0 +1,81 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/assign"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/fsmock"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// AssignmentStore reads assignments from a JSON file on disk, in the format
+// described by assign.Assignments. The file is re-read whenever it changes,
+// so assignments can be updated without restarting the server.
+type AssignmentStore struct {
+	path string
+	fs   fsmock.FileSystem
+
+	lock        sync.Mutex
+	modTime     time.Time
+	size        int64
+	assignments *assign.Assignments
+}
+
+func New(path string) *AssignmentStore {
+	return &AssignmentStore{path: path, fs: fsmock.OSFS{}}
+}
+
+func (a *AssignmentStore) GetAssignment(
+	ctx context.Context,
+	req types.GetAssignmentRequest,
+) (*types.GetAssignmentResponse, error) {
+	assignments, err := a.load()
+	if err != nil {
+		return nil, err
+	}
+	if assignments == nil {
+		return nil, nil
+	}
+
+	return assignments.Lookup(req), nil
+}
+
+func (a *AssignmentStore) load() (*assign.Assignments, error) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	st, err := a.fs.Stat(a.path)
+	if err != nil {
+		// A missing file means that nothing is assigned.
+		if os.IsNotExist(err) {
+			a.assignments = nil
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	// Use the cached copy if the file hasn't changed.
+	if a.assignments != nil && st.ModTime().Equal(a.modTime) && st.Size() == a.size {
+		return a.assignments, nil
+	}
+
+	f, err := a.fs.Open(a.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var assignments assign.Assignments
+	if err := json.NewDecoder(f).Decode(&assignments); err != nil {
+		return nil, err
+	}
+
+	a.assignments = &assignments
+	a.modTime = st.ModTime()
+	a.size = st.Size()
+	return a.assignments, nil
+}
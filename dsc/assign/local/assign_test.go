@@ -0,0 +1,60 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+func TestGetAssignmentReload(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "dsc-assign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "assignments.json")
+	store := New(path)
+	req := types.GetAssignmentRequest{NodeName: "web01"}
+
+	// A missing file assigns nothing.
+	resp, err := store.GetAssignment(ctx, req)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	write := func(content string, modTime time.Time) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	now := time.Now()
+
+	write(`{"Nodes": {"WEB01": ["WebServer"]}}`, now)
+	resp, err = store.GetAssignment(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"WebServer"}, resp.ConfigurationNames)
+
+	// Changes are picked up without restarting...
+	write(`{"Nodes": {"WEB01": ["Database"]}}`, now.Add(time.Second))
+	resp, err = store.GetAssignment(ctx, req)
+	require.NoError(t, err)
+	require.NotNil(t, resp)
+	assert.Equal(t, []string{"Database"}, resp.ConfigurationNames)
+
+	// ... including removing the file.
+	require.NoError(t, os.Remove(path))
+	resp, err = store.GetAssignment(ctx, req)
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	write(`{"Nodes": `, now.Add(2*time.Second))
+	_, err = store.GetAssignment(ctx, req)
+	assert.Error(t, err)
+}
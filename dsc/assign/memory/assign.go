@@ -0,0 +1,64 @@
+package memory
+
+import (
+	"context"
+	"sync"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/assign"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+type AssignmentStore struct {
+	assignments assign.Assignments
+	lock        sync.RWMutex
+}
+
+func New() *AssignmentStore {
+	return &AssignmentStore{
+		assignments: assign.Assignments{
+			Agents:           make(map[string][]string),
+			Nodes:            make(map[string][]string),
+			RegistrationKeys: make(map[string][]string),
+		},
+	}
+}
+
+// AssignAgent assigns the given configurations to the agent with the given
+// ID. Passing no configuration names removes the assignment.
+func (a *AssignmentStore) AssignAgent(agentId string, names ...string) {
+	a.set(a.assignments.Agents, agentId, names)
+}
+
+// AssignNode assigns the given configurations to the node with the given
+// name. Passing no configuration names removes the assignment.
+func (a *AssignmentStore) AssignNode(nodeName string, names ...string) {
+	a.set(a.assignments.Nodes, nodeName, names)
+}
+
+// AssignRegistrationKey assigns the given configurations to every node that
+// signs its requests with the registration key with the given ID. Passing no
+// configuration names removes the assignment.
+func (a *AssignmentStore) AssignRegistrationKey(keyId string, names ...string) {
+	a.set(a.assignments.RegistrationKeys, keyId, names)
+}
+
+func (a *AssignmentStore) set(m map[string][]string, key string, names []string) {
+	a.lock.Lock()
+	defer a.lock.Unlock()
+
+	if len(names) == 0 {
+		delete(m, key)
+	} else {
+		m[key] = names
+	}
+}
+
+func (a *AssignmentStore) GetAssignment(
+	ctx context.Context,
+	req types.GetAssignmentRequest,
+) (*types.GetAssignmentResponse, error) {
+	a.lock.RLock()
+	defer a.lock.RUnlock()
+
+	return a.assignments.Lookup(req), nil
+}
@@ -0,0 +1,57 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/assign"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// AssignmentStore reads assignments from a JSON object in S3, in the format
+// described by assign.Assignments. The object is fetched on every lookup, so
+// assignments can be updated without redeploying the server.
+type AssignmentStore struct {
+	bucket *string
+	key    *string
+	s3     s3iface.S3API
+}
+
+func New(bucket, key string, s3 s3iface.S3API) *AssignmentStore {
+	return &AssignmentStore{&bucket, &key, s3}
+}
+
+func (a *AssignmentStore) GetAssignment(
+	ctx context.Context,
+	req types.GetAssignmentRequest,
+) (*types.GetAssignmentResponse, error) {
+	result, err := a.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: a.bucket,
+		Key:    a.key,
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok {
+			switch aerr.Code() {
+			case s3.ErrCodeNoSuchKey:
+				// A missing object means that nothing is
+				// assigned.
+				return nil, nil
+			}
+		}
+
+		// Unknown error; return as-is
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	var assignments assign.Assignments
+	if err := json.NewDecoder(result.Body).Decode(&assignments); err != nil {
+		return nil, err
+	}
+
+	return assignments.Lookup(req), nil
+}
@@ -0,0 +1,150 @@
+package dsc
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/hex"
+	"net/http"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/httperr"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/regexpat"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// certPolicy describes how a route checks that the TLS client certificate
+// matches the one that the agent registered with.
+type certPolicy int
+
+const (
+	// The route isn't checked by middleware, since it doesn't identify
+	// an agent.
+	certUnchecked certPolicy = iota
+
+	// The agent must be registered, and must present its certificate.
+	certRequired
+
+	// As above, but unregistered agents are passed through to the
+	// handler, so that they can be told to register.
+	certIfRegistered
+)
+
+// clientThumbprint returns the thumbprint of the TLS client certificate on
+// the request, in the same format as the LCM sends at registration, or the
+// empty string if there is none.
+func clientThumbprint(r *http.Request) string {
+	if r.TLS == nil || len(r.TLS.PeerCertificates) == 0 {
+		return ""
+	}
+
+	return *certificateInformation(r.TLS.PeerCertificates[0]).Thumbprint
+}
+
+// certificateInformation returns the details of a certificate, in the same
+// format as the LCM sends them at registration.
+func certificateInformation(cert *x509.Certificate) types.CertificateInformation {
+	h := sha1.Sum(cert.Raw)
+	thumbprint := strings.ToUpper(hex.EncodeToString(h[:]))
+	subject := cert.Subject.String()
+	issuer := cert.Issuer.String()
+	notBefore := cert.NotBefore.Format(lcmTimeFormat)
+	notAfter := cert.NotAfter.Format(lcmTimeFormat)
+
+	return types.CertificateInformation{
+		Thumbprint: &thumbprint,
+		Subject:    &subject,
+		Issuer:     &issuer,
+		NotBefore:  &notBefore,
+		NotAfter:   &notAfter,
+		Version:    &cert.Version,
+	}
+}
+
+// The format of the certificate dates sent by the LCM, e.g.
+// "2017-12-21T11:40:36.0000000-05:00".
+const lcmTimeFormat = "2006-01-02T15:04:05.0000000Z07:00"
+
+// thumbprintMatches returns whether the given thumbprint matches the
+// certificate information, ignoring case.
+func thumbprintMatches(thumbprint string, info types.CertificateInformation) bool {
+	return thumbprint != "" && info.Thumbprint != nil &&
+		strings.EqualFold(thumbprint, *info.Thumbprint)
+}
+
+// checkClientCertificate returns a ClientCertificateMismatchError if the
+// request's TLS client certificate isn't the one that the agent registered
+// with.
+func (m *Manager) checkClientCertificate(r *http.Request, agentId string) error {
+	thumbprint := clientThumbprint(r)
+
+	getter, ok := m.status.(RegistrationGetter)
+	if !ok {
+		// We check for this in NewManager, so this is a programming
+		// error.
+		panic("dsc: NodeStatus does not implement RegistrationGetter")
+	}
+
+	reg, err := getter.GetRegistration(r.Context(), agentId)
+	if err != nil {
+		return err
+	}
+	if !thumbprintMatches(thumbprint, reg.RegistrationInformation.CertificateInformation) {
+		return types.ClientCertificateMismatchError{
+			AgentID:    agentId,
+			Thumbprint: thumbprint,
+		}
+	}
+	return nil
+}
+
+// bindClientCertificate returns middleware that rejects requests whose TLS
+// client certificate isn't the one that the agent registered with.
+func (m *Manager) bindClientCertificate(policy certPolicy) func(http.Handler) http.Handler {
+	return func(inner http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			// GetModule identifies the agent with a header rather
+			// than in the URL.
+			agentId, ok := regexpat.OptionalParam(r, "agent_id")
+			if !ok {
+				agentId = r.Header.Get("AgentId")
+			}
+
+			// The header isn't matched by the route, and backends
+			// may use the agent ID in paths, so it's validated
+			// before the registration is looked up.
+			if !agentIdRegexp.MatchString(agentId) {
+				httperr.Write(w, httperr.BadRequest("invalid agent id"))
+				return
+			}
+
+			err := m.checkClientCertificate(r, agentId)
+			if _, ok := err.(types.AgentNotRegisteredError); ok && policy == certIfRegistered {
+				err = nil
+			}
+			if err != nil {
+				m.writeCertificateError(w, err)
+				return
+			}
+
+			inner.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
+	}
+}
+
+func (m *Manager) writeCertificateError(w http.ResponseWriter, err error) {
+	switch err.(type) {
+	case types.ClientCertificateMismatchError:
+		m.log.WithError(err).Warn("rejecting request with mismatched client certificate")
+		httperr.Write(w, err)
+
+	case types.AgentNotRegisteredError:
+		// An unregistered agent has no certificate to match, so this
+		// is an authentication failure rather than a missing agent.
+		m.log.WithError(err).Warn("rejecting request with mismatched client certificate")
+		httperr.Write(w, httperr.Unauthorized("%s", err))
+
+	default:
+		m.writeError(w, "error checking client certificate", err)
+	}
+}
@@ -0,0 +1,548 @@
+// Package compile compiles declarative configurations, written in YAML or
+// JSON, to the MOF documents that the LCM applies, so that configurations can
+// be built without Windows PowerShell. A configuration looks like:
+//
+//     configuration: WebServer
+//     imports:
+//       - name: xWebAdministration
+//         version: 3.1.1
+//         classes:
+//           xWebsite: MSFT_xWebsite
+//     resources:
+//       - type: WindowsFeature
+//         name: IIS
+//         properties:
+//           Ensure: Present
+//           Name: Web-Server
+//     nodes:
+//       - name: web01
+//         resources:
+//           - type: xWebsite
+//             name: DefaultSite
+//             dependsOn: ["[WindowsFeature]IIS"]
+//             properties:
+//               Name: Default Web Site
+//               BindingInfo:
+//                 - class: MSFT_xWebBindingInformation
+//                   properties:
+//                     Protocol: HTTP
+//                     Port: 80
+//
+// Each node is compiled to a separate document, named after the node, with
+// the top-level resources followed by the node's own; a configuration without
+// nodes is compiled to a single document named after the configuration.
+//
+// A resource's MOF class is looked up from its type: resources from
+// PSDesiredStateConfiguration are known, and other modules must list the
+// classes of the resources they provide, as in their .schema.mof files.
+//
+// Property values may be strings, numbers, booleans, lists of those, embedded
+// instances (mappings with "class" and "properties") or credentials (mappings
+// with "username" and "password"). Credentials are written in plain text, as
+// with PSDscAllowPlainTextPassword, and should be encrypted when they are
+// served.
+//
+// Compiling is deterministic: properties are sorted, and no generation date
+// or host is recorded, so a document's checksum only changes when its
+// content does.
+package compile
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+)
+
+// The module that provides the resources that ship with Windows.
+const builtinModule = "PSDesiredStateConfiguration"
+
+// The version of builtinModule that compiled documents refer to, unless
+// another version is imported.
+const builtinVersion = "1.0"
+
+// The classes of the resources in builtinModule, keyed by lower-cased type.
+var builtinClasses = map[string]string{
+	"archive":                "MSFT_ArchiveResource",
+	"environment":            "MSFT_EnvironmentResource",
+	"file":                   "MSFT_FileDirectoryConfiguration",
+	"group":                  "MSFT_GroupResource",
+	"log":                    "MSFT_LogResource",
+	"package":                "MSFT_PackageResource",
+	"registry":               "MSFT_RegistryResource",
+	"script":                 "MSFT_ScriptResource",
+	"service":                "MSFT_ServiceResource",
+	"user":                   "MSFT_UserResource",
+	"windowsfeature":         "MSFT_RoleResource",
+	"windowsoptionalfeature": "MSFT_WindowsOptionalFeature",
+	"windowsprocess":         "MSFT_ProcessResource",
+}
+
+// Configuration is a declarative configuration.
+type Configuration struct {
+	// The name of the configuration, which is recorded in each resource.
+	Name string `yaml:"configuration"`
+
+	// The modules that the configuration's resources come from.
+	Imports []Import `yaml:"imports"`
+
+	// Resources that are applied to every node.
+	Resources []Resource `yaml:"resources"`
+
+	Nodes []Node `yaml:"nodes"`
+}
+
+// Import is a module that a configuration uses.
+type Import struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+
+	// The MOF classes of the module's resources, keyed by resource type.
+	Classes map[string]string `yaml:"classes"`
+}
+
+// Resource is a resource in a configuration.
+type Resource struct {
+	// The resource type, e.g. "WindowsFeature", and the name of this
+	// instance of it, which together form its ResourceID.
+	Type string `yaml:"type"`
+	Name string `yaml:"name"`
+
+	// Optionally, the module that provides the resource, if more than one
+	// imported module does, and its MOF class, if the module doesn't list
+	// it.
+	Module string `yaml:"module"`
+	Class  string `yaml:"class"`
+
+	// The ResourceIDs of the resources that must be applied first.
+	DependsOn []string `yaml:"dependsOn"`
+
+	Properties map[string]interface{} `yaml:"properties"`
+}
+
+// ID returns the ResourceID of the resource, e.g. "[WindowsFeature]IIS".
+func (r *Resource) ID() string {
+	return fmt.Sprintf("[%s]%s", r.Type, r.Name)
+}
+
+// Node is a node block in a configuration.
+type Node struct {
+	// The name of the node, or of several nodes that have the same
+	// resources.
+	Name  string   `yaml:"name"`
+	Names []string `yaml:"names"`
+
+	Resources []Resource `yaml:"resources"`
+}
+
+// Error is returned when a configuration can't be compiled.
+type Error struct {
+	// The node and resource that the error is in, if any.
+	Node     string
+	Resource string
+
+	Msg string
+}
+
+func (e Error) Error() string {
+	switch {
+	case e.Node != "" && e.Resource != "":
+		return fmt.Sprintf("compile: node %q: resource %s: %s", e.Node, e.Resource, e.Msg)
+	case e.Node != "":
+		return fmt.Sprintf("compile: node %q: %s", e.Node, e.Msg)
+	case e.Resource != "":
+		return fmt.Sprintf("compile: resource %s: %s", e.Resource, e.Msg)
+	}
+	return "compile: " + e.Msg
+}
+
+// Parse parses a configuration from YAML or JSON. Unknown fields are
+// rejected, to catch typos.
+func Parse(data []byte) (*Configuration, error) {
+	var ret Configuration
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&ret); err != nil {
+		return nil, fmt.Errorf("compile: %s", err)
+	}
+	if ret.Name == "" {
+		return nil, Error{Msg: "configuration has no name"}
+	}
+	return &ret, nil
+}
+
+// Output is a compiled document.
+type Output struct {
+	// The name of the node that the document is for, which is the name
+	// it should be published as.
+	Node string
+
+	Document *mof.Document
+}
+
+// Marshal serializes the document, with a header that names its node, as
+// PowerShell does.
+func (o *Output) Marshal() ([]byte, error) {
+	body, err := mof.Marshal(o.Document)
+	if err != nil {
+		return nil, err
+	}
+	header := fmt.Sprintf("/*\n@TargetNode='%s'\n*/\n\n", o.Node)
+	return append([]byte(header), body...), nil
+}
+
+// Compile compiles a configuration to a document for each node, in the order
+// the nodes are declared.
+func Compile(c *Configuration) ([]Output, error) {
+	nodes := c.Nodes
+	if len(nodes) == 0 {
+		nodes = []Node{{Name: c.Name}}
+	}
+
+	var ret []Output
+	seen := make(map[string]bool)
+	for _, node := range nodes {
+		names := node.Names
+		if node.Name != "" {
+			names = append([]string{node.Name}, names...)
+		}
+		if len(names) == 0 {
+			return nil, Error{Msg: "node has no name"}
+		}
+
+		for _, name := range names {
+			if seen[strings.ToLower(name)] {
+				return nil, Error{Node: name, Msg: "node is declared more than once"}
+			}
+			seen[strings.ToLower(name)] = true
+
+			resources := append(append([]Resource(nil), c.Resources...), node.Resources...)
+			doc, err := c.compileNode(resources)
+			if err != nil {
+				if cerr, ok := err.(Error); ok {
+					cerr.Node = name
+					return nil, cerr
+				}
+				return nil, err
+			}
+			ret = append(ret, Output{Node: name, Document: doc})
+		}
+	}
+	return ret, nil
+}
+
+// compiler holds the state of compiling a single document.
+type compiler struct {
+	c   *Configuration
+	doc *mof.Document
+
+	// The number of instances of each class, which are used to number
+	// their aliases.
+	counts map[string]int
+}
+
+func (c *Configuration) compileNode(resources []Resource) (*mof.Document, error) {
+	comp := &compiler{
+		c:      c,
+		doc:    &mof.Document{},
+		counts: make(map[string]int),
+	}
+
+	ids := make(map[string]bool)
+	for _, r := range resources {
+		if r.Type == "" || r.Name == "" {
+			return nil, Error{Resource: r.ID(), Msg: "resource must have a type and a name"}
+		}
+		id := strings.ToLower(r.ID())
+		if ids[id] {
+			return nil, Error{Resource: r.ID(), Msg: "resource is declared more than once"}
+		}
+		ids[id] = true
+	}
+	if err := checkDependencies(resources, ids); err != nil {
+		return nil, err
+	}
+
+	for i := range resources {
+		if err := comp.resource(&resources[i]); err != nil {
+			return nil, err
+		}
+	}
+
+	comp.doc.Instances = append(comp.doc.Instances, &mof.Instance{
+		Class: mof.ConfigurationDocumentClass,
+		Properties: []mof.Property{
+			{Name: "Version", Value: "2.0.0"},
+			{Name: "MinimumCompatibleVersion", Value: "1.0.0"},
+			{Name: "CompatibleVersionAdditionalProperties", Value: []interface{}{"Omi_BaseResource:ConfigurationName"}},
+			{Name: "Name", Value: c.Name},
+		},
+	})
+	return comp.doc, nil
+}
+
+// checkDependencies checks that every resource's dependencies are declared,
+// and that there are no cycles.
+func checkDependencies(resources []Resource, ids map[string]bool) error {
+	deps := make(map[string][]string)
+	for _, r := range resources {
+		for _, dep := range r.DependsOn {
+			if !ids[strings.ToLower(dep)] {
+				return Error{Resource: r.ID(), Msg: fmt.Sprintf("depends on undeclared resource %s", dep)}
+			}
+			deps[strings.ToLower(r.ID())] = append(deps[strings.ToLower(r.ID())], strings.ToLower(dep))
+		}
+	}
+
+	const (
+		visiting = 1
+		done     = 2
+	)
+	state := make(map[string]int)
+	var visit func(id string) bool
+	visit = func(id string) bool {
+		switch state[id] {
+		case visiting:
+			return false
+		case done:
+			return true
+		}
+		state[id] = visiting
+		for _, dep := range deps[id] {
+			if !visit(dep) {
+				return false
+			}
+		}
+		state[id] = done
+		return true
+	}
+	for _, r := range resources {
+		if !visit(strings.ToLower(r.ID())) {
+			return Error{Resource: r.ID(), Msg: "dependencies form a cycle"}
+		}
+	}
+	return nil
+}
+
+// alias returns a new alias for an instance of class, in the same format as
+// PowerShell, e.g. "MSFT_RoleResource1ref".
+func (comp *compiler) alias(class string) string {
+	comp.counts[strings.ToLower(class)]++
+	return fmt.Sprintf("%s%dref", class, comp.counts[strings.ToLower(class)])
+}
+
+func (comp *compiler) resource(r *Resource) error {
+	fail := func(format string, args ...interface{}) error {
+		return Error{Resource: r.ID(), Msg: fmt.Sprintf(format, args...)}
+	}
+
+	module, version, class, err := comp.c.resolve(r)
+	if err != nil {
+		return fail("%s", err)
+	}
+
+	inst := &mof.Instance{Class: class, Alias: comp.alias(class)}
+	inst.Properties = append(inst.Properties, mof.Property{Name: "ResourceID", Value: r.ID()})
+
+	props, err := comp.properties(r.Properties)
+	if err != nil {
+		return fail("%s", err)
+	}
+	for _, p := range props {
+		switch strings.ToLower(p.Name) {
+		case "resourceid", "dependson", "modulename", "moduleversion", "configurationname":
+			return fail("property %s is set by the compiler", p.Name)
+		}
+	}
+	inst.Properties = append(inst.Properties, props...)
+
+	if len(r.DependsOn) > 0 {
+		var deps []interface{}
+		for _, dep := range r.DependsOn {
+			deps = append(deps, dep)
+		}
+		inst.Properties = append(inst.Properties, mof.Property{Name: "DependsOn", Value: deps})
+	}
+	inst.Properties = append(inst.Properties,
+		mof.Property{Name: "ModuleName", Value: module},
+		mof.Property{Name: "ModuleVersion", Value: version},
+		mof.Property{Name: "ConfigurationName", Value: comp.c.Name},
+	)
+
+	comp.doc.Instances = append(comp.doc.Instances, inst)
+	return nil
+}
+
+// resolve returns the module, module version and class of a resource.
+func (c *Configuration) resolve(r *Resource) (module, version, class string, err error) {
+	imports := c.Imports
+	if r.Module != "" {
+		imports = nil
+		for _, imp := range c.Imports {
+			if strings.EqualFold(imp.Name, r.Module) {
+				imports = append(imports, imp)
+			}
+		}
+		if len(imports) == 0 && !strings.EqualFold(r.Module, builtinModule) {
+			return "", "", "", fmt.Errorf("module %s isn't imported", r.Module)
+		}
+	}
+
+	// Modules that list the resource's class.
+	var matches []Import
+	for _, imp := range imports {
+		for typ := range imp.Classes {
+			if strings.EqualFold(typ, r.Type) {
+				matches = append(matches, imp)
+				break
+			}
+		}
+	}
+
+	switch {
+	case len(matches) > 1:
+		return "", "", "", fmt.Errorf("resource type %s is provided by more than one module; set module", r.Type)
+	case len(matches) == 1:
+		imp := matches[0]
+		for typ, cls := range imp.Classes {
+			if strings.EqualFold(typ, r.Type) {
+				class = cls
+			}
+		}
+		module, version = imp.Name, imp.Version
+	case builtinClasses[strings.ToLower(r.Type)] != "" && (r.Module == "" || strings.EqualFold(r.Module, builtinModule)):
+		class = builtinClasses[strings.ToLower(r.Type)]
+		module, version = builtinModule, builtinVersion
+		for _, imp := range c.Imports {
+			if strings.EqualFold(imp.Name, builtinModule) && imp.Version != "" {
+				version = imp.Version
+			}
+		}
+	case r.Module != "" && len(imports) == 1:
+		module, version = imports[0].Name, imports[0].Version
+	default:
+		return "", "", "", fmt.Errorf("unknown resource type %s; set module and class, or list it in the module's classes", r.Type)
+	}
+
+	if r.Class != "" {
+		class = r.Class
+	}
+	if class == "" {
+		return "", "", "", fmt.Errorf("unknown class for resource type %s; set class, or list it in the module's classes", r.Type)
+	}
+	if version == "" {
+		return "", "", "", fmt.Errorf("module %s has no version", module)
+	}
+	return module, version, class, nil
+}
+
+// properties converts a map of property values to MOF properties, sorted by
+// name.
+func (comp *compiler) properties(values map[string]interface{}) ([]mof.Property, error) {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		return strings.ToLower(names[i]) < strings.ToLower(names[j])
+	})
+
+	var ret []mof.Property
+	for i, name := range names {
+		if i > 0 && strings.EqualFold(names[i-1], name) {
+			return nil, fmt.Errorf("property %s is set more than once", name)
+		}
+		v, err := comp.value(values[name], false)
+		if err != nil {
+			return nil, fmt.Errorf("property %s: %s", name, err)
+		}
+		ret = append(ret, mof.Property{Name: name, Value: v})
+	}
+	return ret, nil
+}
+
+// value converts a YAML value to a MOF value. Embedded instances and
+// credentials are added to the document, and referred to by alias.
+func (comp *compiler) value(v interface{}, inList bool) (interface{}, error) {
+	switch v := v.(type) {
+	case nil, string, bool, float64:
+		return v, nil
+	case int:
+		return int64(v), nil
+	case int64:
+		return v, nil
+	case uint64:
+		if v > 1<<63-1 {
+			return nil, fmt.Errorf("%d is out of range", v)
+		}
+		return int64(v), nil
+	case []interface{}:
+		if inList {
+			return nil, fmt.Errorf("lists can't be nested")
+		}
+		ret := make([]interface{}, 0, len(v))
+		for _, elem := range v {
+			converted, err := comp.value(elem, true)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, converted)
+		}
+		return ret, nil
+	case map[string]interface{}:
+		return comp.instance(v)
+	}
+	return nil, fmt.Errorf("unsupported value %v", v)
+}
+
+// instance adds an embedded instance or credential to the document, and
+// returns a reference to it.
+func (comp *compiler) instance(m map[string]interface{}) (interface{}, error) {
+	keys := make(map[string]interface{})
+	for k, v := range m {
+		keys[strings.ToLower(k)] = v
+	}
+
+	var inst *mof.Instance
+	switch {
+	case len(keys) == 2 && keys["username"] != nil && keys["password"] != nil:
+		username, ok1 := keys["username"].(string)
+		password, ok2 := keys["password"].(string)
+		if !ok1 || !ok2 {
+			return nil, fmt.Errorf("credential username and password must be strings")
+		}
+		inst = &mof.Instance{
+			Class: "MSFT_Credential",
+			Properties: []mof.Property{
+				{Name: "UserName", Value: username},
+				{Name: "Password", Value: password},
+			},
+		}
+
+	case keys["class"] != nil && len(keys) <= 2:
+		class, ok := keys["class"].(string)
+		if !ok || class == "" {
+			return nil, fmt.Errorf("instance class must be a string")
+		}
+		props, _ := keys["properties"].(map[string]interface{})
+		if keys["properties"] != nil && props == nil {
+			return nil, fmt.Errorf("instance properties must be a mapping")
+		}
+		converted, err := comp.properties(props)
+		if err != nil {
+			return nil, err
+		}
+		inst = &mof.Instance{Class: class, Properties: converted}
+
+	default:
+		return nil, fmt.Errorf("mappings must be instances, with class and properties, or credentials, with username and password")
+	}
+
+	inst.Alias = comp.alias(inst.Class)
+	comp.doc.Instances = append(comp.doc.Instances, inst)
+	return mof.Reference(inst.Alias), nil
+}
@@ -0,0 +1,90 @@
+package compile
+
+import (
+	"flag"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+)
+
+var update = flag.Bool("update", false, "update the expected output in testdata")
+
+func compileFile(t *testing.T, name string) []Output {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+	c, err := Parse(data)
+	require.NoError(t, err, "parsing %s", name)
+	outputs, err := Compile(c)
+	require.NoError(t, err, "compiling %s", name)
+	return outputs
+}
+
+func TestCompile(t *testing.T) {
+	outputs := compileFile(t, "WebServer.yaml")
+	require.Len(t, outputs, 2)
+	assert.Equal(t, "web01", outputs[0].Node)
+	assert.Equal(t, "web02", outputs[1].Node)
+
+	data, err := outputs[0].Marshal()
+	require.NoError(t, err)
+	golden := filepath.Join("testdata", "web01.mof")
+	if *update {
+		require.NoError(t, ioutil.WriteFile(golden, data, 0644))
+	}
+	want, err := ioutil.ReadFile(golden)
+	require.NoError(t, err)
+	assert.Equal(t, string(want), string(data))
+
+	// The output is a valid document.
+	doc, err := mof.Parse(data)
+	require.NoError(t, err)
+	var ids []string
+	for _, r := range doc.Resources() {
+		ids = append(ids, r.ResourceID())
+	}
+	assert.Equal(t, []string{
+		"[WindowsFeature]IIS",
+		"[WindowsFeature]AspNet45",
+		"[xWebsite]DefaultSite",
+		"[User]AppPool",
+	}, ids)
+	assert.Equal(t, "WebServer", doc.ConfigurationDocument().String("Name"))
+
+	// JSON compiles to exactly the same document.
+	fromJSON := compileFile(t, "WebServer.json")
+	jsonData, err := fromJSON[0].Marshal()
+	require.NoError(t, err)
+	assert.Equal(t, string(data), string(jsonData))
+}
+
+func TestCompileErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Input string
+	}{
+		{"no name", `resources: []`},
+		{"unknown field", "configuration: C\nresource: []"},
+		{"unknown type", "configuration: C\nresources: [{type: xFoo, name: a}]"},
+		{"missing version", "configuration: C\nimports: [{name: xFoo, classes: {xFoo: MSFT_xFoo}}]\nresources: [{type: xFoo, name: a}]"},
+		{"duplicate resource", "configuration: C\nresources: [{type: File, name: a}, {type: file, name: A}]"},
+		{"undeclared dependency", "configuration: C\nresources: [{type: File, name: a, dependsOn: ['[File]b']}]"},
+		{"cycle", "configuration: C\nresources: [{type: File, name: a, dependsOn: ['[File]b']}, {type: File, name: b, dependsOn: ['[File]a']}]"},
+		{"reserved property", "configuration: C\nresources: [{type: File, name: a, properties: {ModuleName: x}}]"},
+		{"nested list", "configuration: C\nresources: [{type: File, name: a, properties: {X: [[1]]}}]"},
+		{"bad mapping", "configuration: C\nresources: [{type: File, name: a, properties: {X: {a: 1}}}]"},
+		{"duplicate node", "configuration: C\nnodes: [{name: a}, {names: [A]}]"},
+	}
+
+	for _, tc := range testCases {
+		c, err := Parse([]byte(tc.Input))
+		if err == nil {
+			_, err = Compile(c)
+		}
+		assert.Error(t, err, "test case %q", tc.Name)
+	}
+}
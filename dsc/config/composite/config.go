@@ -0,0 +1,180 @@
+// Package composite implements a ConfigurationRepository that chains several
+// other repositories together; e.g. a local repository can be used to
+// override a few configurations from a shared one.
+//
+// Configurations and modules are looked up in each layer in order, and the
+// first layer that has them serves them.
+package composite
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/util"
+)
+
+// Layer is a single repository in a composite repository.
+type Layer struct {
+	// The name of the layer, which is recorded in the Source of each
+	// response that it serves.
+	Name string
+
+	Repo dsc.ConfigurationRepository
+}
+
+type ConfigurationRepository struct {
+	layers []Layer
+}
+
+// New creates a ConfigurationRepository from the given layers, with the
+// first taking precedence.
+func New(layers ...Layer) *ConfigurationRepository {
+	return &ConfigurationRepository{layers}
+}
+
+// source describes which layer served a response.
+func source(layer Layer, inner string) string {
+	if inner == "" {
+		return layer.Name
+	}
+	return fmt.Sprintf("%s (%s)", layer.Name, inner)
+}
+
+func (c *ConfigurationRepository) RegisterDscAgent(
+	ctx context.Context,
+	req types.RegisterDscAgentRequest,
+) (*types.RegisterDscAgentResponse, error) {
+	// Any layer may serve the agent, so register with all of them.
+	for _, layer := range c.layers {
+		if _, err := layer.Repo.RegisterDscAgent(ctx, req); err != nil {
+			return nil, err
+		}
+	}
+	return nil, nil
+}
+
+func (c *ConfigurationRepository) GetConfiguration(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (*types.GetConfigurationResponse, error) {
+	for _, layer := range c.layers {
+		resp, err := layer.Repo.GetConfiguration(ctx, req)
+		if _, ok := err.(types.ConfigurationNotFoundError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Source = source(layer, resp.Source)
+		return resp, nil
+	}
+
+	return nil, types.ConfigurationNotFoundError{
+		AgentID: req.AgentID,
+		Name:    req.ConfigurationName,
+	}
+}
+
+// GetConfigurationHash implements the util.ConfigRepoHasher interface. The
+// hash comes from the same layer that would serve the configuration, using
+// that layer's GetConfigurationHash method if it has one.
+func (c *ConfigurationRepository) GetConfigurationHash(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (string, string, error) {
+	for _, layer := range c.layers {
+		hash, algo, err := util.GetConfigHash(ctx, layer.Repo, req.AgentID, req.ConfigurationName)
+		if _, ok := err.(types.ConfigurationNotFoundError); ok {
+			continue
+		}
+		return hash, algo, err
+	}
+
+	return "", "", types.ConfigurationNotFoundError{
+		AgentID: req.AgentID,
+		Name:    req.ConfigurationName,
+	}
+}
+
+func (c *ConfigurationRepository) GetModule(
+	ctx context.Context,
+	req types.GetModuleRequest,
+) (*types.GetModuleResponse, error) {
+	for _, layer := range c.layers {
+		resp, err := layer.Repo.GetModule(ctx, req)
+		if _, ok := err.(types.ModuleNotFoundError); ok {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		resp.Source = source(layer, resp.Source)
+		return resp, nil
+	}
+
+	return nil, types.ModuleNotFoundError{
+		AgentID: req.AgentID,
+		Name:    req.Name,
+		Version: req.Version,
+	}
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface. The
+// versions from all layers are combined, and the pinned default version is
+// taken from the first layer that has one.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	ret := &types.ListModuleVersionsResponse{}
+	seen := make(map[string]bool)
+
+	for _, layer := range c.layers {
+		lister, ok := layer.Repo.(dsc.ModuleVersionLister)
+		if !ok {
+			continue
+		}
+
+		resp, err := lister.ListModuleVersions(ctx, req)
+		if err != nil {
+			return nil, err
+		}
+		for _, version := range resp.Versions {
+			if !seen[version] {
+				seen[version] = true
+				ret.Versions = append(ret.Versions, version)
+			}
+		}
+		if ret.Default == "" {
+			ret.Default = resp.Default
+		}
+	}
+	return ret, nil
+}
+
+// GetMetaConfiguration implements the dsc.MetaConfigurationRepository
+// interface, using the first layer that has a meta-configuration for the
+// agent.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	for _, layer := range c.layers {
+		meta, ok := layer.Repo.(dsc.MetaConfigurationRepository)
+		if !ok {
+			continue
+		}
+
+		resp, err := meta.GetMetaConfiguration(ctx, req)
+		if err != nil || resp != nil {
+			return resp, err
+		}
+	}
+
+	// No meta-configuration for this agent
+	return nil, nil
+}
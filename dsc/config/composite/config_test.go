@@ -0,0 +1,75 @@
+package composite
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/local"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// newLocal creates a local repository containing the given files.
+func newLocal(t *testing.T, files map[string]string) *local.ConfigurationRepository {
+	root, err := ioutil.TempDir("", "dsc-composite")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(root) })
+
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+	return local.New(root)
+}
+
+func TestComposite(t *testing.T) {
+	ctx := context.Background()
+	overlay := newLocal(t, map[string]string{
+		"config/web": "overridden web",
+	})
+	base := newLocal(t, map[string]string{
+		"config/web": "web",
+		"config/db":  "db",
+	})
+	repo := New(Layer{"overlay", overlay}, Layer{"base", base})
+
+	testCases := []struct {
+		Name    string
+		Content string
+		Source  string
+	}{
+		{"web", "overridden web", "overlay"},
+		{"db", "db", "base"},
+	}
+	for _, tc := range testCases {
+		req := types.GetConfigurationRequest{ConfigurationName: tc.Name}
+
+		resp, err := repo.GetConfiguration(ctx, req)
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(resp.Content)
+		require.NoError(t, err)
+		resp.Content.(io.Closer).Close()
+
+		assert.Equal(t, tc.Content, string(content), "configuration %q", tc.Name)
+		assert.Equal(t, tc.Source, resp.Source, "configuration %q", tc.Name)
+
+		// The hash must match the configuration that is served.
+		hash, _, err := repo.GetConfigurationHash(ctx, req)
+		require.NoError(t, err)
+		assert.Equal(t, resp.Checksum, hash, "configuration %q", tc.Name)
+	}
+
+	_, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "missing"})
+	assert.IsType(t, types.ConfigurationNotFoundError{}, err)
+	_, _, err = repo.GetConfigurationHash(ctx, types.GetConfigurationRequest{ConfigurationName: "missing"})
+	assert.IsType(t, types.ConfigurationNotFoundError{}, err)
+	_, err = repo.GetModule(ctx, types.GetModuleRequest{Name: "missing", Version: "1.0"})
+	assert.IsType(t, types.ModuleNotFoundError{}, err)
+}
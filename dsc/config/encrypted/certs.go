@@ -0,0 +1,55 @@
+package encrypted
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/fsmock"
+)
+
+// DirCertificates is a CertificateSource that reads certificates from files
+// in a directory named by their thumbprint, e.g.:
+//
+//     5A2E0C4B3D8E9F1A7B6C5D4E3F2A1B0C9D8E7F6A.cer
+//
+// Files may be DER-encoded, as written by Export-Certificate, or PEM-encoded.
+// File names are upper-cased, and files that don't exist are ignored.
+type DirCertificates struct {
+	dir string
+	fs  fsmock.FileSystem
+}
+
+func NewDirCertificates(dir string) *DirCertificates {
+	return &DirCertificates{dir: dir, fs: fsmock.OSFS{}}
+}
+
+func (d *DirCertificates) GetCertificate(ctx context.Context, agentId, thumbprint string) (*x509.Certificate, error) {
+	// Thumbprints come from the agent, so don't let them escape the
+	// directory.
+	if thumbprint == "" || strings.ContainsAny(thumbprint, `/\.`) {
+		return nil, nil
+	}
+
+	f, err := d.fs.Open(filepath.Join(d.dir, strings.ToUpper(thumbprint)+".cer"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	data, err := ioutil.ReadAll(f)
+	if err != nil {
+		return nil, err
+	}
+	if block, _ := pem.Decode(data); block != nil {
+		data = block.Bytes
+	}
+	return x509.ParseCertificate(data)
+}
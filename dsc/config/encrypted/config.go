@@ -0,0 +1,520 @@
+// Package encrypted implements a ConfigurationRepository that encrypts the
+// credentials in the configurations of another repository separately for
+// each agent, so that plaintext passwords never leave the server.
+//
+// Configurations compiled with PSDscAllowPlainTextPassword contain
+// MSFT_Credential instances whose Password is plaintext. Each such password
+// is replaced with a CMS message encrypted to the certificate that the agent
+// registered with, as Protect-CmsMessage would, and the document is marked
+// as containing encrypted passwords; the LCM decrypts them with the private
+// key of its CertificateID certificate, which must therefore be the
+// certificate that it registers with.
+//
+// So that an agent isn't told to reapply its configuration every time it's
+// encrypted, encryption is deterministic: the content-encryption key and IV
+// of each password are derived from the password and the thumbprint of the
+// agent's certificate with a secret key, so an encrypted configuration only
+// changes when the configuration or the certificate does. Pass the same key
+// with WithKey to keep checksums stable across restarts. As a result, anyone
+// who can read the configurations served to an agent can tell which of its
+// passwords are the same, but not what they are. Encrypted configurations
+// are also cached, up to a fixed total size.
+//
+// The LCM doesn't send its certificate at registration, so in practice the
+// certificates must be provided with WithCertificates.
+//
+// The encrypted passwords haven't yet been decrypted by a Windows LCM; see
+// the pkcs7 package. Test them on a node before relying on them.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/lru"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/pkcs7"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/util"
+)
+
+const (
+	// The class of the instances that hold credentials.
+	credentialClass = "MSFT_Credential"
+
+	// The markers of an encrypted password, as written by
+	// Protect-CmsMessage.
+	cmsBegin = "-----BEGIN CMS-----"
+	cmsEnd   = "-----END CMS-----"
+
+	// The properties of the OMI_ConfigurationDocument that tell the LCM
+	// that passwords are encrypted, and which certificate they are
+	// encrypted to.
+	contentTypeProperty = "ContentType"
+	contentType         = "PasswordEncrypted"
+	thumbprintProperty  = "EncryptionCertificateThumbprint"
+)
+
+// DefaultCacheSize is the default maximum total size of the encrypted
+// configurations that are cached, in bytes.
+const DefaultCacheSize = 64 << 20
+
+// CertificateSource is the interface that should be implemented in order to
+// provide agents' certificates when they can't be read from their
+// registrations. The LCM doesn't send its certificate at registration, only
+// the certificate's thumbprint and details, so the certificates must be
+// exported from the nodes.
+type CertificateSource interface {
+	// GetCertificate returns the certificate with the given thumbprint
+	// for an agent. If there is none, it should return a nil certificate
+	// and a nil error.
+	GetCertificate(ctx context.Context, agentId, thumbprint string) (*x509.Certificate, error)
+}
+
+// EncryptionError is returned when a configuration's credentials can't be
+// encrypted for an agent, e.g. because its certificate isn't known.
+// Configurations that contain credentials are never served unencrypted.
+type EncryptionError struct {
+	AgentID string
+	Name    string
+	Err     error
+}
+
+func (e EncryptionError) Error() string {
+	return fmt.Sprintf("encrypted: error encrypting configuration %q for agent %q: %s", e.Name, e.AgentID, e.Err)
+}
+
+type ConfigurationRepository struct {
+	base          dsc.ConfigurationRepository
+	registrations dsc.RegistrationGetter
+	certs         CertificateSource
+	key           []byte
+	cacheSize     int64
+
+	// Encrypted configurations, keyed by cacheKey. Configurations without
+	// credentials are cached with an empty agent ID and thumbprint, since
+	// they're served unchanged to every agent. Since encryption is
+	// deterministic, evicting an entry doesn't change its checksum.
+	cache *lru.Cache
+}
+
+type cacheKey struct {
+	agentId    string
+	thumbprint string
+	checksum   string
+}
+
+type entry struct {
+	content  []byte
+	checksum string
+}
+
+// Option configures a ConfigurationRepository.
+type Option func(*ConfigurationRepository)
+
+// WithCertificates sets a source of agents' certificates, which is consulted
+// before the registration.
+func WithCertificates(certs CertificateSource) Option {
+	return func(c *ConfigurationRepository) {
+		c.certs = certs
+	}
+}
+
+// WithKey sets the secret key from which passwords' content-encryption keys
+// are derived. It should be at least 32 random bytes, and kept as secret as
+// the passwords themselves. By default, a random key is generated, so
+// encrypted configurations, and their checksums, change when the server
+// restarts.
+func WithKey(key []byte) Option {
+	return func(c *ConfigurationRepository) {
+		c.key = key
+	}
+}
+
+// WithCacheSize sets the maximum total size of the encrypted configurations
+// that are cached, in bytes. The default is DefaultCacheSize.
+func WithCacheSize(size int64) Option {
+	return func(c *ConfigurationRepository) {
+		c.cacheSize = size
+	}
+}
+
+// New creates a ConfigurationRepository that encrypts the credentials in the
+// configurations of base, to the certificates that agents registered with as
+// returned by registrations.
+func New(base dsc.ConfigurationRepository, registrations dsc.RegistrationGetter, opts ...Option) *ConfigurationRepository {
+	ret := &ConfigurationRepository{
+		base:          base,
+		registrations: registrations,
+		cacheSize:     DefaultCacheSize,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	if ret.key == nil {
+		ret.key = make([]byte, 32)
+		if _, err := rand.Read(ret.key); err != nil {
+			panic(err)
+		}
+	}
+	ret.cache = lru.New(ret.cacheSize)
+	return ret
+}
+
+func (c *ConfigurationRepository) RegisterDscAgent(
+	ctx context.Context,
+	req types.RegisterDscAgentRequest,
+) (*types.RegisterDscAgentResponse, error) {
+	return c.base.RegisterDscAgent(ctx, req)
+}
+
+func (c *ConfigurationRepository) GetConfiguration(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (*types.GetConfigurationResponse, error) {
+	resp, err := c.base.GetConfiguration(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if cl, ok := resp.Content.(io.Closer); ok {
+		defer cl.Close()
+	}
+
+	data, err := ioutil.ReadAll(resp.Content)
+	if err != nil {
+		return nil, err
+	}
+	sum := resp.Checksum
+	if resp.ChecksumAlgorithm != checksum.SHA256 || sum == "" {
+		sum = checksum.SumBytes(data)
+	}
+	sum = checksum.Normalize(sum)
+
+	info, thumbprint, err := c.certificateInfo(ctx, req.AgentID)
+	if err != nil {
+		return nil, err
+	}
+	if e, ok := c.cached(req.AgentID, thumbprint, sum); ok {
+		return response(e, resp.Source), nil
+	}
+
+	e, shared, err := c.encrypt(ctx, req, info, data)
+	if err != nil {
+		return nil, err
+	}
+
+	key := cacheKey{req.AgentID, thumbprint, sum}
+	if shared {
+		key = cacheKey{checksum: sum}
+	}
+	c.cache.Add(key, e, int64(len(e.content)))
+	return response(e, resp.Source), nil
+}
+
+func response(e entry, source string) *types.GetConfigurationResponse {
+	return &types.GetConfigurationResponse{
+		Content:           bytes.NewReader(e.content),
+		Checksum:          e.checksum,
+		ChecksumAlgorithm: checksum.SHA256,
+		Source:            source,
+	}
+}
+
+// GetConfigurationHash implements the util.ConfigRepoHasher interface. If the
+// underlying repository reports the hash of a configuration that has already
+// been served to the agent, the checksum of the encrypted configuration is
+// returned without fetching it; otherwise the empty string is returned, so
+// that callers fall back to GetConfiguration.
+func (c *ConfigurationRepository) GetConfigurationHash(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (string, string, error) {
+	hasher, ok := c.base.(util.ConfigRepoHasher)
+	if !ok {
+		return "", "", nil
+	}
+
+	hash, algo, err := hasher.GetConfigurationHash(ctx, req)
+	if err != nil || hash == "" || algo != checksum.SHA256 {
+		return "", "", err
+	}
+
+	_, thumbprint, err := c.certificateInfo(ctx, req.AgentID)
+	if err != nil {
+		return "", "", err
+	}
+	e, ok := c.cached(req.AgentID, thumbprint, checksum.Normalize(hash))
+	if !ok {
+		return "", "", nil
+	}
+	return e.checksum, checksum.SHA256, nil
+}
+
+// cached returns the cached configuration for an agent, or the configuration
+// shared by all agents if it has no credentials.
+func (c *ConfigurationRepository) cached(agentId, thumbprint, sum string) (entry, bool) {
+	if e, ok := c.cache.Get(cacheKey{checksum: sum}); ok {
+		return e.(entry), true
+	}
+	if e, ok := c.cache.Get(cacheKey{agentId, thumbprint, sum}); ok {
+		return e.(entry), true
+	}
+	return entry{}, false
+}
+
+// certificateInfo returns the details of the certificate that an agent
+// registered with, and its upper-cased thumbprint.
+func (c *ConfigurationRepository) certificateInfo(ctx context.Context, agentId string) (types.CertificateInformation, string, error) {
+	reg, err := c.registrations.GetRegistration(ctx, agentId)
+	if err != nil {
+		return types.CertificateInformation{}, "", err
+	}
+	info := reg.RegistrationInformation.CertificateInformation
+	if info.Thumbprint == nil {
+		return info, "", nil
+	}
+	return info, strings.ToUpper(*info.Thumbprint), nil
+}
+
+// encrypt encrypts the credentials in a configuration for an agent. If the
+// configuration has none, it's returned unchanged, and shared is true.
+func (c *ConfigurationRepository) encrypt(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+	info types.CertificateInformation,
+	data []byte,
+) (e entry, shared bool, err error) {
+	fail := func(err error) (entry, bool, error) {
+		return entry{}, false, EncryptionError{
+			AgentID: req.AgentID,
+			Name:    req.ConfigurationName,
+			Err:     err,
+		}
+	}
+
+	doc, err := mof.Parse(data)
+	if err != nil {
+		return fail(err)
+	}
+	creds := Credentials(doc)
+	if len(creds) == 0 {
+		return entry{content: data, checksum: checksum.SumBytes(data)}, true, nil
+	}
+
+	// Without the document's properties, the LCM wouldn't know to
+	// decrypt the passwords.
+	cd := doc.ConfigurationDocument()
+	if cd == nil {
+		return fail(errors.New("configuration has no OMI_ConfigurationDocument instance"))
+	}
+
+	cert, err := c.certificate(ctx, req.AgentID, info)
+	if err != nil {
+		return fail(err)
+	}
+
+	for _, cred := range creds {
+		password := cred.String("Password")
+		armored, err := encryptWithRand(c.keyStream(password, cert), password, cert)
+		if err != nil {
+			return fail(err)
+		}
+		cred.Set("Password", armored)
+	}
+
+	cd.Set(contentTypeProperty, contentType)
+	cd.Set(thumbprintProperty, Thumbprint(cert))
+
+	encrypted, err := mof.Marshal(doc)
+	if err != nil {
+		return fail(err)
+	}
+	return entry{content: encrypted, checksum: checksum.SumBytes(encrypted)}, false, nil
+}
+
+// certificate returns the certificate that an agent registered with, from the
+// CertificateSource if there is one, or from the registration otherwise.
+func (c *ConfigurationRepository) certificate(ctx context.Context, agentId string, info types.CertificateInformation) (*x509.Certificate, error) {
+	if info.Thumbprint == nil || *info.Thumbprint == "" {
+		return nil, fmt.Errorf("agent didn't register a certificate")
+	}
+	thumbprint := *info.Thumbprint
+
+	if c.certs != nil {
+		cert, err := c.certs.GetCertificate(ctx, agentId, thumbprint)
+		if err != nil {
+			return nil, err
+		}
+		if cert != nil {
+			if !strings.EqualFold(Thumbprint(cert), thumbprint) {
+				return nil, fmt.Errorf("certificate source returned a certificate with thumbprint %s, not %s", Thumbprint(cert), thumbprint)
+			}
+			return cert, nil
+		}
+	}
+
+	// Some clients send the certificate itself as the PublicKey, but the
+	// LCM sends the name of a .NET type, so this usually fails.
+	if info.PublicKey != nil {
+		if der, err := base64.StdEncoding.DecodeString(*info.PublicKey); err == nil {
+			if cert, err := x509.ParseCertificate(der); err == nil && strings.EqualFold(Thumbprint(cert), thumbprint) {
+				return cert, nil
+			}
+		}
+	}
+	return nil, fmt.Errorf("no certificate found with thumbprint %s", thumbprint)
+}
+
+// Credentials returns the MSFT_Credential instances in a document, including
+// embedded ones, whose passwords aren't already encrypted.
+func Credentials(doc *mof.Document) []*mof.Instance {
+	var ret []*mof.Instance
+	var visit func(value interface{})
+	visit = func(value interface{}) {
+		switch v := value.(type) {
+		case *mof.Instance:
+			if strings.EqualFold(v.Class, credentialClass) {
+				if pw, ok := v.Get("Password"); ok && pw != nil && !strings.HasPrefix(v.String("Password"), cmsBegin) {
+					ret = append(ret, v)
+				}
+			}
+			for _, p := range v.Properties {
+				visit(p.Value)
+			}
+		case []interface{}:
+			for _, elem := range v {
+				visit(elem)
+			}
+		}
+	}
+	for _, inst := range doc.Instances {
+		visit(inst)
+	}
+	return ret
+}
+
+// Encrypt encrypts a password to a certificate, returning it as a CMS message
+// with the same armor as Protect-CmsMessage.
+func Encrypt(password string, cert *x509.Certificate) (string, error) {
+	return encryptWithRand(rand.Reader, password, cert)
+}
+
+func encryptWithRand(random io.Reader, password string, cert *x509.Certificate) (string, error) {
+	msg, err := pkcs7.EncryptWithRand(random, []byte(password), cert)
+	if err != nil {
+		return "", err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(cmsBegin + "\r\n")
+	encoded := base64.StdEncoding.EncodeToString(msg)
+	for len(encoded) > 0 {
+		n := 64
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		sb.WriteString(encoded[:n] + "\r\n")
+		encoded = encoded[n:]
+	}
+	sb.WriteString(cmsEnd)
+	return sb.String(), nil
+}
+
+// keyStream returns the stream of bytes from which a password's
+// content-encryption key, IV and key padding are read when it's encrypted to
+// a certificate: HMAC-SHA256 in counter mode, keyed by an HMAC of the
+// password and the certificate's thumbprint with the repository's key.
+func (c *ConfigurationRepository) keyStream(password string, cert *x509.Certificate) io.Reader {
+	mac := hmac.New(sha256.New, c.key)
+	mac.Write([]byte(Thumbprint(cert)))
+	mac.Write([]byte{0})
+	mac.Write([]byte(password))
+	return &hmacStream{key: mac.Sum(nil)}
+}
+
+// hmacStream is an endless stream of the HMACs of successive counter values.
+type hmacStream struct {
+	key     []byte
+	counter uint64
+	buf     []byte
+}
+
+func (s *hmacStream) Read(p []byte) (int, error) {
+	n := 0
+	for n < len(p) {
+		if len(s.buf) == 0 {
+			mac := hmac.New(sha256.New, s.key)
+			var ctr [8]byte
+			binary.BigEndian.PutUint64(ctr[:], s.counter)
+			mac.Write(ctr[:])
+			s.buf = mac.Sum(nil)
+			s.counter++
+		}
+		copied := copy(p[n:], s.buf)
+		s.buf = s.buf[copied:]
+		n += copied
+	}
+	return n, nil
+}
+
+// Thumbprint returns the thumbprint of a certificate, in the format the LCM
+// sends at registration.
+func Thumbprint(cert *x509.Certificate) string {
+	h := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+func (c *ConfigurationRepository) GetModule(
+	ctx context.Context,
+	req types.GetModuleRequest,
+) (*types.GetModuleResponse, error) {
+	return c.base.GetModule(ctx, req)
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface if the
+// underlying repository does.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	if lister, ok := c.base.(dsc.ModuleVersionLister); ok {
+		return lister.ListModuleVersions(ctx, req)
+	}
+	return &types.ListModuleVersionsResponse{}, nil
+}
+
+// ListConfigurations implements the dsc.ConfigurationLister interface if the
+// underlying repository does.
+func (c *ConfigurationRepository) ListConfigurations(ctx context.Context) ([]string, error) {
+	if lister, ok := c.base.(dsc.ConfigurationLister); ok {
+		return lister.ListConfigurations(ctx)
+	}
+	return nil, nil
+}
+
+// GetMetaConfiguration implements the dsc.MetaConfigurationRepository
+// interface if the underlying repository does.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	if meta, ok := c.base.(dsc.MetaConfigurationRepository); ok {
+		return meta.GetMetaConfiguration(ctx, req)
+	}
+	return nil, nil
+}
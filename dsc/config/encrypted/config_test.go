@@ -0,0 +1,205 @@
+package encrypted
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/static"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/util"
+)
+
+const credentialsMOF = `instance of MSFT_Credential as $MSFT_Credential1ref
+{
+    UserName = "CORP\\svc-sql";
+    Password = "hunter2";
+};
+
+instance of MSFT_UserResource as $MSFT_UserResource1ref
+{
+    ResourceID = "[User]ServiceAccount";
+    UserName = "svc-sql";
+    Password = $MSFT_Credential1ref;
+    ModuleName = "PSDesiredStateConfiguration";
+    ModuleVersion = "1.0";
+};
+
+instance of OMI_ConfigurationDocument
+{
+    Version = "2.0.0";
+    Name = "Database";
+};
+`
+
+func newCertificate(t *testing.T) *x509.Certificate {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "DSC Encryption"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageKeyEncipherment | x509.KeyUsageDataEncipherment,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert
+}
+
+func TestEncrypted(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsc-encrypted")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	cert := newCertificate(t)
+	thumbprint := Thumbprint(cert)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, thumbprint+".cer"), cert.Raw, 0644))
+
+	// The LCM sends the name of a type instead of its public key.
+	publicKey := "U3lzdGVtLlNlY3VyaXR5LkNyeXB0b2dyYXBoeS5YNTA5Q2VydGlmaWNhdGVzLlB1YmxpY0tleQ=="
+	thumbprints := map[string]string{
+		"agent-1": strings.ToLower(thumbprint),
+		"agent-2": "0000000000000000000000000000000000000000",
+	}
+	registrations := dsc.RegistrationGetterFunc(func(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+		tp := thumbprints[agentId]
+		body := &types.RegisterDscAgentRequestBody{}
+		body.RegistrationInformation.CertificateInformation = types.CertificateInformation{
+			PublicKey:  &publicKey,
+			Thumbprint: &tp,
+		}
+		return body, nil
+	})
+
+	ctx := context.Background()
+	repo := New(static.New([]byte(credentialsMOF), nil), registrations, WithCertificates(NewDirCertificates(dir)))
+	req := types.GetConfigurationRequest{AgentID: "agent-1", ConfigurationName: "Database"}
+
+	resp, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(resp.Content)
+	require.NoError(t, err)
+	assert.NotContains(t, string(content), "hunter2")
+
+	doc, err := mof.Parse(content)
+	require.NoError(t, err)
+	password := doc.Instance("MSFT_Credential1ref").String("Password")
+	assert.True(t, strings.HasPrefix(password, cmsBegin+"\r\n"), "password: %q", password)
+	assert.True(t, strings.HasSuffix(password, cmsEnd), "password: %q", password)
+	assert.Equal(t, "CORP\\svc-sql", doc.Instance("MSFT_Credential1ref").String("UserName"))
+	assert.Equal(t, contentType, doc.ConfigurationDocument().String(contentTypeProperty))
+	assert.Equal(t, thumbprint, doc.ConfigurationDocument().String(thumbprintProperty))
+
+	// The agent is served the same encrypted configuration until it
+	// changes, so it isn't reapplied on every check.
+	again, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, resp.Checksum, again.Checksum)
+	hash, _, err := util.GetConfigHash(ctx, repo, "agent-1", "Database")
+	require.NoError(t, err)
+	assert.Equal(t, resp.Checksum, hash)
+
+	// Configurations aren't served unencrypted to agents without a known
+	// certificate.
+	_, err = repo.GetConfiguration(ctx, types.GetConfigurationRequest{AgentID: "agent-2", ConfigurationName: "Database"})
+	assert.IsType(t, EncryptionError{}, err)
+}
+
+func TestNoCredentials(t *testing.T) {
+	const plain = `instance of MSFT_FileDirectoryConfiguration as $MSFT_FileDirectoryConfiguration1ref
+{
+    ResourceID = "[File]Hello";
+    DestinationPath = "C:\\hello.txt";
+};
+`
+	registrations := dsc.RegistrationGetterFunc(func(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+		return &types.RegisterDscAgentRequestBody{}, nil
+	})
+	base := static.New([]byte(plain), nil)
+	repo := New(base, registrations)
+
+	resp, err := repo.GetConfiguration(context.Background(), types.GetConfigurationRequest{AgentID: "agent-1"})
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(resp.Content)
+	require.NoError(t, err)
+	assert.Equal(t, plain, string(content))
+
+	want, _, err := base.GetConfigurationHash(context.Background(), types.GetConfigurationRequest{})
+	require.NoError(t, err)
+	assert.Equal(t, want, resp.Checksum)
+}
+
+// certSource is a CertificateSource for a single certificate.
+type certSource struct {
+	cert *x509.Certificate
+}
+
+func (s certSource) GetCertificate(ctx context.Context, agentId, thumbprint string) (*x509.Certificate, error) {
+	return s.cert, nil
+}
+
+func registeredWith(cert *x509.Certificate) dsc.RegistrationGetter {
+	return dsc.RegistrationGetterFunc(func(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+		tp := Thumbprint(cert)
+		body := &types.RegisterDscAgentRequestBody{}
+		body.RegistrationInformation.CertificateInformation.Thumbprint = &tp
+		return body, nil
+	})
+}
+
+func TestDeterministic(t *testing.T) {
+	ctx := context.Background()
+	cert := newCertificate(t)
+	key := []byte("0123456789abcdef0123456789abcdef")
+	req := types.GetConfigurationRequest{AgentID: "agent-1", ConfigurationName: "Database"}
+	get := func(repo *ConfigurationRepository) string {
+		resp, err := repo.GetConfiguration(ctx, req)
+		require.NoError(t, err)
+		return resp.Checksum
+	}
+	newRepo := func(opts ...Option) *ConfigurationRepository {
+		opts = append(opts, WithCertificates(certSource{cert}))
+		return New(static.New([]byte(credentialsMOF), nil), registeredWith(cert), opts...)
+	}
+
+	// With the same key, a restarted server serves the same encrypted
+	// configuration.
+	first := get(newRepo(WithKey(key)))
+	assert.Equal(t, first, get(newRepo(WithKey(key))))
+	assert.NotEqual(t, first, get(newRepo(WithKey([]byte("another key")))))
+	assert.NotEqual(t, first, get(newRepo()))
+
+	// The cache is bounded, and configurations too large to cache are
+	// encrypted the same way every time.
+	repo := newRepo(WithKey(key), WithCacheSize(1))
+	assert.Equal(t, first, get(repo))
+	assert.Equal(t, first, get(repo))
+	assert.Equal(t, 0, repo.cache.Len())
+}
+
+func TestNoConfigurationDocument(t *testing.T) {
+	cert := newCertificate(t)
+	content := credentialsMOF[:strings.Index(credentialsMOF, "instance of OMI_ConfigurationDocument")]
+	repo := New(static.New([]byte(content), nil), registeredWith(cert), WithCertificates(certSource{cert}))
+
+	_, err := repo.GetConfiguration(context.Background(), types.GetConfigurationRequest{AgentID: "agent-1"})
+	assert.IsType(t, EncryptionError{}, err)
+}
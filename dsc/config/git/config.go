@@ -0,0 +1,407 @@
+// Package git implements a ConfigurationRepository that serves
+// configurations and modules from a ref in a local git repository, using the
+// same layout as the "local" ConfigurationRepository:
+//
+//     config/<configuration name>
+//     modules/<module name>/<module version>.zip
+//     meta/<agent id>
+//     meta/default
+//
+// The ref is resolved on every request, so new commits are picked up as soon
+// as they're in the repository; Fetch can be used to update a clone from its
+// remote. Each response records the commit that it was read from in its
+// Source field.
+//
+// This uses the git command-line tool, which must be in the PATH.
+package git
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os/exec"
+	"path"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/lru"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// DefaultHashCacheSize is the default number of blob checksums that are
+// cached.
+const DefaultHashCacheSize = 10000
+
+type ConfigurationRepository struct {
+	dir           string
+	ref           string
+	hashCacheSize int
+
+	// Checksums of blobs that we've already read, keyed by the blob ID.
+	// Since blobs are content-addressed, entries never need to be
+	// invalidated, but the least recently used are evicted so that old
+	// blobs don't accumulate as the ref moves.
+	hashes *lru.Cache
+}
+
+// Option configures a ConfigurationRepository.
+type Option func(*ConfigurationRepository)
+
+// WithHashCacheSize sets the number of blob checksums that are cached. A
+// blob whose checksum isn't cached is read into memory to hash it. The
+// default is DefaultHashCacheSize.
+func WithHashCacheSize(size int) Option {
+	return func(c *ConfigurationRepository) {
+		c.hashCacheSize = size
+	}
+}
+
+// New creates a ConfigurationRepository that serves files from the given ref
+// (e.g. a branch, tag or commit) of the git repository in dir.
+func New(dir, ref string, opts ...Option) *ConfigurationRepository {
+	ret := &ConfigurationRepository{
+		dir:           dir,
+		ref:           ref,
+		hashCacheSize: DefaultHashCacheSize,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	ret.hashes = lru.New(int64(ret.hashCacheSize))
+	return ret
+}
+
+// Fetch updates the repository from its default remote; if the ref is a
+// remote-tracking branch, e.g. "origin/master", new commits will be served
+// after this returns.
+func (c *ConfigurationRepository) Fetch(ctx context.Context) error {
+	_, err := c.git(ctx, "fetch", "--quiet")
+	return err
+}
+
+func (c *ConfigurationRepository) RegisterDscAgent(
+	ctx context.Context,
+	req types.RegisterDscAgentRequest,
+) (*types.RegisterDscAgentResponse, error) {
+	// No registration required
+	return nil, nil
+}
+
+func (c *ConfigurationRepository) GetConfiguration(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (*types.GetConfigurationResponse, error) {
+	commit, blob, err := c.lookup(ctx, configPath(req.ConfigurationName))
+	if err != nil {
+		return nil, err
+	}
+	if blob == "" {
+		return nil, types.ConfigurationNotFoundError{
+			AgentID: req.AgentID,
+			Name:    req.ConfigurationName,
+		}
+	}
+
+	content, checksum, err := c.openBlob(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &types.GetConfigurationResponse{
+		Content:           content,
+		Checksum:          checksum,
+		ChecksumAlgorithm: "SHA-256",
+		Source:            commit,
+	}
+	return ret, nil
+}
+
+// GetConfigurationHash implements the util.ConfigRepoHasher interface. Since
+// checksums are cached by blob ID, this usually doesn't need to read the
+// configuration.
+func (c *ConfigurationRepository) GetConfigurationHash(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (string, string, error) {
+	_, blob, err := c.lookup(ctx, configPath(req.ConfigurationName))
+	if err != nil {
+		return "", "", err
+	}
+	if blob == "" {
+		return "", "", types.ConfigurationNotFoundError{
+			AgentID: req.AgentID,
+			Name:    req.ConfigurationName,
+		}
+	}
+
+	checksum, ok := c.cachedHash(blob)
+	if !ok {
+		_, checksum, err = c.readBlob(ctx, blob)
+		if err != nil {
+			return "", "", err
+		}
+	}
+
+	return checksum, "SHA-256", nil
+}
+
+func (c *ConfigurationRepository) GetModule(
+	ctx context.Context,
+	req types.GetModuleRequest,
+) (*types.GetModuleResponse, error) {
+	// As with the local ConfigurationRepository, paths are lower-cased,
+	// since the server MUST use case-insensitive comparison to match
+	// ModuleName and ModuleVersion (section 3.7.5).
+	p := path.Join(
+		"modules",
+		strings.ToLower(req.Name),
+		strings.ToLower(req.Version)+".zip",
+	)
+	commit, blob, err := c.lookup(ctx, p)
+	if err != nil {
+		return nil, err
+	}
+	if blob == "" {
+		return nil, types.ModuleNotFoundError{
+			AgentID: req.AgentID,
+			Name:    req.Name,
+			Version: req.Version,
+		}
+	}
+
+	content, checksum, err := c.openBlob(ctx, blob)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &types.GetModuleResponse{
+		Content:           content,
+		Checksum:          checksum,
+		ChecksumAlgorithm: "SHA-256",
+		Source:            commit,
+	}
+	return ret, nil
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface. The
+// default version of a module can be pinned by committing it to a file named
+// "default" in the module's directory.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	commit, err := c.resolve(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	dir := path.Join("modules", strings.ToLower(req.Name)) + "/"
+	entries, err := c.lsTree(ctx, commit, dir)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &types.ListModuleVersionsResponse{}
+	for _, entry := range entries {
+		if entry.typ != "blob" {
+			continue
+		}
+
+		name := path.Base(entry.path)
+		switch {
+		case strings.HasSuffix(name, ".zip"):
+			ret.Versions = append(ret.Versions, strings.TrimSuffix(name, ".zip"))
+
+		case name == "default":
+			pinned, _, err := c.readBlob(ctx, entry.object)
+			if err != nil {
+				return nil, err
+			}
+			ret.Default = strings.TrimSpace(string(pinned))
+		}
+	}
+	return ret, nil
+}
+
+// GetMetaConfiguration returns the meta-configuration for an agent from the
+// "meta" directory, using a file named after the agent ID if one exists, and
+// a file named "default" otherwise.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	for _, name := range []string{strings.ToLower(req.AgentID), "default"} {
+		_, blob, err := c.lookup(ctx, path.Join("meta", name))
+		if err != nil {
+			return nil, err
+		}
+		if blob == "" {
+			continue
+		}
+
+		meta, checksum, err := c.readBlob(ctx, blob)
+		if err != nil {
+			return nil, err
+		}
+
+		ret := &types.GetMetaConfigurationResponse{
+			Content:           bytes.NewReader(meta),
+			Checksum:          checksum,
+			ChecksumAlgorithm: "SHA-256",
+		}
+		return ret, nil
+	}
+
+	// No meta-configuration for this agent
+	return nil, nil
+}
+
+func configPath(name string) string {
+	// From section 3.6.5:
+	//     The server MUST use case-insensitive ordinal comparison to match
+	//     the AgentId and ConfigurationName.
+	return path.Join("config", strings.ToLower(name))
+}
+
+// lookup resolves the ref to a commit, and returns the ID of the blob at the
+// given path in that commit, or the empty string if there is no such file.
+func (c *ConfigurationRepository) lookup(ctx context.Context, p string) (commit, blob string, err error) {
+	commit, err = c.resolve(ctx)
+	if err != nil {
+		return "", "", err
+	}
+
+	entries, err := c.lsTree(ctx, commit, p)
+	if err != nil {
+		return "", "", err
+	}
+
+	// Anything other than a blob (e.g. a directory) is treated the same as
+	// a missing file.
+	if len(entries) != 1 || entries[0].typ != "blob" {
+		return commit, "", nil
+	}
+	return commit, entries[0].object, nil
+}
+
+// resolve returns the commit that the ref currently points to.
+func (c *ConfigurationRepository) resolve(ctx context.Context) (string, error) {
+	out, err := c.git(ctx, "rev-parse", "--verify", "--quiet", c.ref+"^{commit}")
+	if err != nil {
+		return "", fmt.Errorf("git: error resolving ref %q: %s", c.ref, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+type treeEntry struct {
+	typ    string
+	object string
+	path   string
+}
+
+// lsTree lists the entries matching the given path in a commit; if the path
+// ends in a slash, it lists the contents of that directory.
+func (c *ConfigurationRepository) lsTree(ctx context.Context, commit, p string) ([]treeEntry, error) {
+	out, err := c.git(ctx, "ls-tree", "-z", "--full-tree", commit, "--", p)
+	if err != nil {
+		return nil, err
+	}
+
+	// Each entry is "<mode> SP <type> SP <object> TAB <file>", terminated
+	// by a NUL; there's no output if the path doesn't exist.
+	var entries []treeEntry
+	for _, entry := range strings.Split(string(out), "\x00") {
+		if entry == "" {
+			continue
+		}
+
+		parts := strings.SplitN(entry, "\t", 2)
+		fields := strings.Fields(parts[0])
+		if len(parts) != 2 || len(fields) != 3 {
+			return nil, fmt.Errorf("git: unexpected ls-tree output %q", entry)
+		}
+		entries = append(entries, treeEntry{
+			typ:    fields[1],
+			object: fields[2],
+			path:   parts[1],
+		})
+	}
+	return entries, nil
+}
+
+// openBlob returns a reader for the contents of a blob, and its checksum. If
+// we've seen the blob before, its contents are streamed from git; otherwise,
+// they're read into memory so that they can be hashed.
+func (c *ConfigurationRepository) openBlob(ctx context.Context, blob string) (io.ReadCloser, string, error) {
+	sum, ok := c.cachedHash(blob)
+	if !ok {
+		data, sum, err := c.readBlob(ctx, blob)
+		if err != nil {
+			return nil, "", err
+		}
+		return ioutil.NopCloser(bytes.NewReader(data)), sum, nil
+	}
+
+	cmd := exec.CommandContext(ctx, "git", "-C", c.dir, "cat-file", "blob", blob)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, "", err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, "", fmt.Errorf("git cat-file: %s", err)
+	}
+	return &cmdReader{stdout, cmd}, sum, nil
+}
+
+// cmdReader reads the output of a command, and waits for it to exit when
+// closed. If the command fails partway through, the content will be
+// truncated, which is detected when it's verified against its checksum.
+type cmdReader struct {
+	io.ReadCloser
+	cmd *exec.Cmd
+}
+
+func (r *cmdReader) Close() error {
+	r.ReadCloser.Close()
+	return r.cmd.Wait()
+}
+
+// readBlob returns the contents of a blob, and its checksum.
+func (c *ConfigurationRepository) readBlob(ctx context.Context, blob string) ([]byte, string, error) {
+	data, err := c.git(ctx, "cat-file", "blob", blob)
+	if err != nil {
+		return nil, "", err
+	}
+
+	sum := checksum.SumBytes(data)
+	c.hashes.Add(blob, sum, 1)
+	return data, sum, nil
+}
+
+// cachedHash returns the cached checksum of a blob, if there is one.
+func (c *ConfigurationRepository) cachedHash(blob string) (string, bool) {
+	sum, ok := c.hashes.Get(blob)
+	if !ok {
+		return "", false
+	}
+	return sum.(string), true
+}
+
+// git runs a git command in the repository, and returns its standard output.
+func (c *ConfigurationRepository) git(ctx context.Context, args ...string) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+
+	cmd := exec.CommandContext(ctx, "git", append([]string{"-C", c.dir}, args...)...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("git %s: %s: %s", args[0], err, msg)
+		}
+		return nil, fmt.Errorf("git %s: %s", args[0], err)
+	}
+
+	return stdout.Bytes(), nil
+}
@@ -0,0 +1,295 @@
+package git
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// testRepo is a temporary git repository.
+type testRepo struct {
+	t   *testing.T
+	dir string
+}
+
+func newTestRepo(t *testing.T) *testRepo {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir, err := ioutil.TempDir("", "dsc-git")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	r := &testRepo{t, dir}
+	r.git("init", "--quiet")
+	r.git("symbolic-ref", "HEAD", "refs/heads/main")
+	return r
+}
+
+func (r *testRepo) git(args ...string) string {
+	cmd := exec.Command("git", append([]string{"-C", r.dir}, args...)...)
+	cmd.Env = append(os.Environ(),
+		"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+		"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com",
+		"GIT_CONFIG_NOSYSTEM=1", "HOME="+r.dir,
+	)
+	out, err := cmd.CombinedOutput()
+	require.NoError(r.t, err, "git %s: %s", args[0], out)
+	return strings.TrimSpace(string(out))
+}
+
+// commit writes the given files, removing those with empty contents, and
+// commits them, returning the ID of the new commit.
+func (r *testRepo) commit(files map[string]string) string {
+	for name, content := range files {
+		p := filepath.Join(r.dir, filepath.FromSlash(name))
+		if content == "" {
+			require.NoError(r.t, os.Remove(p))
+			continue
+		}
+		require.NoError(r.t, os.MkdirAll(filepath.Dir(p), 0755))
+		require.NoError(r.t, ioutil.WriteFile(p, []byte(content), 0644))
+	}
+	r.git("add", "--all")
+	r.git("commit", "--quiet", "--allow-empty", "--message", "commit")
+	return r.git("rev-parse", "HEAD")
+}
+
+func readAll(t *testing.T, rd io.Reader) string {
+	data, err := ioutil.ReadAll(rd)
+	require.NoError(t, err)
+	if cl, ok := rd.(io.Closer); ok {
+		require.NoError(t, cl.Close())
+	}
+	return string(data)
+}
+
+func TestGetConfiguration(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	first := r.commit(map[string]string{
+		"config/webserver":          "first",
+		"config/directory/file.mof": "not a configuration",
+	})
+	r.git("tag", "v1")
+
+	repo := New(r.dir, "main")
+	resp, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "WebServer"})
+	require.NoError(t, err)
+	assert.Equal(t, "first", readAll(t, resp.Content))
+	assert.Equal(t, checksum.SumBytes([]byte("first")), resp.Checksum)
+	assert.Equal(t, "SHA-256", resp.ChecksumAlgorithm)
+	assert.Equal(t, first, resp.Source)
+
+	// Missing files, and anything that isn't a file, aren't found.
+	for _, name := range []string{"Missing", "directory"} {
+		_, err = repo.GetConfiguration(ctx, types.GetConfigurationRequest{AgentID: "agent", ConfigurationName: name})
+		assert.Equal(t, types.ConfigurationNotFoundError{AgentID: "agent", Name: name}, err)
+		_, _, err = repo.GetConfigurationHash(ctx, types.GetConfigurationRequest{AgentID: "agent", ConfigurationName: name})
+		assert.Equal(t, types.ConfigurationNotFoundError{AgentID: "agent", Name: name}, err)
+	}
+
+	// New commits are served without restarting.
+	second := r.commit(map[string]string{"config/webserver": "second"})
+	resp, err = repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "WebServer"})
+	require.NoError(t, err)
+	assert.Equal(t, "second", readAll(t, resp.Content))
+	assert.Equal(t, second, resp.Source)
+
+	// Other refs, including tags and commits, serve what they point to.
+	for _, ref := range []string{"v1", first, first[:12], "main~1"} {
+		resp, err = New(r.dir, ref).GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "WebServer"})
+		require.NoError(t, err, "ref %q", ref)
+		assert.Equal(t, "first", readAll(t, resp.Content), "ref %q", ref)
+		assert.Equal(t, first, resp.Source, "ref %q", ref)
+	}
+
+	// A ref that doesn't exist is an error, rather than a missing
+	// configuration.
+	_, err = New(r.dir, "missing").GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "WebServer"})
+	require.Error(t, err)
+	assert.Contains(t, err.Error(), `"missing"`)
+	_, notFound := err.(types.ConfigurationNotFoundError)
+	assert.False(t, notFound)
+}
+
+func TestGetModule(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	commit := r.commit(map[string]string{"modules/auditpolicydsc/1.3.0.0.zip": "module"})
+	repo := New(r.dir, "main")
+
+	resp, err := repo.GetModule(ctx, types.GetModuleRequest{Name: "AuditPolicyDsc", Version: "1.3.0.0"})
+	require.NoError(t, err)
+	assert.Equal(t, "module", readAll(t, resp.Content))
+	assert.Equal(t, checksum.SumBytes([]byte("module")), resp.Checksum)
+	assert.Equal(t, commit, resp.Source)
+
+	_, err = repo.GetModule(ctx, types.GetModuleRequest{AgentID: "agent", Name: "AuditPolicyDsc", Version: "2.0"})
+	assert.Equal(t, types.ModuleNotFoundError{AgentID: "agent", Name: "AuditPolicyDsc", Version: "2.0"}, err)
+}
+
+func TestBlobHashCache(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	r.commit(map[string]string{"config/webserver": "content"})
+	repo := New(r.dir, "main")
+	req := types.GetConfigurationRequest{ConfigurationName: "WebServer"}
+
+	// The first read of a blob is buffered in order to hash it.
+	resp, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	_, streamed := resp.Content.(*cmdReader)
+	assert.False(t, streamed)
+	assert.Equal(t, "content", readAll(t, resp.Content))
+	require.Equal(t, 1, repo.hashes.Len())
+
+	// After that, the checksum is cached, and the content is streamed.
+	hash, algo, err := repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, checksum.SumBytes([]byte("content")), hash)
+	assert.Equal(t, "SHA-256", algo)
+
+	resp, err = repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	assert.IsType(t, &cmdReader{}, resp.Content)
+	assert.Equal(t, "content", readAll(t, resp.Content))
+	assert.Equal(t, hash, resp.Checksum)
+
+	// The same content in another file or commit is the same blob.
+	r.commit(map[string]string{"config/other": "content", "config/unrelated": "x"})
+	_, _, err = repo.GetConfigurationHash(ctx, types.GetConfigurationRequest{ConfigurationName: "Other"})
+	require.NoError(t, err)
+	assert.Equal(t, 1, repo.hashes.Len())
+
+	// Changed content is a new blob, which is hashed when it's first seen.
+	r.commit(map[string]string{"config/webserver": "changed"})
+	hash, _, err = repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, checksum.SumBytes([]byte("changed")), hash)
+	assert.Equal(t, 2, repo.hashes.Len())
+}
+
+func TestBlobHashCacheSize(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	r.commit(map[string]string{"config/first": "first", "config/second": "second"})
+	repo := New(r.dir, "main", WithHashCacheSize(1))
+	get := func(name string) *types.GetConfigurationResponse {
+		resp, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: name})
+		require.NoError(t, err)
+		return resp
+	}
+
+	readAll(t, get("First").Content)
+	readAll(t, get("Second").Content)
+	assert.Equal(t, 1, repo.hashes.Len())
+
+	// The first blob's checksum was evicted, so it's hashed again.
+	resp := get("First")
+	_, streamed := resp.Content.(*cmdReader)
+	assert.False(t, streamed)
+	assert.Equal(t, "first", readAll(t, resp.Content))
+	assert.Equal(t, checksum.SumBytes([]byte("first")), resp.Checksum)
+}
+
+func TestFetch(t *testing.T) {
+	ctx := context.Background()
+	origin := newTestRepo(t)
+	origin.commit(map[string]string{"config/webserver": "first"})
+
+	clone := newTestRepo(t)
+	clone.git("remote", "add", "origin", origin.dir)
+	clone.git("fetch", "--quiet", "origin")
+
+	repo := New(clone.dir, "origin/main")
+	get := func() string {
+		resp, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "WebServer"})
+		require.NoError(t, err)
+		return readAll(t, resp.Content)
+	}
+	assert.Equal(t, "first", get())
+
+	// New commits in the remote aren't served until they're fetched.
+	second := origin.commit(map[string]string{"config/webserver": "second"})
+	assert.Equal(t, "first", get())
+
+	require.NoError(t, repo.Fetch(ctx))
+	assert.Equal(t, "second", get())
+	resp, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "WebServer"})
+	require.NoError(t, err)
+	readAll(t, resp.Content)
+	assert.Equal(t, second, resp.Source)
+
+	// Fetching from a remote that's gone is an error.
+	require.NoError(t, os.RemoveAll(origin.dir))
+	assert.Error(t, repo.Fetch(ctx))
+}
+
+func TestListModuleVersions(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	r.commit(map[string]string{
+		"modules/auditpolicydsc/1.2.0.0.zip":    "old",
+		"modules/auditpolicydsc/1.3.0.0.zip":    "new",
+		"modules/auditpolicydsc/README.md":      "not a module",
+		"modules/auditpolicydsc/nested/2.0.zip": "not a version",
+	})
+	repo := New(r.dir, "main")
+	req := types.ListModuleVersionsRequest{Name: "AuditPolicyDsc"}
+
+	resp, err := repo.ListModuleVersions(ctx, req)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.2.0.0", "1.3.0.0"}, resp.Versions)
+	assert.Equal(t, "", resp.Default)
+
+	// The default version is pinned by a file named "default".
+	r.commit(map[string]string{"modules/auditpolicydsc/default": "1.2.0.0\n"})
+	resp, err = repo.ListModuleVersions(ctx, req)
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.2.0.0", "1.3.0.0"}, resp.Versions)
+	assert.Equal(t, "1.2.0.0", resp.Default)
+
+	// Removing the file unpins it.
+	r.commit(map[string]string{"modules/auditpolicydsc/default": ""})
+	resp, err = repo.ListModuleVersions(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "", resp.Default)
+
+	resp, err = repo.ListModuleVersions(ctx, types.ListModuleVersionsRequest{Name: "Missing"})
+	require.NoError(t, err)
+	assert.Empty(t, resp.Versions)
+}
+
+func TestGetMetaConfiguration(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRepo(t)
+	r.commit(map[string]string{"config/webserver": "config"})
+	repo := New(r.dir, "main")
+
+	resp, err := repo.GetMetaConfiguration(ctx, types.GetMetaConfigurationRequest{AgentID: "ABC"})
+	require.NoError(t, err)
+	assert.Nil(t, resp)
+
+	r.commit(map[string]string{"meta/default": "default", "meta/abc": "specific"})
+	resp, err = repo.GetMetaConfiguration(ctx, types.GetMetaConfigurationRequest{AgentID: "ABC"})
+	require.NoError(t, err)
+	assert.Equal(t, "specific", readAll(t, resp.Content))
+	assert.Equal(t, checksum.SumBytes([]byte("specific")), resp.Checksum)
+
+	resp, err = repo.GetMetaConfiguration(ctx, types.GetMetaConfigurationRequest{AgentID: "DEF"})
+	require.NoError(t, err)
+	assert.Equal(t, "default", readAll(t, resp.Content))
+}
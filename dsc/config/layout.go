@@ -0,0 +1,123 @@
+// Package config contains options that are shared between
+// ConfigurationRepository implementations.
+package config
+
+import (
+	"path"
+	"strings"
+)
+
+// Layout describes where configurations and modules are stored in a
+// repository.
+type Layout int
+
+const (
+	// DefaultLayout stores configurations and modules with lower-cased
+	// names, as:
+	//
+	//     config/<name>
+	//     modules/<name>/<version>.zip
+	DefaultLayout Layout = iota
+
+	// MicrosoftLayout is the layout used by the official pull server, and
+	// by tools like Publish-DSCModuleAndMof:
+	//
+	//     Configuration/<name>.mof
+	//     Configuration/<name>.mof.checksum
+	//     Modules/<name>_<version>.zip
+	//     Modules/<name>_<version>.zip.checksum
+	//
+	// Names keep their original case, so repositories must match them
+	// case-insensitively. The ".checksum" files contain the SHA-256
+	// checksum of the corresponding file.
+	MicrosoftLayout
+)
+
+// The suffix of a file that holds the checksum of another file.
+const ChecksumSuffix = ".checksum"
+
+// ConfigurationPath returns the slash-separated path of a configuration.
+func (l Layout) ConfigurationPath(name string) string {
+	if l == MicrosoftLayout {
+		return path.Join("Configuration", name+".mof")
+	}
+
+	// From section 3.6.5:
+	//     The server MUST use case-insensitive ordinal comparison to match
+	//     the AgentId and ConfigurationName.
+	return path.Join("config", strings.ToLower(name))
+}
+
+// ConfigurationDirectory returns the slash-separated path of the directory
+// that contains configurations.
+func (l Layout) ConfigurationDirectory() string {
+	if l == MicrosoftLayout {
+		return "Configuration"
+	}
+	return "config"
+}
+
+// ConfigurationName returns the name of a configuration, given the name of a
+// file in the ConfigurationDirectory, and whether the file is a
+// configuration.
+func (l Layout) ConfigurationName(file string) (string, bool) {
+	if l == MicrosoftLayout {
+		if !strings.HasSuffix(file, ".mof") {
+			return "", false
+		}
+		return strings.TrimSuffix(file, ".mof"), true
+	}
+	return file, !strings.HasSuffix(file, ChecksumSuffix)
+}
+
+// ModulePath returns the slash-separated path of a module.
+func (l Layout) ModulePath(name, version string) string {
+	if l == MicrosoftLayout {
+		return path.Join("Modules", name+"_"+version+".zip")
+	}
+
+	// From section 3.7.5:
+	//     The server MUST use case-insensitive ordinal
+	//     comparison to match ModuleName and ModuleVersion.
+	return path.Join("modules", strings.ToLower(name), strings.ToLower(version)+".zip")
+}
+
+// ModuleDirectory returns the slash-separated path of the directory that
+// contains the versions of a module.
+func (l Layout) ModuleDirectory(name string) string {
+	if l == MicrosoftLayout {
+		return "Modules"
+	}
+	return path.Join("modules", strings.ToLower(name))
+}
+
+// ModuleVersion returns the version of a module, given the name of a file in
+// its ModuleDirectory, and whether the file is a version of the module.
+func (l Layout) ModuleVersion(name, file string) (string, bool) {
+	if !strings.HasSuffix(file, ".zip") {
+		return "", false
+	}
+	file = strings.TrimSuffix(file, ".zip")
+
+	if l == MicrosoftLayout {
+		prefix := name + "_"
+		if len(file) <= len(prefix) || !strings.EqualFold(file[:len(prefix)], prefix) {
+			return "", false
+		}
+		return file[len(prefix):], true
+	}
+	return file, true
+}
+
+// PreservesCase returns whether paths in the layout keep the case of the
+// names they contain, rather than lower-casing them; such paths must be
+// matched case-insensitively.
+func (l Layout) PreservesCase() bool {
+	return l == MicrosoftLayout
+}
+
+// HasChecksumFiles returns whether the layout stores checksums alongside
+// configurations and modules.
+func (l Layout) HasChecksumFiles() bool {
+	return l == MicrosoftLayout
+}
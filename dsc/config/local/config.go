@@ -3,24 +3,78 @@ package local
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
+	"io"
 	"io/ioutil"
 	"os"
 	"path/filepath"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
+	atomicfile "github.com/natefinch/atomic"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/fsmock"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/module"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
 type ConfigurationRepository struct {
-	root string
-	fs   fsmock.FileSystem
+	root   string
+	fs     fsmock.FileSystem
+	layout config.Layout
+
+	// Checksums of files that we've read, keyed by path. An entry is
+	// only used if the file's modification time and size haven't changed
+	// since it was hashed.
+	mu     sync.Mutex
+	hashes map[string]hashEntry
+
+	// The checksums of module zips that have passed validation, keyed by
+	// path.
+	validated map[string]string
+
+	// Accessed atomically
+	hits   uint64
+	misses uint64
+}
+
+type hashEntry struct {
+	modTime  time.Time
+	size     int64
+	checksum string
+}
+
+// CacheStats contains statistics about the checksum cache of a
+// ConfigurationRepository.
+type CacheStats struct {
+	// The number of checksums that were served from the cache.
+	Hits uint64
+
+	// The number of checksums that had to be calculated, because the file
+	// wasn't in the cache or had changed.
+	Misses uint64
+}
+
+func New(root string, opts ...config.Option) *ConfigurationRepository {
+	options := config.NewOptions(opts...)
+	return &ConfigurationRepository{
+		root:      root,
+		fs:        fsmock.OSFS{},
+		layout:    options.Layout,
+		hashes:    make(map[string]hashEntry),
+		validated: make(map[string]string),
+	}
 }
 
-func New(root string) *ConfigurationRepository {
-	return &ConfigurationRepository{root, fsmock.OSFS{}}
+// CacheStats returns statistics about the checksum cache.
+func (c *ConfigurationRepository) CacheStats() CacheStats {
+	return CacheStats{
+		Hits:   atomic.LoadUint64(&c.hits),
+		Misses: atomic.LoadUint64(&c.misses),
+	}
 }
 
 func (c *ConfigurationRepository) RegisterDscAgent(
@@ -35,17 +89,14 @@ func (c *ConfigurationRepository) GetConfiguration(
 	ctx context.Context,
 	req types.GetConfigurationRequest,
 ) (*types.GetConfigurationResponse, error) {
-	// From section 3.6.5:
-	//     The server MUST use case-insensitive ordinal comparison to match
-	//     the AgentId and ConfigurationName.
-	//
-	// Thus, we lower-case the module name and version.
-	path := filepath.Join(
-		c.root,
-		"config",
-		strings.ToLower(req.ConfigurationName),
-	)
-	f, err := c.fs.Open(path)
+	path := c.resolve(c.layout.ConfigurationPath(req.ConfigurationName))
+	f, checksum, err := c.openFile(path)
+	if err == nil {
+		err = c.verifyChecksumFile(path, checksum)
+		if err != nil {
+			f.Close()
+		}
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, types.ConfigurationNotFoundError{
@@ -57,44 +108,74 @@ func (c *ConfigurationRepository) GetConfiguration(
 		// Unknown error; return as-is
 		return nil, err
 	}
-	defer f.Close()
-
-	// Read config body into memory
-	config, err := ioutil.ReadAll(f)
-	if err != nil {
-		return nil, err
-	}
-
-	// Hash body
-	h := sha256.Sum256(config)
 
 	// All set!
 	ret := &types.GetConfigurationResponse{
 		//ConfigurationName: req.ConfigurationName,
-		Content:           bytes.NewReader(config),
-		Checksum:          strings.ToUpper(hex.EncodeToString(h[:])),
+		Content:           f,
+		Checksum:          checksum,
 		ChecksumAlgorithm: "SHA-256",
 	}
 	return ret, nil
 }
 
+// GetConfigurationHash implements the util.ConfigRepoHasher interface, so
+// that checking whether an agent's configuration is up to date doesn't
+// require reading the configuration if it hasn't changed. If the layout has
+// checksum files, the hash is read from the configuration's checksum file
+// instead.
+func (c *ConfigurationRepository) GetConfigurationHash(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (string, string, error) {
+	path := c.resolve(c.layout.ConfigurationPath(req.ConfigurationName))
+
+	info, err := c.fs.Stat(path)
+	if err == nil && c.layout.HasChecksumFiles() {
+		sum, err := c.readChecksumFile(path)
+		if err != nil || sum != "" {
+			return sum, "SHA-256", err
+		}
+	}
+
+	// If the file hasn't changed, we don't need to open it.
+	if err == nil {
+		if checksum, ok := c.cachedChecksum(path, info); ok {
+			atomic.AddUint64(&c.hits, 1)
+			return checksum, "SHA-256", nil
+		}
+	}
+
+	// Otherwise, hash it; openFile records the cache miss.
+	f, checksum, err := c.openFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", "", types.ConfigurationNotFoundError{
+				AgentID: req.AgentID,
+				Name:    req.ConfigurationName,
+			}
+		}
+
+		// Unknown error; return as-is
+		return "", "", err
+	}
+	f.Close()
+
+	return checksum, "SHA-256", nil
+}
+
 func (c *ConfigurationRepository) GetModule(
 	ctx context.Context,
 	req types.GetModuleRequest,
 ) (*types.GetModuleResponse, error) {
-	// From section 3.7.5:
-	//     The server MUST use case-insensitive ordinal
-	//     comparison to match ModuleName and ModuleVersion.
-	//
-	// Thus, we lower-case the module name and version, since S3 doesn't
-	// support case-insensitive comparison.
-	path := filepath.Join(
-		c.root,
-		"modules",
-		strings.ToLower(req.Name),
-		strings.ToLower(req.Version)+".zip",
-	)
-	f, err := c.fs.Open(path)
+	path := c.resolve(c.layout.ModulePath(req.Name, req.Version))
+	f, checksum, err := c.openFile(path)
+	if err == nil {
+		err = c.verifyChecksumFile(path, checksum)
+		if err != nil {
+			f.Close()
+		}
+	}
 	if err != nil {
 		if os.IsNotExist(err) {
 			return nil, types.ModuleNotFoundError{
@@ -107,21 +188,314 @@ func (c *ConfigurationRepository) GetModule(
 		// Unknown error; return as-is
 		return nil, err
 	}
-	defer f.Close()
 
-	config, err := ioutil.ReadAll(f)
-	if err != nil {
+	if err := c.validateModule(path, f, checksum, req); err != nil {
+		f.Close()
 		return nil, err
 	}
 
-	// Hash body
-	h := sha256.Sum256(config)
-
 	// All set!
 	ret := &types.GetModuleResponse{
-		Content:           bytes.NewReader(config),
-		Checksum:          strings.ToUpper(hex.EncodeToString(h[:])),
+		Content:           f,
+		Checksum:          checksum,
 		ChecksumAlgorithm: "SHA-256",
 	}
 	return ret, nil
 }
+
+// ListConfigurations implements the dsc.ConfigurationLister interface.
+func (c *ConfigurationRepository) ListConfigurations(ctx context.Context) ([]string, error) {
+	infos, err := c.fs.ReadDir(c.resolve(c.layout.ConfigurationDirectory()))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	var ret []string
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if name, ok := c.layout.ConfigurationName(info.Name()); ok {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// PublishConfiguration writes a configuration to the repository, along with
+// its checksum file if the layout has them. An existing configuration whose
+// name matches case-insensitively is replaced.
+func (c *ConfigurationRepository) PublishConfiguration(ctx context.Context, name string, content []byte) error {
+	path := c.resolve(c.layout.ConfigurationPath(name))
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := atomicfile.WriteFile(path, bytes.NewReader(content)); err != nil || !c.layout.HasChecksumFiles() {
+		return err
+	}
+
+	// Other tools that read this layout expect checksum files.
+	return atomicfile.WriteFile(path+config.ChecksumSuffix, strings.NewReader(checksum.SumBytes(content)))
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface. The
+// default version of a module can be pinned by writing it to a file named
+// "default" in the module's directory; the Microsoft layout has no way to pin
+// a version.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	dir := c.resolve(c.layout.ModuleDirectory(req.Name))
+	infos, err := c.fs.ReadDir(dir)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return &types.ListModuleVersionsResponse{}, nil
+		}
+		return nil, err
+	}
+
+	ret := &types.ListModuleVersionsResponse{}
+	for _, info := range infos {
+		if info.IsDir() {
+			continue
+		}
+		if version, ok := c.layout.ModuleVersion(req.Name, info.Name()); ok {
+			ret.Versions = append(ret.Versions, version)
+		}
+	}
+	if c.layout == config.MicrosoftLayout {
+		return ret, nil
+	}
+
+	f, err := c.fs.Open(filepath.Join(dir, "default"))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return ret, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	pinned, err := ioutil.ReadAll(io.LimitReader(f, 256))
+	if err != nil {
+		return nil, err
+	}
+	ret.Default = strings.TrimSpace(string(pinned))
+	return ret, nil
+}
+
+// GetMetaConfiguration returns the meta-configuration for an agent from the
+// "meta" directory, using a file named after the agent ID if one exists, and
+// a file named "default" otherwise.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	for _, name := range []string{strings.ToLower(req.AgentID), "default"} {
+		f, checksum, err := c.openFile(filepath.Join(c.root, "meta", name))
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+
+			// Unknown error; return as-is
+			return nil, err
+		}
+
+		ret := &types.GetMetaConfigurationResponse{
+			Content:           f,
+			Checksum:          checksum,
+			ChecksumAlgorithm: "SHA-256",
+		}
+		return ret, nil
+	}
+
+	// No meta-configuration for this agent
+	return nil, nil
+}
+
+// resolve returns the path of a file in the repository, given its
+// slash-separated path in the layout. Layouts that don't lower-case names are
+// matched case-insensitively, as required by the protocol, which is done by
+// searching each directory if the exact path doesn't exist.
+func (c *ConfigurationRepository) resolve(name string) string {
+	path := filepath.Join(c.root, filepath.FromSlash(name))
+	if !c.layout.PreservesCase() {
+		return path
+	}
+	if _, err := c.fs.Stat(path); err == nil {
+		return path
+	}
+
+	path = c.root
+	for _, elem := range strings.Split(name, "/") {
+		path = filepath.Join(path, c.findEntry(path, elem))
+	}
+	return path
+}
+
+// findEntry returns the name of the entry in dir that matches name
+// case-insensitively, preferring an exact match, or name if there is none.
+func (c *ConfigurationRepository) findEntry(dir, name string) string {
+	if _, err := c.fs.Stat(filepath.Join(dir, name)); err == nil {
+		return name
+	}
+
+	infos, err := c.fs.ReadDir(dir)
+	if err != nil {
+		return name
+	}
+	for _, info := range infos {
+		if strings.EqualFold(info.Name(), name) {
+			return info.Name()
+		}
+	}
+	return name
+}
+
+// readChecksumFile returns the checksum from the checksum file of the given
+// file, or the empty string if there is none.
+func (c *ConfigurationRepository) readChecksumFile(path string) (string, error) {
+	dir, name := filepath.Split(path)
+	f, err := c.fs.Open(filepath.Join(dir, c.findEntry(dir, name+config.ChecksumSuffix)))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer f.Close()
+
+	// A SHA-256 checksum is 64 hex characters; allow some room for
+	// whitespace, but don't read anything unreasonably large.
+	data, err := ioutil.ReadAll(io.LimitReader(f, 256))
+	if err != nil {
+		return "", err
+	}
+	return checksum.Normalize(string(data)), nil
+}
+
+// verifyChecksumFile checks that the checksum of a file matches its checksum
+// file, if the layout has them; a stale checksum file would otherwise cause
+// clients to reject the content after downloading it.
+func (c *ConfigurationRepository) verifyChecksumFile(path, sum string) error {
+	if !c.layout.HasChecksumFiles() {
+		return nil
+	}
+
+	expected, err := c.readChecksumFile(path)
+	if err != nil {
+		return err
+	}
+	if expected != "" && expected != sum {
+		return types.ChecksumMismatchError{
+			Path:     path,
+			Expected: expected,
+			Actual:   sum,
+		}
+	}
+	return nil
+}
+
+// openFile opens the given file, and returns it along with its checksum,
+// which is taken from the cache if the file hasn't changed. The file is
+// positioned at the start, so that it can be streamed to the client.
+func (c *ConfigurationRepository) openFile(path string) (fsmock.File, string, error) {
+	f, err := c.fs.Open(path)
+	if err != nil {
+		return nil, "", err
+	}
+
+	checksum, err := c.fileChecksum(path, f)
+	if err != nil {
+		f.Close()
+		return nil, "", err
+	}
+	return f, checksum, nil
+}
+
+// fileChecksum returns the checksum of an open file, using the cache if
+// possible. If the file has to be hashed, it's rewound afterwards.
+func (c *ConfigurationRepository) fileChecksum(path string, f fsmock.File) (string, error) {
+	// Stat the open file, rather than the path, so that the cache entry
+	// describes the data that we actually read.
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if sum, ok := c.cachedChecksum(path, info); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return sum, nil
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	sum, err := checksum.Sum(f)
+	if err != nil {
+		return "", err
+	}
+	if _, err := f.Seek(0, io.SeekStart); err != nil {
+		return "", err
+	}
+
+	c.mu.Lock()
+	c.hashes[path] = hashEntry{
+		modTime:  info.ModTime(),
+		size:     info.Size(),
+		checksum: sum,
+	}
+	c.mu.Unlock()
+
+	return sum, nil
+}
+
+// validateModule checks that a module zip is valid, the first time that it's
+// served with a given checksum.
+func (c *ConfigurationRepository) validateModule(path string, f fsmock.File, checksum string, req types.GetModuleRequest) error {
+	c.mu.Lock()
+	validated := c.validated[path] == checksum
+	c.mu.Unlock()
+	if validated {
+		return nil
+	}
+
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if err := module.Validate(f, info.Size(), req.Name, req.Version); err != nil {
+		if verr, ok := err.(module.ValidationError); ok {
+			return types.InvalidModuleError{
+				AgentID: req.AgentID,
+				Name:    req.Name,
+				Version: req.Version,
+				Reason:  verr.Reason,
+			}
+		}
+		return err
+	}
+
+	c.mu.Lock()
+	c.validated[path] = checksum
+	c.mu.Unlock()
+	return nil
+}
+
+// cachedChecksum returns the cached checksum for the given file, if the file
+// hasn't changed since it was cached. Note that a file that is rewritten with
+// the same size within the resolution of the filesystem's modification times
+// won't be detected as changed.
+func (c *ConfigurationRepository) cachedChecksum(path string, info os.FileInfo) (string, bool) {
+	c.mu.Lock()
+	entry, ok := c.hashes[path]
+	c.mu.Unlock()
+
+	if ok && entry.modTime.Equal(info.ModTime()) && entry.size == info.Size() {
+		return entry.checksum, true
+	}
+	return "", false
+}
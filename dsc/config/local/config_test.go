@@ -0,0 +1,110 @@
+package local
+
+import (
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+func TestConfigurationHashCache(t *testing.T) {
+	root, err := ioutil.TempDir("", "dsc-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	path := filepath.Join(root, "config", "web")
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	require.NoError(t, ioutil.WriteFile(path, []byte("first"), 0644))
+
+	ctx := context.Background()
+	repo := New(root)
+	req := types.GetConfigurationRequest{ConfigurationName: "Web"}
+
+	// The first request hashes the file; later ones use the cache.
+	first, _, err := repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, CacheStats{Hits: 0, Misses: 1}, repo.CacheStats())
+
+	hash, _, err := repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, first, hash)
+	assert.Equal(t, CacheStats{Hits: 1, Misses: 1}, repo.CacheStats())
+
+	config, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(config.Content)
+	require.NoError(t, err)
+	config.Content.(io.Closer).Close()
+	assert.Equal(t, "first", string(content))
+	assert.Equal(t, first, config.Checksum)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 1}, repo.CacheStats())
+
+	// Changing the file invalidates the cache entry.
+	require.NoError(t, ioutil.WriteFile(path, []byte("second"), 0644))
+	later := time.Now().Add(time.Minute)
+	require.NoError(t, os.Chtimes(path, later, later))
+
+	hash, _, err = repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.NotEqual(t, first, hash)
+	assert.Equal(t, CacheStats{Hits: 2, Misses: 2}, repo.CacheStats())
+
+	// Missing configurations are reported as such.
+	_, _, err = repo.GetConfigurationHash(ctx, types.GetConfigurationRequest{ConfigurationName: "missing"})
+	assert.IsType(t, types.ConfigurationNotFoundError{}, err)
+}
+
+func TestMicrosoftLayout(t *testing.T) {
+	root, err := ioutil.TempDir("", "dsc-config")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	files := map[string]string{
+		"Configuration/WebServer.mof":          "web",
+		"Configuration/WebServer.mof.checksum": checksum.SumBytes([]byte("web")) + "\r\n",
+		"Configuration/Stale.mof":              "new content",
+		"Configuration/Stale.mof.checksum":     checksum.SumBytes([]byte("old content")),
+		"Modules/xExample_1.0.zip":             "",
+		"Modules/xExample_1.2.zip":             "",
+		"Modules/xOther_2.0.zip":               "",
+	}
+	for name, content := range files {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	ctx := context.Background()
+	repo := New(root, config.WithLayout(config.MicrosoftLayout))
+
+	// Names are matched case-insensitively, and the hash comes from the
+	// checksum file.
+	req := types.GetConfigurationRequest{ConfigurationName: "webserver"}
+	hash, _, err := repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, checksum.SumBytes([]byte("web")), hash)
+	assert.Equal(t, CacheStats{}, repo.CacheStats())
+
+	resp, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	resp.Content.(io.Closer).Close()
+	assert.Equal(t, hash, resp.Checksum)
+
+	// Content that doesn't match its checksum file isn't served.
+	_, err = repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "Stale"})
+	assert.IsType(t, types.ChecksumMismatchError{}, err)
+
+	versions, err := repo.ListModuleVersions(ctx, types.ListModuleVersionsRequest{Name: "XEXAMPLE"})
+	require.NoError(t, err)
+	assert.ElementsMatch(t, []string{"1.0", "1.2"}, versions.Versions)
+}
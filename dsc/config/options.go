@@ -0,0 +1,29 @@
+package config
+
+// Options contains configuration that is common to all
+// ConfigurationRepository implementations.
+type Options struct {
+	// Layout is where configurations and modules are stored in the
+	// repository.
+	Layout Layout
+}
+
+// Option is the type of functional options that can be passed to the
+// constructors of ConfigurationRepository implementations.
+type Option func(*Options)
+
+// WithLayout sets the layout of the repository.
+func WithLayout(layout Layout) Option {
+	return func(o *Options) {
+		o.Layout = layout
+	}
+}
+
+// NewOptions applies the given functional options and returns the result.
+func NewOptions(opts ...Option) Options {
+	var ret Options
+	for _, opt := range opts {
+		opt(&ret)
+	}
+	return ret
+}
@@ -3,26 +3,36 @@ package s3
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"fmt"
+	"io"
 	"io/ioutil"
+	"path"
 	"strings"
 
+	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/awserr"
 	"github.com/aws/aws-sdk-go/service/s3"
 	"github.com/aws/aws-sdk-go/service/s3/s3iface"
 
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/module"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
+// The user-defined metadata key (i.e. "x-amz-meta-sha256") that holds the
+// SHA-256 checksum of an object, as an upper-case hex string.
+const checksumMetadataKey = "Sha256"
+
 type ConfigurationRepository struct {
 	bucket *string
 	s3     s3iface.S3API
+	layout config.Layout
 }
 
-func New(bucket string, s3 s3iface.S3API) *ConfigurationRepository {
-	return &ConfigurationRepository{&bucket, s3}
+func New(bucket string, s3 s3iface.S3API, opts ...config.Option) *ConfigurationRepository {
+	options := config.NewOptions(opts...)
+	return &ConfigurationRepository{&bucket, s3, options.Layout}
 }
 
 func (c *ConfigurationRepository) RegisterDscAgent(
@@ -37,111 +47,408 @@ func (c *ConfigurationRepository) GetConfiguration(
 	ctx context.Context,
 	req types.GetConfigurationRequest,
 ) (*types.GetConfigurationResponse, error) {
-	// From section 3.6.5:
-	//     The server MUST use case-insensitive ordinal comparison to match
-	//     the AgentId and ConfigurationName.
-	//
-	// Thus, we lower-case the module name and version, since S3 doesn't
-	// support case-insensitive comparison.
-	key := fmt.Sprintf("config/%s",
-		strings.ToLower(req.ConfigurationName),
+	var (
+		body     io.ReadCloser
+		checksum string
 	)
-
-	// Attempt to fetch the configuration from S3
-	input := &s3.GetObjectInput{
-		Bucket: c.bucket,
-		Key:    &key,
-	}
-
-	result, err := c.s3.GetObjectWithContext(ctx, input)
+	err := c.lookup(ctx, c.layout.ConfigurationPath(req.ConfigurationName), func(key string) (err error) {
+		body, checksum, err = c.getObject(ctx, key)
+		return err
+	})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchKey:
-				return nil, types.ConfigurationNotFoundError{
-					AgentID: req.AgentID,
-					Name:    req.ConfigurationName,
-				}
+		if isNotFound(err) {
+			return nil, types.ConfigurationNotFoundError{
+				AgentID: req.AgentID,
+				Name:    req.ConfigurationName,
 			}
 		}
 
 		// Unknown error; return as-is
 		return nil, err
 	}
-	defer result.Body.Close()
-
-	// Read config body into memory
-	config, err := ioutil.ReadAll(result.Body)
-	if err != nil {
-		return nil, err
-	}
-
-	// Hash body
-	h := sha256.Sum256(config)
 
 	// All set!
 	ret := &types.GetConfigurationResponse{
 		//ConfigurationName: req.ConfigurationName,
-		Content:           bytes.NewReader(config),
-		Checksum:          strings.ToUpper(hex.EncodeToString(h[:])),
+		Content:           body,
+		Checksum:          checksum,
 		ChecksumAlgorithm: "SHA-256",
 	}
 	return ret, nil
 }
 
-func (c *ConfigurationRepository) GetModule(
+// GetConfigurationHash implements the util.ConfigRepoHasher interface, using
+// a precomputed checksum so that the configuration doesn't need to be
+// downloaded. The checksum is read from the object's metadata, or from a
+// sidecar object (e.g. "config/foo.checksum") if there is none; these must be
+// kept up to date when the configuration changes, e.g. by using
+// PublishConfiguration. If neither is
+// present, this returns an empty hash, and callers fall back to fetching the
+// configuration.
+func (c *ConfigurationRepository) GetConfigurationHash(
 	ctx context.Context,
-	req types.GetModuleRequest,
-) (*types.GetModuleResponse, error) {
-	// From section 3.7.5:
-	//     The server MUST use case-insensitive ordinal
-	//     comparison to match ModuleName and ModuleVersion.
-	//
-	// Thus, we lower-case the module name and version, since S3 doesn't
-	// support case-insensitive comparison.
-	key := fmt.Sprintf("modules/%s/%s.zip",
-		strings.ToLower(req.Name),
-		strings.ToLower(req.Version),
+	req types.GetConfigurationRequest,
+) (string, string, error) {
+	var (
+		key    string
+		result *s3.HeadObjectOutput
 	)
+	err := c.lookup(ctx, c.layout.ConfigurationPath(req.ConfigurationName), func(k string) (err error) {
+		key = k
+		result, err = c.s3.HeadObjectWithContext(ctx, &s3.HeadObjectInput{
+			Bucket: c.bucket,
+			Key:    &key,
+		})
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "", "", types.ConfigurationNotFoundError{
+				AgentID: req.AgentID,
+				Name:    req.ConfigurationName,
+			}
+		}
 
-	// Attempt to fetch the configuration from S3
-	input := &s3.GetObjectInput{
-		Bucket: c.bucket,
-		Key:    &key,
+		// Unknown error; return as-is
+		return "", "", err
+	}
+
+	if checksum := metadataChecksum(result.Metadata); checksum != "" {
+		return checksum, "SHA-256", nil
+	}
+	if !c.layout.HasChecksumFiles() {
+		return "", "", nil
+	}
+
+	checksum, err := c.sidecarChecksum(ctx, key)
+	if err != nil || checksum == "" {
+		return "", "", err
+	}
+	return checksum, "SHA-256", nil
+}
+
+// PublishConfiguration uploads a configuration, along with the metadata
+// needed by GetConfigurationHash.
+func (c *ConfigurationRepository) PublishConfiguration(ctx context.Context, name string, content []byte) error {
+	return c.publish(ctx, c.layout.ConfigurationPath(name), content)
+}
+
+// PublishModule uploads a module, along with its checksum metadata. The module
+// is validated first, and a module.ValidationError is returned if it isn't a
+// valid module with the given name and version.
+func (c *ConfigurationRepository) PublishModule(ctx context.Context, name, version string, content []byte) error {
+	if err := module.Validate(bytes.NewReader(content), int64(len(content)), name, version); err != nil {
+		return err
 	}
 
-	result, err := c.s3.GetObjectWithContext(ctx, input)
+	return c.publish(ctx, c.layout.ModulePath(name, version), content)
+}
+
+func (c *ConfigurationRepository) publish(ctx context.Context, key string, content []byte) error {
+	sum := checksum.SumBytes(content)
+
+	_, err := c.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      c.bucket,
+		Key:         &key,
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String("application/octet-stream"),
+		Metadata: map[string]*string{
+			checksumMetadataKey: &sum,
+		},
+	})
+	if err != nil || !c.layout.HasChecksumFiles() {
+		return err
+	}
+
+	// Other tools that read this layout expect checksum files.
+	sidecar := key + config.ChecksumSuffix
+	_, err = c.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      c.bucket,
+		Key:         &sidecar,
+		Body:        strings.NewReader(sum),
+		ContentType: aws.String("text/plain"),
+	})
+	return err
+}
+
+// ListConfigurations implements the dsc.ConfigurationLister interface.
+func (c *ConfigurationRepository) ListConfigurations(ctx context.Context) ([]string, error) {
+	prefix := c.layout.ConfigurationDirectory() + "/"
+
+	var ret []string
+	err := c.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    c.bucket,
+		Prefix:    &prefix,
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			file := strings.TrimPrefix(aws.StringValue(obj.Key), prefix)
+			if name, ok := c.layout.ConfigurationName(file); ok {
+				ret = append(ret, name)
+			}
+		}
+		return true
+	})
 	if err != nil {
-		if aerr, ok := err.(awserr.Error); ok {
-			switch aerr.Code() {
-			case s3.ErrCodeNoSuchKey:
-				return nil, types.ModuleNotFoundError{
-					AgentID: req.AgentID,
-					Name:    req.Name,
-					Version: req.Version,
-				}
+		return nil, err
+	}
+	return ret, nil
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface. The
+// default version of a module can be pinned by writing it to an object named
+// "default" under the module's prefix; the Microsoft layout has no way to pin
+// a version.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	prefix := c.layout.ModuleDirectory(req.Name) + "/"
+
+	ret := &types.ListModuleVersionsResponse{}
+	err := c.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    c.bucket,
+		Prefix:    &prefix,
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			name := strings.TrimPrefix(aws.StringValue(obj.Key), prefix)
+			if version, ok := c.layout.ModuleVersion(req.Name, name); ok {
+				ret.Versions = append(ret.Versions, version)
 			}
 		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	if c.layout == config.MicrosoftLayout {
+		return ret, nil
+	}
 
-		// Unknown error; return as-is
+	key := prefix + "default"
+	result, err := c.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: c.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return ret, nil
+		}
 		return nil, err
 	}
 	defer result.Body.Close()
 
-	// Read config body into memory
-	config, err := ioutil.ReadAll(result.Body)
+	pinned, err := ioutil.ReadAll(io.LimitReader(result.Body, 256))
 	if err != nil {
 		return nil, err
 	}
+	ret.Default = strings.TrimSpace(string(pinned))
+	return ret, nil
+}
 
-	// Hash body
-	h := sha256.Sum256(config)
+// getObject fetches an object, and returns its body along with its checksum.
+// If the checksum is in the object's metadata, which only this package
+// writes, the body is streamed from S3; otherwise, it's read into memory so
+// that it can be hashed. In layouts with checksum files, the content is
+// checked against its checksum file, if there is one, and a
+// ChecksumMismatchError is returned if they differ.
+func (c *ConfigurationRepository) getObject(ctx context.Context, key string) (io.ReadCloser, string, error) {
+	result, err := c.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: c.bucket,
+		Key:    &key,
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	if sum := metadataChecksum(result.Metadata); sum != "" {
+		return result.Body, sum, nil
+	}
+	defer result.Body.Close()
+
+	var expected string
+	if c.layout.HasChecksumFiles() {
+		expected, err = c.sidecarChecksum(ctx, key)
+		if err != nil {
+			return nil, "", err
+		}
+	}
+
+	data, err := ioutil.ReadAll(result.Body)
+	if err != nil {
+		return nil, "", err
+	}
+	sum := checksum.SumBytes(data)
+	if expected != "" && expected != sum {
+		return nil, "", types.ChecksumMismatchError{
+			Path:     key,
+			Expected: expected,
+			Actual:   sum,
+		}
+	}
+	return ioutil.NopCloser(bytes.NewReader(data)), sum, nil
+}
+
+// metadataChecksum returns the checksum from an object's metadata, or the
+// empty string if there is none. The SDK normalizes the case of metadata
+// keys, but objects may have been uploaded by other tools, so we compare them
+// case-insensitively.
+func metadataChecksum(metadata map[string]*string) string {
+	for k, v := range metadata {
+		if strings.EqualFold(k, checksumMetadataKey) && v != nil {
+			return checksum.Normalize(*v)
+		}
+	}
+	return ""
+}
+
+// sidecarChecksum returns the checksum from the sidecar object for the given
+// key, or the empty string if there is none.
+func (c *ConfigurationRepository) sidecarChecksum(ctx context.Context, key string) (string, error) {
+	sidecar := key + config.ChecksumSuffix
+	result, err := c.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: c.bucket,
+		Key:    &sidecar,
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	defer result.Body.Close()
+
+	// A SHA-256 checksum is 64 hex characters; allow some room for
+	// whitespace, but don't read anything unreasonably large.
+	data, err := ioutil.ReadAll(io.LimitReader(result.Body, 256))
+	if err != nil {
+		return "", err
+	}
+	return checksum.Normalize(string(data)), nil
+}
+
+// lookup calls fn with the key of an object. S3 doesn't support
+// case-insensitive comparison, so the default layout lower-cases names in
+// keys; layouts that don't are matched case-insensitively by listing the
+// object's prefix if fn can't find the exact key.
+func (c *ConfigurationRepository) lookup(ctx context.Context, key string, fn func(key string) error) error {
+	err := fn(key)
+	if !isNotFound(err) || !c.layout.PreservesCase() {
+		return err
+	}
+
+	prefix := path.Dir(key) + "/"
+	var found string
+	lerr := c.s3.ListObjectsV2PagesWithContext(ctx, &s3.ListObjectsV2Input{
+		Bucket:    c.bucket,
+		Prefix:    &prefix,
+		Delimiter: aws.String("/"),
+	}, func(page *s3.ListObjectsV2Output, last bool) bool {
+		for _, obj := range page.Contents {
+			if k := aws.StringValue(obj.Key); strings.EqualFold(k, key) {
+				found = k
+				return false
+			}
+		}
+		return true
+	})
+	if lerr != nil {
+		return lerr
+	}
+	if found == "" || found == key {
+		return err
+	}
+	return fn(found)
+}
+
+// isNotFound returns whether err indicates that an object doesn't exist.
+// HeadObject has no response body, so its error code is "NotFound" rather
+// than "NoSuchKey".
+func isNotFound(err error) bool {
+	if aerr, ok := err.(awserr.Error); ok {
+		switch aerr.Code() {
+		case s3.ErrCodeNoSuchKey, "NotFound":
+			return true
+		}
+	}
+	return false
+}
+
+func (c *ConfigurationRepository) GetModule(
+	ctx context.Context,
+	req types.GetModuleRequest,
+) (*types.GetModuleResponse, error) {
+	var (
+		body     io.ReadCloser
+		checksum string
+	)
+	err := c.lookup(ctx, c.layout.ModulePath(req.Name, req.Version), func(key string) (err error) {
+		body, checksum, err = c.getObject(ctx, key)
+		return err
+	})
+	if err != nil {
+		if isNotFound(err) {
+			return nil, types.ModuleNotFoundError{
+				AgentID: req.AgentID,
+				Name:    req.Name,
+				Version: req.Version,
+			}
+		}
+
+		// Unknown error; return as-is
+		return nil, err
+	}
 
 	// All set!
 	ret := &types.GetModuleResponse{
-		Content:           bytes.NewReader(config),
-		Checksum:          strings.ToUpper(hex.EncodeToString(h[:])),
+		Content:           body,
+		Checksum:          checksum,
 		ChecksumAlgorithm: "SHA-256",
 	}
 	return ret, nil
 }
+
+// GetMetaConfiguration returns the meta-configuration for an agent from the
+// "meta/" prefix, using an object named after the agent ID if one exists, and
+// an object named "default" otherwise.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	for _, name := range []string{strings.ToLower(req.AgentID), "default"} {
+		key := fmt.Sprintf("meta/%s", name)
+
+		result, err := c.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+			Bucket: c.bucket,
+			Key:    &key,
+		})
+		if err != nil {
+			if aerr, ok := err.(awserr.Error); ok {
+				switch aerr.Code() {
+				case s3.ErrCodeNoSuchKey:
+					continue
+				}
+			}
+
+			// Unknown error; return as-is
+			return nil, err
+		}
+		defer result.Body.Close()
+
+		// Read meta-configuration body into memory
+		meta, err := ioutil.ReadAll(result.Body)
+		if err != nil {
+			return nil, err
+		}
+
+		ret := &types.GetMetaConfigurationResponse{
+			Content:           bytes.NewReader(meta),
+			Checksum:          checksum.SumBytes(meta),
+			ChecksumAlgorithm: "SHA-256",
+		}
+		return ret, nil
+	}
+
+	// No meta-configuration for this agent
+	return nil, nil
+}
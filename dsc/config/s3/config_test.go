@@ -0,0 +1,126 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// fakeS3 is an in-memory S3API that only implements the methods used by the
+// ConfigurationRepository.
+type fakeS3 struct {
+	s3iface.S3API
+
+	objects  map[string][]byte
+	metadata map[string]map[string]*string
+	gets     int
+}
+
+func (f *fakeS3) HeadObjectWithContext(ctx aws.Context, in *s3.HeadObjectInput, opts ...request.Option) (*s3.HeadObjectOutput, error) {
+	if _, ok := f.objects[*in.Key]; !ok {
+		return nil, awserr.New("NotFound", "Not Found", nil)
+	}
+	return &s3.HeadObjectOutput{Metadata: f.metadata[*in.Key]}, nil
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	f.gets++
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, in *s3.PutObjectInput, opts ...request.Option) (*s3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*in.Key] = data
+	f.metadata[*in.Key] = in.Metadata
+	return &s3.PutObjectOutput{}, nil
+}
+
+func TestGetConfigurationHash(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{
+		objects:  make(map[string][]byte),
+		metadata: make(map[string]map[string]*string),
+	}
+	repo := New("bucket", fake)
+
+	hash := func(name string) (string, error) {
+		h, _, err := repo.GetConfigurationHash(ctx, types.GetConfigurationRequest{ConfigurationName: name})
+		return h, err
+	}
+
+	// Published configurations are hashed without downloading them.
+	require.NoError(t, repo.PublishConfiguration(ctx, "Published", []byte("configuration")))
+	published, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "published"})
+	require.NoError(t, err)
+
+	fake.gets = 0
+	h, err := hash("PUBLISHED")
+	require.NoError(t, err)
+	assert.Equal(t, published.Checksum, h)
+	assert.Equal(t, 0, fake.gets)
+
+	// Otherwise, no hash is returned, so that callers fall back to
+	// fetching the configuration. The default layout has no checksum
+	// files, so none are fetched.
+	fake.objects["config/plain"] = []byte("configuration")
+	fake.objects["config/plain.checksum"] = []byte(published.Checksum)
+	fake.gets = 0
+	h, err = hash("plain")
+	require.NoError(t, err)
+	assert.Equal(t, "", h)
+	assert.Equal(t, 0, fake.gets)
+
+	_, err = hash("missing")
+	assert.IsType(t, types.ConfigurationNotFoundError{}, err)
+}
+
+func TestChecksumFiles(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{
+		objects:  make(map[string][]byte),
+		metadata: make(map[string]map[string]*string),
+	}
+	repo := New("bucket", fake, config.WithLayout(config.MicrosoftLayout))
+	sum := checksum.SumBytes([]byte("configuration"))
+	req := types.GetConfigurationRequest{ConfigurationName: "Sidecar"}
+
+	// Checksum files are used if there's no metadata.
+	fake.objects["Configuration/Sidecar.mof"] = []byte("configuration")
+	fake.objects["Configuration/Sidecar.mof.checksum"] = []byte(sum + "\n")
+	h, _, err := repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, sum, h)
+
+	resp, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, sum, resp.Checksum)
+
+	// Content that doesn't match its checksum file isn't served.
+	fake.objects["Configuration/Sidecar.mof"] = []byte("changed")
+	_, err = repo.GetConfiguration(ctx, req)
+	assert.Equal(t, types.ChecksumMismatchError{
+		Path:     "Configuration/Sidecar.mof",
+		Expected: sum,
+		Actual:   checksum.SumBytes([]byte("changed")),
+	}, err)
+}
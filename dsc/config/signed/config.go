@@ -0,0 +1,244 @@
+// Package signed implements a ConfigurationRepository that signs the
+// configurations and modules of another repository as they are served, for
+// nodes whose LCM is configured with SignatureValidation.
+//
+// Signing needs the whole document, so each document is read into memory to
+// sign it. Since signing is deterministic, signed documents are cached by the
+// checksum of the unsigned document, up to a maximum total size, and a cached
+// configuration's checksum can be served without signing it again if the
+// underlying repository can report its hash. Large modules are better signed
+// at publish time, with dsctool sign-module, and served with
+// WithPresignedModules, so that they're streamed instead.
+package signed
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/lru"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/sign"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/util"
+)
+
+// DefaultCacheSize is the default maximum total size of the signed documents
+// that are cached, in bytes.
+const DefaultCacheSize = 64 << 20
+
+type ConfigurationRepository struct {
+	base             dsc.ConfigurationRepository
+	signer           *sign.Signer
+	cacheSize        int64
+	presignedModules bool
+
+	// Signed documents, keyed by cacheKey. Entries never need to be
+	// invalidated, since the key includes the checksum of the unsigned
+	// document, but the least recently used are evicted to bound the
+	// size of the cache.
+	cache *lru.Cache
+}
+
+type cacheKey struct {
+	// "configuration" or "module"; modules are also keyed by name, since
+	// the name of the catalog depends on it.
+	kind     string
+	name     string
+	checksum string
+}
+
+type entry struct {
+	content  []byte
+	checksum string
+}
+
+// Option configures a ConfigurationRepository.
+type Option func(*ConfigurationRepository)
+
+// WithCacheSize sets the maximum total size of the signed documents that are
+// cached, in bytes. The default is DefaultCacheSize.
+func WithCacheSize(size int64) Option {
+	return func(c *ConfigurationRepository) {
+		c.cacheSize = size
+	}
+}
+
+// WithPresignedModules serves the modules of the underlying repository
+// unchanged, for repositories whose modules are signed at publish time.
+func WithPresignedModules() Option {
+	return func(c *ConfigurationRepository) {
+		c.presignedModules = true
+	}
+}
+
+// New creates a ConfigurationRepository that signs the configurations and
+// modules of base with signer.
+func New(base dsc.ConfigurationRepository, signer *sign.Signer, opts ...Option) *ConfigurationRepository {
+	ret := &ConfigurationRepository{
+		base:      base,
+		signer:    signer,
+		cacheSize: DefaultCacheSize,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	ret.cache = lru.New(ret.cacheSize)
+	return ret
+}
+
+func (c *ConfigurationRepository) RegisterDscAgent(
+	ctx context.Context,
+	req types.RegisterDscAgentRequest,
+) (*types.RegisterDscAgentResponse, error) {
+	return c.base.RegisterDscAgent(ctx, req)
+}
+
+func (c *ConfigurationRepository) GetConfiguration(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (*types.GetConfigurationResponse, error) {
+	resp, err := c.base.GetConfiguration(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+
+	e, err := c.signed(resp.Content, resp.Checksum, resp.ChecksumAlgorithm, "configuration", "", c.signer.SignMOF)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &types.GetConfigurationResponse{
+		Content:           bytes.NewReader(e.content),
+		Checksum:          e.checksum,
+		ChecksumAlgorithm: checksum.SHA256,
+		Source:            resp.Source,
+	}
+	return ret, nil
+}
+
+// GetConfigurationHash implements the util.ConfigRepoHasher interface. If the
+// underlying repository reports the hash of a configuration that has already
+// been signed, the checksum of the signed configuration is returned without
+// fetching it; otherwise the empty string is returned, so that callers fall
+// back to GetConfiguration.
+func (c *ConfigurationRepository) GetConfigurationHash(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (string, string, error) {
+	hasher, ok := c.base.(util.ConfigRepoHasher)
+	if !ok {
+		return "", "", nil
+	}
+
+	hash, algo, err := hasher.GetConfigurationHash(ctx, req)
+	if err != nil || hash == "" || algo != checksum.SHA256 {
+		return "", "", err
+	}
+
+	e, ok := c.cache.Get(cacheKey{kind: "configuration", checksum: checksum.Normalize(hash)})
+	if !ok {
+		return "", "", nil
+	}
+	return e.(entry).checksum, checksum.SHA256, nil
+}
+
+func (c *ConfigurationRepository) GetModule(
+	ctx context.Context,
+	req types.GetModuleRequest,
+) (*types.GetModuleResponse, error) {
+	resp, err := c.base.GetModule(ctx, req)
+	if err != nil || c.presignedModules {
+		return resp, err
+	}
+
+	signModule := func(content []byte) ([]byte, error) {
+		return c.signer.SignModule(content, req.Name)
+	}
+	e, err := c.signed(resp.Content, resp.Checksum, resp.ChecksumAlgorithm, "module", strings.ToLower(req.Name), signModule)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &types.GetModuleResponse{
+		Content:           bytes.NewReader(e.content),
+		Checksum:          e.checksum,
+		ChecksumAlgorithm: checksum.SHA256,
+		Source:            resp.Source,
+	}
+	return ret, nil
+}
+
+// signed reads and signs a document, or returns the cached result of signing
+// it before. The document is closed if it's an io.Closer.
+func (c *ConfigurationRepository) signed(
+	content io.Reader,
+	sum, algo, kind, name string,
+	signFn func([]byte) ([]byte, error),
+) (entry, error) {
+	if cl, ok := content.(io.Closer); ok {
+		defer cl.Close()
+	}
+
+	data, err := ioutil.ReadAll(content)
+	if err != nil {
+		return entry{}, err
+	}
+
+	// Only trust the underlying repository's checksum if it's one that we
+	// can look up again later; otherwise compute our own.
+	if algo != checksum.SHA256 || sum == "" {
+		sum = checksum.SumBytes(data)
+	}
+	key := cacheKey{kind: kind, name: name, checksum: checksum.Normalize(sum)}
+
+	if e, ok := c.cache.Get(key); ok {
+		return e.(entry), nil
+	}
+
+	signedData, err := signFn(data)
+	if err != nil {
+		return entry{}, err
+	}
+	e := entry{content: signedData, checksum: checksum.SumBytes(signedData)}
+	c.cache.Add(key, e, int64(len(signedData)))
+	return e, nil
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface if the
+// underlying repository does.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	if lister, ok := c.base.(dsc.ModuleVersionLister); ok {
+		return lister.ListModuleVersions(ctx, req)
+	}
+	return &types.ListModuleVersionsResponse{}, nil
+}
+
+// ListConfigurations implements the dsc.ConfigurationLister interface if the
+// underlying repository does.
+func (c *ConfigurationRepository) ListConfigurations(ctx context.Context) ([]string, error) {
+	if lister, ok := c.base.(dsc.ConfigurationLister); ok {
+		return lister.ListConfigurations(ctx)
+	}
+	return nil, nil
+}
+
+// GetMetaConfiguration implements the dsc.MetaConfigurationRepository
+// interface if the underlying repository does. Meta-configurations aren't
+// signed, since the LCM only validates signatures of configurations and
+// modules.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	if meta, ok := c.base.(dsc.MetaConfigurationRepository); ok {
+		return meta.GetMetaConfiguration(ctx, req)
+	}
+	return nil, nil
+}
@@ -0,0 +1,180 @@
+package signed
+
+import (
+	"archive/zip"
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/static"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/sign"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/util"
+)
+
+const mof = `instance of MSFT_Example
+{
+    ResourceID = "[Example]Test";
+};
+`
+
+func newSigner(t *testing.T) *sign.Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "DSC Signing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+
+	signer, err := sign.New(cert, nil, key)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestSigned(t *testing.T) {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	w, err := zw.Create("xExample.psd1")
+	require.NoError(t, err)
+	w.Write([]byte("@{ ModuleVersion = '1.0' }"))
+	require.NoError(t, zw.Close())
+
+	ctx := context.Background()
+	base := static.New([]byte(mof), map[static.ModuleSpec][]byte{
+		{Name: "xExample", Version: "1.0"}: buf.Bytes(),
+	})
+	repo := New(base, newSigner(t))
+	req := types.GetConfigurationRequest{AgentID: "agent", ConfigurationName: "example"}
+
+	// Until the configuration has been signed, its hash isn't known.
+	hash, _, err := repo.GetConfigurationHash(ctx, req)
+	require.NoError(t, err)
+	assert.Equal(t, "", hash)
+
+	resp, err := repo.GetConfiguration(ctx, req)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(resp.Content)
+	require.NoError(t, err)
+	assert.True(t, strings.HasPrefix(string(content), "instance of MSFT_Example"))
+	assert.Contains(t, string(content), "SIG # Begin signature block")
+	assert.Equal(t, checksum.SumBytes(content), resp.Checksum)
+
+	// Afterwards, it's served from the cache.
+	hash, algo, err := util.GetConfigHash(ctx, repo, "agent", "example")
+	require.NoError(t, err)
+	assert.Equal(t, resp.Checksum, hash)
+	assert.Equal(t, checksum.SHA256, algo)
+
+	mod, err := repo.GetModule(ctx, types.GetModuleRequest{Name: "xexample", Version: "1.0"})
+	require.NoError(t, err)
+	modContent, err := ioutil.ReadAll(mod.Content)
+	require.NoError(t, err)
+	assert.Equal(t, checksum.SumBytes(modContent), mod.Checksum)
+
+	zr, err := zip.NewReader(bytes.NewReader(modContent), int64(len(modContent)))
+	require.NoError(t, err)
+	require.Len(t, zr.File, 2)
+	assert.Equal(t, "xExample.cat", zr.File[1].Name)
+}
+
+func TestSignedCacheSize(t *testing.T) {
+	ctx := context.Background()
+	base := static.New([]byte(mof), nil)
+	signer := newSigner(t)
+	first := types.GetConfigurationRequest{AgentID: "agent", ConfigurationName: "first"}
+
+	// A signed document that doesn't fit in the cache is still served,
+	// but has to be signed again each time.
+	repo := New(base, signer, WithCacheSize(100))
+	resp, err := repo.GetConfiguration(ctx, first)
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(resp.Content)
+	require.NoError(t, err)
+	assert.Equal(t, checksum.SumBytes(content), resp.Checksum)
+	assert.Equal(t, 0, repo.cache.Len())
+	hash, _, err := repo.GetConfigurationHash(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "", hash)
+
+	// Otherwise, the least recently used documents are evicted to make
+	// room for new ones.
+	other := strings.Replace(mof, "[Example]Test", "[Example]Else", 1)
+	repo = New(configs{base, map[string]string{"first": mof, "second": other}}, signer, WithCacheSize(int64(len(content))))
+	get := func(req types.GetConfigurationRequest) string {
+		_, err := repo.GetConfiguration(ctx, req)
+		require.NoError(t, err)
+		hash, _, err := repo.GetConfigurationHash(ctx, req)
+		require.NoError(t, err)
+		return hash
+	}
+	assert.Equal(t, resp.Checksum, get(first))
+
+	second := types.GetConfigurationRequest{AgentID: "agent", ConfigurationName: "second"}
+	assert.NotEqual(t, "", get(second))
+	assert.Equal(t, 1, repo.cache.Len())
+	hash, _, err = repo.GetConfigurationHash(ctx, first)
+	require.NoError(t, err)
+	assert.Equal(t, "", hash)
+}
+
+// configs serves a different configuration for each name.
+type configs struct {
+	*static.ConfigurationRepository
+	byName map[string]string
+}
+
+func (c configs) GetConfiguration(ctx context.Context, req types.GetConfigurationRequest) (*types.GetConfigurationResponse, error) {
+	content := c.byName[req.ConfigurationName]
+	return &types.GetConfigurationResponse{
+		Content:           strings.NewReader(content),
+		Checksum:          checksum.SumBytes([]byte(content)),
+		ChecksumAlgorithm: checksum.SHA256,
+	}, nil
+}
+
+func (c configs) GetConfigurationHash(ctx context.Context, req types.GetConfigurationRequest) (string, string, error) {
+	return checksum.SumBytes([]byte(c.byName[req.ConfigurationName])), checksum.SHA256, nil
+}
+
+func TestPresignedModules(t *testing.T) {
+	ctx := context.Background()
+	module := []byte("a module that was signed at publish time")
+	base := static.New([]byte(mof), map[static.ModuleSpec][]byte{
+		{Name: "xExample", Version: "1.0"}: module,
+	})
+	repo := New(base, newSigner(t), WithPresignedModules())
+
+	mod, err := repo.GetModule(ctx, types.GetModuleRequest{Name: "xExample", Version: "1.0"})
+	require.NoError(t, err)
+	content, err := ioutil.ReadAll(mod.Content)
+	require.NoError(t, err)
+	assert.Equal(t, module, content)
+	assert.Equal(t, 0, repo.cache.Len())
+
+	// Configurations are still signed.
+	resp, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: "example"})
+	require.NoError(t, err)
+	content, err = ioutil.ReadAll(resp.Content)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "SIG # Begin signature block")
+}
@@ -3,10 +3,9 @@ package static
 import (
 	"bytes"
 	"context"
-	"crypto/sha256"
-	"encoding/hex"
 	"strings"
 
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
@@ -25,6 +24,9 @@ type ConfigurationRepository struct {
 	configHash string
 
 	modules map[ModuleSpec]module
+
+	// Pinned default versions, keyed by lower-cased module name
+	defaults map[string]string
 }
 
 func New(config []byte, modules map[ModuleSpec][]byte) *ConfigurationRepository {
@@ -40,19 +42,18 @@ func New(config []byte, modules map[ModuleSpec][]byte) *ConfigurationRepository
 				Name:    strings.ToLower(k.Name),
 				Version: strings.ToLower(k.Version),
 			}
-			h := sha256.Sum256(v)
 			mods[spec] = module{
 				content: v,
-				hash:    strings.ToUpper(hex.EncodeToString(h[:])),
+				hash:    checksum.SumBytes(v),
 			}
 		}
 	}
 
-	configHash := sha256.Sum256(config)
 	return &ConfigurationRepository{
 		config:     config,
-		configHash: strings.ToUpper(hex.EncodeToString(configHash[:])),
+		configHash: checksum.SumBytes(config),
 		modules:    mods,
+		defaults:   make(map[string]string),
 	}
 }
 
@@ -111,3 +112,28 @@ func (c *ConfigurationRepository) GetModule(
 	}
 	return ret, nil
 }
+
+// PinModuleVersion sets the version of a module that is served to clients
+// that don't request a specific version. It must not be called concurrently
+// with requests.
+func (c *ConfigurationRepository) PinModuleVersion(name, version string) {
+	c.defaults[strings.ToLower(name)] = version
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	name := strings.ToLower(req.Name)
+
+	ret := &types.ListModuleVersionsResponse{
+		Default: c.defaults[name],
+	}
+	for spec := range c.modules {
+		if spec.Name == name {
+			ret.Versions = append(ret.Versions, spec.Version)
+		}
+	}
+	return ret, nil
+}
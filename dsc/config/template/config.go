@@ -0,0 +1,333 @@
+// Package template implements a ConfigurationRepository that renders the
+// configurations of another repository separately for each agent, so that
+// nodes that differ only in a few values (hostnames, addresses, etc.) can
+// share a single configuration.
+//
+// Configurations are Go text/template templates, which are executed with a
+// Data value built from the agent's registration and any variables defined
+// for the node; e.g.:
+//
+//     instance of MSFT_Credential as $MSFT_Credential1ref
+//     {
+//         UserName = "{{ .NodeName }}\\Administrator";
+//     };
+//
+// Facts like the NodeName are reported by the agent, so the output of every
+// action is escaped for use inside a MOF string literal, and values should
+// only be inserted inside one. Output that shouldn't be escaped, such as MOF
+// held in a trusted variable, must be marked with the raw function:
+// {{ raw .Vars.resources }}. (The mof function, which escapes its argument,
+// is kept for existing templates; its output isn't escaped again.)
+//
+// Each agent is served a checksum of its own rendered configuration, so
+// checking whether a node is up to date compares it against the document
+// that it would be served.
+package template
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+	"text/template"
+	"text/template/parse"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Data is the value that a configuration template is executed with.
+type Data struct {
+	AgentID string
+
+	// Facts from the agent's registration. IPAddress is the list of
+	// addresses as sent by the agent, separated by semicolons, and
+	// IPAddresses is the same list split into its elements.
+	NodeName    string
+	IPAddress   string
+	IPAddresses []string
+	LCMVersion  string
+
+	// The certificate that the agent registered with, or rotated to.
+	Certificate Certificate
+
+	// Variables defined for the node by the VarsSource, if any.
+	Vars map[string]interface{}
+}
+
+// Certificate contains the details of an agent's certificate.
+type Certificate struct {
+	FriendlyName string
+	Issuer       string
+	NotAfter     string
+	NotBefore    string
+	PublicKey    string
+	Subject      string
+	Thumbprint   string
+}
+
+// VarsSource is the interface that should be implemented in order to provide
+// per-node variables to configuration templates.
+type VarsSource interface {
+	// GetVars returns the variables for the given agent. If there are
+	// none, it should return a nil map and a nil error. The node name is
+	// reported by the agent, so it shouldn't be used to look up secrets.
+	GetVars(ctx context.Context, agentId, nodeName string) (map[string]interface{}, error)
+}
+
+// RenderError is returned when a configuration template can't be rendered
+// for an agent, e.g. because it refers to a variable that isn't defined.
+type RenderError struct {
+	AgentID string
+	Name    string
+	Err     error
+}
+
+func (e RenderError) Error() string {
+	return fmt.Sprintf("template: error rendering configuration %q for agent %q: %s", e.Name, e.AgentID, e.Err)
+}
+
+type ConfigurationRepository struct {
+	base          dsc.ConfigurationRepository
+	registrations dsc.RegistrationGetter
+	vars          VarsSource
+}
+
+// Option configures a ConfigurationRepository.
+type Option func(*ConfigurationRepository)
+
+// WithVars sets the source of per-node variables, which are available to
+// templates as .Vars.
+func WithVars(vars VarsSource) Option {
+	return func(c *ConfigurationRepository) {
+		c.vars = vars
+	}
+}
+
+// New creates a ConfigurationRepository that renders the configurations of
+// base, using the facts returned by registrations.
+func New(base dsc.ConfigurationRepository, registrations dsc.RegistrationGetter, opts ...Option) *ConfigurationRepository {
+	ret := &ConfigurationRepository{
+		base:          base,
+		registrations: registrations,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+func (c *ConfigurationRepository) RegisterDscAgent(
+	ctx context.Context,
+	req types.RegisterDscAgentRequest,
+) (*types.RegisterDscAgentResponse, error) {
+	return c.base.RegisterDscAgent(ctx, req)
+}
+
+func (c *ConfigurationRepository) GetConfiguration(
+	ctx context.Context,
+	req types.GetConfigurationRequest,
+) (*types.GetConfigurationResponse, error) {
+	resp, err := c.base.GetConfiguration(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if cl, ok := resp.Content.(io.Closer); ok {
+		defer cl.Close()
+	}
+
+	text, err := ioutil.ReadAll(resp.Content)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := c.data(ctx, req.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	rendered, err := Render(req.ConfigurationName, text, data)
+	if err != nil {
+		return nil, RenderError{
+			AgentID: req.AgentID,
+			Name:    req.ConfigurationName,
+			Err:     err,
+		}
+	}
+
+	ret := &types.GetConfigurationResponse{
+		Content:           bytes.NewReader(rendered),
+		Checksum:          checksum.SumBytes(rendered),
+		ChecksumAlgorithm: checksum.SHA256,
+		Source:            resp.Source,
+	}
+	return ret, nil
+}
+
+// Render executes a configuration template with the given data. The output
+// of each action is escaped with the mof function, unless it's marked raw.
+func Render(name string, text []byte, data *Data) ([]byte, error) {
+	tmpl, err := template.New(name).
+		Funcs(funcs).
+		Option("missingkey=error").
+		Parse(string(text))
+	if err != nil {
+		return nil, err
+	}
+	for _, t := range tmpl.Templates() {
+		if t.Tree != nil {
+			escapeActions(t.Tree, t.Tree.Root)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// The functions available to templates, in addition to the built-in ones.
+var funcs = template.FuncMap{
+	"mof": escapeMOF,
+	"raw": func(v interface{}) rawString { return rawString(fmt.Sprint(v)) },
+}
+
+// rawString is output that isn't escaped, either because it already has been
+// or because it's marked raw.
+type rawString string
+
+// escapeActions appends a call to the mof function to every action under
+// node that prints a value.
+func escapeActions(tree *parse.Tree, node parse.Node) {
+	switch n := node.(type) {
+	case *parse.ListNode:
+		if n == nil {
+			return
+		}
+		for _, child := range n.Nodes {
+			escapeActions(tree, child)
+		}
+	case *parse.ActionNode:
+		// Actions that declare variables don't print anything.
+		if len(n.Pipe.Decl) > 0 {
+			return
+		}
+		n.Pipe.Cmds = append(n.Pipe.Cmds, &parse.CommandNode{
+			NodeType: parse.NodeCommand,
+			Pos:      n.Pos,
+			Args:     []parse.Node{parse.NewIdentifier("mof").SetTree(tree).SetPos(n.Pos)},
+		})
+	case *parse.IfNode:
+		escapeActions(tree, n.List)
+		escapeActions(tree, n.ElseList)
+	case *parse.RangeNode:
+		escapeActions(tree, n.List)
+		escapeActions(tree, n.ElseList)
+	case *parse.WithNode:
+		escapeActions(tree, n.List)
+		escapeActions(tree, n.ElseList)
+	}
+}
+
+var mofReplacer = strings.NewReplacer(
+	`\`, `\\`,
+	`"`, `\"`,
+	"\n", `\n`,
+	"\r", `\r`,
+	"\t", `\t`,
+)
+
+// escapeMOF escapes a value for use inside a MOF string literal. Values that
+// are already escaped, or marked raw, are returned as they are.
+func escapeMOF(v interface{}) rawString {
+	if s, ok := v.(rawString); ok {
+		return s
+	}
+	return rawString(mofReplacer.Replace(fmt.Sprint(v)))
+}
+
+// data builds the Data for an agent from its registration and variables.
+func (c *ConfigurationRepository) data(ctx context.Context, agentId string) (*Data, error) {
+	reg, err := c.registrations.GetRegistration(ctx, agentId)
+	if err != nil {
+		return nil, err
+	}
+
+	info := reg.AgentInformation
+	cert := reg.RegistrationInformation.CertificateInformation
+	ret := &Data{
+		AgentID:    agentId,
+		NodeName:   stringValue(info.NodeName),
+		IPAddress:  stringValue(info.IPAddress),
+		LCMVersion: stringValue(info.LCMVersion),
+		Certificate: Certificate{
+			FriendlyName: stringValue(cert.FriendlyName),
+			Issuer:       stringValue(cert.Issuer),
+			NotAfter:     stringValue(cert.NotAfter),
+			NotBefore:    stringValue(cert.NotBefore),
+			PublicKey:    stringValue(cert.PublicKey),
+			Subject:      stringValue(cert.Subject),
+			Thumbprint:   stringValue(cert.Thumbprint),
+		},
+		Vars: map[string]interface{}{},
+	}
+	for _, addr := range strings.Split(ret.IPAddress, ";") {
+		if addr = strings.TrimSpace(addr); addr != "" {
+			ret.IPAddresses = append(ret.IPAddresses, addr)
+		}
+	}
+
+	if c.vars != nil {
+		vars, err := c.vars.GetVars(ctx, agentId, ret.NodeName)
+		if err != nil {
+			return nil, err
+		}
+		if vars != nil {
+			ret.Vars = vars
+		}
+	}
+	return ret, nil
+}
+
+func stringValue(s *string) string {
+	if s == nil {
+		return ""
+	}
+	return *s
+}
+
+func (c *ConfigurationRepository) GetModule(
+	ctx context.Context,
+	req types.GetModuleRequest,
+) (*types.GetModuleResponse, error) {
+	return c.base.GetModule(ctx, req)
+}
+
+// ListModuleVersions implements the dsc.ModuleVersionLister interface if the
+// underlying repository does.
+func (c *ConfigurationRepository) ListModuleVersions(
+	ctx context.Context,
+	req types.ListModuleVersionsRequest,
+) (*types.ListModuleVersionsResponse, error) {
+	if lister, ok := c.base.(dsc.ModuleVersionLister); ok {
+		return lister.ListModuleVersions(ctx, req)
+	}
+	return &types.ListModuleVersionsResponse{}, nil
+}
+
+// GetMetaConfiguration implements the dsc.MetaConfigurationRepository
+// interface if the underlying repository does. Meta-configurations aren't
+// rendered.
+func (c *ConfigurationRepository) GetMetaConfiguration(
+	ctx context.Context,
+	req types.GetMetaConfigurationRequest,
+) (*types.GetMetaConfigurationResponse, error) {
+	if meta, ok := c.base.(dsc.MetaConfigurationRepository); ok {
+		return meta.GetMetaConfiguration(ctx, req)
+	}
+	return nil, nil
+}
@@ -0,0 +1,120 @@
+package template
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/static"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/util"
+)
+
+const mof = `instance of MSFT_Example
+{
+    NodeName = "{{ .NodeName }}";
+    Address = "{{ index .IPAddresses 0 }}";
+    Role = "{{ .Vars.role }}";
+};
+`
+
+func TestTemplate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "dsc-template")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "default.json"), []byte(`{"role": "web"}`), 0644))
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "db01.json"), []byte(`{"role": "db"}`), 0644))
+
+	nodes := map[string]string{
+		"agent-1": `WEB"01`,
+		"agent-2": "DB01",
+	}
+	registrations := dsc.RegistrationGetterFunc(func(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+		name, ok := nodes[agentId]
+		if !ok {
+			return nil, types.AgentNotRegisteredError{AgentID: agentId}
+		}
+		addr := "10.0.0.1;fe80::1"
+		return &types.RegisterDscAgentRequestBody{
+			AgentInformation: types.RegisterAgentInformation{
+				NodeName:  &name,
+				IPAddress: &addr,
+			},
+		}, nil
+	})
+
+	ctx := context.Background()
+	repo := New(static.New([]byte(mof), nil), registrations, WithVars(NewDirVars(dir)))
+
+	testCases := []struct {
+		AgentID string
+		Want    string
+	}{
+		{"agent-1", `NodeName = "WEB\"01";` + "\n" + `    Address = "10.0.0.1";` + "\n" + `    Role = "web";`},
+		{"agent-2", `NodeName = "DB01";` + "\n" + `    Address = "10.0.0.1";` + "\n" + `    Role = "db";`},
+	}
+	hashes := make(map[string]bool)
+	for _, tc := range testCases {
+		resp, err := repo.GetConfiguration(ctx, types.GetConfigurationRequest{
+			AgentID:           tc.AgentID,
+			ConfigurationName: "example",
+		})
+		require.NoError(t, err)
+		content, err := ioutil.ReadAll(resp.Content)
+		require.NoError(t, err)
+		assert.Contains(t, string(content), tc.Want, "agent %q", tc.AgentID)
+
+		// Each agent is compared against its own rendered configuration.
+		hash, _, err := util.GetConfigHash(ctx, repo, tc.AgentID, "example")
+		require.NoError(t, err)
+		assert.Equal(t, resp.Checksum, hash, "agent %q", tc.AgentID)
+		hashes[hash] = true
+	}
+	assert.Len(t, hashes, 2)
+
+	// Agents must be registered for their configuration to be rendered.
+	_, err = repo.GetConfiguration(ctx, types.GetConfigurationRequest{AgentID: "agent-3", ConfigurationName: "example"})
+	assert.IsType(t, types.AgentNotRegisteredError{}, err)
+}
+
+func TestRenderMissingVariable(t *testing.T) {
+	_, err := Render("example", []byte(`{{ .Vars.missing }}`), &Data{Vars: map[string]interface{}{}})
+	assert.Error(t, err)
+}
+
+func TestRenderEscapes(t *testing.T) {
+	data := &Data{
+		NodeName:    "WEB01\";\n};\ninstance of MSFT_Evil\n{\n    Name = \"x",
+		IPAddresses: []string{`10.0.0.1"`, `fe80::1\`},
+		Vars:        map[string]interface{}{"resource": `instance of MSFT_Trusted { Name = "a"; };`},
+	}
+	render := func(text string) string {
+		out, err := Render("example", []byte(text), data)
+		require.NoError(t, err)
+		return string(out)
+	}
+
+	// Facts can't end the string literal they're inserted into, whether or
+	// not the template escapes them.
+	const escaped = `WEB01\";\n};\ninstance of MSFT_Evil\n{\n    Name = \"x`
+	assert.Equal(t, `Name = "`+escaped+`";`, render(`Name = "{{ .NodeName }}";`))
+	assert.Equal(t, `Name = "`+escaped+`";`, render(`Name = "{{ mof .NodeName }}";`))
+	assert.Equal(t, `Name = "`+escaped+`";`, render(`Name = "{{ .NodeName | mof }}";`))
+
+	// That includes actions inside other actions, and in defined templates.
+	assert.Equal(t, `"10.0.0.1\"" "fe80::1\\" `, render(`{{ range .IPAddresses }}"{{ . }}" {{ end }}`))
+	assert.Equal(t, `"10.0.0.1\""`, render(`{{ if .IPAddresses }}"{{ index .IPAddresses 0 }}"{{ end }}`))
+	assert.Equal(t, `"10.0.0.1\""`, render(`{{ define "addr" }}"{{ . }}"{{ end }}{{ template "addr" index .IPAddresses 0 }}`))
+	assert.Equal(t, `"10.0.0.1\""`, render(`{{ $addr := index .IPAddresses 0 }}"{{ $addr }}"`))
+
+	// Output has to be marked raw to be inserted as it is.
+	assert.Equal(t, `instance of MSFT_Trusted { Name = "a"; };`, render(`{{ raw .Vars.resource }}`))
+	assert.Equal(t, `instance of MSFT_Trusted { Name = \"a\"; };`, render(`{{ .Vars.resource }}`))
+}
@@ -0,0 +1,81 @@
+package template
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/fsmock"
+)
+
+// DirVars is a VarsSource that reads variables from JSON files in a
+// directory. Each file contains a JSON object, and the variables for a node
+// are taken from the following files, with later ones overriding the values
+// of earlier ones:
+//
+//     default.json
+//     <node name>.json
+//     <agent ID>.json
+//
+// File names are lower-cased, and files that don't exist are ignored.
+//
+// The node name is reported by the agent when it registers, and isn't
+// verified, so an agent can read the variables of any node by registering
+// with its name; files named after nodes must not contain secrets. The agent
+// ID is only as trustworthy as the agent's authentication, but with
+// dsc.WithClientCertificateBinding it is tied to the agent's certificate, so
+// per-node secrets belong in files named after agent IDs.
+type DirVars struct {
+	dir string
+	fs  fsmock.FileSystem
+}
+
+func NewDirVars(dir string) *DirVars {
+	return &DirVars{dir: dir, fs: fsmock.OSFS{}}
+}
+
+func (d *DirVars) GetVars(ctx context.Context, agentId, nodeName string) (map[string]interface{}, error) {
+	var ret map[string]interface{}
+	for _, name := range []string{"default", nodeName, agentId} {
+		if name == "" {
+			continue
+		}
+
+		vars, err := d.read(strings.ToLower(name) + ".json")
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range vars {
+			if ret == nil {
+				ret = make(map[string]interface{})
+			}
+			ret[k] = v
+		}
+	}
+	return ret, nil
+}
+
+func (d *DirVars) read(name string) (map[string]interface{}, error) {
+	// Node names come from the agent, so don't let them escape the
+	// directory.
+	if strings.ContainsAny(name, `/\`) {
+		return nil, nil
+	}
+
+	f, err := d.fs.Open(filepath.Join(d.dir, name))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+
+	var vars map[string]interface{}
+	if err := json.NewDecoder(f).Decode(&vars); err != nil {
+		return nil, err
+	}
+	return vars, nil
+}
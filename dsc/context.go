@@ -0,0 +1,34 @@
+package dsc
+
+import (
+	"context"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+type contextKey int
+
+const (
+	registrationKeyContextKey contextKey = iota
+)
+
+// RegistrationKeyFromContext returns the registration key that the current
+// request was signed with. It returns false if the request wasn't signed, or
+// if no keys were configured.
+func RegistrationKeyFromContext(ctx context.Context) (*types.RegistrationKey, bool) {
+	key, ok := ctx.Value(registrationKeyContextKey).(*types.RegistrationKey)
+	return key, ok
+}
+
+// registrationKeyID returns the ID of the registration key that the current
+// request was signed with, or the empty string if there is none.
+func registrationKeyID(ctx context.Context) string {
+	if key, ok := RegistrationKeyFromContext(ctx); ok {
+		return key.ID
+	}
+	return ""
+}
+
+func withRegistrationKey(ctx context.Context, key *types.RegistrationKey) context.Context {
+	return context.WithValue(ctx, registrationKeyContextKey, key)
+}
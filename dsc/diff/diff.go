@@ -0,0 +1,370 @@
+// Package diff compares two versions of a configuration resource by resource,
+// to show what a new checksum actually changes. Resources are matched by
+// their ResourceID, and their properties by name, ignoring case; the
+// instances that properties refer to (credentials, embedded instances) are
+// compared by content, so renumbered aliases don't show up as changes.
+package diff
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+)
+
+// Properties that are ignored by default, since they change whenever the
+// configuration's source is edited or recompiled, without changing what is
+// applied.
+var DefaultIgnore = []string{"SourceInfo"}
+
+// Properties whose values are never shown.
+var redacted = map[string]bool{
+	"password": true,
+}
+
+// Kind is the kind of a change.
+type Kind string
+
+const (
+	Added   Kind = "added"
+	Removed Kind = "removed"
+	Changed Kind = "changed"
+)
+
+func (k Kind) symbol() string {
+	switch k {
+	case Added:
+		return "+"
+	case Removed:
+		return "-"
+	}
+	return "~"
+}
+
+// PropertyChange is a change to a property of a resource.
+type PropertyChange struct {
+	Kind Kind
+	Name string
+
+	// The old and new values, formatted as in a MOF document; Old is
+	// empty for added properties, and New for removed ones.
+	Old string
+	New string
+}
+
+func (p PropertyChange) String() string {
+	switch p.Kind {
+	case Added:
+		return fmt.Sprintf("+ %s = %s", p.Name, p.New)
+	case Removed:
+		return fmt.Sprintf("- %s = %s", p.Name, p.Old)
+	}
+	return fmt.Sprintf("~ %s: %s -> %s", p.Name, p.Old, p.New)
+}
+
+// ResourceChange is a change to a resource.
+type ResourceChange struct {
+	Kind       Kind
+	ResourceID string
+
+	// The properties that changed. For added and removed resources, this
+	// is every property of the resource.
+	Properties []PropertyChange
+}
+
+// Result is the difference between two documents.
+type Result struct {
+	// The changed resources: removed resources in the order of the old
+	// document, followed by added and changed resources in the order of
+	// the new one.
+	Changes []ResourceChange
+}
+
+// Empty returns whether the documents have the same resources.
+func (r *Result) Empty() bool {
+	return len(r.Changes) == 0
+}
+
+// Summary returns a one-line summary of the changes, e.g. "1 added, 2
+// changed".
+func (r *Result) Summary() string {
+	counts := make(map[Kind]int)
+	for _, c := range r.Changes {
+		counts[c.Kind]++
+	}
+
+	var parts []string
+	for _, kind := range []Kind{Added, Removed, Changed} {
+		if counts[kind] > 0 {
+			parts = append(parts, fmt.Sprintf("%d %s", counts[kind], kind))
+		}
+	}
+	if len(parts) == 0 {
+		return "no changes"
+	}
+	return strings.Join(parts, ", ")
+}
+
+// String returns the changes in a human-readable format, e.g.:
+//
+//     ~ [xWebsite]DefaultSite
+//         ~ State: "Started" -> "Stopped"
+//     + [Service]W3SVC
+//         + Name = "W3SVC"
+func (r *Result) String() string {
+	var sb strings.Builder
+	for _, c := range r.Changes {
+		fmt.Fprintf(&sb, "%s %s\n", c.Kind.symbol(), c.ResourceID)
+		for _, p := range c.Properties {
+			fmt.Fprintf(&sb, "    %s\n", p)
+		}
+	}
+	return sb.String()
+}
+
+// Option configures a comparison.
+type Option func(*options)
+
+type options struct {
+	ignore map[string]bool
+}
+
+// WithIgnore sets the properties to ignore, instead of DefaultIgnore.
+func WithIgnore(names ...string) Option {
+	return func(o *options) {
+		o.ignore = make(map[string]bool)
+		for _, name := range names {
+			o.ignore[strings.ToLower(name)] = true
+		}
+	}
+}
+
+// Diff compares the resources of an old version of a document, from, with
+// those of a new version, to.
+func Diff(from, to *mof.Document, opts ...Option) *Result {
+	o := &options{}
+	WithIgnore(DefaultIgnore...)(o)
+	for _, opt := range opts {
+		opt(o)
+	}
+
+	oldResources := index(from)
+	newResources := index(to)
+	ret := &Result{}
+
+	for _, r := range from.Resources() {
+		if _, ok := newResources[strings.ToLower(r.ResourceID())]; !ok {
+			ret.Changes = append(ret.Changes, ResourceChange{
+				Kind:       Removed,
+				ResourceID: r.ResourceID(),
+				Properties: o.compare(from, r, to, nil),
+			})
+		}
+	}
+
+	for _, r := range to.Resources() {
+		oldR, ok := oldResources[strings.ToLower(r.ResourceID())]
+		if !ok {
+			ret.Changes = append(ret.Changes, ResourceChange{
+				Kind:       Added,
+				ResourceID: r.ResourceID(),
+				Properties: o.compare(from, nil, to, r),
+			})
+			continue
+		}
+
+		if props := o.compare(from, oldR, to, r); len(props) > 0 {
+			ret.Changes = append(ret.Changes, ResourceChange{
+				Kind:       Changed,
+				ResourceID: r.ResourceID(),
+				Properties: props,
+			})
+		}
+	}
+	return ret
+}
+
+// index returns the resources of a document, keyed by lower-cased
+// ResourceID.
+func index(doc *mof.Document) map[string]*mof.Instance {
+	ret := make(map[string]*mof.Instance)
+	for _, r := range doc.Resources() {
+		ret[strings.ToLower(r.ResourceID())] = r
+	}
+	return ret
+}
+
+// compare returns the changes between the properties of two instances,
+// either of which may be nil: properties of the new instance in order,
+// followed by those that were removed.
+func (o *options) compare(oldDoc *mof.Document, oldInst *mof.Instance, newDoc *mof.Document, newInst *mof.Instance) []PropertyChange {
+	var ret []PropertyChange
+	seen := make(map[string]bool)
+
+	if newInst != nil {
+		for _, p := range newInst.Properties {
+			name := strings.ToLower(p.Name)
+			seen[name] = true
+			if o.ignore[name] {
+				continue
+			}
+
+			newValue := canonical(newDoc, p.Value, nil)
+			var oldValue interface{}
+			found := false
+			if oldInst != nil {
+				var v interface{}
+				if v, found = oldInst.Get(p.Name); found {
+					oldValue = canonical(oldDoc, v, nil)
+				}
+			}
+
+			switch {
+			case !found:
+				ret = append(ret, PropertyChange{Kind: Added, Name: p.Name, New: format(p.Name, newValue)})
+			case !equal(oldValue, newValue):
+				ret = append(ret, PropertyChange{
+					Kind: Changed,
+					Name: p.Name,
+					Old:  format(p.Name, oldValue),
+					New:  format(p.Name, newValue),
+				})
+			}
+		}
+	}
+
+	if oldInst != nil {
+		for _, p := range oldInst.Properties {
+			name := strings.ToLower(p.Name)
+			if seen[name] || o.ignore[name] {
+				continue
+			}
+			ret = append(ret, PropertyChange{Kind: Removed, Name: p.Name, Old: format(p.Name, canonical(oldDoc, p.Value, nil))})
+		}
+	}
+	return ret
+}
+
+// instance is the canonical form of an instance that a property refers to.
+type instance struct {
+	class string
+
+	// Property names in their original case, sorted ignoring case, and
+	// values keyed by lower-cased name.
+	names  []string
+	values map[string]interface{}
+}
+
+// canonical returns a value in a form that can be compared with equal:
+// instances are resolved and replaced with their content. visiting guards
+// against reference cycles.
+func canonical(doc *mof.Document, value interface{}, visiting map[*mof.Instance]bool) interface{} {
+	switch v := value.(type) {
+	case mof.Reference, *mof.Instance:
+		inst := doc.Resolve(v)
+		if inst == nil {
+			// A dangling reference is compared by alias.
+			return v
+		}
+		if visiting[inst] {
+			return mof.Reference(inst.Alias)
+		}
+		if visiting == nil {
+			visiting = make(map[*mof.Instance]bool)
+		}
+		visiting[inst] = true
+		defer delete(visiting, inst)
+
+		ret := &instance{class: inst.Class, values: make(map[string]interface{})}
+		for _, p := range inst.Properties {
+			ret.names = append(ret.names, p.Name)
+			ret.values[strings.ToLower(p.Name)] = canonical(doc, p.Value, visiting)
+		}
+		sort.Slice(ret.names, func(i, j int) bool {
+			return strings.ToLower(ret.names[i]) < strings.ToLower(ret.names[j])
+		})
+		return ret
+	case []interface{}:
+		ret := make([]interface{}, len(v))
+		for i, elem := range v {
+			ret[i] = canonical(doc, elem, visiting)
+		}
+		return ret
+	}
+	return value
+}
+
+// equal returns whether two canonical values are equal. Class and property
+// names are compared ignoring case, but values aren't.
+func equal(a, b interface{}) bool {
+	switch a := a.(type) {
+	case *instance:
+		b, ok := b.(*instance)
+		if !ok || !strings.EqualFold(a.class, b.class) || len(a.values) != len(b.values) {
+			return false
+		}
+		for name, av := range a.values {
+			bv, ok := b.values[name]
+			if !ok || !equal(av, bv) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		b, ok := b.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !equal(a[i], b[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return a == b
+}
+
+// format formats a canonical value for display.
+func format(name string, value interface{}) string {
+	if redacted[strings.ToLower(name)] {
+		if _, ok := value.(string); ok {
+			return "(redacted)"
+		}
+	}
+
+	switch v := value.(type) {
+	case nil:
+		return "NULL"
+	case string:
+		return strconv.Quote(v)
+	case rune:
+		return strconv.QuoteRune(v)
+	case bool:
+		if v {
+			return "True"
+		}
+		return "False"
+	case int64:
+		return strconv.FormatInt(v, 10)
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	case mof.Reference:
+		return "$" + string(v)
+	case []interface{}:
+		var elems []string
+		for _, elem := range v {
+			elems = append(elems, format(name, elem))
+		}
+		return "{" + strings.Join(elems, ", ") + "}"
+	case *instance:
+		var props []string
+		for _, n := range v.names {
+			props = append(props, fmt.Sprintf("%s = %s", n, format(n, v.values[strings.ToLower(n)])))
+		}
+		return fmt.Sprintf("instance of %s {%s}", v.class, strings.Join(props, "; "))
+	}
+	return fmt.Sprint(value)
+}
@@ -0,0 +1,144 @@
+package diff
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+)
+
+const before = `instance of MSFT_Credential as $MSFT_Credential1ref
+{
+    UserName = "CORP\\svc-web";
+    Password = "hunter2";
+};
+
+instance of MSFT_RoleResource as $MSFT_RoleResource1ref
+{
+    ResourceID = "[WindowsFeature]IIS";
+    SourceInfo = "C:\\dsc\\WebServer.ps1::12::9::WindowsFeature";
+    Ensure = "Present";
+    Name = "Web-Server";
+};
+
+instance of MSFT_UserResource as $MSFT_UserResource1ref
+{
+    ResourceID = "[User]AppPool";
+    UserName = "svc-web";
+    Password = $MSFT_Credential1ref;
+};
+
+instance of MSFT_FileDirectoryConfiguration as $MSFT_FileDirectoryConfiguration1ref
+{
+    ResourceID = "[File]Old";
+    DestinationPath = "C:\\old.txt";
+};
+
+instance of MSFT_ServiceResource as $MSFT_ServiceResource1ref
+{
+    ResourceID = "[Service]W3SVC";
+    Name = "W3SVC";
+    State = "Running";
+    StartupType = "Automatic";
+    DependsOn = {"[WindowsFeature]IIS"};
+};
+`
+
+// The same configuration, recompiled with a new resource that renumbers the
+// credential's alias, and some changes.
+const after = `instance of MSFT_Credential as $MSFT_Credential1ref
+{
+    UserName = "CORP\\svc-sql";
+    Password = "hunter3";
+};
+
+instance of MSFT_Credential as $MSFT_Credential2ref
+{
+    UserName = "CORP\\svc-web";
+    Password = "hunter2";
+};
+
+instance of MSFT_RoleResource as $MSFT_RoleResource1ref
+{
+    ResourceID = "[WindowsFeature]IIS";
+    SourceInfo = "C:\\dsc\\WebServer.ps1::14::9::WindowsFeature";
+    Ensure = "Present";
+    Name = "Web-Server";
+};
+
+instance of MSFT_UserResource as $MSFT_UserResource1ref
+{
+    ResourceID = "[User]AppPool";
+    username = "svc-web";
+    Password = $MSFT_Credential2ref;
+};
+
+instance of MSFT_UserResource as $MSFT_UserResource2ref
+{
+    ResourceID = "[User]Sql";
+    UserName = "svc-sql";
+    Password = $MSFT_Credential1ref;
+};
+
+instance of MSFT_ServiceResource as $MSFT_ServiceResource1ref
+{
+    ResourceID = "[Service]W3SVC";
+    Name = "W3SVC";
+    State = "Stopped";
+    DependsOn = {"[WindowsFeature]IIS"};
+    Description = "World Wide Web Publishing Service";
+};
+`
+
+func parse(t *testing.T, s string) *mof.Document {
+	doc, err := mof.Parse([]byte(s))
+	require.NoError(t, err)
+	return doc
+}
+
+func TestDiff(t *testing.T) {
+	result := Diff(parse(t, before), parse(t, after))
+	assert.Equal(t, "1 added, 1 removed, 1 changed", result.Summary())
+
+	require.Len(t, result.Changes, 3)
+	assert.Equal(t, ResourceChange{
+		Kind:       Removed,
+		ResourceID: "[File]Old",
+		Properties: []PropertyChange{
+			{Kind: Removed, Name: "ResourceID", Old: `"[File]Old"`},
+			{Kind: Removed, Name: "DestinationPath", Old: `"C:\\old.txt"`},
+		},
+	}, result.Changes[0])
+
+	assert.Equal(t, Added, result.Changes[1].Kind)
+	assert.Equal(t, "[User]Sql", result.Changes[1].ResourceID)
+	assert.Contains(t, result.Changes[1].Properties, PropertyChange{
+		Kind: Added,
+		Name: "Password",
+		New:  `instance of MSFT_Credential {Password = (redacted); UserName = "CORP\\svc-sql"}`,
+	})
+
+	assert.Equal(t, ResourceChange{
+		Kind:       Changed,
+		ResourceID: "[Service]W3SVC",
+		Properties: []PropertyChange{
+			{Kind: Changed, Name: "State", Old: `"Running"`, New: `"Stopped"`},
+			{Kind: Added, Name: "Description", New: `"World Wide Web Publishing Service"`},
+			{Kind: Removed, Name: "StartupType", Old: `"Automatic"`},
+		},
+	}, result.Changes[2])
+
+	assert.Equal(t, `- [File]Old
+    - ResourceID = "[File]Old"
+    - DestinationPath = "C:\\old.txt"
+`, (&Result{Changes: result.Changes[:1]}).String())
+
+	// SourceInfo is only compared if asked.
+	result = Diff(parse(t, before), parse(t, after), WithIgnore())
+	require.Len(t, result.Changes, 4)
+	assert.Equal(t, "[WindowsFeature]IIS", result.Changes[1].ResourceID)
+
+	assert.True(t, Diff(parse(t, before), parse(t, before)).Empty())
+}
@@ -2,6 +2,7 @@ package dsc
 
 import (
 	"context"
+	"time"
 
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
@@ -50,3 +51,128 @@ type NodeStatus interface {
 	// state of the node and return an appropriate action to perform.
 	GetDscAction(ctx context.Context, req types.GetDscActionRequest) (*types.GetDscActionResponse, error)
 }
+
+// CertificateRotator is an optional interface that a NodeStatus can implement
+// in order to support the CertificateRotation request. If the NodeStatus does
+// not implement this interface, the request is rejected as not supported.
+type CertificateRotator interface {
+	// RotateCertificate is called by the client in order to replace the
+	// certificate that it provided at registration time with a new one.
+	RotateCertificate(ctx context.Context, req types.CertificateRotationRequest) (*types.CertificateRotationResponse, error)
+}
+
+// ModuleVersionLister is an optional interface for a ConfigurationRepository
+// that can list the versions of a module. If implemented, GetModule requests
+// with an empty ModuleVersion are served the pinned default version of the
+// module if there is one, and the highest version otherwise.
+type ModuleVersionLister interface {
+	// ListModuleVersions returns the versions of the given module. If the
+	// module doesn't exist, it returns an empty list.
+	ListModuleVersions(ctx context.Context, req types.ListModuleVersionsRequest) (*types.ListModuleVersionsResponse, error)
+}
+
+// ConfigurationLister is an optional interface for a ConfigurationRepository
+// that can list the configurations it serves, which is used by tools that
+// check every configuration in a repository.
+type ConfigurationLister interface {
+	// ListConfigurations returns the names of all configurations in the
+	// repository.
+	ListConfigurations(ctx context.Context) ([]string, error)
+}
+
+// ConfigurationIDResolver maps the ConfigurationId used by protocol version
+// 1.0 and 1.1 clients to the name of a configuration in the
+// ConfigurationRepository.
+type ConfigurationIDResolver interface {
+	// ResolveConfigurationID returns the name of the configuration for
+	// the given ConfigurationId, or a ConfigurationNotFoundError if there
+	// is none.
+	ResolveConfigurationID(ctx context.Context, id string) (string, error)
+}
+
+// AssignmentStore is the interface that should be implemented in order to
+// assign configurations to nodes on the server, rather than relying on the
+// ConfigurationNames that the client sent when registering.
+type AssignmentStore interface {
+	// GetAssignment returns the configurations assigned to the given
+	// node. If no assignment applies to the node, it should return a nil
+	// response and a nil error.
+	GetAssignment(ctx context.Context, req types.GetAssignmentRequest) (*types.GetAssignmentResponse, error)
+}
+
+// ConfigurationNameResolver is an optional interface that a NodeStatus can
+// implement in order to serve a client a different configuration than the
+// one it asked for, e.g. because of a server-side assignment.
+type ConfigurationNameResolver interface {
+	// ResolveConfigurationName returns the name of the configuration
+	// that should be served when the given agent requests the named
+	// configuration.
+	ResolveConfigurationName(ctx context.Context, agentId, name string) (string, error)
+}
+
+// MetaConfigurationRepository is the interface that should be implemented in
+// order to push meta-configuration (LCM settings) updates to DSC clients.
+type MetaConfigurationRepository interface {
+	// GetMetaConfiguration returns the meta-configuration that the given
+	// agent should have applied. If there is none, it should return a nil
+	// response and a nil error.
+	GetMetaConfiguration(ctx context.Context, req types.GetMetaConfigurationRequest) (*types.GetMetaConfigurationResponse, error)
+}
+
+// MetaConfigurationTracker is an optional interface that a NodeStatus can
+// implement in order to track the meta-configuration applied by each agent.
+// Meta-configuration updates are only pushed if the NodeStatus implements this
+// interface.
+//
+// The LCM doesn't report applying a meta-configuration, but it registers
+// again when it does; so a meta-configuration that was served to an agent
+// should be considered applied when the agent next registers. Until then, the
+// agent is told to update its meta-configuration, so that a failed update is
+// retried.
+type MetaConfigurationTracker interface {
+	// GetMetaConfigurationChecksum returns the checksum of the
+	// meta-configuration that the agent last applied, or the empty
+	// string if it hasn't applied one.
+	GetMetaConfigurationChecksum(ctx context.Context, agentId string) (string, error)
+
+	// SetServedMetaConfigurationChecksum records the checksum of a
+	// meta-configuration served to the agent, which becomes the applied
+	// one when the agent next registers.
+	SetServedMetaConfigurationChecksum(ctx context.Context, agentId, checksum string) error
+}
+
+// KeyStore is the interface that should be implemented in order to provide
+// the registration keys that clients sign their requests with.
+type KeyStore interface {
+	// GetKeys returns every registration key, including any that are
+	// revoked or expired.
+	GetKeys(ctx context.Context) ([]types.RegistrationKey, error)
+}
+
+// ReplayCache is the interface that should be implemented in order to reject
+// signed requests that have already been seen. Deployments with multiple
+// servers should use an implementation that shares state between them.
+type ReplayCache interface {
+	// Seen records a request signature, which can't be replayed after the
+	// given time, and returns whether it had already been recorded.
+	Seen(ctx context.Context, signature string, expires time.Time) (bool, error)
+}
+
+// RegistrationGetter is an optional interface that a NodeStatus can implement
+// in order to return the information that an agent registered with.
+type RegistrationGetter interface {
+	// GetRegistration returns the most recent registration of the given
+	// agent, including any rotated certificate, or an
+	// AgentNotRegisteredError if it isn't registered.
+	GetRegistration(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error)
+}
+
+// RegistrationGetterFunc is an adapter to allow the use of a function as a
+// RegistrationGetter; e.g. to give a ConfigurationRepository access to the
+// registrations stored by a NodeStatus that is created after it.
+type RegistrationGetterFunc func(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error)
+
+// GetRegistration calls f(ctx, agentId).
+func (f RegistrationGetterFunc) GetRegistration(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+	return f(ctx, agentId)
+}
@@ -0,0 +1,95 @@
+// Package checksum calculates and verifies the checksums that are sent to
+// clients alongside configurations and modules, without needing to hold the
+// content in memory.
+package checksum
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"io"
+	"strings"
+)
+
+// The checksum algorithm that is used by all ConfigurationRepositories.
+const SHA256 = "SHA-256"
+
+// Sum returns the SHA-256 checksum of everything read from r, in the format
+// sent to clients.
+func Sum(r io.Reader) (string, error) {
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return encode(h), nil
+}
+
+// SumBytes returns the SHA-256 checksum of b, in the format sent to clients.
+func SumBytes(b []byte) string {
+	h := sha256.Sum256(b)
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+func encode(h hash.Hash) string {
+	return strings.ToUpper(hex.EncodeToString(h.Sum(nil)))
+}
+
+// MismatchError is returned by a VerifyingReader when the content doesn't
+// match the expected checksum.
+type MismatchError struct {
+	Expected string
+	Actual   string
+}
+
+func (e MismatchError) Error() string {
+	return fmt.Sprintf("checksum: content has checksum %q, expected %q", e.Actual, e.Expected)
+}
+
+// VerifyingReader hashes content as it is read, and returns a MismatchError
+// instead of io.EOF if it doesn't match the expected checksum.
+type VerifyingReader struct {
+	r        io.Reader
+	h        hash.Hash
+	expected string
+}
+
+// NewVerifyingReader returns a reader that verifies that the content read
+// from r has the given checksum. If the checksum algorithm isn't supported,
+// the content is passed through unverified.
+func NewVerifyingReader(r io.Reader, algo, expected string) io.Reader {
+	if !strings.EqualFold(algo, SHA256) {
+		return r
+	}
+	return &VerifyingReader{
+		r:        r,
+		h:        sha256.New(),
+		expected: expected,
+	}
+}
+
+func (v *VerifyingReader) Read(p []byte) (int, error) {
+	n, err := v.r.Read(p)
+	v.h.Write(p[:n])
+
+	if err == io.EOF {
+		if actual := encode(v.h); !strings.EqualFold(actual, v.expected) {
+			return n, MismatchError{
+				Expected: v.expected,
+				Actual:   actual,
+			}
+		}
+	}
+	return n, err
+}
+
+// Normalize returns the given hex-encoded SHA-256 checksum in the format sent
+// to clients, or the empty string if it isn't valid. Surrounding whitespace
+// is ignored, so that it can be used on the contents of checksum files.
+func Normalize(s string) string {
+	s = strings.TrimSpace(s)
+	if b, err := hex.DecodeString(s); err != nil || len(b) != sha256.Size {
+		return ""
+	}
+	return strings.ToUpper(s)
+}
@@ -2,6 +2,7 @@ package fsmock
 
 import (
 	"io"
+	"io/ioutil"
 	"os"
 )
 
@@ -11,6 +12,7 @@ type FileSystem interface {
 	Create(name string) (File, error)
 	Stat(name string) (os.FileInfo, error)
 	MkdirAll(path string, perm os.FileMode) error
+	ReadDir(dirname string) ([]os.FileInfo, error)
 }
 
 // Mock return value for a file
@@ -26,7 +28,8 @@ type File interface {
 // OSFS implements FileSystem using the local disk.
 type OSFS struct{}
 
-func (OSFS) Open(name string) (File, error)               { return os.Open(name) }
-func (OSFS) Create(name string) (File, error)             { return os.Create(name) }
-func (OSFS) Stat(name string) (os.FileInfo, error)        { return os.Stat(name) }
-func (OSFS) MkdirAll(path string, perm os.FileMode) error { return os.Mkdir(path, perm) }
+func (OSFS) Open(name string) (File, error)                { return os.Open(name) }
+func (OSFS) Create(name string) (File, error)              { return os.Create(name) }
+func (OSFS) Stat(name string) (os.FileInfo, error)         { return os.Stat(name) }
+func (OSFS) MkdirAll(path string, perm os.FileMode) error  { return os.Mkdir(path, perm) }
+func (OSFS) ReadDir(dirname string) ([]os.FileInfo, error) { return ioutil.ReadDir(dirname) }
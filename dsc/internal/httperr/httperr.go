@@ -0,0 +1,75 @@
+// Package httperr writes error responses in the OData JSON format that the
+// LCM expects, e.g.:
+//
+//	{"error":{"code":"ConfigurationNotFound","message":"..."}}
+//
+// The status code and error code are taken from errors that implement
+// types.HTTPError; any other error is an internal server error.
+package httperr
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Error is a types.HTTPError for errors that are detected by the server
+// itself, rather than by a backend; e.g. malformed requests.
+type Error struct {
+	Status  int
+	Code    string
+	Message string
+}
+
+func (e Error) Error() string     { return e.Message }
+func (e Error) StatusCode() int   { return e.Status }
+func (e Error) ErrorCode() string { return e.Code }
+
+// BadRequest returns an Error for a malformed request.
+func BadRequest(format string, args ...interface{}) error {
+	return Error{http.StatusBadRequest, "BadRequest", fmt.Sprintf(format, args...)}
+}
+
+// Unauthorized returns an Error for a request that failed authentication.
+func Unauthorized(format string, args ...interface{}) error {
+	return Error{http.StatusUnauthorized, "Unauthorized", fmt.Sprintf(format, args...)}
+}
+
+// NotImplemented returns an Error for a request that the server doesn't
+// support.
+func NotImplemented(format string, args ...interface{}) error {
+	return Error{http.StatusNotImplemented, "NotImplemented", fmt.Sprintf(format, args...)}
+}
+
+// Status returns the HTTP status code and error code for the given error.
+func Status(err error) (int, string) {
+	var herr types.HTTPError
+	if errors.As(err, &herr) {
+		return herr.StatusCode(), herr.ErrorCode()
+	}
+	return http.StatusInternalServerError, "InternalServerError"
+}
+
+type body struct {
+	Error bodyError `json:"error"`
+}
+
+type bodyError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Write writes the given error to the response.
+func Write(w http.ResponseWriter, err error) {
+	status, code := Status(err)
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(&body{bodyError{
+		Code:    code,
+		Message: err.Error(),
+	}})
+}
@@ -0,0 +1,90 @@
+// Package lru implements a cache that is bounded by the total size of its
+// values, evicting the least recently used values first.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+type entry struct {
+	key   interface{}
+	value interface{}
+	size  int64
+}
+
+// Cache is a size-bounded LRU cache, which is safe for concurrent use. Keys
+// must be comparable.
+type Cache struct {
+	maxSize int64
+
+	lock    sync.Mutex
+	size    int64
+	entries map[interface{}]*list.Element
+	order   *list.List // most recently used first
+}
+
+// New creates a Cache that holds values with a total size of at most
+// maxSize.
+func New(maxSize int64) *Cache {
+	return &Cache{
+		maxSize: maxSize,
+		entries: make(map[interface{}]*list.Element),
+		order:   list.New(),
+	}
+}
+
+// Get returns the value for a key, and whether it was found.
+func (c *Cache) Get(key interface{}) (interface{}, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.entries[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*entry).value, true
+}
+
+// Add adds a value of the given size to the cache, replacing any value with
+// the same key, and evicts the least recently used values until the cache is
+// within its maximum size. A value larger than the maximum size isn't cached.
+func (c *Cache) Add(key, value interface{}, size int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if el, ok := c.entries[key]; ok {
+		c.remove(el)
+	}
+	if size > c.maxSize {
+		return
+	}
+
+	c.entries[key] = c.order.PushFront(&entry{key, value, size})
+	c.size += size
+	for c.size > c.maxSize {
+		c.remove(c.order.Back())
+	}
+}
+
+// Len returns the number of values in the cache.
+func (c *Cache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+// Size returns the total size of the values in the cache.
+func (c *Cache) Size() int64 {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.size
+}
+
+func (c *Cache) remove(el *list.Element) {
+	e := el.Value.(*entry)
+	c.order.Remove(el)
+	delete(c.entries, e.key)
+	c.size -= e.size
+}
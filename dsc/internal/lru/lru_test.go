@@ -0,0 +1,59 @@
+package lru
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCache(t *testing.T) {
+	c := New(10)
+	get := func(key string) interface{} {
+		v, _ := c.Get(key)
+		return v
+	}
+
+	c.Add("a", "A", 4)
+	c.Add("b", "B", 4)
+	assert.Equal(t, "A", get("a"))
+	assert.Equal(t, "B", get("b"))
+	assert.Equal(t, int64(8), c.Size())
+
+	// "a" is now the least recently used, so it's evicted first.
+	c.Add("c", "C", 4)
+	_, ok := c.Get("a")
+	assert.False(t, ok)
+	assert.Equal(t, "B", get("b"))
+	assert.Equal(t, "C", get("c"))
+	assert.Equal(t, 2, c.Len())
+	assert.Equal(t, int64(8), c.Size())
+
+	// Replacing a value updates its size.
+	c.Add("b", "B2", 1)
+	assert.Equal(t, "B2", get("b"))
+	assert.Equal(t, int64(5), c.Size())
+
+	// Values that don't fit aren't cached, and don't evict others.
+	c.Add("d", "D", 11)
+	_, ok = c.Get("d")
+	assert.False(t, ok)
+	assert.Equal(t, 2, c.Len())
+
+	// A value that fills the cache evicts everything else.
+	c.Add("e", "E", 10)
+	assert.Equal(t, "E", get("e"))
+	assert.Equal(t, 1, c.Len())
+	assert.Equal(t, int64(10), c.Size())
+}
+
+func TestCacheStructKeys(t *testing.T) {
+	type key struct{ kind, name string }
+
+	c := New(10)
+	c.Add(key{"module", "x"}, 1, 1)
+	v, ok := c.Get(key{"module", "x"})
+	assert.True(t, ok)
+	assert.Equal(t, 1, v)
+	_, ok = c.Get(key{"configuration", "x"})
+	assert.False(t, ok)
+}
@@ -1,16 +1,16 @@
 package middleware
 
 import (
-	"fmt"
 	"net/http"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/httperr"
 )
 
 func MaxBodySize(size int64) func(http.Handler) http.Handler {
 	return func(h http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			if r.ContentLength > size {
-				w.WriteHeader(http.StatusBadRequest)
-				fmt.Fprintf(w, "request body too large")
+				httperr.Write(w, httperr.BadRequest("request body too large"))
 				return
 			}
 
@@ -0,0 +1,148 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io"
+	"math/big"
+)
+
+// Object identifiers used in CMS envelopes.
+var (
+	OIDEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	OIDAES256CBC     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+type envelopedData struct {
+	Version              int
+	RecipientInfos       []keyTransRecipientInfo `asn1:"set"`
+	EncryptedContentInfo encryptedContentInfo
+}
+
+type keyTransRecipientInfo struct {
+	Version                int
+	IssuerAndSerialNumber  issuerAndSerialNumber
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+type encryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0,optional"`
+}
+
+// Encrypt returns a DER-encoded ContentInfo containing EnvelopedData, which
+// encrypts content for the holder of the given certificate's private key, as
+// by Protect-CmsMessage. The content is encrypted with AES-256-CBC, and the
+// key with RSA PKCS #1 v1.5, which every version of Windows can decrypt.
+//
+// Messages follow the CMS format as documented, but haven't been decrypted by
+// Unprotect-CmsMessage or a Windows LCM; the tests only decrypt them with
+// crypto/rsa and crypto/aes.
+func Encrypt(content []byte, cert *x509.Certificate) ([]byte, error) {
+	return EncryptWithRand(rand.Reader, content, cert)
+}
+
+// EncryptWithRand is like Encrypt, but reads the content-encryption key, the
+// IV and the padding of the encrypted key from random, always in the same
+// way; so the same content, certificate and stream of bytes produce the same
+// message. The stream must be unpredictable to anyone who can't decrypt it.
+func EncryptWithRand(random io.Reader, content []byte, cert *x509.Certificate) ([]byte, error) {
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("pkcs7: only RSA certificates are supported")
+	}
+
+	key := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	if _, err := io.ReadFull(random, key); err != nil {
+		return nil, err
+	}
+	if _, err := io.ReadFull(random, iv); err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	encrypted := append(append([]byte(nil), content...), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(encrypted, encrypted)
+
+	encryptedKey, err := encryptPKCS1v15(random, pub, key)
+	if err != nil {
+		return nil, err
+	}
+	ivParam, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	ed := envelopedData{
+		Version: 0,
+		RecipientInfos: []keyTransRecipientInfo{{
+			Version: 0,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			KeyEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: OIDRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedKey:           encryptedKey,
+		}},
+		EncryptedContentInfo: encryptedContentInfo{
+			ContentType:                OIDData,
+			ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: OIDAES256CBC, Parameters: asn1.RawValue{FullBytes: ivParam}},
+			EncryptedContent:           encrypted,
+		},
+	}
+	edBytes, err := asn1.Marshal(ed)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDEnvelopedData,
+		Content:     Explicit(0, edBytes),
+	})
+}
+
+// encryptPKCS1v15 encrypts msg with RSA PKCS #1 v1.5 (RFC 8017, section
+// 7.2.1). Unlike rsa.EncryptPKCS1v15, which may read an extra byte from
+// random at random, the padding only depends on the bytes read from random.
+func encryptPKCS1v15(random io.Reader, pub *rsa.PublicKey, msg []byte) ([]byte, error) {
+	k := pub.Size()
+	if len(msg) > k-11 {
+		return nil, rsa.ErrMessageTooLong
+	}
+
+	// EM = 0x00 || 0x02 || PS || 0x00 || M, where PS is non-zero.
+	em := make([]byte, k)
+	em[1] = 2
+	ps := em[2 : k-len(msg)-1]
+	if _, err := io.ReadFull(random, ps); err != nil {
+		return nil, err
+	}
+	for i := range ps {
+		for ps[i] == 0 {
+			if _, err := io.ReadFull(random, ps[i:i+1]); err != nil {
+				return nil, err
+			}
+		}
+	}
+	copy(em[k-len(msg):], msg)
+
+	m := new(big.Int).SetBytes(em)
+	c := m.Exp(m, big.NewInt(int64(pub.E)), pub.N).Bytes()
+	out := make([]byte, k)
+	copy(out[k-len(c):], c)
+	return out, nil
+}
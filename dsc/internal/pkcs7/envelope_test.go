@@ -0,0 +1,109 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/asn1"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEncrypt(t *testing.T) {
+	cert, key := newCertificate(t)
+	plaintext := []byte("P@ssw0rd, sixteen+")
+
+	msg, err := Encrypt(plaintext, cert)
+	require.NoError(t, err)
+
+	var ci contentInfo
+	_, err = asn1.Unmarshal(msg, &ci)
+	require.NoError(t, err)
+	assert.Equal(t, OIDEnvelopedData, ci.ContentType)
+
+	var ed envelopedData
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &ed)
+	require.NoError(t, err)
+	require.Len(t, ed.RecipientInfos, 1)
+	ri := ed.RecipientInfos[0]
+	assert.Equal(t, 0, ri.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber))
+
+	cek, err := rsa.DecryptPKCS1v15(nil, key, ri.EncryptedKey)
+	require.NoError(t, err)
+	var iv []byte
+	_, err = asn1.Unmarshal(ed.EncryptedContentInfo.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv)
+	require.NoError(t, err)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+	decrypted := append([]byte(nil), ed.EncryptedContentInfo.EncryptedContent...)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(decrypted, decrypted)
+	padding := int(decrypted[len(decrypted)-1])
+	assert.Equal(t, plaintext, decrypted[:len(decrypted)-padding])
+
+	// Each message uses a new content key.
+	again, err := Encrypt(plaintext, cert)
+	require.NoError(t, err)
+	assert.NotEqual(t, msg, again)
+}
+
+func TestEncryptWithRand(t *testing.T) {
+	cert, key := newCertificate(t)
+	plaintext := []byte("P@ssw0rd")
+	seed := bytes.Repeat([]byte{0, 1, 2, 3}, 1024)
+
+	// The same stream produces the same message, which decrypts.
+	msg, err := EncryptWithRand(bytes.NewReader(seed), plaintext, cert)
+	require.NoError(t, err)
+	again, err := EncryptWithRand(bytes.NewReader(seed), plaintext, cert)
+	require.NoError(t, err)
+	assert.Equal(t, msg, again)
+
+	var ci contentInfo
+	_, err = asn1.Unmarshal(msg, &ci)
+	require.NoError(t, err)
+	var ed envelopedData
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &ed)
+	require.NoError(t, err)
+	cek, err := rsa.DecryptPKCS1v15(nil, key, ed.RecipientInfos[0].EncryptedKey)
+	require.NoError(t, err)
+	assert.Equal(t, seed[:32], cek)
+
+	block, err := aes.NewCipher(cek)
+	require.NoError(t, err)
+	decrypted := append([]byte(nil), ed.EncryptedContentInfo.EncryptedContent...)
+	cipher.NewCBCDecrypter(block, seed[32:48]).CryptBlocks(decrypted, decrypted)
+	assert.Equal(t, plaintext, decrypted[:len(plaintext)])
+
+	// A short stream is an error.
+	_, err = EncryptWithRand(bytes.NewReader(seed[:64]), plaintext, cert)
+	assert.Error(t, err)
+}
+
+// The key transport is implemented here, rather than with crypto/rsa, so check
+// that crypto/rsa can decrypt it whatever padding it's given.
+func TestEncryptPKCS1v15(t *testing.T) {
+	_, key := newCertificate(t)
+	for i := 0; i < 64; i++ {
+		msg := make([]byte, 1+i%48)
+		_, err := rand.Read(msg)
+		require.NoError(t, err)
+		random := make([]byte, 4096)
+		_, err = rand.Read(random)
+		require.NoError(t, err)
+
+		encrypted, err := encryptPKCS1v15(bytes.NewReader(random), &key.PublicKey, msg)
+		require.NoError(t, err)
+		assert.Len(t, encrypted, key.PublicKey.Size())
+		decrypted, err := rsa.DecryptPKCS1v15(nil, key, encrypted)
+		require.NoError(t, err)
+		assert.Equal(t, msg, decrypted)
+	}
+
+	_, err := encryptPKCS1v15(bytes.NewReader(make([]byte, 4096)), &key.PublicKey, make([]byte, key.PublicKey.Size()-10))
+	assert.Equal(t, rsa.ErrMessageTooLong, err)
+}
@@ -0,0 +1,192 @@
+// Package pkcs7 builds the PKCS #7 (RFC 2315) structures that Windows uses
+// for Authenticode signatures and catalogs, and the CMS envelopes that the
+// LCM decrypts credentials from. Only what's needed to produce them is
+// implemented; messages are never parsed.
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"sort"
+)
+
+// Object identifiers used in PKCS #7 messages.
+var (
+	OIDData          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	OIDSignedData    = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	OIDContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	OIDMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+
+	OIDSHA256        = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	OIDRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+)
+
+// SHA256 is the AlgorithmIdentifier of SHA-256, with the explicit NULL
+// parameters that Windows expects.
+var SHA256 = pkix.AlgorithmIdentifier{Algorithm: OIDSHA256, Parameters: asn1.NullRawValue}
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerialNumber
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+}
+
+type attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue
+}
+
+// Attribute is an authenticated attribute of a signature. Value is the DER
+// encoding of the attribute's (single) value.
+type Attribute struct {
+	Type  asn1.ObjectIdentifier
+	Value []byte
+}
+
+// Explicit wraps the DER encoding of a value in an explicit context-specific
+// tag.
+func Explicit(tag int, der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der}
+}
+
+// Sign returns a DER-encoded ContentInfo containing SignedData, which signs
+// content with the given certificate and key. content is the DER encoding of
+// the content, whose type is contentType; as specified by RFC 2315, the
+// message digest covers its contents octets, excluding the tag and length.
+//
+// The signing certificate and any intermediates in chain are included in the
+// message. Only RSA keys are supported, so that signing the same content
+// always produces the same message.
+func Sign(contentType asn1.ObjectIdentifier, content []byte, cert *x509.Certificate, chain []*x509.Certificate, key crypto.Signer, attrs []Attribute) ([]byte, error) {
+	if _, ok := key.Public().(*rsa.PublicKey); !ok {
+		return nil, errors.New("pkcs7: only RSA keys are supported")
+	}
+
+	var raw asn1.RawValue
+	if rest, err := asn1.Unmarshal(content, &raw); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, errors.New("pkcs7: trailing data after content")
+	}
+	digest := sha256.Sum256(raw.Bytes)
+
+	digestValue, err := asn1.Marshal(digest[:])
+	if err != nil {
+		return nil, err
+	}
+	contentTypeValue, err := asn1.Marshal(contentType)
+	if err != nil {
+		return nil, err
+	}
+	attrs = append([]Attribute{
+		{OIDContentType, contentTypeValue},
+		{OIDMessageDigest, digestValue},
+	}, attrs...)
+
+	// The signature covers the DER encoding of the attributes as a SET,
+	// but they're included in the SignerInfo with an implicit tag.
+	attrSet, err := marshalAttributes(attrs)
+	if err != nil {
+		return nil, err
+	}
+	attrDigest := sha256.Sum256(attrSet)
+	signature, err := key.Sign(rand.Reader, attrDigest[:], crypto.SHA256)
+	if err != nil {
+		return nil, err
+	}
+
+	var attrRaw asn1.RawValue
+	if _, err := asn1.Unmarshal(attrSet, &attrRaw); err != nil {
+		return nil, err
+	}
+
+	var certs []byte
+	for _, c := range append([]*x509.Certificate{cert}, chain...) {
+		certs = append(certs, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{SHA256},
+		ContentInfo: contentInfo{
+			ContentType: contentType,
+			Content:     Explicit(0, content),
+		},
+		Certificates: asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: certs},
+		SignerInfos: []signerInfo{{
+			Version: 1,
+			IssuerAndSerialNumber: issuerAndSerialNumber{
+				Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+				SerialNumber: cert.SerialNumber,
+			},
+			DigestAlgorithm:           SHA256,
+			AuthenticatedAttributes:   asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: attrRaw.Bytes},
+			DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: OIDRSAEncryption, Parameters: asn1.NullRawValue},
+			EncryptedDigest:           signature,
+		}},
+	}
+	sdBytes, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{
+		ContentType: OIDSignedData,
+		Content:     Explicit(0, sdBytes),
+	})
+}
+
+// marshalAttributes returns the DER encoding of a SET OF Attribute, whose
+// elements must be sorted by their encodings.
+func marshalAttributes(attrs []Attribute) ([]byte, error) {
+	var encoded [][]byte
+	for _, a := range attrs {
+		b, err := asn1.Marshal(attribute{
+			Type:  a.Type,
+			Value: asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true, Bytes: a.Value},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, b)
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      bytes.Join(encoded, nil),
+	})
+}
@@ -0,0 +1,102 @@
+package pkcs7
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newCertificate(t *testing.T) (*x509.Certificate, *rsa.PrivateKey) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "DSC Signing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+	cert, err := x509.ParseCertificate(der)
+	require.NoError(t, err)
+	return cert, key
+}
+
+func TestSign(t *testing.T) {
+	cert, key := newCertificate(t)
+
+	content, err := asn1.Marshal(struct{ Name string }{"content"})
+	require.NoError(t, err)
+	extra, err := asn1.Marshal("extra")
+	require.NoError(t, err)
+	contentType := asn1.ObjectIdentifier{1, 2, 3, 4}
+
+	msg, err := Sign(contentType, content, cert, nil, key, []Attribute{{asn1.ObjectIdentifier{1, 2, 3, 5}, extra}})
+	require.NoError(t, err)
+
+	// Signing is deterministic.
+	again, err := Sign(contentType, content, cert, nil, key, []Attribute{{asn1.ObjectIdentifier{1, 2, 3, 5}, extra}})
+	require.NoError(t, err)
+	assert.Equal(t, msg, again)
+
+	var ci contentInfo
+	_, err = asn1.Unmarshal(msg, &ci)
+	require.NoError(t, err)
+	assert.Equal(t, OIDSignedData, ci.ContentType)
+
+	var sd signedData
+	_, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	require.NoError(t, err)
+	assert.Equal(t, contentType, sd.ContentInfo.ContentType)
+	assert.Equal(t, content, sd.ContentInfo.Content.Bytes)
+	assert.Equal(t, cert.Raw, sd.Certificates.Bytes)
+	require.Len(t, sd.SignerInfos, 1)
+
+	si := sd.SignerInfos[0]
+	assert.Equal(t, 0, si.IssuerAndSerialNumber.SerialNumber.Cmp(cert.SerialNumber))
+
+	// The signature covers the attributes, re-tagged as a SET.
+	attrSet := si.AuthenticatedAttributes
+	attrSet.Class, attrSet.Tag = asn1.ClassUniversal, asn1.TagSet
+	attrSet.FullBytes = nil
+	attrBytes, err := asn1.Marshal(attrSet)
+	require.NoError(t, err)
+	digest := sha256.Sum256(attrBytes)
+	assert.NoError(t, rsa.VerifyPKCS1v15(&key.PublicKey, crypto.SHA256, digest[:], si.EncryptedDigest))
+
+	var attrs []attribute
+	_, err = asn1.UnmarshalWithParams(attrBytes, &attrs, "set")
+	require.NoError(t, err)
+	require.Len(t, attrs, 3)
+
+	var raw asn1.RawValue
+	_, err = asn1.Unmarshal(content, &raw)
+	require.NoError(t, err)
+	wantDigest := sha256.Sum256(raw.Bytes)
+
+	found := false
+	for _, a := range attrs {
+		if a.Type.Equal(OIDMessageDigest) {
+			var got []byte
+			_, err := asn1.Unmarshal(a.Value.Bytes, &got)
+			require.NoError(t, err)
+			assert.True(t, bytes.Equal(wantDigest[:], got))
+			found = true
+		}
+	}
+	assert.True(t, found, "no messageDigest attribute")
+}
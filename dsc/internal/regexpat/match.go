@@ -22,9 +22,19 @@ func (m *regexMatch) Value(key interface{}) interface{} {
 		return m.Context.Value(key)
 	}
 
-	return m.matches[v]
+	if s, ok := m.matches[v]; ok {
+		return s
+	}
+	return m.Context.Value(key)
 }
 
 func Param(r *http.Request, name string) string {
 	return r.Context().Value(pattern.Variable(name)).(string)
 }
+
+// OptionalParam is like Param, but returns false instead of panicking if the
+// matched pattern doesn't have a variable with the given name.
+func OptionalParam(r *http.Request, name string) (string, bool) {
+	v, ok := r.Context().Value(pattern.Variable(name)).(string)
+	return v, ok
+}
@@ -0,0 +1,32 @@
+// Package textenc decodes the text files that PowerShell writes, which may be
+// UTF-8 or UTF-16 depending on the version of PowerShell and the cmdlet that
+// wrote them.
+package textenc
+
+import (
+	"bytes"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+)
+
+// Decode returns the contents of a text file as a string, converting it from
+// UTF-16 if it starts with a UTF-16 byte order mark. Any byte order mark is
+// removed.
+func Decode(data []byte) string {
+	var order binary.ByteOrder
+	switch {
+	case bytes.HasPrefix(data, []byte{0xFF, 0xFE}):
+		order = binary.LittleEndian
+	case bytes.HasPrefix(data, []byte{0xFE, 0xFF}):
+		order = binary.BigEndian
+	default:
+		return strings.TrimPrefix(string(data), "\ufeff")
+	}
+
+	units := make([]uint16, 0, len(data)/2)
+	for i := 2; i+1 < len(data); i += 2 {
+		units = append(units, order.Uint16(data[i:]))
+	}
+	return string(utf16.Decode(units))
+}
@@ -9,7 +9,7 @@ const (
 	GetModuleV1URL = `Module\(` +
 		`ConfigurationId='(?P<configuration_id>` + ConfigurationId + `)',` +
 		`ModuleName='(?P<module_name>` + ModuleName + `)',` +
-		`ModuleVersion='(?P<module_name>` + ModuleVersion + `)'` +
+		`ModuleVersion='(?P<module_version>` + ModuleVersion + `)'` +
 		`\)\/ModuleContent`
 
 	// 3.3 GetAction Versions 1.0 and 1.1
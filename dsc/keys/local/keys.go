@@ -0,0 +1,61 @@
+package local
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/fsmock"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// KeyStore reads registration keys from a JSON file on disk containing an
+// array of types.RegistrationKey. The file is re-read whenever it changes, so
+// keys can be added or revoked without restarting the server.
+type KeyStore struct {
+	path string
+	fs   fsmock.FileSystem
+
+	lock    sync.Mutex
+	modTime time.Time
+	size    int64
+	keys    []types.RegistrationKey
+}
+
+func New(path string) *KeyStore {
+	return &KeyStore{path: path, fs: fsmock.OSFS{}}
+}
+
+func (k *KeyStore) GetKeys(ctx context.Context) ([]types.RegistrationKey, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	// Unlike other stores, a missing file is an error, since it would
+	// otherwise lock out every client.
+	st, err := k.fs.Stat(k.path)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use the cached copy if the file hasn't changed.
+	if k.keys != nil && st.ModTime().Equal(k.modTime) && st.Size() == k.size {
+		return k.keys, nil
+	}
+
+	f, err := k.fs.Open(k.path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var keys []types.RegistrationKey
+	if err := json.NewDecoder(f).Decode(&keys); err != nil {
+		return nil, err
+	}
+
+	k.keys = keys
+	k.modTime = st.ModTime()
+	k.size = st.Size()
+	return k.keys, nil
+}
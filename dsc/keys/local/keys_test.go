@@ -0,0 +1,59 @@
+package local
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetKeysReload(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "dsc-keys")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "keys.json")
+	store := New(path)
+
+	// A missing file is an error, rather than an empty list of keys.
+	_, err = store.GetKeys(ctx)
+	assert.Error(t, err)
+
+	write := func(content string, modTime time.Time) {
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+		require.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+	now := time.Now()
+
+	write(`[{"ID": "web", "Key": "secret", "AllowedConfigurations": ["WebServer"], "Expires": "2030-01-01T00:00:00Z"}]`, now)
+	keys, err := store.GetKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "web", keys[0].ID)
+	assert.Equal(t, "secret", keys[0].Key)
+	assert.Equal(t, []string{"WebServer"}, keys[0].AllowedConfigurations)
+	assert.Equal(t, time.Date(2030, 1, 1, 0, 0, 0, 0, time.UTC), keys[0].Expires.UTC())
+	assert.False(t, keys[0].Revoked)
+
+	// Revoking a key takes effect once the file changes.
+	write(`[{"ID": "web", "Key": "secret", "Revoked": true}]`, now.Add(time.Second))
+	keys, err = store.GetKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.True(t, keys[0].Revoked)
+
+	// An invalid file is an error.
+	write(`[{"ID": `, now.Add(2*time.Second))
+	_, err = store.GetKeys(ctx)
+	assert.Error(t, err)
+
+	require.NoError(t, os.Remove(path))
+	_, err = store.GetKeys(ctx)
+	assert.Error(t, err)
+}
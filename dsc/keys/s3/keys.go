@@ -0,0 +1,88 @@
+package s3
+
+import (
+	"context"
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// DefaultCacheTTL is the default time for which keys fetched from S3 are
+// used before they are fetched again.
+const DefaultCacheTTL = time.Minute
+
+// KeyStore reads registration keys from a JSON object in S3 containing an
+// array of types.RegistrationKey. The object is cached for a short time, so
+// keys can be added or revoked without redeploying the server, but take up to
+// the cache TTL to do so.
+type KeyStore struct {
+	bucket *string
+	key    *string
+	s3     s3iface.S3API
+	ttl    time.Duration
+	now    func() time.Time
+
+	lock    sync.Mutex
+	fetched time.Time
+	keys    []types.RegistrationKey
+}
+
+// Option configures a KeyStore.
+type Option func(*KeyStore)
+
+// WithCacheTTL sets the time for which keys are cached; a TTL of zero fetches
+// them from S3 for every request. The default is DefaultCacheTTL.
+func WithCacheTTL(ttl time.Duration) Option {
+	return func(k *KeyStore) {
+		k.ttl = ttl
+	}
+}
+
+func New(bucket, key string, s3 s3iface.S3API, opts ...Option) *KeyStore {
+	ret := &KeyStore{
+		bucket: &bucket,
+		key:    &key,
+		s3:     s3,
+		ttl:    DefaultCacheTTL,
+		now:    time.Now,
+	}
+	for _, opt := range opts {
+		opt(ret)
+	}
+	return ret
+}
+
+func (k *KeyStore) GetKeys(ctx context.Context) ([]types.RegistrationKey, error) {
+	k.lock.Lock()
+	defer k.lock.Unlock()
+
+	now := k.now()
+	if !k.fetched.IsZero() && now.Sub(k.fetched) < k.ttl {
+		return k.keys, nil
+	}
+
+	// Unlike other stores, a missing object is an error, since it would
+	// otherwise lock out every client. Errors aren't cached, and a stale
+	// copy isn't used in their place, since it may contain revoked keys.
+	result, err := k.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
+		Bucket: k.bucket,
+		Key:    k.key,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer result.Body.Close()
+
+	var keys []types.RegistrationKey
+	if err := json.NewDecoder(result.Body).Decode(&keys); err != nil {
+		return nil, err
+	}
+	k.keys = keys
+	k.fetched = now
+	return keys, nil
+}
@@ -0,0 +1,88 @@
+package s3
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeS3 is an in-memory S3API that only implements the methods used by the
+// KeyStore.
+type fakeS3 struct {
+	s3iface.S3API
+
+	objects map[string][]byte
+	gets    int
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *s3.GetObjectInput, opts ...request.Option) (*s3.GetObjectOutput, error) {
+	f.gets++
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New(s3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &s3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func TestGetKeysCache(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{objects: make(map[string][]byte)}
+	store := New("bucket", "keys.json", fake)
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+	store.now = func() time.Time { return now }
+
+	// A missing object is an error, and isn't cached.
+	_, err := store.GetKeys(ctx)
+	assert.Error(t, err)
+	fake.objects["keys.json"] = []byte(`[{"ID": "web", "Key": "secret", "AllowedConfigurations": ["WebServer"]}]`)
+	keys, err := store.GetKeys(ctx)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	assert.Equal(t, "web", keys[0].ID)
+	assert.Equal(t, []string{"WebServer"}, keys[0].AllowedConfigurations)
+	assert.Equal(t, 2, fake.gets)
+
+	// Within the TTL, the cached keys are used.
+	fake.objects["keys.json"] = []byte(`[{"ID": "web", "Key": "secret", "Revoked": true}]`)
+	now = now.Add(DefaultCacheTTL - time.Second)
+	keys, err = store.GetKeys(ctx)
+	require.NoError(t, err)
+	assert.False(t, keys[0].Revoked)
+	assert.Equal(t, 2, fake.gets)
+
+	// After it, they're fetched again.
+	now = now.Add(time.Second)
+	keys, err = store.GetKeys(ctx)
+	require.NoError(t, err)
+	assert.True(t, keys[0].Revoked)
+	assert.Equal(t, 3, fake.gets)
+
+	// A stale copy isn't used if fetching fails.
+	delete(fake.objects, "keys.json")
+	now = now.Add(DefaultCacheTTL)
+	_, err = store.GetKeys(ctx)
+	assert.Error(t, err)
+}
+
+func TestGetKeysWithoutCache(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeS3{objects: map[string][]byte{"keys.json": []byte(`[]`)}}
+	store := New("bucket", "keys.json", fake, WithCacheTTL(0))
+
+	for i := 0; i < 3; i++ {
+		keys, err := store.GetKeys(ctx)
+		require.NoError(t, err)
+		assert.Empty(t, keys)
+	}
+	assert.Equal(t, 3, fake.gets)
+}
@@ -0,0 +1,19 @@
+package static
+
+import (
+	"context"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+type KeyStore struct {
+	keys []types.RegistrationKey
+}
+
+func New(keys []types.RegistrationKey) *KeyStore {
+	return &KeyStore{keys}
+}
+
+func (k *KeyStore) GetKeys(ctx context.Context) ([]types.RegistrationKey, error) {
+	return k.keys, nil
+}
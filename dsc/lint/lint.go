@@ -0,0 +1,303 @@
+// Package lint checks configurations against the modules that a
+// ConfigurationRepository can serve. A configuration that uses a resource from
+// a module that the repository doesn't have is only rejected by the node after
+// it has downloaded the configuration, so this is best checked before the
+// configuration is published.
+package lint
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/module"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Modules that ship with Windows, and so are never downloaded from the pull
+// server, keyed by lower-cased name.
+var builtinModules = map[string]bool{
+	"psdesiredstateconfiguration": true,
+}
+
+// Kind is the kind of a Problem.
+type Kind string
+
+const (
+	// The configuration couldn't be fetched or parsed.
+	InvalidConfiguration Kind = "invalid configuration"
+
+	// The repository has no versions of the module.
+	MissingModule Kind = "missing module"
+
+	// The repository has the module, but not the requested version.
+	VersionMismatch Kind = "version mismatch"
+
+	// The repository serves the module, but it isn't a valid module with
+	// the requested name and version.
+	InvalidModule Kind = "invalid module"
+)
+
+// Problem is a problem found with a configuration.
+type Problem struct {
+	Kind Kind
+
+	// The module and version that the problem is with, if any.
+	Module  string
+	Version string
+
+	// The ResourceIDs of the resources that use the module.
+	Resources []string
+
+	// A description of the problem.
+	Detail string
+}
+
+func (p Problem) String() string {
+	if p.Module == "" {
+		return fmt.Sprintf("%s: %s", p.Kind, p.Detail)
+	}
+	return fmt.Sprintf("%s %s %s (used by %s): %s",
+		p.Kind, p.Module, p.Version, strings.Join(p.Resources, ", "), p.Detail)
+}
+
+// ModuleRef is a module that a configuration uses.
+type ModuleRef struct {
+	Name    string
+	Version string
+
+	// The ResourceIDs of the resources from the module.
+	Resources []string
+}
+
+// Result is the result of checking a single configuration.
+type Result struct {
+	Configuration string
+
+	// The modules that the configuration uses, excluding those that
+	// ship with Windows, in the order they are first used.
+	Modules []ModuleRef
+
+	Problems []Problem
+}
+
+// OK returns whether no problems were found.
+func (r *Result) OK() bool {
+	return len(r.Problems) == 0
+}
+
+// Linter checks configurations against the modules in a repository. The
+// result of checking each module is cached, so a Linter should only be used
+// for one run.
+type Linter struct {
+	repo dsc.ConfigurationRepository
+
+	// The result of checking each module, keyed by lower-cased name and
+	// version.
+	modules map[moduleKey]*Problem
+}
+
+type moduleKey struct {
+	name    string
+	version string
+}
+
+func keyOf(name, version string) moduleKey {
+	return moduleKey{strings.ToLower(name), strings.ToLower(version)}
+}
+
+func New(repo dsc.ConfigurationRepository) *Linter {
+	return &Linter{
+		repo:    repo,
+		modules: make(map[moduleKey]*Problem),
+	}
+}
+
+// Lint checks the named configurations, or every configuration in the
+// repository if no names are given; the repository must then implement
+// dsc.ConfigurationLister.
+func Lint(ctx context.Context, repo dsc.ConfigurationRepository, names ...string) ([]*Result, error) {
+	if len(names) == 0 {
+		lister, ok := repo.(dsc.ConfigurationLister)
+		if !ok {
+			return nil, fmt.Errorf("lint: repository can't list configurations")
+		}
+
+		var err error
+		if names, err = lister.ListConfigurations(ctx); err != nil {
+			return nil, err
+		}
+		sort.Strings(names)
+	}
+
+	l := New(repo)
+	var ret []*Result
+	for _, name := range names {
+		result, err := l.Configuration(ctx, name)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, result)
+	}
+	return ret, nil
+}
+
+// Configuration checks a single configuration. Problems with the
+// configuration are returned in the Result; an error is only returned if
+// checking failed, e.g. because the repository couldn't be reached.
+func (l *Linter) Configuration(ctx context.Context, name string) (*Result, error) {
+	ret := &Result{Configuration: name}
+
+	resp, err := l.repo.GetConfiguration(ctx, types.GetConfigurationRequest{ConfigurationName: name})
+	if err != nil {
+		if _, ok := err.(types.ConfigurationNotFoundError); ok {
+			ret.Problems = append(ret.Problems, Problem{Kind: InvalidConfiguration, Detail: err.Error()})
+			return ret, nil
+		}
+		return nil, err
+	}
+	if cl, ok := resp.Content.(io.Closer); ok {
+		defer cl.Close()
+	}
+
+	doc, err := mof.ParseReader(resp.Content)
+	if err != nil {
+		if _, ok := err.(mof.SyntaxError); ok {
+			ret.Problems = append(ret.Problems, Problem{Kind: InvalidConfiguration, Detail: err.Error()})
+			return ret, nil
+		}
+		return nil, err
+	}
+
+	ret.Modules = Modules(doc)
+	for _, ref := range ret.Modules {
+		problem, err := l.module(ctx, ref)
+		if err != nil {
+			return nil, err
+		}
+		if problem != nil {
+			p := *problem
+			p.Resources = ref.Resources
+			ret.Problems = append(ret.Problems, p)
+		}
+	}
+	return ret, nil
+}
+
+// Modules returns the modules used by the resources in a configuration,
+// excluding those that ship with Windows, in the order they are first used.
+func Modules(doc *mof.Document) []ModuleRef {
+	var ret []ModuleRef
+	index := make(map[moduleKey]int)
+	for _, r := range doc.Resources() {
+		name := r.String("ModuleName")
+		version := r.String("ModuleVersion")
+		if name == "" || builtinModules[strings.ToLower(name)] {
+			continue
+		}
+
+		key := keyOf(name, version)
+		i, ok := index[key]
+		if !ok {
+			i = len(ret)
+			index[key] = i
+			ret = append(ret, ModuleRef{Name: name, Version: version})
+		}
+		ret[i].Resources = append(ret[i].Resources, r.ResourceID())
+	}
+	return ret
+}
+
+// module checks that the repository serves a valid module for ref, and
+// returns the problem if it doesn't.
+func (l *Linter) module(ctx context.Context, ref ModuleRef) (*Problem, error) {
+	key := keyOf(ref.Name, ref.Version)
+	if problem, ok := l.modules[key]; ok {
+		return problem, nil
+	}
+
+	problem, err := l.checkModule(ctx, ref)
+	if err != nil {
+		return nil, err
+	}
+	if problem != nil {
+		problem.Module = ref.Name
+		problem.Version = ref.Version
+	}
+	l.modules[key] = problem
+	return problem, nil
+}
+
+func (l *Linter) checkModule(ctx context.Context, ref ModuleRef) (*Problem, error) {
+	var (
+		versions []string
+		pinned   string
+	)
+	lister, listed := l.repo.(dsc.ModuleVersionLister)
+	if listed {
+		resp, err := lister.ListModuleVersions(ctx, types.ListModuleVersionsRequest{Name: ref.Name})
+		if err != nil {
+			return nil, err
+		}
+		versions, pinned = resp.Versions, resp.Default
+	}
+
+	// Resources that don't specify a version are served the pinned or
+	// latest version, as by the Manager.
+	version := ref.Version
+	if version == "" && listed {
+		version = pinned
+		if version == "" {
+			version, _ = module.Latest(versions)
+		}
+		if version == "" {
+			return &Problem{Kind: MissingModule, Detail: "the repository has no versions of this module"}, nil
+		}
+	}
+
+	resp, err := l.repo.GetModule(ctx, types.GetModuleRequest{Name: ref.Name, Version: version})
+	switch err.(type) {
+	case nil:
+	case types.ModuleNotFoundError:
+		if len(versions) == 0 {
+			return &Problem{Kind: MissingModule, Detail: "the repository has no versions of this module"}, nil
+		}
+		sort.Strings(versions)
+		return &Problem{
+			Kind:   VersionMismatch,
+			Detail: fmt.Sprintf("the repository has versions %s", strings.Join(versions, ", ")),
+		}, nil
+	case types.InvalidModuleError, types.ChecksumMismatchError:
+		return &Problem{Kind: InvalidModule, Detail: err.Error()}, nil
+	default:
+		return nil, err
+	}
+	if cl, ok := resp.Content.(io.Closer); ok {
+		defer cl.Close()
+	}
+
+	content, err := ioutil.ReadAll(resp.Content)
+	if err != nil {
+		return nil, err
+	}
+	if resp.ChecksumAlgorithm == checksum.SHA256 && !strings.EqualFold(checksum.SumBytes(content), resp.Checksum) {
+		return &Problem{
+			Kind:   InvalidModule,
+			Detail: fmt.Sprintf("content doesn't match checksum %s", resp.Checksum),
+		}, nil
+	}
+	if err := module.Validate(bytes.NewReader(content), int64(len(content)), ref.Name, version); err != nil {
+		if verr, ok := err.(module.ValidationError); ok {
+			return &Problem{Kind: InvalidModule, Detail: verr.Reason}, nil
+		}
+		return nil, err
+	}
+	return nil, nil
+}
@@ -0,0 +1,93 @@
+package lint
+
+import (
+	"archive/zip"
+	"context"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/local"
+)
+
+func resource(id, module, version string) string {
+	return fmt.Sprintf(`instance of MSFT_Example
+{
+    ResourceID = %q;
+    ModuleName = %q;
+    ModuleVersion = %q;
+    ConfigurationName = "Example";
+};
+`, id, module, version)
+}
+
+func writeModule(t *testing.T, path, name, version string) {
+	require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+	f, err := os.Create(path)
+	require.NoError(t, err)
+	defer f.Close()
+
+	zw := zip.NewWriter(f)
+	w, err := zw.Create(name + ".psd1")
+	require.NoError(t, err)
+	fmt.Fprintf(w, "@{ ModuleVersion = '%s' }", version)
+	require.NoError(t, zw.Close())
+}
+
+func TestLint(t *testing.T) {
+	root, err := ioutil.TempDir("", "dsc-lint")
+	require.NoError(t, err)
+	defer os.RemoveAll(root)
+
+	writeModule(t, filepath.Join(root, "modules", "xwebadministration", "3.1.1.zip"), "xWebAdministration", "3.1.1")
+	writeModule(t, filepath.Join(root, "modules", "xnetworking", "5.7.0.0.zip"), "xNetworking", "5.7.0.0")
+	// Zipped with the wrong version in its manifest.
+	writeModule(t, filepath.Join(root, "modules", "xtimezone", "1.8.0.0.zip"), "xTimeZone", "1.7.0.0")
+
+	configs := map[string]string{
+		"good": resource("[File]Hello", "PSDesiredStateConfiguration", "1.0") +
+			resource("[xWebsite]Site", "xWebAdministration", "3.1.1") +
+			resource("[xWebAppPool]Pool", "xWebAdministration", "3.1.1"),
+		"bad": resource("[xWebsite]Site", "xWebAdministration", "3.1.1") +
+			resource("[xFirewall]Rule", "xNetworking", "5.6.0.0") +
+			resource("[xDnsServerAddress]DNS", "xNetworking", "5.6.0.0") +
+			resource("[xTimeZone]UTC", "xTimeZone", "1.8.0.0") +
+			resource("[SqlServer]Setup", "SqlServerDsc", "13.0.0"),
+		"broken": "instance of MSFT_Example {",
+	}
+	for name, content := range configs {
+		path := filepath.Join(root, "config", name)
+		require.NoError(t, os.MkdirAll(filepath.Dir(path), 0755))
+		require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	}
+
+	results, err := Lint(context.Background(), local.New(root))
+	require.NoError(t, err)
+	require.Len(t, results, 3)
+
+	// Results are sorted by configuration name.
+	bad, broken, good := results[0], results[1], results[2]
+
+	assert.True(t, good.OK(), "problems: %v", good.Problems)
+	assert.Equal(t, []ModuleRef{
+		{"xWebAdministration", "3.1.1", []string{"[xWebsite]Site", "[xWebAppPool]Pool"}},
+	}, good.Modules)
+
+	require.Len(t, broken.Problems, 1)
+	assert.Equal(t, InvalidConfiguration, broken.Problems[0].Kind)
+
+	require.Len(t, bad.Problems, 3)
+	assert.Equal(t, VersionMismatch, bad.Problems[0].Kind)
+	assert.Equal(t, "xNetworking", bad.Problems[0].Module)
+	assert.Equal(t, []string{"[xFirewall]Rule", "[xDnsServerAddress]DNS"}, bad.Problems[0].Resources)
+	assert.Contains(t, bad.Problems[0].Detail, "5.7.0.0")
+	assert.Equal(t, InvalidModule, bad.Problems[1].Kind)
+	assert.Equal(t, "xTimeZone", bad.Problems[1].Module)
+	assert.Equal(t, MissingModule, bad.Problems[2].Kind)
+	assert.Equal(t, "SqlServerDsc", bad.Problems[2].Module)
+}
@@ -1,18 +1,25 @@
 package dsc
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
 	"regexp"
+	"strings"
+	"time"
 
 	"github.com/sirupsen/logrus"
 	"goji.io"
 
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/checksum"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/httperr"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/middleware"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/regexpat"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/urls"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/module"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
@@ -24,10 +31,25 @@ type Manager struct {
 	config ConfigurationRepository
 	report ReportServer
 	status NodeStatus
+	meta   MetaConfigurationRepository
 
 	mux  *goji.Mux
 	log  logrus.FieldLogger
-	keys []string // TODO: don't keep around?
+	keys KeyStore
+
+	// Replay protection for signed requests
+	skew   time.Duration
+	replay ReplayCache
+
+	// Whether to check TLS client certificates against registrations
+	certBinding bool
+
+	// Whether to register unknown agents that call GetDscAction
+	autoRegistration bool
+
+	// Support for protocol versions 1.0 and 1.1
+	v1        bool
+	configIDs ConfigurationIDResolver
 }
 
 // NewManager creates a new Manager with the given ConfigurationRepository and
@@ -37,46 +59,52 @@ func NewManager(config ConfigurationRepository, report ReportServer, status Node
 		config: config,
 		report: report,
 		status: status,
+		skew:   DefaultClockSkew,
 	}
 
 	for _, opt := range opts {
 		opt(ret)
 	}
+	if ret.log == nil {
+		ret.log = logrus.StandardLogger()
+	}
+	if _, ok := status.(RegistrationGetter); ret.certBinding && !ok {
+		panic("dsc: client certificate binding requires a NodeStatus that implements RegistrationGetter")
+	}
+	if ret.replay != nil && ret.skew <= 0 {
+		// Signatures are only remembered for as long as the skew
+		// allows them to be used, so without one, nothing is.
+		panic("dsc: a replay cache requires a non-zero clock skew")
+	}
 
 	// Make mux + middleware
 	ret.mux = goji.NewMux()
-	ret.mux.Use(protocolVersion)
 	ret.mux.Use(middleware.MaxBodySize(1 * 1024 * 1024))
 	ret.mux.Use(middleware.LogrusLogger(ret.log))
 
-	// Optionally add middlware if we have the right config
-	if len(ret.keys) > 0 {
-		ret.mux.Use(checkRegistration(ret.keys))
-	}
-
 	// Register routes on mux
 	routes := []struct {
 		Method  string
 		Regexp  string
 		Handler func(http.ResponseWriter, *http.Request)
+		V1      bool
+		Cert    certPolicy
 	}{
-		// API Versions 1.0 and 1.1 (not supported)
-		{"GET", urls.GetConfigurationV1URL, ret.methodNotSupported},
-		{"GET", urls.GetModuleV1URL, ret.methodNotSupported},
-		{"POST", urls.GetActionV1URL, ret.methodNotSupported},
-		{"POST", urls.SendStatusReportURL, ret.methodNotSupported},
-		{"GET", urls.GetStatusReportURL, ret.methodNotSupported},
+		// API Versions 1.0 and 1.1
+		{"GET", urls.GetConfigurationV1URL, ret.getConfigurationV1, true, certUnchecked},
+		{"GET", urls.GetModuleV1URL, ret.getModuleV1, true, certUnchecked},
+		{"POST", urls.GetActionV1URL, ret.getActionV1, true, certUnchecked},
+		{"POST", urls.SendStatusReportURL, ret.sendStatusReportV1, true, certUnchecked},
+		{"GET", urls.GetStatusReportURL, ret.getStatusReportV1, true, certUnchecked},
 
 		// API Version 2.0
-		{"GET", urls.GetConfigurationV2URL, ret.getConfiguration},
-		{"GET", urls.GetModuleV2URL, ret.getModule},
-		{"POST", urls.GetDscActionV2URL, ret.getDscAction},
-		{"PUT", urls.RegisterDscAgentV2URL, ret.registerDscAgent},
-		{"POST", urls.SendReportV2URL, ret.sendReport},
-		{"GET", urls.GetReportsV2URL, ret.getReports},
-
-		// TODO: support this, or at least do nothing
-		{"POST", urls.CertificateRotationURL, ret.methodNotSupported},
+		{"GET", urls.GetConfigurationV2URL, ret.getConfiguration, false, certRequired},
+		{"GET", urls.GetModuleV2URL, ret.getModule, false, certRequired},
+		{"POST", urls.GetDscActionV2URL, ret.getDscAction, false, certIfRegistered},
+		{"PUT", urls.RegisterDscAgentV2URL, ret.registerDscAgent, false, certIfRegistered},
+		{"POST", urls.SendReportV2URL, ret.sendReport, false, certRequired},
+		{"GET", urls.GetReportsV2URL, ret.getReports, false, certRequired},
+		{"POST", urls.CertificateRotationURL, ret.rotateCertificate, false, certRequired},
 	}
 
 	for _, route := range routes {
@@ -86,8 +114,33 @@ func NewManager(config ConfigurationRepository, report ReportServer, status Node
 		// Create new pattern that matches the given regex/method
 		pat := regexpat.NewWithMethods(re, route.Method)
 
+		// Wrap the handler in the middleware for its protocol version.
+		var handler http.Handler = http.HandlerFunc(route.Handler)
+		if route.V1 {
+			if !ret.v1 {
+				handler = http.HandlerFunc(ret.methodNotSupported)
+			}
+
+			// Version 1.0 and 1.1 clients don't sign their requests,
+			// so we can't check registration keys for them.
+			handler = protocolVersion(v1ProtocolVersions...)(handler)
+		} else {
+			// Optionally add middlware if we have the right config
+			if ret.certBinding && route.Cert != certUnchecked {
+				handler = ret.bindClientCertificate(route.Cert)(handler)
+			}
+			if ret.keys != nil {
+				handler = checkRegistration(registrationConfig{
+					keys:   ret.keys,
+					skew:   ret.skew,
+					replay: ret.replay,
+				})(handler)
+			}
+			handler = protocolVersion("2.0")(handler)
+		}
+
 		// Attach it to the mux
-		ret.mux.HandleFunc(pat, route.Handler)
+		ret.mux.Handle(pat, handler)
 	}
 
 	return ret
@@ -103,8 +156,7 @@ func (m *Manager) registerDscAgent(w http.ResponseWriter, r *http.Request) {
 
 	var body types.RegisterDscAgentRequestBody
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "error decoding body: %s", err)
+		httperr.Write(w, httperr.BadRequest("error decoding body: %s", err))
 		return
 	}
 
@@ -119,23 +171,39 @@ func (m *Manager) registerDscAgent(w http.ResponseWriter, r *http.Request) {
 		"registration_type":   regType,
 		"agent_id":            agentId,
 		"configuration_names": body.ConfigurationNames,
+		"registration_key_id": registrationKeyID(r.Context()),
 	}).Debug("registering new agent")
 
+	// Clients may only register for the configurations that their
+	// registration key allows.
+	if err := checkAllowedConfigurations(r.Context(), agentId, body.ConfigurationNames); err != nil {
+		m.log.WithError(err).Warn("rejecting registration")
+		httperr.Write(w, err)
+		return
+	}
+
+	// When binding agents to their certificates, the certificate that
+	// the agent registers with must be the one it's connecting with. An
+	// agent that is already registered must also connect with the
+	// certificate it registered with, which is checked by middleware;
+	// so re-registering can't change the certificate, which must be
+	// done with a CertificateRotation request instead.
+	if m.certBinding && !thumbprintMatches(clientThumbprint(r), body.RegistrationInformation.CertificateInformation) {
+		m.writeCertificateError(w, types.ClientCertificateMismatchError{
+			AgentID:    agentId,
+			Thumbprint: clientThumbprint(r),
+		})
+		return
+	}
+
 	// Dispatch to the correct implementation depending on what we're
 	// registering. This method will be called multiple times, usually once
 	// per type.
 	if regType == "ConfigurationRepository" {
-		// Register with both config repository and node status handler
-		req := types.RegisterDscAgentRequest{
+		err = m.registerConfigurationRepository(r.Context(), types.RegisterDscAgentRequest{
 			AgentID: regexpat.Param(r, "agent_id"),
 			Body:    body,
-		}
-
-		_, err = m.config.RegisterDscAgent(r.Context(), req)
-		if err == nil {
-			_, err = m.status.RegisterDscAgent(r.Context(), req)
-		}
-
+		})
 	} else if regType == "ReportServer" {
 		_, err = m.report.RegisterDscAgent(
 			r.Context(),
@@ -145,15 +213,12 @@ func (m *Manager) registerDscAgent(w http.ResponseWriter, r *http.Request) {
 			},
 		)
 	} else {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "unknown registration type: %s", regType)
+		httperr.Write(w, httperr.BadRequest("unknown registration type: %s", regType))
 		return
 	}
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "error registering agent: %s", err)
-		m.log.WithError(err).Errorf("error registering agent")
+		m.writeError(w, "error registering agent", err)
 		return
 	}
 
@@ -161,15 +226,104 @@ func (m *Manager) registerDscAgent(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusNoContent)
 }
 
+// registerConfigurationRepository registers an agent with both the
+// ConfigurationRepository and the NodeStatus.
+func (m *Manager) registerConfigurationRepository(ctx context.Context, req types.RegisterDscAgentRequest) error {
+	if _, err := m.config.RegisterDscAgent(ctx, req); err != nil {
+		return err
+	}
+	if _, err := m.status.RegisterDscAgent(ctx, req); err != nil {
+		return err
+	}
+	return nil
+}
+
 func (m *Manager) getConfiguration(w http.ResponseWriter, r *http.Request) {
 	agentId := regexpat.Param(r, "agent_id")
 	configName := regexpat.Param(r, "configuration_name")
 
 	m.log.WithFields(logrus.Fields{
-		"agent_id":           agentId,
-		"configuration_name": configName,
+		"agent_id":            agentId,
+		"configuration_name":  configName,
+		"registration_key_id": registrationKeyID(r.Context()),
 	}).Debug("getting configuration")
 
+	// Clients that were told to update their meta-configuration fetch
+	// it using a reserved configuration name. Other requests for that
+	// name are for an ordinary configuration.
+	if strings.EqualFold(configName, types.MetaConfigurationName) {
+		meta, err := m.pendingMetaConfiguration(r.Context(), agentId)
+		if err != nil {
+			m.writeError(w, "error getting meta-configuration", err)
+			return
+		}
+		if meta != nil {
+			m.serveMetaConfiguration(w, r, agentId, meta)
+			return
+		}
+	}
+
+	// If the NodeStatus assigns configurations on the server side, it
+	// may want to serve a different configuration than was requested.
+	if resolver, ok := m.status.(ConfigurationNameResolver); ok {
+		resolved, err := resolver.ResolveConfigurationName(r.Context(), agentId, configName)
+		if err != nil {
+			switch err.(type) {
+			case types.AgentNotRegisteredError:
+				// Serve the requested configuration as-is.
+				resolved = configName
+
+			default:
+				m.writeError(w, "error resolving configuration name", err)
+				return
+			}
+		}
+
+		if resolved != configName {
+			m.log.WithFields(logrus.Fields{
+				"agent_id":           agentId,
+				"configuration_name": configName,
+				"assigned_name":      resolved,
+			}).Info("serving assigned configuration")
+			configName = resolved
+		}
+	}
+
+	// The registration key must allow the configuration that's actually
+	// served, so we check this after any assignment.
+	if err := checkAllowedConfigurations(r.Context(), agentId, []string{configName}); err != nil {
+		m.log.WithError(err).Warn("rejecting configuration request")
+		httperr.Write(w, err)
+		return
+	}
+
+	m.serveConfiguration(w, r, agentId, configName)
+}
+
+// checkAllowedConfigurations returns a ConfigurationNotAllowedError if the
+// registration key that the request was signed with doesn't allow one of the
+// given configurations.
+func checkAllowedConfigurations(ctx context.Context, agentId string, names []string) error {
+	key, ok := RegistrationKeyFromContext(ctx)
+	if !ok {
+		return nil
+	}
+
+	for _, name := range names {
+		if !key.AllowsConfiguration(name) {
+			return types.ConfigurationNotAllowedError{
+				AgentID: agentId,
+				KeyID:   key.ID,
+				Name:    name,
+			}
+		}
+	}
+	return nil
+}
+
+// serveConfiguration fetches the given configuration from the
+// ConfigurationRepository and writes it to the response.
+func (m *Manager) serveConfiguration(w http.ResponseWriter, r *http.Request, agentId, configName string) {
 	resp, err := m.config.GetConfiguration(
 		r.Context(),
 		types.GetConfigurationRequest{
@@ -178,42 +332,29 @@ func (m *Manager) getConfiguration(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 	if err != nil {
-		switch v := err.(type) {
-		case types.ConfigurationNotFoundError:
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, "%s", v)
-
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "error getting configuration: %s", err)
-		}
+		m.writeError(w, "error getting configuration", err)
 		return
 	}
 
-	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Checksum", resp.Checksum)
-
-	// Case-sensitive
-	w.Header()["ChecksumAlgorithm"] = []string{resp.ChecksumAlgorithm}
-
-	_, err = io.Copy(w, resp.Content)
-	if cl, ok := resp.Content.(io.Closer); ok {
-		cl.Close()
-	}
-	if err != nil {
-		m.log.WithError(err).Errorf("error copying response body")
-		return
+	if resp.Source != "" {
+		m.log.WithFields(logrus.Fields{
+			"agent_id":           agentId,
+			"configuration_name": configName,
+			"checksum":           resp.Checksum,
+			"source":             resp.Source,
+		}).Info("serving configuration")
 	}
+
+	m.writeContent(w, resp.Content, resp.Checksum, resp.ChecksumAlgorithm)
 }
 
-var agentIdRegexp = regexp.MustCompile(urls.AgentId)
+var agentIdRegexp = regexp.MustCompile(`^` + urls.AgentId + `$`)
 
 func (m *Manager) getModule(w http.ResponseWriter, r *http.Request) {
 	agentId := r.Header.Get("AgentId")
 	matched := agentIdRegexp.Match([]byte(agentId))
 	if !matched {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "invalid agent id")
+		httperr.Write(w, httperr.BadRequest("invalid agent id"))
 		return
 	}
 
@@ -226,6 +367,21 @@ func (m *Manager) getModule(w http.ResponseWriter, r *http.Request) {
 		"module_version": moduleVersion,
 	}).Debug("getting module")
 
+	m.serveModule(w, r, agentId, moduleName, moduleVersion)
+}
+
+// serveModule fetches the given module from the ConfigurationRepository and
+// writes it to the response.
+func (m *Manager) serveModule(w http.ResponseWriter, r *http.Request, agentId, moduleName, moduleVersion string) {
+	if moduleVersion == "" {
+		resolved, err := m.resolveModuleVersion(r.Context(), agentId, moduleName)
+		if err != nil {
+			m.writeError(w, "error resolving module version", err)
+			return
+		}
+		moduleVersion = resolved
+	}
+
 	resp, err := m.config.GetModule(
 		r.Context(),
 		types.GetModuleRequest{
@@ -235,30 +391,83 @@ func (m *Manager) getModule(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 	if err != nil {
-		switch v := err.(type) {
-		case types.ModuleNotFoundError:
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, "%s", v)
+		m.writeError(w, "error getting module", err)
+		return
+	}
 
-		default:
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "error getting module: %s", err)
-			m.log.WithError(err).Errorf("error getting module")
+	if resp.Source != "" {
+		m.log.WithFields(logrus.Fields{
+			"agent_id":       agentId,
+			"module_name":    moduleName,
+			"module_version": moduleVersion,
+			"checksum":       resp.Checksum,
+			"source":         resp.Source,
+		}).Info("serving module")
+	}
+
+	m.writeContent(w, resp.Content, resp.Checksum, resp.ChecksumAlgorithm)
+}
+
+// resolveModuleVersion returns the version of a module to serve to a client
+// that didn't ask for a specific version. If the ConfigurationRepository can't
+// list module versions, the empty version is passed through to it as-is.
+func (m *Manager) resolveModuleVersion(ctx context.Context, agentId, moduleName string) (string, error) {
+	lister, ok := m.config.(ModuleVersionLister)
+	if !ok {
+		return "", nil
+	}
+
+	resp, err := lister.ListModuleVersions(ctx, types.ListModuleVersionsRequest{
+		AgentID: agentId,
+		Name:    moduleName,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	version := resp.Default
+	if version == "" {
+		version, ok = module.Latest(resp.Versions)
+		if !ok {
+			return "", types.ModuleNotFoundError{
+				AgentID: agentId,
+				Name:    moduleName,
+			}
 		}
-		return
 	}
 
+	m.log.WithFields(logrus.Fields{
+		"agent_id":       agentId,
+		"module_name":    moduleName,
+		"module_version": version,
+		"pinned":         resp.Default != "",
+	}).Debug("resolved module version")
+	return version, nil
+}
+
+// writeContent writes a configuration or module body to the response, along
+// with the headers that describe its checksum.
+func (m *Manager) writeContent(w http.ResponseWriter, content io.Reader, sum, algo string) {
 	w.Header().Set("Content-Type", "application/octet-stream")
-	w.Header().Set("Checksum", resp.Checksum)
+	w.Header().Set("Checksum", sum)
 
 	// Case-sensitive
-	w.Header()["ChecksumAlgorithm"] = []string{resp.ChecksumAlgorithm}
-
-	_, err = io.Copy(w, resp.Content)
-	if cl, ok := resp.Content.(io.Closer); ok {
+	w.Header()["ChecksumAlgorithm"] = []string{algo}
+
+	// The content is streamed to the client, so we can only verify its
+	// checksum once it has been sent. If it doesn't match, we abort the
+	// response, so that the client sees a failed download rather than a
+	// complete one with the wrong content.
+	_, err := io.Copy(w, checksum.NewVerifyingReader(content, algo, sum))
+	if cl, ok := content.(io.Closer); ok {
 		cl.Close()
 	}
 	if err != nil {
+		if _, ok := err.(checksum.MismatchError); ok {
+			m.log.WithError(err).Error("aborting response with mismatched checksum")
+			panic(http.ErrAbortHandler)
+		}
+
 		m.log.WithError(err).Errorf("error copying response body")
 		return
 	}
@@ -269,39 +478,48 @@ func (m *Manager) getDscAction(w http.ResponseWriter, r *http.Request) {
 
 	var body types.GetDscActionRequestBody
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "error decoding body: %s", err)
+		httperr.Write(w, httperr.BadRequest("error decoding body: %s", err))
 		return
 	}
 
 	m.log.WithFields(logrus.Fields{
-		"agent_id": agentId,
-		"body":     body,
+		"agent_id":            agentId,
+		"body":                body,
+		"registration_key_id": registrationKeyID(r.Context()),
 	}).Infof("getting action for client")
 
-	resp, err := m.status.GetDscAction(
-		r.Context(),
-		types.GetDscActionRequest{
-			AgentID: agentId,
-			Body:    body,
-		},
-	)
-	if err != nil {
-		switch v := err.(type) {
-		case types.AgentNotRegisteredError:
-			// If the agent isn't registered, we send a 400 response to trigger a re-registration.
-			// TODO: figure out why this doesn't actually re-register
-			w.Header().Set("Content-Type", "application/json")
-			w.WriteHeader(http.StatusBadRequest)
-			fmt.Fprintf(w, "agent not registered: %s", v.AgentID)
+	req := types.GetDscActionRequest{
+		AgentID: agentId,
+		Body:    body,
+	}
+	resp, err := m.status.GetDscAction(r.Context(), req)
 
-		default:
-			m.log.WithError(err).Errorf("error getting status")
+	// If the agent isn't registered, we may be able to register it from
+	// the request and try again.
+	if _, ok := err.(types.AgentNotRegisteredError); ok && m.canAutoRegister(r) {
+		err = m.autoRegister(r, req)
+		if err == nil {
+			resp, err = m.status.GetDscAction(r.Context(), req)
+		}
+	}
 
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "error getting status: %s", err)
+	if err != nil {
+		// If the agent isn't registered, the client needs to be told
+		// that the server doesn't know about it, so that it registers
+		// again; AgentNotRegisteredError is reported as a 404 for this
+		// reason.
+		if _, ok := err.(types.AgentNotRegisteredError); ok {
+			m.log.WithField("agent_id", agentId).Warn("agent not registered; requesting re-registration")
 		}
 
+		m.writeError(w, "error getting status", actionError(err))
+		return
+	}
+
+	// Pushing a new meta-configuration takes precedence over any
+	// configuration updates, since it may change where those come from.
+	if err := m.checkMetaConfiguration(r.Context(), agentId, resp); err != nil {
+		m.writeError(w, "error checking meta-configuration", actionError(err))
 		return
 	}
 
@@ -322,12 +540,171 @@ func (m *Manager) getDscAction(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// checkMetaConfiguration updates a GetDscAction response to tell the agent to
+// update its meta-configuration, if it hasn't applied the current one.
+func (m *Manager) checkMetaConfiguration(ctx context.Context, agentId string, resp *types.GetDscActionResponse) error {
+	meta, err := m.pendingMetaConfiguration(ctx, agentId)
+	if err != nil || meta == nil {
+		return err
+	}
+	if cl, ok := meta.Content.(io.Closer); ok {
+		cl.Close()
+	}
+
+	m.log.WithFields(logrus.Fields{
+		"agent_id":     agentId,
+		"new_checksum": meta.Checksum,
+	}).Info("agent needs meta-configuration update")
+	resp.Body.NodeStatus = "UpdateMetaConfiguration"
+	return nil
+}
+
+// pendingMetaConfiguration returns the meta-configuration that the agent
+// should apply, or nil if it has already applied it or meta-configurations
+// aren't pushed to it.
+//
+// Agents that registered with a configuration named
+// types.MetaConfigurationName are never pushed a meta-configuration, since
+// they couldn't fetch it without shadowing that configuration.
+func (m *Manager) pendingMetaConfiguration(ctx context.Context, agentId string) (*types.GetMetaConfigurationResponse, error) {
+	if m.meta == nil {
+		return nil, nil
+	}
+	tracker, ok := m.status.(MetaConfigurationTracker)
+	if !ok {
+		return nil, nil
+	}
+
+	if getter, ok := m.status.(RegistrationGetter); ok {
+		reg, err := getter.GetRegistration(ctx, agentId)
+		switch err.(type) {
+		case nil:
+			for _, name := range reg.ConfigurationNames {
+				if strings.EqualFold(name, types.MetaConfigurationName) {
+					return nil, nil
+				}
+			}
+		case types.AgentNotRegisteredError:
+			return nil, nil
+		default:
+			return nil, err
+		}
+	}
+
+	applied, err := tracker.GetMetaConfigurationChecksum(ctx, agentId)
+	if err != nil {
+		if _, ok := err.(types.AgentNotRegisteredError); ok {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	meta, err := m.meta.GetMetaConfiguration(ctx, types.GetMetaConfigurationRequest{AgentID: agentId})
+	if err != nil || meta == nil {
+		return nil, err
+	}
+	if meta.Checksum == applied {
+		if cl, ok := meta.Content.(io.Closer); ok {
+			cl.Close()
+		}
+		return nil, nil
+	}
+	return meta, nil
+}
+
+// serveMetaConfiguration writes the agent's meta-configuration to the
+// response, and records that it was served.
+func (m *Manager) serveMetaConfiguration(w http.ResponseWriter, r *http.Request, agentId string, meta *types.GetMetaConfigurationResponse) {
+	// The meta-configuration isn't considered applied until the agent
+	// registers again, so the agent keeps being told to update it until
+	// it succeeds.
+	tracker := m.status.(MetaConfigurationTracker)
+	if err := tracker.SetServedMetaConfigurationChecksum(r.Context(), agentId, meta.Checksum); err != nil {
+		m.log.WithError(err).Errorf("error recording meta-configuration checksum")
+	}
+
+	m.log.WithFields(logrus.Fields{
+		"agent_id": agentId,
+		"checksum": meta.Checksum,
+	}).Info("serving meta-configuration")
+
+	m.writeContent(w, meta.Content, meta.Checksum, meta.ChecksumAlgorithm)
+}
+
+// canAutoRegister returns whether an unregistered agent making the given
+// request can be registered automatically. This requires the request to have
+// been signed with a valid registration key, since that is what would have
+// authorized a normal registration, and, when binding agents to their
+// certificates, a client certificate to bind the agent to.
+func (m *Manager) canAutoRegister(r *http.Request) bool {
+	if !m.autoRegistration {
+		return false
+	}
+
+	if _, signed := RegistrationKeyFromContext(r.Context()); !signed {
+		return false
+	}
+	return !m.certBinding || clientThumbprint(r) != ""
+}
+
+// autoRegister registers an agent using the information in a GetDscAction
+// request. Since the request doesn't contain the agent's
+// ConfigurationNames, we use any names in the ClientStatus; agents with a
+// single, unnamed configuration should be given one with an AssignmentStore.
+//
+// The agent's certificate and IP address are taken from the connection, but
+// its NodeName isn't known until it registers itself.
+func (m *Manager) autoRegister(r *http.Request, req types.GetDscActionRequest) error {
+	ctx := r.Context()
+
+	var names []string
+	for _, status := range req.Body.ClientStatus {
+		if status.ConfigurationName != "" {
+			names = append(names, status.ConfigurationName)
+		}
+	}
+
+	if err := checkAllowedConfigurations(ctx, req.AgentID, names); err != nil {
+		return err
+	}
+
+	regType := "ConfigurationRepository"
+	body := types.RegisterDscAgentRequestBody{
+		ConfigurationNames: names,
+		RegistrationInformation: types.RegistrationInformation{
+			RegistrationMessageType: &regType,
+		},
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		body.RegistrationInformation.CertificateInformation = certificateInformation(r.TLS.PeerCertificates[0])
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		body.AgentInformation.IPAddress = &host
+	}
+
+	m.log.WithFields(logrus.Fields{
+		"agent_id":            req.AgentID,
+		"configuration_names": names,
+		"registration_key_id": registrationKeyID(ctx),
+		"thumbprint":          clientThumbprint(r),
+	}).Info("automatically registering agent")
+
+	return m.registerConfigurationRepository(ctx, types.RegisterDscAgentRequest{
+		AgentID: req.AgentID,
+		Body:    body,
+	})
+}
+
 func (m *Manager) sendReport(w http.ResponseWriter, r *http.Request) {
-	agentId := regexpat.Param(r, "agent_id")
+	m.saveReport(w, r, regexpat.Param(r, "agent_id"))
+}
+
+// saveReport decodes a report from the request body and saves it to the
+// ReportServer under the given agent ID.
+func (m *Manager) saveReport(w http.ResponseWriter, r *http.Request, agentId string) {
 	var body types.SendReportRequestBody
 	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
-		fmt.Fprintf(w, "error decoding body: %s", err)
+		httperr.Write(w, httperr.BadRequest("error decoding body: %s", err))
 		return
 	}
 
@@ -339,10 +716,7 @@ func (m *Manager) sendReport(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 	if err != nil {
-		m.log.WithError(err).Error("error saving report")
-
-		w.WriteHeader(http.StatusInternalServerError)
-		fmt.Fprintf(w, "error saving report: %s", err)
+		m.writeError(w, "error saving report", err)
 		return
 	}
 
@@ -351,9 +725,12 @@ func (m *Manager) sendReport(w http.ResponseWriter, r *http.Request) {
 }
 
 func (m *Manager) getReports(w http.ResponseWriter, r *http.Request) {
-	agentId := regexpat.Param(r, "agent_id")
-	jobId := regexpat.Param(r, "job_id")
+	m.serveReport(w, r, regexpat.Param(r, "agent_id"), regexpat.Param(r, "job_id"))
+}
 
+// serveReport fetches the given report from the ReportServer and writes it to
+// the response.
+func (m *Manager) serveReport(w http.ResponseWriter, r *http.Request, agentId, jobId string) {
 	resp, err := m.report.GetReports(
 		r.Context(),
 		types.GetReportsRequest{
@@ -362,17 +739,7 @@ func (m *Manager) getReports(w http.ResponseWriter, r *http.Request) {
 		},
 	)
 	if err != nil {
-		switch v := err.(type) {
-		case types.ReportNotFoundError:
-			w.WriteHeader(http.StatusNotFound)
-			fmt.Fprintf(w, "%s", v)
-
-		default:
-			m.log.WithError(err).Error("error getting report")
-
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprintf(w, "error getting report: %s", err)
-		}
+		m.writeError(w, "error getting report", err)
 		return
 	}
 
@@ -394,10 +761,77 @@ func (m *Manager) getReports(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+func (m *Manager) rotateCertificate(w http.ResponseWriter, r *http.Request) {
+	agentId := regexpat.Param(r, "agent_id")
+
+	// Certificate rotation is optional; if the NodeStatus doesn't support
+	// it, respond as we would for any other unsupported method.
+	rotator, ok := m.status.(CertificateRotator)
+	if !ok {
+		m.methodNotSupported(w, r)
+		return
+	}
+
+	var body types.RotateCertificateRequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httperr.Write(w, httperr.BadRequest("error decoding body: %s", err))
+		return
+	}
+
+	// When binding agents to their certificates, the client must connect
+	// with its current certificate, which is checked by middleware.
+
+	var thumbprint string
+	if s := body.RotationInformation.CertificateInformation.Thumbprint; s != nil {
+		thumbprint = *s
+	}
+	m.log.WithFields(logrus.Fields{
+		"agent_id":   agentId,
+		"thumbprint": thumbprint,
+	}).Info("rotating agent certificate")
+
+	_, err := rotator.RotateCertificate(
+		r.Context(),
+		types.CertificateRotationRequest{
+			AgentID: agentId,
+			Body:    body,
+		},
+	)
+	if err != nil {
+		m.writeError(w, "error rotating certificate", err)
+		return
+	}
+
+	// 3.12.5.1.1.2: No response body.
+	w.WriteHeader(http.StatusOK)
+}
+
 func (m *Manager) methodNotSupported(w http.ResponseWriter, r *http.Request) {
-	w.WriteHeader(http.StatusNotImplemented)
+	httperr.Write(w, httperr.NotImplemented("method not supported"))
+}
+
+// writeError writes an error response. Errors that don't implement
+// types.HTTPError are internal errors; these are logged, and reported to the
+// client with the given context.
+// actionError returns the error to report for a failed GetDscAction. The
+// LCM registers again whenever GetDscAction returns a 404, so only
+// AgentNotRegisteredError is reported as one; anything else that would be,
+// such as a missing configuration, is a server-side error, with the same
+// error code.
+func actionError(err error) error {
+	if _, ok := err.(types.AgentNotRegisteredError); ok {
+		return err
+	}
+	if status, code := httperr.Status(err); status == http.StatusNotFound {
+		return httperr.Error{Status: http.StatusInternalServerError, Code: code, Message: err.Error()}
+	}
+	return err
+}
 
-	// TODO: real error
-	w.Header().Set("Content-Type", "text/plain")
-	fmt.Fprint(w, "method not supported")
+func (m *Manager) writeError(w http.ResponseWriter, context string, err error) {
+	if status, _ := httperr.Status(err); status >= http.StatusInternalServerError {
+		m.log.WithError(err).Error(context)
+		err = fmt.Errorf("%s: %w", context, err)
+	}
+	httperr.Write(w, err)
 }
@@ -0,0 +1,550 @@
+package dsc
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/static"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/report/memory"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Sample requests in the format sent by a WMF 5.1 LCM configured to pull a single
+// configuration named "ClientConfig2". The registration body is the one from
+// the Tug project's registration key documentation, which is also used in
+// middleware_test.go; the GetDscAction bodies are written to match it, rather
+// than recorded. Traffic captured from a real LCM, including re-registration
+// after a 404, is replayed from testdata/lcm by TestRecordedLCMTraffic.
+const (
+	sampleAgentId = `B1F28971-2CEB-46D5-9DCB-79C044395F81`
+
+	sampleRegisterBody = `{"AgentInformation":{"LCMVersion":"2.0","NodeName":"EC2AMAZ-VT1I874","IPAddress":"10.50.1.9;127.0.0.1;fe80::288e:6e98:1555:55e9%6;::2000:0:0:0;::1;::2000:0:0:0"},"ConfigurationNames":["ClientConfig2"],"RegistrationInformation":{"CertificateInformation":{"FriendlyName":"DSC-OaaS Client Authentication","Issuer":"CN=http://10.50.1.5:8080/PSDSCPullServer.svc","NotAfter":"2017-12-21T11:40:36.0000000-05:00","NotBefore":"2016-12-21T16:30:36.0000000-05:00","Subject":"CN=http://10.50.1.5:8080/PSDSCPullServer.svc","PublicKey":"U3lzdGVtLlNlY3VyaXR5LkNyeXB0b2dyYXBoeS5YNTA5Q2VydGlmaWNhdGVzLlB1YmxpY0tleQ==","Thumbprint":"AC5849ACDB6DD19FD79B6ACA2D077E71CEE31C4F","Version":3},"RegistrationMessageType":"ConfigurationRepository"}}`
+
+	sampleGetDscActionBody = `{"ClientStatus":[{"Checksum":"","ChecksumAlgorithm":"SHA-256"}]}`
+
+	samplePartialGetDscActionBody = `{"ClientStatus":[{"Checksum":"","ConfigurationName":"ClientConfig2","ChecksumAlgorithm":"SHA-256"}]}`
+)
+
+// nodeStatus is a minimal in-memory NodeStatus; the real implementations
+// can't be used here, since they depend on this package.
+type nodeStatus struct {
+	regs   map[string][]string
+	bodies map[string]types.RegisterDscAgentRequestBody
+}
+
+func (s *nodeStatus) RegisterDscAgent(ctx context.Context, req types.RegisterDscAgentRequest) (*types.RegisterDscAgentResponse, error) {
+	s.regs[req.AgentID] = req.Body.ConfigurationNames
+	s.bodies[req.AgentID] = req.Body
+	return nil, nil
+}
+
+func (s *nodeStatus) GetRegistration(ctx context.Context, agentId string) (*types.RegisterDscAgentRequestBody, error) {
+	body, ok := s.bodies[agentId]
+	if !ok {
+		return nil, types.AgentNotRegisteredError{AgentID: agentId}
+	}
+	return &body, nil
+}
+
+func (s *nodeStatus) RotateCertificate(ctx context.Context, req types.CertificateRotationRequest) (*types.CertificateRotationResponse, error) {
+	body, ok := s.bodies[req.AgentID]
+	if !ok {
+		return nil, types.AgentNotRegisteredError{AgentID: req.AgentID}
+	}
+	body.RegistrationInformation.CertificateInformation = req.Body.RotationInformation.CertificateInformation
+	s.bodies[req.AgentID] = body
+	return nil, nil
+}
+
+func (s *nodeStatus) GetDscAction(ctx context.Context, req types.GetDscActionRequest) (*types.GetDscActionResponse, error) {
+	regs, ok := s.regs[req.AgentID]
+	if !ok {
+		return nil, types.AgentNotRegisteredError{AgentID: req.AgentID}
+	}
+
+	var details []types.GetDscActionResponseBodyDetail
+	for _, name := range regs {
+		details = append(details, types.GetDscActionResponseBodyDetail{
+			ConfigurationName: name,
+			Status:            "GetConfiguration",
+		})
+	}
+	return &types.GetDscActionResponse{
+		Body: types.GetDscActionResponseBody{
+			Details:    details,
+			NodeStatus: "GetConfiguration",
+		},
+	}, nil
+}
+
+func newTestManager(opts ...Option) (*Manager, *nodeStatus) {
+	status := &nodeStatus{
+		regs:   make(map[string][]string),
+		bodies: make(map[string]types.RegisterDscAgentRequestBody),
+	}
+
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	opts = append([]Option{WithLogger(log)}, opts...)
+
+	config := static.New([]byte("configuration"), nil)
+	return NewManager(config, memory.New(), status, opts...), status
+}
+
+func newLCMRequest(method, path, body, key string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("ProtocolVersion", "2.0")
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	if key != "" {
+		date := time.Now().UTC().Format(time.RFC3339Nano)
+		bodyHash := sha256.Sum256([]byte(body))
+		req.Header.Set("x-ms-date", date)
+		req.Header.Set("Authorization", "Shared "+calculateDSCSignature(key, date, bodyHash[:]))
+	}
+	return req
+}
+
+func TestGetDscActionReRegistration(t *testing.T) {
+	mgr, _ := newTestManager()
+	actionURL := fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId)
+
+	// An unknown agent must be told that it isn't registered, with a 404.
+	resp := httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("POST", actionURL, sampleGetDscActionBody, ""))
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, []string{"2.0"}, resp.Header()["ProtocolVersion"])
+	assert.Equal(t, "application/json;charset=utf-8", resp.Header().Get("Content-Type"))
+	assert.Equal(t, "AgentNotRegistered", errorCode(t, resp))
+
+	// The LCM then re-registers...
+	resp = httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("PUT", fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId), sampleRegisterBody, ""))
+	assert.Equal(t, http.StatusNoContent, resp.Code)
+
+	// ... and retries.
+	resp = httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("POST", actionURL, sampleGetDscActionBody, ""))
+	require.Equal(t, http.StatusOK, resp.Code)
+
+	var body types.GetDscActionResponseBody
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+	assert.Equal(t, "GetConfiguration", body.NodeStatus)
+}
+
+// missingConfigStatus is a NodeStatus whose configurations have been deleted
+// from the repository.
+type missingConfigStatus struct {
+	*nodeStatus
+}
+
+func (s missingConfigStatus) GetDscAction(ctx context.Context, req types.GetDscActionRequest) (*types.GetDscActionResponse, error) {
+	if _, err := s.nodeStatus.GetDscAction(ctx, req); err != nil {
+		return nil, err
+	}
+	return nil, types.ConfigurationNotFoundError{AgentID: req.AgentID, Name: "ClientConfig2"}
+}
+
+func TestGetDscActionMissingConfiguration(t *testing.T) {
+	mgr, fake := newTestManager()
+	mgr.status = missingConfigStatus{fake}
+	actionURL := fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId)
+
+	resp := httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("PUT", fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId), sampleRegisterBody, ""))
+	require.Equal(t, http.StatusNoContent, resp.Code)
+
+	// A missing configuration is a server-side error, rather than a 404,
+	// which would tell the agent to register again on every check.
+	resp = httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("POST", actionURL, sampleGetDscActionBody, ""))
+	assert.Equal(t, http.StatusInternalServerError, resp.Code)
+	assert.Equal(t, "ConfigurationNotFound", errorCode(t, resp))
+}
+
+func TestGetDscActionAutoRegistration(t *testing.T) {
+	regKey := `f65e1a0c-46b0-424c-a6a5-c3701aef32e5`
+	actionURL := fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId)
+
+	testCases := []struct {
+		Name     string
+		Opts     []Option
+		Key      string
+		Expected int
+	}{
+		{"enabled", []Option{WithKeys([]string{regKey}), WithAutoRegistration()}, regKey, http.StatusOK},
+		{"disabled", []Option{WithKeys([]string{regKey})}, regKey, http.StatusNotFound},
+		{"no keys", []Option{WithAutoRegistration()}, "", http.StatusNotFound},
+		{"bad key", []Option{WithKeys([]string{regKey}), WithAutoRegistration()}, "bad", http.StatusUnauthorized},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.Name, func(t *testing.T) {
+			mgr, status := newTestManager(tc.Opts...)
+
+			resp := httptest.NewRecorder()
+			mgr.ServeHTTP(resp, newLCMRequest("POST", actionURL, samplePartialGetDscActionBody, tc.Key))
+			assert.Equal(t, tc.Expected, resp.Code)
+
+			if tc.Expected == http.StatusOK {
+				assert.Equal(t, []string{"ClientConfig2"}, status.regs[sampleAgentId])
+			} else {
+				assert.NotContains(t, status.regs, sampleAgentId)
+			}
+		})
+	}
+}
+
+// Automatically registered agents are bound to the certificate they
+// connected with, so that they can make further requests.
+func TestAutoRegistrationWithCertificateBinding(t *testing.T) {
+	regKey := `f65e1a0c-46b0-424c-a6a5-c3701aef32e5`
+	actionURL := fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId)
+	configURL := fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='ClientConfig2')/ConfigurationContent", sampleAgentId)
+	cert := &x509.Certificate{Raw: []byte("agent certificate")}
+
+	mgr, status := newTestManager(WithKeys([]string{regKey}), WithAutoRegistration(), WithClientCertificateBinding())
+	serve := func(req *http.Request) int {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// Without a certificate, there's nothing to bind the agent to.
+	assert.Equal(t, http.StatusNotFound, serve(withCert(newLCMRequest("POST", actionURL, samplePartialGetDscActionBody, regKey), nil)))
+	assert.NotContains(t, status.regs, sampleAgentId)
+
+	assert.Equal(t, http.StatusOK, serve(withCert(newLCMRequest("POST", actionURL, samplePartialGetDscActionBody, regKey), cert)))
+	reg := status.bodies[sampleAgentId]
+	require.NotNil(t, reg.RegistrationInformation.CertificateInformation.Thumbprint)
+	assert.Equal(t, thumbprintOf(cert), *reg.RegistrationInformation.CertificateInformation.Thumbprint)
+	require.NotNil(t, reg.AgentInformation.IPAddress)
+	assert.Equal(t, "192.0.2.1", *reg.AgentInformation.IPAddress)
+
+	assert.Equal(t, http.StatusOK, serve(withCert(newLCMRequest("GET", configURL, "", regKey), cert)))
+	assert.Equal(t, http.StatusUnauthorized, serve(withCert(newLCMRequest("GET", configURL, "", regKey), &x509.Certificate{Raw: []byte("other")})))
+}
+
+func TestClientCertificateBinding(t *testing.T) {
+	mgr, _ := newTestManager(WithClientCertificateBinding())
+	actionURL := fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId)
+	configURL := fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='ClientConfig2')/ConfigurationContent", sampleAgentId)
+	moduleURL := "/Modules(ModuleName='Module',ModuleVersion='1.0')/ModuleContent"
+	withModuleAgent := func(req *http.Request, agentId string) *http.Request {
+		req.Header.Set("AgentId", agentId)
+		return req
+	}
+
+	// The sample registration has a thumbprint of
+	// AC5849ACDB6DD19FD79B6ACA2D077E71CEE31C4F; rather than recording the
+	// certificate itself, we swap in the thumbprint of our fake one.
+	registered := &x509.Certificate{Raw: []byte("registered certificate")}
+	other := &x509.Certificate{Raw: []byte("other certificate")}
+	registerBody := strings.Replace(sampleRegisterBody, "AC5849ACDB6DD19FD79B6ACA2D077E71CEE31C4F", thumbprintOf(registered), 1)
+
+	testCases := []struct {
+		Name     string
+		Req      *http.Request
+		Expected int
+	}{
+		// Unregistered agents can only be told to register
+		{"unregistered action", withCert(newLCMRequest("POST", actionURL, sampleGetDscActionBody, ""), other), http.StatusNotFound},
+		{"unregistered config", withCert(newLCMRequest("GET", configURL, "", ""), other), http.StatusUnauthorized},
+
+		// Registration must use the certificate being registered
+		{"register mismatch", withCert(newLCMRequest("PUT", fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId), registerBody, ""), other), http.StatusUnauthorized},
+		{"register", withCert(newLCMRequest("PUT", fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId), registerBody, ""), registered), http.StatusNoContent},
+
+		// Afterwards, only that certificate can be used
+		{"action", withCert(newLCMRequest("POST", actionURL, sampleGetDscActionBody, ""), registered), http.StatusOK},
+		{"config", withCert(newLCMRequest("GET", configURL, "", ""), registered), http.StatusOK},
+		{"action mismatch", withCert(newLCMRequest("POST", actionURL, sampleGetDscActionBody, ""), other), http.StatusUnauthorized},
+		{"config mismatch", withCert(newLCMRequest("GET", configURL, "", ""), other), http.StatusUnauthorized},
+		{"config without certificate", withCert(newLCMRequest("GET", configURL, "", ""), nil), http.StatusUnauthorized},
+
+		// GetModule's agent ID comes from a header, which must be an
+		// agent ID rather than, say, a path. (The test repository has
+		// no modules, so requests that get past the check 404.)
+		{"module", withModuleAgent(withCert(newLCMRequest("GET", moduleURL, "", ""), registered), sampleAgentId), http.StatusNotFound},
+		{"module mismatch", withModuleAgent(withCert(newLCMRequest("GET", moduleURL, "", ""), other), sampleAgentId), http.StatusUnauthorized},
+		{"module path", withModuleAgent(withCert(newLCMRequest("GET", moduleURL, "", ""), registered), "../reports/"+sampleAgentId), http.StatusBadRequest},
+		{"module suffix", withModuleAgent(withCert(newLCMRequest("GET", moduleURL, "", ""), registered), sampleAgentId+"/x"), http.StatusBadRequest},
+	}
+
+	// These cases depend on each other, so they run in order.
+	for _, tc := range testCases {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, tc.Req)
+		assert.Equal(t, tc.Expected, resp.Code, "test case %q: %s", tc.Name, resp.Body.String())
+	}
+}
+
+// Agents bound to a certificate can only change it by rotating it, which must
+// be signed with the current certificate.
+func TestCertificateRotationWithCertificateBinding(t *testing.T) {
+	mgr, status := newTestManager(WithClientCertificateBinding())
+	registerURL := fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId)
+	rotateURL := fmt.Sprintf("/Nodes(AgentId='%s')/CertificateRotation", sampleAgentId)
+	configURL := fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='ClientConfig2')/ConfigurationContent", sampleAgentId)
+
+	oldCert := &x509.Certificate{Raw: []byte("old certificate")}
+	newCert := &x509.Certificate{Raw: []byte("new certificate")}
+	other := &x509.Certificate{Raw: []byte("other certificate")}
+	registerBody := func(cert *x509.Certificate) string {
+		return strings.Replace(sampleRegisterBody, "AC5849ACDB6DD19FD79B6ACA2D077E71CEE31C4F", thumbprintOf(cert), 1)
+	}
+	rotateBody := func(cert *x509.Certificate) string {
+		return fmt.Sprintf(`{"RotationInformation":{"CertificateInformation":{"Thumbprint":"%s","Version":3}}}`, thumbprintOf(cert))
+	}
+
+	testCases := []struct {
+		Name     string
+		Req      *http.Request
+		Expected int
+	}{
+		{"rotate unregistered", withCert(newLCMRequest("POST", rotateURL, rotateBody(newCert), ""), oldCert), http.StatusUnauthorized},
+		{"register", withCert(newLCMRequest("PUT", registerURL, registerBody(oldCert), ""), oldCert), http.StatusNoContent},
+
+		// Registering again can't change the certificate, even with a
+		// consistent request made with the new one.
+		{"re-register new certificate", withCert(newLCMRequest("PUT", registerURL, registerBody(newCert), ""), newCert), http.StatusUnauthorized},
+		{"re-register mismatch", withCert(newLCMRequest("PUT", registerURL, registerBody(newCert), ""), oldCert), http.StatusUnauthorized},
+		{"re-register", withCert(newLCMRequest("PUT", registerURL, registerBody(oldCert), ""), oldCert), http.StatusNoContent},
+
+		// Rotation must be made with the current certificate.
+		{"rotate with new certificate", withCert(newLCMRequest("POST", rotateURL, rotateBody(newCert), ""), newCert), http.StatusUnauthorized},
+		{"rotate with other certificate", withCert(newLCMRequest("POST", rotateURL, rotateBody(newCert), ""), other), http.StatusUnauthorized},
+		{"rotate without certificate", withCert(newLCMRequest("POST", rotateURL, rotateBody(newCert), ""), nil), http.StatusUnauthorized},
+		{"config before rotation", withCert(newLCMRequest("GET", configURL, "", ""), oldCert), http.StatusOK},
+		{"rotate", withCert(newLCMRequest("POST", rotateURL, rotateBody(newCert), ""), oldCert), http.StatusOK},
+
+		// Afterwards, only the new certificate can be used.
+		{"config with old certificate", withCert(newLCMRequest("GET", configURL, "", ""), oldCert), http.StatusUnauthorized},
+		{"config with new certificate", withCert(newLCMRequest("GET", configURL, "", ""), newCert), http.StatusOK},
+		{"re-register old certificate", withCert(newLCMRequest("PUT", registerURL, registerBody(oldCert), ""), oldCert), http.StatusUnauthorized},
+		{"re-register after rotation", withCert(newLCMRequest("PUT", registerURL, registerBody(newCert), ""), newCert), http.StatusNoContent},
+	}
+
+	// These cases depend on each other, so they run in order.
+	for _, tc := range testCases {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, tc.Req)
+		assert.Equal(t, tc.Expected, resp.Code, "test case %q: %s", tc.Name, resp.Body.String())
+	}
+
+	reg := status.bodies[sampleAgentId]
+	assert.Equal(t, thumbprintOf(newCert), *reg.RegistrationInformation.CertificateInformation.Thumbprint)
+}
+
+// withCert sets the TLS client certificate of a request; if cert is nil, the
+// request is made over TLS without one.
+func withCert(req *http.Request, cert *x509.Certificate) *http.Request {
+	req.TLS = &tls.ConnectionState{}
+	if cert != nil {
+		req.TLS.PeerCertificates = []*x509.Certificate{cert}
+	}
+	return req
+}
+
+func thumbprintOf(cert *x509.Certificate) string {
+	h := sha1.Sum(cert.Raw)
+	return strings.ToUpper(hex.EncodeToString(h[:]))
+}
+
+func TestRegistrationKeyScoping(t *testing.T) {
+	const (
+		webKey     = `9b1cf2a4-07a5-4bf7-8d48-3c2f5c0f6e51`
+		dbKey      = `0a6f2b1e-5c8d-4e3a-9f7b-2d1c4e6a8b90`
+		revokedKey = `5d3e7f9a-1b2c-4d5e-8f6a-7b8c9d0e1f2a`
+		expiredKey = `e4f5a6b7-c8d9-4e0f-a1b2-c3d4e5f6a7b8`
+	)
+	mgr, status := newTestManager(WithKeyStore(keyList{
+		{ID: "web", Key: webKey, AllowedConfigurations: []string{"clientconfig2"}},
+		{ID: "db", Key: dbKey, AllowedConfigurations: []string{"Database"}},
+		{ID: "revoked", Key: revokedKey, Revoked: true},
+		{ID: "expired", Key: expiredKey, Expires: time.Now().Add(-time.Hour)},
+	}))
+	registerURL := fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId)
+	configURL := func(name string) string {
+		return fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='%s')/ConfigurationContent", sampleAgentId, name)
+	}
+
+	testCases := []struct {
+		Name   string
+		Req    *http.Request
+		Status int
+	}{
+		// Keys can't be used to register for configurations that they
+		// don't allow, or at all once revoked or expired.
+		{"register revoked", newLCMRequest("PUT", registerURL, sampleRegisterBody, revokedKey), http.StatusUnauthorized},
+		{"register expired", newLCMRequest("PUT", registerURL, sampleRegisterBody, expiredKey), http.StatusUnauthorized},
+		{"register not allowed", newLCMRequest("PUT", registerURL, sampleRegisterBody, dbKey), http.StatusForbidden},
+		{"register", newLCMRequest("PUT", registerURL, sampleRegisterBody, webKey), http.StatusNoContent},
+
+		// Nor to fetch them.
+		{"config", newLCMRequest("GET", configURL("ClientConfig2"), "", webKey), http.StatusOK},
+		{"config not allowed", newLCMRequest("GET", configURL("Database"), "", webKey), http.StatusForbidden},
+		{"config other key", newLCMRequest("GET", configURL("ClientConfig2"), "", dbKey), http.StatusForbidden},
+		{"config revoked", newLCMRequest("GET", configURL("ClientConfig2"), "", revokedKey), http.StatusUnauthorized},
+	}
+
+	// These cases depend on each other, so they run in order.
+	for _, tc := range testCases {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, tc.Req)
+		assert.Equal(t, tc.Status, resp.Code, "test case %q: %s", tc.Name, resp.Body.String())
+		if tc.Status == http.StatusForbidden {
+			assert.Equal(t, "ConfigurationNotAllowed", errorCode(t, resp), "test case %q", tc.Name)
+		}
+	}
+	assert.Equal(t, []string{"ClientConfig2"}, status.regs[sampleAgentId])
+}
+
+func TestReplayCacheRequiresClockSkew(t *testing.T) {
+	assert.Panics(t, func() {
+		newTestManager(WithReplayCache(newTestReplayCache()), WithClockSkew(0))
+	})
+	assert.NotPanics(t, func() {
+		newTestManager(WithReplayCache(newTestReplayCache()))
+	})
+	assert.NotPanics(t, func() {
+		newTestManager(WithClockSkew(0))
+	})
+}
+
+func TestErrorResponses(t *testing.T) {
+	regKey := `f65e1a0c-46b0-424c-a6a5-c3701aef32e5`
+	mgr, _ := newTestManager(WithKeys([]string{regKey}))
+
+	testCases := []struct {
+		Name   string
+		Req    *http.Request
+		Status int
+		Code   string
+	}{
+		{"bad signature", newLCMRequest("POST", fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId), sampleGetDscActionBody, "wrong key"), http.StatusUnauthorized, "Unauthorized"},
+		{"unknown module", func() *http.Request {
+			req := newLCMRequest("GET", "/Modules(ModuleName='Missing',ModuleVersion='1.0')/ModuleContent", "", regKey)
+			req.Header.Set("AgentId", sampleAgentId)
+			return req
+		}(), http.StatusNotFound, "ModuleNotFound"},
+		{"unsupported protocol version", func() *http.Request {
+			req := newLCMRequest("GET", fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='Missing')/ConfigurationContent", sampleAgentId), "", regKey)
+			req.Header.Set("ProtocolVersion", "3.0")
+			return req
+		}(), http.StatusNotImplemented, "NotImplemented"},
+	}
+
+	for _, tc := range testCases {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, tc.Req)
+		assert.Equal(t, tc.Status, resp.Code, "test case %q", tc.Name)
+		assert.Equal(t, "application/json;charset=utf-8", resp.Header().Get("Content-Type"), "test case %q", tc.Name)
+		assert.Equal(t, tc.Code, errorCode(t, resp), "test case %q", tc.Name)
+	}
+}
+
+// errorCode returns the OData error code from an error response.
+func errorCode(t *testing.T, resp *httptest.ResponseRecorder) string {
+	var body struct {
+		Error struct {
+			Code    string `json:"code"`
+			Message string `json:"message"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(resp.Body.Bytes(), &body); err != nil {
+		t.Fatalf("error decoding error response %q: %s", resp.Body.String(), err)
+	}
+	return body.Error.Code
+}
+
+// corruptRepo serves content that doesn't match the advertised checksum.
+type corruptRepo struct {
+	*static.ConfigurationRepository
+}
+
+func (c corruptRepo) GetModule(ctx context.Context, req types.GetModuleRequest) (*types.GetModuleResponse, error) {
+	resp, err := c.ConfigurationRepository.GetModule(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	resp.Content = strings.NewReader("corrupted")
+	return resp, nil
+}
+
+func TestChecksumMismatchAbortsResponse(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	spec := static.ModuleSpec{Name: "Module", Version: "1.0"}
+	config := corruptRepo{static.New(nil, map[static.ModuleSpec][]byte{spec: []byte("module")})}
+	status := &nodeStatus{
+		regs:   make(map[string][]string),
+		bodies: make(map[string]types.RegisterDscAgentRequestBody),
+	}
+	mgr := NewManager(config, memory.New(), status, WithLogger(log))
+
+	req := newLCMRequest("GET", "/Modules(ModuleName='Module',ModuleVersion='1.0')/ModuleContent", "", "")
+	req.Header.Set("AgentId", sampleAgentId)
+
+	// The content has already been sent by the time the mismatch is
+	// detected, so the response is aborted.
+	assert.PanicsWithValue(t, http.ErrAbortHandler, func() {
+		mgr.ServeHTTP(httptest.NewRecorder(), req)
+	})
+}
+
+func TestGetModuleLatestVersion(t *testing.T) {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+
+	config := static.New(nil, map[static.ModuleSpec][]byte{
+		{Name: "Module", Version: "1.2"}:     []byte("1.2"),
+		{Name: "Module", Version: "1.10"}:    []byte("1.10"),
+		{Name: "Module", Version: "1.9.0.0"}: []byte("1.9.0.0"),
+	})
+	status := &nodeStatus{
+		regs:   make(map[string][]string),
+		bodies: make(map[string]types.RegisterDscAgentRequestBody),
+	}
+	mgr := NewManager(config, memory.New(), status, WithLogger(log))
+
+	getModule := func(name string) *httptest.ResponseRecorder {
+		req := newLCMRequest("GET", fmt.Sprintf("/Modules(ModuleName='%s',ModuleVersion='')/ModuleContent", name), "", "")
+		req.Header.Set("AgentId", sampleAgentId)
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, req)
+		return resp
+	}
+
+	// The highest version is served by default...
+	resp := getModule("module")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "1.10", resp.Body.String())
+
+	// ... unless another version is pinned.
+	config.PinModuleVersion("Module", "1.2")
+	resp = getModule("module")
+	assert.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "1.2", resp.Body.String())
+
+	resp = getModule("missing")
+	assert.Equal(t, http.StatusNotFound, resp.Code)
+	assert.Equal(t, "ModuleNotFound", errorCode(t, resp))
+}
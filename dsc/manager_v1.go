@@ -0,0 +1,151 @@
+package dsc
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/httperr"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/regexpat"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// This file contains the handlers for protocol versions 1.0 and 1.1. These
+// clients don't register with the server; instead, each request is addressed
+// by a ConfigurationId, which we map to a configuration name in the
+// ConfigurationRepository. Since there is no AgentId, the ConfigurationId is
+// used in its place when calling into the ConfigurationRepository and
+// ReportServer.
+
+// ConfigurationIDMap is a ConfigurationIDResolver that maps ConfigurationIds
+// to configuration names using a static map. Keys are matched
+// case-insensitively.
+type ConfigurationIDMap map[string]string
+
+// ResolveConfigurationID implements the ConfigurationIDResolver interface.
+func (c ConfigurationIDMap) ResolveConfigurationID(ctx context.Context, id string) (string, error) {
+	for k, v := range c {
+		if strings.EqualFold(k, id) {
+			return v, nil
+		}
+	}
+
+	return "", types.ConfigurationNotFoundError{
+		AgentID: id,
+		Name:    id,
+	}
+}
+
+// resolveConfigurationID maps the ConfigurationId in the request to a
+// configuration name. If no resolver was configured, the ConfigurationId is
+// itself the name of the configuration, as it is on the official pull server.
+//
+// Every version 1.0 and 1.1 request is resolved, including those for modules
+// and reports, so that a resolver also limits which ConfigurationIds the
+// server answers to at all.
+func (m *Manager) resolveConfigurationID(w http.ResponseWriter, r *http.Request) (string, string, bool) {
+	configId := regexpat.Param(r, "configuration_id")
+	if m.configIDs == nil {
+		return configId, configId, true
+	}
+
+	configName, err := m.configIDs.ResolveConfigurationID(r.Context(), configId)
+	if err != nil {
+		m.writeError(w, "error resolving configuration ID", err)
+		return "", "", false
+	}
+
+	return configId, configName, true
+}
+
+func (m *Manager) getConfigurationV1(w http.ResponseWriter, r *http.Request) {
+	configId, configName, ok := m.resolveConfigurationID(w, r)
+	if !ok {
+		return
+	}
+
+	m.log.WithFields(logrus.Fields{
+		"configuration_id":   configId,
+		"configuration_name": configName,
+	}).Debug("getting configuration")
+
+	m.serveConfiguration(w, r, configId, configName)
+}
+
+func (m *Manager) getModuleV1(w http.ResponseWriter, r *http.Request) {
+	configId, _, ok := m.resolveConfigurationID(w, r)
+	if !ok {
+		return
+	}
+
+	moduleName := regexpat.Param(r, "module_name")
+	moduleVersion := regexpat.Param(r, "module_version")
+
+	m.log.WithFields(logrus.Fields{
+		"configuration_id": configId,
+		"module_name":      moduleName,
+		"module_version":   moduleVersion,
+	}).Debug("getting module")
+
+	m.serveModule(w, r, configId, moduleName, moduleVersion)
+}
+
+func (m *Manager) getActionV1(w http.ResponseWriter, r *http.Request) {
+	var body types.GetActionV1RequestBody
+	if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+		httperr.Write(w, httperr.BadRequest("error decoding body: %s", err))
+		return
+	}
+
+	configId, configName, ok := m.resolveConfigurationID(w, r)
+	if !ok {
+		return
+	}
+
+	expected, _, err := GetConfigHash(r.Context(), m.config, configId, configName)
+	if err != nil {
+		m.writeError(w, "error getting configuration hash", err)
+		return
+	}
+
+	// Version 1.0 and 1.1 clients only have a single configuration, so
+	// the action depends only on whether the checksum matches. A client
+	// that hasn't applied a configuration yet sends an empty checksum.
+	var resp types.GetActionV1ResponseBody
+	if body.Checksum != "" && strings.EqualFold(body.Checksum, expected) {
+		resp.Value = "OK"
+	} else {
+		resp.Value = "GetConfiguration"
+	}
+
+	m.log.WithFields(logrus.Fields{
+		"configuration_id":   configId,
+		"configuration_name": configName,
+		"status":             resp.Value,
+	}).Debug("replying with DSC action")
+
+	w.Header().Set("Content-Type", "application/json;charset=utf-8")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
+		m.log.WithError(err).Error("error encoding response")
+		return
+	}
+}
+
+func (m *Manager) sendStatusReportV1(w http.ResponseWriter, r *http.Request) {
+	configId, _, ok := m.resolveConfigurationID(w, r)
+	if !ok {
+		return
+	}
+	m.saveReport(w, r, configId)
+}
+
+func (m *Manager) getStatusReportV1(w http.ResponseWriter, r *http.Request) {
+	configId, _, ok := m.resolveConfigurationID(w, r)
+	if !ok {
+		return
+	}
+	m.serveReport(w, r, configId, regexpat.Param(r, "job_id"))
+}
@@ -0,0 +1,159 @@
+package dsc
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/sirupsen/logrus"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/config/static"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/report/memory"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+const (
+	v1ConfigId      = `8A5F3E2D-1C4B-4F6A-9E8D-7C6B5A4F3E2D`
+	v1UnknownId     = `00000000-0000-0000-0000-000000000000`
+	v1JobId         = `1F2E3D4C-5B6A-4798-8A7B-6C5D4E3F2A1B`
+	v1ReportBody    = `{"JobId":"` + v1JobId + `","OperationType":"Consistency","ReportFormatVersion":"1.0","NodeName":"wmf4"}`
+	v1Configuration = "configuration"
+)
+
+func newV1TestManager(opts ...Option) *Manager {
+	log := logrus.New()
+	log.Out = ioutil.Discard
+	opts = append([]Option{WithLogger(log)}, opts...)
+
+	config := static.New([]byte(v1Configuration), map[static.ModuleSpec][]byte{
+		{Name: "Module", Version: "1.0"}: []byte("module"),
+	})
+	status := &nodeStatus{
+		regs:   make(map[string][]string),
+		bodies: make(map[string]types.RegisterDscAgentRequestBody),
+	}
+	return NewManager(config, memory.New(), status, opts...)
+}
+
+// newV1Request returns a request as sent by a WMF 4.0 client, which doesn't
+// send a ProtocolVersion header or sign its requests.
+func newV1Request(method, path, body string) *http.Request {
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	return req
+}
+
+func TestProtocolV1(t *testing.T) {
+	mgr := newV1TestManager(WithProtocolV1(ConfigurationIDMap{
+		strings.ToLower(v1ConfigId): "ClientConfig",
+	}))
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, req)
+		return resp
+	}
+
+	t.Run("GetConfiguration", func(t *testing.T) {
+		resp := serve(newV1Request("GET", fmt.Sprintf("/Action(ConfigurationId='%s')/ConfigurationContent", v1ConfigId), ""))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, v1Configuration, resp.Body.String())
+		assert.Equal(t, []string{"SHA-256"}, resp.Header()["ChecksumAlgorithm"])
+
+		resp = serve(newV1Request("GET", fmt.Sprintf("/Action(ConfigurationId='%s')/ConfigurationContent", v1UnknownId), ""))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("GetModule", func(t *testing.T) {
+		resp := serve(newV1Request("GET", fmt.Sprintf("/Module(ConfigurationId='%s',ModuleName='Module',ModuleVersion='1.0')/ModuleContent", v1ConfigId), ""))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, "module", resp.Body.String())
+
+		resp = serve(newV1Request("GET", fmt.Sprintf("/Module(ConfigurationId='%s',ModuleName='Module',ModuleVersion='1.0')/ModuleContent", v1UnknownId), ""))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("GetAction", func(t *testing.T) {
+		sum := serve(newV1Request("GET", fmt.Sprintf("/Action(ConfigurationId='%s')/ConfigurationContent", v1ConfigId), "")).Header().Get("Checksum")
+		require.NotEmpty(t, sum)
+
+		action := func(id, checksum string) *httptest.ResponseRecorder {
+			body := fmt.Sprintf(`{"Checksum":"%s","ChecksumAlgorithm":"SHA-256","NodeCompliant":"true"}`, checksum)
+			return serve(newV1Request("POST", fmt.Sprintf("/Action(ConfigurationId='%s')/GetAction", id), body))
+		}
+		value := func(resp *httptest.ResponseRecorder) string {
+			var body types.GetActionV1ResponseBody
+			require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+			return body.Value
+		}
+
+		r := action(v1ConfigId, "")
+		require.Equal(t, http.StatusOK, r.Code)
+		assert.Equal(t, "GetConfiguration", value(r))
+
+		r = action(v1ConfigId, strings.ToLower(sum))
+		require.Equal(t, http.StatusOK, r.Code)
+		assert.Equal(t, "OK", value(r))
+
+		r = action(v1UnknownId, sum)
+		assert.Equal(t, http.StatusNotFound, r.Code)
+	})
+
+	t.Run("StatusReport", func(t *testing.T) {
+		resp := serve(newV1Request("POST", fmt.Sprintf("/Node(ConfigurationId='%s')/SendStatusReport", v1ConfigId), v1ReportBody))
+		require.Equal(t, http.StatusOK, resp.Code)
+
+		resp = serve(newV1Request("GET", fmt.Sprintf("/Node(ConfigurationId='%s')/Reports(JobId='%s')", v1ConfigId, v1JobId), ""))
+		require.Equal(t, http.StatusOK, resp.Code)
+		assert.Contains(t, resp.Body.String(), `"NodeName":"wmf4"`)
+
+		// Reports can't be sent or read for unknown ConfigurationIds.
+		resp = serve(newV1Request("POST", fmt.Sprintf("/Node(ConfigurationId='%s')/SendStatusReport", v1UnknownId), v1ReportBody))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+		resp = serve(newV1Request("GET", fmt.Sprintf("/Node(ConfigurationId='%s')/Reports(JobId='%s')", v1UnknownId, v1JobId), ""))
+		assert.Equal(t, http.StatusNotFound, resp.Code)
+	})
+
+	t.Run("ProtocolVersion", func(t *testing.T) {
+		req := newV1Request("GET", fmt.Sprintf("/Action(ConfigurationId='%s')/ConfigurationContent", v1ConfigId), "")
+		req.Header.Set("ProtocolVersion", "2.0")
+		resp := serve(req)
+		assert.Equal(t, http.StatusNotImplemented, resp.Code)
+
+		req.Header.Set("ProtocolVersion", "1.1")
+		resp = serve(req)
+		assert.Equal(t, http.StatusOK, resp.Code)
+		assert.Equal(t, []string{"1.1"}, resp.Header()["ProtocolVersion"])
+	})
+}
+
+func TestProtocolV1Disabled(t *testing.T) {
+	mgr := newV1TestManager()
+
+	for _, req := range []*http.Request{
+		newV1Request("GET", fmt.Sprintf("/Action(ConfigurationId='%s')/ConfigurationContent", v1ConfigId), ""),
+		newV1Request("GET", fmt.Sprintf("/Module(ConfigurationId='%s',ModuleName='Module',ModuleVersion='1.0')/ModuleContent", v1ConfigId), ""),
+		newV1Request("POST", fmt.Sprintf("/Action(ConfigurationId='%s')/GetAction", v1ConfigId), `{}`),
+		newV1Request("POST", fmt.Sprintf("/Node(ConfigurationId='%s')/SendStatusReport", v1ConfigId), v1ReportBody),
+		newV1Request("GET", fmt.Sprintf("/Node(ConfigurationId='%s')/Reports(JobId='%s')", v1ConfigId, v1JobId), ""),
+	} {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, req)
+		assert.Equal(t, http.StatusNotImplemented, resp.Code, "%s %s", req.Method, req.URL)
+		assert.Equal(t, "NotImplemented", errorCode(t, resp))
+	}
+}
+
+// Without a resolver, the ConfigurationId is the name of the configuration.
+func TestProtocolV1WithoutResolver(t *testing.T) {
+	mgr := newV1TestManager(WithProtocolV1(nil))
+
+	resp := httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newV1Request("GET", fmt.Sprintf("/Action(ConfigurationId='%s')/ConfigurationContent", v1UnknownId), ""))
+	assert.Equal(t, http.StatusOK, resp.Code)
+}
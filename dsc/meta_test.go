@@ -0,0 +1,154 @@
+package dsc
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// metaStatus is a nodeStatus that tracks meta-configurations, which are
+// applied when the agent registers again after being served one.
+type metaStatus struct {
+	*nodeStatus
+	served  map[string]string
+	applied map[string]string
+}
+
+func (s *metaStatus) RegisterDscAgent(ctx context.Context, req types.RegisterDscAgentRequest) (*types.RegisterDscAgentResponse, error) {
+	if served, ok := s.served[req.AgentID]; ok {
+		s.applied[req.AgentID] = served
+		delete(s.served, req.AgentID)
+	}
+	return s.nodeStatus.RegisterDscAgent(ctx, req)
+}
+
+func (s *metaStatus) GetMetaConfigurationChecksum(ctx context.Context, agentId string) (string, error) {
+	if _, ok := s.bodies[agentId]; !ok {
+		return "", types.AgentNotRegisteredError{AgentID: agentId}
+	}
+	return s.applied[agentId], nil
+}
+
+func (s *metaStatus) SetServedMetaConfigurationChecksum(ctx context.Context, agentId, checksum string) error {
+	if _, ok := s.bodies[agentId]; !ok {
+		return types.AgentNotRegisteredError{AgentID: agentId}
+	}
+	s.served[agentId] = checksum
+	return nil
+}
+
+// metaRepo serves the same meta-configuration to every agent.
+type metaRepo struct {
+	content string
+}
+
+func (r *metaRepo) GetMetaConfiguration(ctx context.Context, req types.GetMetaConfigurationRequest) (*types.GetMetaConfigurationResponse, error) {
+	if r.content == "" {
+		return nil, nil
+	}
+	return &types.GetMetaConfigurationResponse{
+		Content:           strings.NewReader(r.content),
+		Checksum:          fmt.Sprintf("%X", sha256.Sum256([]byte(r.content))),
+		ChecksumAlgorithm: "SHA-256",
+	}, nil
+}
+
+func TestMetaConfiguration(t *testing.T) {
+	repo := &metaRepo{content: "meta-one"}
+	mgr, fake := newTestManager(WithMetaConfiguration(repo))
+	mgr.status = &metaStatus{
+		nodeStatus: fake,
+		served:     make(map[string]string),
+		applied:    make(map[string]string),
+	}
+
+	serve := func(req *http.Request) *httptest.ResponseRecorder {
+		resp := httptest.NewRecorder()
+		mgr.ServeHTTP(resp, req)
+		return resp
+	}
+	register := func(body string) {
+		resp := serve(newLCMRequest("PUT", fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId), body, ""))
+		require.Equal(t, http.StatusNoContent, resp.Code)
+	}
+	nodeStatus := func() string {
+		resp := serve(newLCMRequest("POST", fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId), sampleGetDscActionBody, ""))
+		require.Equal(t, http.StatusOK, resp.Code)
+		var body types.GetDscActionResponseBody
+		require.NoError(t, json.NewDecoder(resp.Body).Decode(&body))
+		return body.NodeStatus
+	}
+	fetch := func() string {
+		resp := serve(newLCMRequest("GET", fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='%s')/ConfigurationContent", sampleAgentId, types.MetaConfigurationName), "", ""))
+		require.Equal(t, http.StatusOK, resp.Code)
+		return resp.Body.String()
+	}
+
+	register(sampleRegisterBody)
+	assert.Equal(t, "UpdateMetaConfiguration", nodeStatus())
+	assert.Equal(t, "meta-one", fetch())
+
+	// Until the agent registers again, it hasn't applied the
+	// meta-configuration, and is told to fetch it again.
+	assert.Equal(t, "UpdateMetaConfiguration", nodeStatus())
+	assert.Equal(t, "meta-one", fetch())
+
+	register(sampleRegisterBody)
+	assert.Equal(t, "GetConfiguration", nodeStatus())
+
+	// Once it's applied, the reserved name refers to an ordinary
+	// configuration again.
+	assert.Equal(t, "configuration", fetch())
+
+	// Registering without being served a meta-configuration doesn't
+	// apply one.
+	repo.content = "meta-two"
+	register(sampleRegisterBody)
+	assert.Equal(t, "UpdateMetaConfiguration", nodeStatus())
+	assert.Equal(t, "meta-two", fetch())
+	register(sampleRegisterBody)
+	assert.Equal(t, "GetConfiguration", nodeStatus())
+
+	// Without a meta-configuration, nothing is pushed.
+	repo.content = ""
+	assert.Equal(t, "GetConfiguration", nodeStatus())
+	assert.Equal(t, "configuration", fetch())
+}
+
+func TestMetaConfigurationNameCollision(t *testing.T) {
+	mgr, fake := newTestManager(WithMetaConfiguration(&metaRepo{content: "meta"}))
+	mgr.status = &metaStatus{
+		nodeStatus: fake,
+		served:     make(map[string]string),
+		applied:    make(map[string]string),
+	}
+
+	// An agent that uses a configuration with the reserved name is served
+	// that configuration, and never told to update its meta-configuration.
+	body := strings.Replace(sampleRegisterBody, `"ClientConfig2"`, `"`+types.MetaConfigurationName+`"`, 1)
+	resp := httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("PUT", fmt.Sprintf("/Nodes(AgentId='%s')", sampleAgentId), body, ""))
+	require.Equal(t, http.StatusNoContent, resp.Code)
+
+	resp = httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("POST", fmt.Sprintf("/Nodes(AgentId='%s')/GetDscAction", sampleAgentId), sampleGetDscActionBody, ""))
+	require.Equal(t, http.StatusOK, resp.Code)
+	var action types.GetDscActionResponseBody
+	require.NoError(t, json.NewDecoder(resp.Body).Decode(&action))
+	assert.Equal(t, "GetConfiguration", action.NodeStatus)
+
+	resp = httptest.NewRecorder()
+	mgr.ServeHTTP(resp, newLCMRequest("GET", fmt.Sprintf("/Nodes(AgentId='%s')/Configurations(ConfigurationName='%s')/ConfigurationContent", sampleAgentId, types.MetaConfigurationName), "", ""))
+	require.Equal(t, http.StatusOK, resp.Code)
+	assert.Equal(t, "configuration", resp.Body.String())
+}
@@ -9,43 +9,91 @@ import (
 	"io/ioutil"
 	"net/http"
 	"strings"
+	"time"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/httperr"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
-func protocolVersion(inner http.Handler) http.Handler {
-	fn := func(w http.ResponseWriter, r *http.Request) {
-		if ver := r.Header.Get("ProtocolVersion"); ver != "2.0" {
-			w.WriteHeader(http.StatusNotImplemented)
+// The protocol versions spoken by clients using the ConfigurationId-based
+// version 1.0 and 1.1 endpoints. WMF 4.0 clients may not send the
+// ProtocolVersion header at all, so we also accept an empty version.
+var v1ProtocolVersions = []string{"1.0", "1.1", ""}
 
-			// TODO: real error
-			w.Header().Set("Content-Type", "text/plain")
-			fmt.Fprintf(w, "protocol version %q not supported", ver)
-			return
-		}
+func protocolVersion(versions ...string) func(http.Handler) http.Handler {
+	return func(inner http.Handler) http.Handler {
+		fn := func(w http.ResponseWriter, r *http.Request) {
+			ver := r.Header.Get("ProtocolVersion")
+
+			supported := false
+			for _, v := range versions {
+				if v == ver {
+					supported = true
+					break
+				}
+			}
+			if !supported {
+				httperr.Write(w, httperr.NotImplemented("protocol version %q not supported", ver))
+				return
+			}
 
-		// Responses are protocol version 2.0 as well.
-		w.Header()["ProtocolVersion"] = []string{"2.0"}
+			// Responses use the same protocol version as the
+			// request.
+			if ver != "" {
+				w.Header()["ProtocolVersion"] = []string{ver}
+			}
 
-		// Pass to the underlying HTTP handler
-		inner.ServeHTTP(w, r)
+			// Pass to the underlying HTTP handler
+			inner.ServeHTTP(w, r)
+		}
+		return http.HandlerFunc(fn)
 	}
-	return http.HandlerFunc(fn)
 }
 
-func checkRegistration(keys []string) func(http.Handler) http.Handler {
+// registrationConfig contains the configuration for the checkRegistration
+// middleware.
+type registrationConfig struct {
+	// The registration keys to validate signatures with
+	keys KeyStore
+
+	// The maximum difference between the x-ms-date header and the
+	// current time; if zero, the date isn't checked.
+	skew time.Duration
+
+	// If set, used to reject signatures that have already been seen.
+	replay ReplayCache
+
+	// Returns the current time; if nil, time.Now is used.
+	now func() time.Time
+}
+
+// The format of the x-ms-date header sent by the LCM, e.g.
+// "2016-12-21T23:43:48.4718366Z"; this is a superset of RFC 3339.
+const msDateFormat = time.RFC3339Nano
+
+func checkRegistration(cfg registrationConfig) func(http.Handler) http.Handler {
+	now := cfg.now
+	if now == nil {
+		now = time.Now
+	}
+
 	return func(inner http.Handler) http.Handler {
 		fn := func(w http.ResponseWriter, r *http.Request) {
 			// Get needed headers; if they aren't present, it's a
 			// bad request.
 			msDate := r.Header.Get("x-ms-date")
 			if msDate == "" {
-				w.WriteHeader(http.StatusBadRequest)
-				fmt.Fprintf(w, `missing "x-ms-date" header`)
+				httperr.Write(w, httperr.BadRequest(`missing "x-ms-date" header`))
+				return
+			}
+			date, err := time.Parse(msDateFormat, msDate)
+			if err != nil {
+				httperr.Write(w, httperr.BadRequest(`invalid "x-ms-date" header: %s`, err))
 				return
 			}
 			authHeader := r.Header.Get("Authorization")
 			if authHeader == "" {
-				w.WriteHeader(http.StatusBadRequest)
-				fmt.Fprintf(w, `missing "Authorization" header`)
+				httperr.Write(w, httperr.BadRequest(`missing "Authorization" header`))
 				return
 			}
 
@@ -56,8 +104,7 @@ func checkRegistration(keys []string) func(http.Handler) http.Handler {
 			body, err := ioutil.ReadAll(r.Body)
 			r.Body.Close()
 			if err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				fmt.Fprintf(w, "error reading request body: %s", err)
+				httperr.Write(w, fmt.Errorf("error reading request body: %w", err))
 				return
 			}
 
@@ -69,24 +116,63 @@ func checkRegistration(keys []string) func(http.Handler) http.Handler {
 			h.Write(body)
 			bodyHash := h.Sum(nil)
 
+			keys, err := cfg.keys.GetKeys(r.Context())
+			if err != nil {
+				httperr.Write(w, fmt.Errorf("error getting registration keys: %w", err))
+				return
+			}
+
 			// For each registration key, attempt to validate the signature
-			match := false
-			for _, rkey := range keys {
+			var match *types.RegistrationKey
+			for i := range keys {
 				// Verify against the `Authorization` header
-				expectedAuth := calculateDSCSignature(rkey, msDate, bodyHash)
+				expectedAuth := calculateDSCSignature(keys[i].Key, msDate, bodyHash)
 				if hmac.Equal([]byte(expectedAuth), []byte(authHeader)) {
-					match = true
+					match = &keys[i]
 					break
 				}
 			}
 
 			// If we didn't match, return a "Unauthorized" response.
-			if !match {
-				w.WriteHeader(http.StatusUnauthorized)
-				fmt.Fprintf(w, `bad signature on request`)
+			if match == nil {
+				httperr.Write(w, httperr.Unauthorized(`bad signature on request`))
 				return
 			}
 
+			// A correct signature with a key that can't be used is
+			// also unauthorized.
+			current := now()
+			if !match.Usable(current) {
+				httperr.Write(w, httperr.Unauthorized("registration key %q is revoked or expired", match.ID))
+				return
+			}
+
+			// The signature covers the date, so a request with a
+			// valid signature can be replayed until the date falls
+			// outside of the allowed skew; reject requests outside
+			// of that window, and signatures we've already seen
+			// within it.
+			if cfg.skew > 0 {
+				if diff := current.Sub(date); diff > cfg.skew || diff < -cfg.skew {
+					httperr.Write(w, httperr.Unauthorized(`"x-ms-date" header is outside of the allowed clock skew`))
+					return
+				}
+			}
+			if cfg.replay != nil {
+				seen, err := cfg.replay.Seen(r.Context(), authHeader, date.Add(cfg.skew))
+				if err != nil {
+					httperr.Write(w, fmt.Errorf("error checking for replayed request: %w", err))
+					return
+				}
+				if seen {
+					httperr.Write(w, httperr.Unauthorized(`request has already been seen`))
+					return
+				}
+			}
+
+			// Record which key matched, for use in handlers.
+			r = r.WithContext(withRegistrationKey(r.Context(), match))
+
 			// Otherwise, we're good; call our underlying handler.
 			inner.ServeHTTP(w, r)
 		}
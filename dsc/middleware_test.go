@@ -1,11 +1,14 @@
 package dsc
 
 import (
+	"context"
+	"crypto/sha256"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/stretchr/testify/assert"
 )
@@ -13,6 +16,9 @@ import (
 func TestCheckRegistration(t *testing.T) {
 	regKey := `f65e1a0c-46b0-424c-a6a5-c3701aef32e5`
 
+	// The test values below were recorded at roughly this time
+	recordedAt := time.Date(2016, 12, 21, 23, 45, 0, 0, time.UTC)
+
 	testCases := []struct {
 		Authz   string
 		MsDate  string
@@ -69,7 +75,11 @@ func TestCheckRegistration(t *testing.T) {
 
 			resp := httptest.NewRecorder()
 
-			mware := checkRegistration([]string{regKey})
+			mware := checkRegistration(registrationConfig{
+				keys: keyList{{ID: "test", Key: regKey}},
+				skew: DefaultClockSkew,
+				now:  func() time.Time { return recordedAt },
+			})
 			wrappedHandler := mware(handler)
 			wrappedHandler.ServeHTTP(resp, req)
 
@@ -83,3 +93,55 @@ func TestCheckRegistration(t *testing.T) {
 		})
 	}
 }
+
+func TestCheckRegistrationReplay(t *testing.T) {
+	regKey := `f65e1a0c-46b0-424c-a6a5-c3701aef32e5`
+	body := `{"ClientStatus":[{"Checksum":"","ChecksumAlgorithm":"SHA-256"}]}`
+	now := time.Date(2020, 8, 12, 10, 0, 0, 0, time.UTC)
+
+	mware := checkRegistration(registrationConfig{
+		keys:   keyList{{ID: "test", Key: regKey}},
+		skew:   5 * time.Minute,
+		replay: newTestReplayCache(),
+		now:    func() time.Time { return now },
+	})
+	handler := mware(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	send := func(date string) int {
+		bodyHash := sha256.Sum256([]byte(body))
+		req, _ := http.NewRequest("POST", "/something", strings.NewReader(body))
+		req.Header.Set("Authorization", "Shared "+calculateDSCSignature(regKey, date, bodyHash[:]))
+		req.Header.Set("x-ms-date", date)
+
+		resp := httptest.NewRecorder()
+		handler.ServeHTTP(resp, req)
+		return resp.Code
+	}
+
+	// A fresh request is accepted once, but not replayed.
+	assert.Equal(t, http.StatusOK, send(`2020-08-12T09:59:30.1234567Z`))
+	assert.Equal(t, http.StatusUnauthorized, send(`2020-08-12T09:59:30.1234567Z`))
+
+	// Requests outside of the skew window are rejected.
+	assert.Equal(t, http.StatusUnauthorized, send(`2020-08-12T09:54:59.0000000Z`))
+	assert.Equal(t, http.StatusUnauthorized, send(`2020-08-12T10:05:01.0000000Z`))
+	assert.Equal(t, http.StatusOK, send(`2020-08-12T10:04:59.0000000Z`))
+
+	// Dates that can't be parsed are a bad request.
+	assert.Equal(t, http.StatusBadRequest, send(`Wed, 12 Aug 2020 10:00:00 GMT`))
+}
+
+// testReplayCache is a minimal unbounded ReplayCache.
+type testReplayCache map[string]bool
+
+func newTestReplayCache() testReplayCache {
+	return make(testReplayCache)
+}
+
+func (c testReplayCache) Seen(ctx context.Context, signature string, expires time.Time) (bool, error) {
+	seen := c[signature]
+	c[signature] = true
+	return seen, nil
+}
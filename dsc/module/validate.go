@@ -0,0 +1,129 @@
+// Package module validates PowerShell module zips before they're served to
+// clients.
+//
+// The LCM expects a module zip to contain the contents of the module's
+// folder at its root, including a manifest named after the module, e.g.:
+//
+//     xExample.psd1
+//     xExample.psm1
+//     DSCResources/xExampleResource/xExampleResource.psm1
+//     DSCResources/xExampleResource/xExampleResource.schema.mof
+//
+// and the manifest's ModuleVersion must match the version that the zip is
+// served as. Otherwise, the module fails to install on the node, with an
+// error that doesn't make the problem obvious.
+package module
+
+import (
+	"archive/zip"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/psd1"
+)
+
+// ValidationError is returned when a module zip is invalid.
+type ValidationError struct {
+	Name    string
+	Version string
+	Reason  string
+}
+
+func (e ValidationError) Error() string {
+	return fmt.Sprintf("module: %s (version: %s) is invalid: %s", e.Name, e.Version, e.Reason)
+}
+
+// Validate checks that the module zip in r, which is size bytes long, is a
+// valid module with the given name and version. It returns a ValidationError
+// if it isn't, or another error if the zip can't be read.
+func Validate(r io.ReaderAt, size int64, name, version string) error {
+	invalid := func(format string, args ...interface{}) error {
+		return ValidationError{
+			Name:    name,
+			Version: version,
+			Reason:  fmt.Sprintf(format, args...),
+		}
+	}
+
+	zr, err := zip.NewReader(r, size)
+	if err != nil {
+		return invalid("not a valid zip file: %s", err)
+	}
+
+	// Index files by their normalized path; zips created by some versions
+	// of Compress-Archive use backslashes as separators.
+	files := make(map[string]*zip.File)
+	for _, f := range zr.File {
+		files[normalize(f.Name)] = f
+	}
+
+	manifestName := strings.ToLower(name) + ".psd1"
+	manifest, ok := files[manifestName]
+	if !ok {
+		// Give a better error if the module was zipped along with its
+		// containing folder, e.g. "xExample/1.0/xExample.psd1".
+		for p := range files {
+			if path.Base(p) == manifestName {
+				return invalid("manifest is at %q; the contents of the module folder must be at the root of the zip", p)
+			}
+		}
+		return invalid("no manifest named %q at the root of the zip", name+".psd1")
+	}
+
+	rc, err := manifest.Open()
+	if err != nil {
+		return err
+	}
+	defer rc.Close()
+
+	ht, err := psd1.ParseReader(rc)
+	if err != nil {
+		if _, ok := err.(psd1.SyntaxError); ok {
+			return invalid("error parsing manifest: %s", err)
+		}
+		return err
+	}
+
+	actual := ht.String("ModuleVersion")
+	if actual == "" {
+		return invalid("manifest has no ModuleVersion")
+	}
+	got, err := ParseVersion(actual)
+	if err != nil {
+		return invalid("manifest has invalid ModuleVersion %q", actual)
+	}
+	want, err := ParseVersion(version)
+	if err != nil {
+		return invalid("invalid version %q", version)
+	}
+
+	// A module may be served with more or fewer trailing zeroes than its
+	// manifest has, e.g. as "1.0.0.0" for "1.0.0".
+	if got.padded().Compare(want.padded()) != 0 {
+		return invalid("manifest has ModuleVersion %q", actual)
+	}
+
+	// The root module, if any, must be in the zip; it's only optional for
+	// manifest modules that just contain DSC resources.
+	root := ht.String("RootModule")
+	if root == "" {
+		root = ht.String("ModuleToProcess")
+	}
+	if root != "" && path.Ext(root) != "" {
+		if _, ok := files[normalize(root)]; !ok {
+			return invalid("root module %q is not in the zip", root)
+		}
+	}
+
+	return nil
+}
+
+// normalize converts a path within a zip file into a canonical form for
+// comparison, since PowerShell paths are case-insensitive.
+func normalize(p string) string {
+	p = strings.Replace(p, `\`, "/", -1)
+	p = strings.TrimPrefix(p, "./")
+	return strings.ToLower(p)
+}
@@ -0,0 +1,114 @@
+package module
+
+import (
+	"archive/zip"
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func makeZip(t *testing.T, files map[string]string) *bytes.Reader {
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range files {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		_, err = w.Write([]byte(content))
+		require.NoError(t, err)
+	}
+	require.NoError(t, zw.Close())
+	return bytes.NewReader(buf.Bytes())
+}
+
+const manifest = `@{
+    RootModule = 'xExample.psm1'
+    ModuleVersion = '1.2.0.0'
+    DscResourcesToExport = 'xExampleResource'
+}`
+
+func TestValidate(t *testing.T) {
+	testCases := []struct {
+		Name    string
+		Files   map[string]string
+		Version string
+		Valid   bool
+	}{
+		{
+			"valid",
+			map[string]string{"xExample.psd1": manifest, "xExample.psm1": ""},
+			"1.2.0.0",
+			true,
+		},
+		{
+			"backslashes and case",
+			map[string]string{`XEXAMPLE.PSD1`: manifest, `xexample.psm1`: "", `DSCResources\xExampleResource\xExampleResource.psm1`: ""},
+			"1.2.0.0",
+			true,
+		},
+		{
+			"wrong version",
+			map[string]string{"xExample.psd1": manifest, "xExample.psm1": ""},
+			"1.3.0.0",
+			false,
+		},
+		{
+			"fewer parts",
+			map[string]string{"xExample.psd1": manifest, "xExample.psm1": ""},
+			"1.2",
+			true,
+		},
+		{
+			"more parts",
+			map[string]string{"xExample.psd1": strings.Replace(manifest, "'1.2.0.0'", "'1.2.0'", 1), "xExample.psm1": ""},
+			"1.2.0.0",
+			true,
+		},
+		{
+			"invalid manifest version",
+			map[string]string{"xExample.psd1": strings.Replace(manifest, "'1.2.0.0'", "'1.2-beta'", 1), "xExample.psm1": ""},
+			"1.2.0.0",
+			false,
+		},
+		{
+			"nested folder",
+			map[string]string{"xExample/1.2.0.0/xExample.psd1": manifest, "xExample/1.2.0.0/xExample.psm1": ""},
+			"1.2.0.0",
+			false,
+		},
+		{
+			"missing manifest",
+			map[string]string{"xExample.psm1": ""},
+			"1.2.0.0",
+			false,
+		},
+		{
+			"missing root module",
+			map[string]string{"xExample.psd1": manifest},
+			"1.2.0.0",
+			false,
+		},
+		{
+			"invalid manifest",
+			map[string]string{"xExample.psd1": "@{ ModuleVersion = ", "xExample.psm1": ""},
+			"1.2.0.0",
+			false,
+		},
+	}
+
+	for _, tc := range testCases {
+		r := makeZip(t, tc.Files)
+		err := Validate(r, r.Size(), "xExample", tc.Version)
+		if tc.Valid {
+			assert.NoError(t, err, "test case %q", tc.Name)
+		} else {
+			assert.IsType(t, ValidationError{}, err, "test case %q", tc.Name)
+		}
+	}
+
+	// Files that aren't zips are invalid, too.
+	r := bytes.NewReader([]byte("not a zip"))
+	assert.IsType(t, ValidationError{}, Validate(r, r.Size(), "xExample", "1.2.0.0"))
+}
@@ -0,0 +1,90 @@
+package module
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// Version is a module version, with between 2 and 4 numeric parts, like a
+// .NET System.Version; e.g. "1.2" or "1.2.3.4".
+type Version struct {
+	parts []int
+}
+
+// ParseVersion parses a module version.
+func ParseVersion(s string) (Version, error) {
+	fields := strings.Split(s, ".")
+	if len(fields) < 2 || len(fields) > 4 {
+		return Version{}, fmt.Errorf("module: invalid version %q: must have 2-4 parts", s)
+	}
+
+	parts := make([]int, len(fields))
+	for i, f := range fields {
+		n, err := strconv.Atoi(f)
+		if err != nil || n < 0 || strings.HasPrefix(f, "+") {
+			return Version{}, fmt.Errorf("module: invalid version %q", s)
+		}
+		parts[i] = n
+	}
+	return Version{parts}, nil
+}
+
+// Compare returns -1, 0 or 1 if v is less than, equal to or greater than
+// other. As with System.Version, a missing part is less than any value for
+// it, so "1.0" < "1.0.0".
+func (v Version) Compare(other Version) int {
+	for i := 0; i < 4; i++ {
+		a, b := v.part(i), other.part(i)
+		switch {
+		case a < b:
+			return -1
+		case a > b:
+			return 1
+		}
+	}
+	return 0
+}
+
+// padded returns v with any missing parts set to zero, so that e.g. "1.0"
+// and "1.0.0.0" compare as equal.
+func (v Version) padded() Version {
+	parts := make([]int, 4)
+	copy(parts, v.parts)
+	return Version{parts}
+}
+
+func (v Version) part(i int) int {
+	if i < len(v.parts) {
+		return v.parts[i]
+	}
+	return -1
+}
+
+func (v Version) String() string {
+	strs := make([]string, len(v.parts))
+	for i, p := range v.parts {
+		strs[i] = strconv.Itoa(p)
+	}
+	return strings.Join(strs, ".")
+}
+
+// Latest returns the highest of the given versions, ignoring any that can't
+// be parsed, and whether there was one.
+func Latest(versions []string) (string, bool) {
+	var (
+		latest  string
+		highest Version
+		found   bool
+	)
+	for _, s := range versions {
+		v, err := ParseVersion(s)
+		if err != nil {
+			continue
+		}
+		if !found || v.Compare(highest) > 0 {
+			latest, highest, found = s, v, true
+		}
+	}
+	return latest, found
+}
@@ -0,0 +1,27 @@
+package module
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLatest(t *testing.T) {
+	testCases := []struct {
+		Versions []string
+		Expected string
+	}{
+		{[]string{"1.0", "1.2", "1.10"}, "1.10"},
+		{[]string{"2.0.0.0", "10.0", "9.9.9.9"}, "10.0"},
+		{[]string{"1.0", "1.0.0", "1.0.0.0"}, "1.0.0.0"},
+		{[]string{"1.0", "latest", "3", "1.2.3.4.5"}, "1.0"},
+		{[]string{"junk"}, ""},
+		{nil, ""},
+	}
+
+	for _, tc := range testCases {
+		latest, found := Latest(tc.Versions)
+		assert.Equal(t, tc.Expected, latest, "versions %v", tc.Versions)
+		assert.Equal(t, tc.Expected != "", found, "versions %v", tc.Versions)
+	}
+}
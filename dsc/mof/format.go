@@ -0,0 +1,176 @@
+package mof
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// Marshal serializes a document in the same style as the MOFs that
+// PowerShell compiles, encoded as UTF-8. Parsing the result yields an
+// equivalent document, but comments and formatting from a parsed document
+// aren't preserved.
+func Marshal(doc *Document) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := Write(&buf, doc); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Write is like Marshal, but writes the document to w.
+func Write(w io.Writer, doc *Document) error {
+	f := &formatter{}
+	for _, pragma := range doc.Pragmas {
+		f.printf("#pragma %s(%s)\n", pragma.Name, quote(pragma.Value))
+	}
+	if len(doc.Pragmas) > 0 {
+		f.printf("\n")
+	}
+
+	for i, inst := range doc.Instances {
+		if i > 0 {
+			f.printf("\n")
+		}
+		f.instance(inst, 0)
+		f.printf(";\n")
+	}
+
+	if f.err != nil {
+		return f.err
+	}
+	_, err := w.Write(f.buf.Bytes())
+	return err
+}
+
+type formatter struct {
+	buf bytes.Buffer
+	err error
+}
+
+func (f *formatter) printf(format string, args ...interface{}) {
+	fmt.Fprintf(&f.buf, format, args...)
+}
+
+func (f *formatter) indent(depth int) {
+	f.buf.WriteString(strings.Repeat("    ", depth))
+}
+
+// instance writes an instance declaration, without the trailing semicolon.
+func (f *formatter) instance(inst *Instance, depth int) {
+	f.printf("instance of %s", inst.Class)
+	if inst.Alias != "" {
+		f.printf(" as $%s", inst.Alias)
+	}
+	f.printf("\n")
+	f.indent(depth)
+	f.printf("{\n")
+	for _, p := range inst.Properties {
+		f.indent(depth + 1)
+		f.printf("%s = ", p.Name)
+		f.value(p.Value, depth+1)
+		f.printf(";\n")
+	}
+	f.indent(depth)
+	f.printf("}")
+}
+
+func (f *formatter) value(v interface{}, depth int) {
+	switch v := v.(type) {
+	case nil:
+		f.printf("NULL")
+	case string:
+		f.printf("%s", quote(v))
+	case rune:
+		f.printf("'%s'", escape(string(v), '\''))
+	case bool:
+		if v {
+			f.printf("True")
+		} else {
+			f.printf("False")
+		}
+	case int64:
+		f.printf("%d", v)
+	case float64:
+		if math.IsInf(v, 0) || math.IsNaN(v) {
+			f.fail("mof: can't serialize %v", v)
+			return
+		}
+		s := strconv.FormatFloat(v, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eE") {
+			// Keep it a real number when it's parsed again.
+			s += ".0"
+		}
+		f.printf("%s", s)
+	case Reference:
+		f.printf("$%s", string(v))
+	case *Instance:
+		f.instance(v, depth)
+	case []interface{}:
+		if len(v) == 0 {
+			f.printf("{}")
+			return
+		}
+		f.printf("{\n")
+		for i, elem := range v {
+			if _, ok := elem.([]interface{}); ok {
+				f.fail("mof: arrays can't be nested")
+				return
+			}
+			f.indent(depth + 1)
+			f.value(elem, depth+1)
+			if i < len(v)-1 {
+				f.printf(",")
+			}
+			f.printf("\n")
+		}
+		f.indent(depth)
+		f.printf("}")
+	default:
+		f.fail("mof: can't serialize value of type %T", v)
+	}
+}
+
+func (f *formatter) fail(format string, args ...interface{}) {
+	if f.err == nil {
+		f.err = fmt.Errorf(format, args...)
+	}
+}
+
+// quote returns s as a double-quoted string literal.
+func quote(s string) string {
+	return `"` + escape(s, '"') + `"`
+}
+
+func escape(s string, quote rune) string {
+	var sb strings.Builder
+	for _, r := range s {
+		switch r {
+		case '\\':
+			sb.WriteString(`\\`)
+		case quote:
+			sb.WriteRune('\\')
+			sb.WriteRune(r)
+		case '\b':
+			sb.WriteString(`\b`)
+		case '\t':
+			sb.WriteString(`\t`)
+		case '\n':
+			sb.WriteString(`\n`)
+		case '\f':
+			sb.WriteString(`\f`)
+		case '\r':
+			sb.WriteString(`\r`)
+		default:
+			if r < 0x20 {
+				fmt.Fprintf(&sb, `\x%04X`, r)
+			} else {
+				sb.WriteRune(r)
+			}
+		}
+	}
+	return sb.String()
+}
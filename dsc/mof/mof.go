@@ -0,0 +1,206 @@
+// Package mof parses and serializes the MOF documents that DSC configurations
+// are compiled to. A document is a list of instance declarations, e.g.:
+//
+//     instance of MSFT_RoleResource as $MSFT_RoleResource1ref
+//     {
+//         ResourceID = "[WindowsFeature]IIS";
+//         Ensure = "Present";
+//         Name = "Web-Server";
+//         DependsOn = {
+//             "[File]Content"
+//         };
+//         ModuleName = "PSDesiredStateConfiguration";
+//         ModuleVersion = "1.0";
+//     };
+//
+// Only the subset of MOF that is used by configuration documents is
+// supported: compiler pragmas and instance declarations, whose properties may
+// be literals, arrays, references to other instances by alias, or embedded
+// instances. Class declarations and qualifiers aren't supported.
+package mof
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/textenc"
+)
+
+// The class of the instance that describes the document itself.
+const ConfigurationDocumentClass = "OMI_ConfigurationDocument"
+
+// Document is a parsed MOF document.
+type Document struct {
+	// Compiler pragmas, such as "#pragma namespace(...)", in the order
+	// they appear in the document.
+	Pragmas []Pragma
+
+	// The top-level instances in the document, in the order they were
+	// declared.
+	Instances []*Instance
+}
+
+// Pragma is a compiler pragma, e.g. '#pragma namespace("root/cimv2")'.
+type Pragma struct {
+	Name  string
+	Value string
+}
+
+// Instance is an instance declaration, either at the top level of a document
+// or embedded in a property value.
+type Instance struct {
+	// The name of the instance's class.
+	Class string
+
+	// The alias of the instance, without the leading "$", or the empty
+	// string if it has none. Other instances refer to it with a Reference.
+	Alias string
+
+	// The properties of the instance, in the order they were declared.
+	Properties []Property
+}
+
+// Property is a property of an instance.
+//
+// Values are one of: string, int64, float64, bool, rune (for character
+// literals), nil, Reference, *Instance or []interface{} (whose elements are
+// any of the other types).
+type Property struct {
+	Name  string
+	Value interface{}
+}
+
+// Reference is a reference to another instance in the same document, by its
+// alias (without the leading "$").
+type Reference string
+
+// Get returns the value of a property, ignoring case, and whether the
+// instance has it.
+func (i *Instance) Get(name string) (interface{}, bool) {
+	for _, p := range i.Properties {
+		if strings.EqualFold(p.Name, name) {
+			return p.Value, true
+		}
+	}
+	return nil, false
+}
+
+// String returns the value of a property if it is a string, and the empty
+// string otherwise.
+func (i *Instance) String(name string) string {
+	v, _ := i.Get(name)
+	s, _ := v.(string)
+	return s
+}
+
+// Strings returns the value of a property as a list of strings; a single
+// string is treated as a list with one element, and other values are ignored.
+func (i *Instance) Strings(name string) []string {
+	v, _ := i.Get(name)
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var ret []string
+		for _, elem := range v {
+			if s, ok := elem.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+// Set sets the value of a property, replacing any existing value, or adding
+// the property if the instance doesn't have it.
+func (i *Instance) Set(name string, value interface{}) {
+	for idx, p := range i.Properties {
+		if strings.EqualFold(p.Name, name) {
+			i.Properties[idx].Value = value
+			return
+		}
+	}
+	i.Properties = append(i.Properties, Property{Name: name, Value: value})
+}
+
+// ResourceID returns the ResourceID of a resource instance, e.g.
+// "[File]Content", or the empty string if the instance isn't a resource.
+func (i *Instance) ResourceID() string {
+	return i.String("ResourceID")
+}
+
+// Instance returns the top-level instance with the given alias, or nil if
+// there is none.
+func (d *Document) Instance(alias string) *Instance {
+	for _, inst := range d.Instances {
+		if inst.Alias != "" && strings.EqualFold(inst.Alias, alias) {
+			return inst
+		}
+	}
+	return nil
+}
+
+// Resolve returns the instance that a property value refers to: the value
+// itself if it's an embedded instance, or the instance with the given alias
+// if it's a reference. It returns nil for any other value.
+func (d *Document) Resolve(value interface{}) *Instance {
+	switch v := value.(type) {
+	case *Instance:
+		return v
+	case Reference:
+		return d.Instance(string(v))
+	}
+	return nil
+}
+
+// Resources returns the top-level instances that are DSC resources, i.e.
+// those that have a ResourceID, in the order they were declared.
+func (d *Document) Resources() []*Instance {
+	var ret []*Instance
+	for _, inst := range d.Instances {
+		if inst.ResourceID() != "" {
+			ret = append(ret, inst)
+		}
+	}
+	return ret
+}
+
+// ConfigurationDocument returns the OMI_ConfigurationDocument instance that
+// describes the document, or nil if there is none.
+func (d *Document) ConfigurationDocument() *Instance {
+	for _, inst := range d.Instances {
+		if strings.EqualFold(inst.Class, ConfigurationDocumentClass) {
+			return inst
+		}
+	}
+	return nil
+}
+
+// SyntaxError is returned when a document can't be parsed.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("mof: line %d: %s", e.Line, e.Msg)
+}
+
+// Parse parses a MOF document, which may be encoded as UTF-8 or, with a byte
+// order mark, UTF-16.
+func Parse(data []byte) (*Document, error) {
+	p := &parser{s: textenc.Decode(data), line: 1}
+	return p.document()
+}
+
+// ParseReader is like Parse, but reads the document from r.
+func ParseReader(r io.Reader) (*Document, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
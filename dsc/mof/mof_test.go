@@ -0,0 +1,184 @@
+package mof
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func parseFile(t *testing.T, name string) *Document {
+	data, err := ioutil.ReadFile(filepath.Join("testdata", name))
+	require.NoError(t, err)
+
+	doc, err := Parse(data)
+	require.NoError(t, err, "parsing %s", name)
+	return doc
+}
+
+func TestParseCorpus(t *testing.T) {
+	testCases := []struct {
+		File      string
+		Name      string
+		Resources []string
+	}{
+		{"HelloWorld.mof", "HelloWorld", []string{"[File]HelloWorld"}},
+		{"HelloWorld.utf16.mof", "HelloWorld", []string{"[File]HelloWorld"}},
+		{"WebServer.mof", "WebServer", []string{
+			"[WindowsFeature]IIS",
+			"[WindowsFeature]AspNet45",
+			"[xWebsite]DefaultSite",
+			"[Service]W3SVC",
+		}},
+		{"Credentials.mof", "Database", []string{
+			"[User]ServiceAccount",
+			"[Registry]MaxMemory",
+			"[Script]Marker",
+		}},
+		{"Embedded.mof", "App", []string{
+			"[xScheduledTask]Cleanup",
+			"[xEnvironment]AppSettings",
+			"[AppConfig]Settings",
+		}},
+		{"MetaConfig.meta.mof", "DscPull", []string{
+			"[ConfigurationRepositoryWeb]PullServer",
+			"[ReportServerWeb]PullServer",
+		}},
+	}
+
+	for _, tc := range testCases {
+		doc := parseFile(t, tc.File)
+
+		var ids []string
+		for _, r := range doc.Resources() {
+			ids = append(ids, r.ResourceID())
+		}
+		assert.Equal(t, tc.Resources, ids, "file %q", tc.File)
+
+		cd := doc.ConfigurationDocument()
+		require.NotNil(t, cd, "file %q", tc.File)
+		assert.Equal(t, tc.Name, cd.String("name"), "file %q", tc.File)
+		assert.Equal(t, "2.0.0", cd.String("Version"), "file %q", tc.File)
+	}
+}
+
+func TestParseValues(t *testing.T) {
+	doc := parseFile(t, "Embedded.mof")
+	assert.Equal(t, []Pragma{{"namespace", "root/Microsoft/Windows/DesiredStateConfiguration"}}, doc.Pragmas)
+
+	app := doc.Instance("msft_appconfig1ref")
+	require.NotNil(t, app)
+	assert.Equal(t, "MSFT_AppConfig", app.Class)
+	assert.Equal(t, `C:\app\app.config`, app.String("Path"))
+	assert.Equal(t, "Line one\r\nLine two\ttabbed and continued", app.String("Comment"))
+	assert.Equal(t, []string{"[xEnvironment]AppSettings"}, app.Strings("DependsOn"))
+
+	values := map[string]interface{}{
+		"Weight":    1.5,
+		"Threshold": int64(-3),
+		"Mask":      int64(0x1F),
+		"Separator": ';',
+		"Optional":  nil,
+		"EmptyList": []interface{}{},
+	}
+	for name, want := range values {
+		v, ok := app.Get(name)
+		assert.True(t, ok, "property %q", name)
+		assert.Equal(t, want, v, "property %q", name)
+	}
+
+	settings, _ := app.Get("Settings")
+	require.Len(t, settings, 2)
+	first := doc.Resolve(settings.([]interface{})[0])
+	require.NotNil(t, first)
+	assert.Equal(t, "MSFT_KeyValuePair", first.Class)
+	assert.Equal(t, "ConnectionTimeout", first.String("Key"))
+
+	owner, _ := app.Get("Owner")
+	assert.Equal(t, "Platform", doc.Resolve(owner).String("Value"))
+
+	task := doc.Resources()[0]
+	enable, _ := task.Get("Enable")
+	assert.Equal(t, true, enable)
+
+	// References resolve to other top-level instances.
+	doc = parseFile(t, "Credentials.mof")
+	user := doc.Resources()[0]
+	password, _ := user.Get("Password")
+	assert.Equal(t, Reference("MSFT_Credential1ref"), password)
+	assert.Equal(t, `CORP\svc-sql`, doc.Resolve(password).String("UserName"))
+	assert.Equal(t, `P@ss"word\1`, doc.Resolve(password).String("Password"))
+}
+
+func TestRoundTrip(t *testing.T) {
+	files, err := filepath.Glob("testdata/*.mof")
+	require.NoError(t, err)
+	require.NotEmpty(t, files)
+
+	for _, file := range files {
+		doc := parseFile(t, filepath.Base(file))
+
+		data, err := Marshal(doc)
+		require.NoError(t, err, "file %q", file)
+		again, err := Parse(data)
+		require.NoError(t, err, "file %q:\n%s", file, data)
+		assert.Equal(t, doc, again, "file %q", file)
+
+		// Serializing is deterministic.
+		data2, err := Marshal(again)
+		require.NoError(t, err)
+		assert.Equal(t, string(data), string(data2), "file %q", file)
+	}
+}
+
+// TestCompiledCorpus parses the output of the PowerShell compiler in
+// testdata/compiled; see the README there.
+func TestCompiledCorpus(t *testing.T) {
+	files, err := filepath.Glob("testdata/compiled/*.mof")
+	require.NoError(t, err)
+
+	for _, file := range files {
+		doc := parseFile(t, filepath.Join("compiled", filepath.Base(file)))
+		require.NotNil(t, doc.ConfigurationDocument(), "file %q", file)
+
+		// Credentials compiled in plain text are referenced by the
+		// resources that use them.
+		if filepath.Base(file) == "Credentials.mof" {
+			password, _ := doc.Resources()[0].Get("Password")
+			assert.Equal(t, "not-a-real-password", doc.Resolve(password).String("Password"))
+		}
+
+		data, err := Marshal(doc)
+		require.NoError(t, err, "file %q", file)
+		again, err := Parse(data)
+		require.NoError(t, err, "file %q:\n%s", file, data)
+		assert.Equal(t, doc, again, "file %q", file)
+	}
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Input string
+	}{
+		{"missing semicolon", `instance of Foo { Name = "a"; }`},
+		{"missing property semicolon", `instance of Foo { Name = "a" };`},
+		{"unterminated instance", `instance of Foo { Name = "a";`},
+		{"unterminated string", "instance of Foo { Name = \"a;\n};"},
+		{"unterminated array", `instance of Foo { Names = {"a", "b"; };`},
+		{"nested array", `instance of Foo { Names = {{"a"}}; };`},
+		{"duplicate property", `instance of Foo { Name = "a"; name = "b"; };`},
+		{"duplicate alias", `instance of Foo as $a { }; instance of Bar as $A { };`},
+		{"invalid escape", `instance of Foo { Name = "\q"; };`},
+		{"invalid value", `instance of Foo { Name = bogus; };`},
+		{"class declaration", `class Foo { string Name; };`},
+		{"garbage", `@{ Foo = 1 }`},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse([]byte(tc.Input))
+		assert.IsType(t, SyntaxError{}, err, "test case %q", tc.Name)
+	}
+}
@@ -0,0 +1,433 @@
+package mof
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type parser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return SyntaxError{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) peekN(n int) string {
+	end := p.pos + n
+	if end > len(p.s) {
+		end = len(p.s)
+	}
+	return p.s[p.pos:end]
+}
+
+func (p *parser) advance(n int) {
+	for i := 0; i < n && !p.eof(); i++ {
+		if p.s[p.pos] == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+}
+
+// consume advances past the given token if it's next, ignoring case.
+func (p *parser) consume(tok string) bool {
+	if strings.EqualFold(p.peekN(len(tok)), tok) {
+		p.advance(len(tok))
+		return true
+	}
+	return false
+}
+
+// consumeKeyword is like consume, but only matches whole words, so that e.g.
+// "TRUE" doesn't match the start of "TRUEISH".
+func (p *parser) consumeKeyword(kw string) bool {
+	if !strings.EqualFold(p.peekN(len(kw)), kw) {
+		return false
+	}
+	if end := p.pos + len(kw); end < len(p.s) && isIdent(p.s[end]) {
+		return false
+	}
+	p.advance(len(kw))
+	return true
+}
+
+// skipSpace skips whitespace and comments.
+func (p *parser) skipSpace() {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.advance(1)
+		case strings.HasPrefix(p.s[p.pos:], "/*"):
+			end := strings.Index(p.s[p.pos+2:], "*/")
+			if end < 0 {
+				p.advance(len(p.s))
+				return
+			}
+			p.advance(end + 4)
+		case strings.HasPrefix(p.s[p.pos:], "//"):
+			end := strings.IndexByte(p.s[p.pos:], '\n')
+			if end < 0 {
+				p.advance(len(p.s))
+				return
+			}
+			p.advance(end)
+		default:
+			return
+		}
+	}
+}
+
+func (p *parser) expect(tok string) error {
+	p.skipSpace()
+	if !p.consume(tok) {
+		return p.errorf("expected %q, found %q", tok, p.peekN(10))
+	}
+	return nil
+}
+
+func (p *parser) document() (*Document, error) {
+	doc := &Document{}
+	aliases := make(map[string]bool)
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return doc, nil
+		}
+
+		switch {
+		case p.peek() == '#':
+			pragma, err := p.pragma()
+			if err != nil {
+				return nil, err
+			}
+			doc.Pragmas = append(doc.Pragmas, pragma)
+
+		case p.consumeKeyword("instance"):
+			inst, err := p.instance()
+			if err != nil {
+				return nil, err
+			}
+			if inst.Alias != "" {
+				lower := strings.ToLower(inst.Alias)
+				if aliases[lower] {
+					return nil, p.errorf("duplicate alias $%s", inst.Alias)
+				}
+				aliases[lower] = true
+			}
+			if err := p.expect(";"); err != nil {
+				return nil, err
+			}
+			doc.Instances = append(doc.Instances, inst)
+
+		case p.consumeKeyword("class"):
+			return nil, p.errorf("class declarations are not supported")
+
+		default:
+			return nil, p.errorf("expected instance declaration, found %q", p.peekN(10))
+		}
+	}
+}
+
+// pragma parses a compiler pragma, e.g. '#pragma namespace("root/cimv2")'.
+func (p *parser) pragma() (Pragma, error) {
+	if !p.consume("#pragma") {
+		return Pragma{}, p.errorf("expected '#pragma', found %q", p.peekN(10))
+	}
+	p.skipSpace()
+
+	name, err := p.identifier()
+	if err != nil {
+		return Pragma{}, err
+	}
+	if err := p.expect("("); err != nil {
+		return Pragma{}, err
+	}
+	p.skipSpace()
+	value, err := p.str()
+	if err != nil {
+		return Pragma{}, err
+	}
+	if err := p.expect(")"); err != nil {
+		return Pragma{}, err
+	}
+	return Pragma{Name: name, Value: value}, nil
+}
+
+// instance parses an instance declaration, after the "instance" keyword.
+func (p *parser) instance() (*Instance, error) {
+	p.skipSpace()
+	if !p.consumeKeyword("of") {
+		return nil, p.errorf("expected 'of' after 'instance'")
+	}
+	p.skipSpace()
+
+	class, err := p.identifier()
+	if err != nil {
+		return nil, err
+	}
+	inst := &Instance{Class: class}
+
+	p.skipSpace()
+	if p.consumeKeyword("as") {
+		p.skipSpace()
+		if !p.consume("$") {
+			return nil, p.errorf("expected alias after 'as'")
+		}
+		if inst.Alias, err = p.identifier(); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	seen := make(map[string]bool)
+	for {
+		p.skipSpace()
+		if p.eof() {
+			return nil, p.errorf("unterminated instance of %s", class)
+		}
+		if p.consume("}") {
+			return inst, nil
+		}
+
+		name, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect("="); err != nil {
+			return nil, err
+		}
+		p.skipSpace()
+		value, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if err := p.expect(";"); err != nil {
+			return nil, err
+		}
+
+		lower := strings.ToLower(name)
+		if seen[lower] {
+			return nil, p.errorf("duplicate property %q in instance of %s", name, class)
+		}
+		seen[lower] = true
+		inst.Properties = append(inst.Properties, Property{Name: name, Value: value})
+	}
+}
+
+func (p *parser) identifier() (string, error) {
+	start := p.pos
+	for !p.eof() && isIdent(p.peek()) {
+		p.advance(1)
+	}
+	if p.pos == start {
+		return "", p.errorf("expected identifier, found %q", p.peekN(10))
+	}
+	return p.s[start:p.pos], nil
+}
+
+// isIdent returns whether c can be part of an identifier. Any non-ASCII
+// byte is allowed, since MOF identifiers may contain Unicode letters.
+func isIdent(c byte) bool {
+	return c == '_' || c >= 0x80 ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+func (p *parser) value() (interface{}, error) {
+	switch c := p.peek(); {
+	case c == '"':
+		// Adjacent string literals are concatenated.
+		var sb strings.Builder
+		for p.peek() == '"' {
+			s, err := p.str()
+			if err != nil {
+				return nil, err
+			}
+			sb.WriteString(s)
+			p.skipSpace()
+		}
+		return sb.String(), nil
+	case c == '\'':
+		return p.char()
+	case c == '{':
+		return p.array()
+	case c == '$':
+		p.advance(1)
+		alias, err := p.identifier()
+		if err != nil {
+			return nil, err
+		}
+		return Reference(alias), nil
+	case p.consumeKeyword("instance"):
+		return p.instance()
+	case p.consumeKeyword("true"):
+		return true, nil
+	case p.consumeKeyword("false"):
+		return false, nil
+	case p.consumeKeyword("null"):
+		return nil, nil
+	}
+	return p.number()
+}
+
+// array parses an array value, e.g. '{"a", "b"}'.
+func (p *parser) array() ([]interface{}, error) {
+	p.advance(1)
+	list := []interface{}{}
+
+	p.skipSpace()
+	if p.consume("}") {
+		return list, nil
+	}
+	for {
+		p.skipSpace()
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := v.([]interface{}); ok {
+			return nil, p.errorf("arrays can't be nested")
+		}
+		list = append(list, v)
+
+		p.skipSpace()
+		switch {
+		case p.consume(","):
+		case p.consume("}"):
+			return list, nil
+		case p.eof():
+			return nil, p.errorf("unterminated array")
+		default:
+			return nil, p.errorf("expected ',' or '}' in array, found %q", p.peekN(10))
+		}
+	}
+}
+
+func (p *parser) number() (interface{}, error) {
+	start := p.pos
+	if c := p.peek(); c == '-' || c == '+' {
+		p.advance(1)
+	}
+	for !p.eof() {
+		c := p.peek()
+		isExp := (c == '-' || c == '+') && p.pos > start && (p.s[p.pos-1] == 'e' || p.s[p.pos-1] == 'E')
+		if !isIdent(c) && c != '.' && !isExp {
+			break
+		}
+		p.advance(1)
+	}
+	tok := p.s[start:p.pos]
+	if tok == "" {
+		return nil, p.errorf("expected value, found %q", p.peekN(10))
+	}
+
+	if i, err := strconv.ParseInt(tok, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q", tok)
+}
+
+// str parses a double-quoted string literal.
+func (p *parser) str() (string, error) {
+	if !p.consume(`"`) {
+		return "", p.errorf("expected string, found %q", p.peekN(10))
+	}
+
+	var sb strings.Builder
+	for {
+		if p.eof() || p.peek() == '\n' {
+			return "", p.errorf("unterminated string")
+		}
+		switch c := p.peek(); c {
+		case '"':
+			p.advance(1)
+			return sb.String(), nil
+		case '\\':
+			r, err := p.escape()
+			if err != nil {
+				return "", err
+			}
+			sb.WriteRune(r)
+		default:
+			sb.WriteByte(c)
+			p.advance(1)
+		}
+	}
+}
+
+// char parses a single-quoted character literal.
+func (p *parser) char() (rune, error) {
+	p.advance(1)
+
+	var r rune
+	switch c := p.peek(); {
+	case p.eof() || c == '\'' || c == '\n':
+		return 0, p.errorf("invalid character literal")
+	case c == '\\':
+		var err error
+		if r, err = p.escape(); err != nil {
+			return 0, err
+		}
+	default:
+		var size int
+		r, size = utf8.DecodeRuneInString(p.s[p.pos:])
+		p.advance(size)
+	}
+
+	if !p.consume("'") {
+		return 0, p.errorf("unterminated character literal")
+	}
+	return r, nil
+}
+
+// escape parses an escape sequence in a string or character literal.
+func (p *parser) escape() (rune, error) {
+	p.advance(1)
+	c := p.peek()
+	p.advance(1)
+	switch c {
+	case 'b':
+		return '\b', nil
+	case 't':
+		return '\t', nil
+	case 'n':
+		return '\n', nil
+	case 'f':
+		return '\f', nil
+	case 'r':
+		return '\r', nil
+	case '"', '\'', '\\':
+		return rune(c), nil
+	case 'x', 'X':
+		start := p.pos
+		for p.pos-start < 4 && !p.eof() && strings.IndexByte("0123456789abcdefABCDEF", p.peek()) >= 0 {
+			p.advance(1)
+		}
+		n, err := strconv.ParseUint(p.s[start:p.pos], 16, 16)
+		if err != nil {
+			return 0, p.errorf("invalid escape sequence \\%c%s", c, p.s[start:p.pos])
+		}
+		return rune(n), nil
+	}
+	return 0, p.errorf("invalid escape sequence \\%c", c)
+}
@@ -0,0 +1,107 @@
+# Compiles the configurations whose MOFs make up this corpus. Run it on
+# Windows PowerShell 5.1 (WMF 5.1), in this directory:
+#
+#     powershell.exe -NoProfile -ExecutionPolicy Bypass -File .\Build-Corpus.ps1
+#
+# and commit the .mof files it writes, along with the output of
+# $PSVersionTable.BuildVersion in README.md. Don't edit the output by hand.
+
+$ErrorActionPreference = 'Stop'
+$out = Join-Path $PSScriptRoot 'build'
+
+Configuration HelloWorld {
+    Import-DscResource -ModuleName PSDesiredStateConfiguration
+
+    Node 'localhost' {
+        File HelloWorld {
+            DestinationPath = 'C:\Temp\HelloWorld.txt'
+            Contents        = 'Hello, World!'
+        }
+    }
+}
+
+Configuration WebServer {
+    Import-DscResource -ModuleName PSDesiredStateConfiguration
+
+    Node 'localhost' {
+        WindowsFeature IIS {
+            Name   = 'Web-Server'
+            Ensure = 'Present'
+        }
+
+        File Index {
+            DestinationPath = 'C:\inetpub\wwwroot\index.html'
+            Contents        = '<h1>It works</h1>'
+            DependsOn       = '[WindowsFeature]IIS'
+        }
+
+        Registry DisableServerHeader {
+            Key       = 'HKLM:\SYSTEM\CurrentControlSet\Services\HTTP\Parameters'
+            ValueName = 'DisableServerHeader'
+            ValueData = '1'
+            ValueType = 'Dword'
+        }
+    }
+}
+
+Configuration Credentials {
+    param([Parameter(Mandatory)] [PSCredential] $Credential)
+
+    Import-DscResource -ModuleName PSDesiredStateConfiguration
+
+    Node $AllNodes.NodeName {
+        User ServiceAccount {
+            UserName = 'svc-sql'
+            Password = $Credential
+            Ensure   = 'Present'
+        }
+    }
+}
+
+[DscLocalConfigurationManager()]
+Configuration MetaConfig {
+    Node 'localhost' {
+        Settings {
+            RefreshMode          = 'Pull'
+            ConfigurationMode    = 'ApplyAndAutoCorrect'
+            RefreshFrequencyMins = 30
+        }
+
+        ConfigurationRepositoryWeb PullServer {
+            ServerURL          = 'https://dsc.example.com:8080/PSDSCPullServer.svc'
+            RegistrationKey    = '00000000-0000-0000-0000-000000000000'
+            ConfigurationNames = @('HelloWorld')
+        }
+    }
+}
+
+if (Test-Path $out) { Remove-Item -Recurse $out }
+
+HelloWorld -OutputPath "$out\HelloWorld" | Out-Null
+WebServer -OutputPath "$out\WebServer" | Out-Null
+MetaConfig -OutputPath "$out\MetaConfig" | Out-Null
+
+# The credential is only compiled in plain text because the configuration
+# data allows it; the password is a placeholder.
+$configData = @{
+    AllNodes = @(@{
+        NodeName                    = 'localhost'
+        PSDscAllowPlainTextPassword = $true
+        PSDscAllowDomainUser        = $true
+    })
+}
+$password = ConvertTo-SecureString 'not-a-real-password' -AsPlainText -Force
+$credential = New-Object PSCredential ('CORP\svc-sql', $password)
+Credentials -ConfigurationData $configData -Credential $credential -OutputPath "$out\Credentials" | Out-Null
+
+# The compiler writes UTF-8 (WMF 5.1 adds no byte order mark); copy the
+# output under each configuration's name, and also write HelloWorld the way
+# Out-File does by default, as UTF-16LE with a byte order mark.
+Copy-Item "$out\HelloWorld\localhost.mof" "$PSScriptRoot\HelloWorld.mof"
+Copy-Item "$out\WebServer\localhost.mof" "$PSScriptRoot\WebServer.mof"
+Copy-Item "$out\Credentials\localhost.mof" "$PSScriptRoot\Credentials.mof"
+Copy-Item "$out\MetaConfig\localhost.meta.mof" "$PSScriptRoot\MetaConfig.meta.mof"
+Get-Content -Raw "$out\HelloWorld\localhost.mof" |
+    Out-File -NoNewline "$PSScriptRoot\HelloWorld.utf16.mof"
+
+Remove-Item -Recurse $out
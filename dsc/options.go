@@ -1,7 +1,13 @@
 package dsc
 
 import (
+	"context"
+	"fmt"
+	"time"
+
 	"github.com/sirupsen/logrus"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
 )
 
 // Option is the type of functional options that can be passed to the
@@ -16,11 +22,140 @@ func WithLogger(l logrus.FieldLogger) Option {
 }
 
 // WithKeys sets the authentication keys to use to validate incoming requests;
-// if none are provided, then no authentication is performed.
+// if none are provided, then no authentication is performed. Each key is
+// given an ID derived from its position in the list; use WithKeyStore for
+// keys with IDs and scoping.
 //
 // TODO(andrew): switch default to "fail auth" if none provided/not insecure
 func WithKeys(keys []string) Option {
 	return func(m *Manager) {
-		m.keys = keys
+		if len(keys) == 0 {
+			m.keys = nil
+			return
+		}
+
+		var store keyList
+		for i, key := range keys {
+			store = append(store, types.RegistrationKey{
+				ID:  fmt.Sprintf("key-%d", i),
+				Key: key,
+			})
+		}
+		m.keys = store
+	}
+}
+
+// WithKeyStore sets the store of registration keys to use to validate
+// incoming requests. The key that a request was signed with is available to
+// handlers and backends through RegistrationKeyFromContext.
+func WithKeyStore(store KeyStore) Option {
+	return func(m *Manager) {
+		m.keys = store
+	}
+}
+
+// DefaultClockSkew is the default maximum difference between the time that a
+// client signed a request and the time that it is received.
+const DefaultClockSkew = 15 * time.Minute
+
+// WithClockSkew sets the maximum difference between the time that a client
+// signed a request, as given by its x-ms-date header, and the time that it is
+// received. A skew of zero disables the check, and can't be combined with
+// WithReplayCache. This has no effect unless keys are provided.
+func WithClockSkew(skew time.Duration) Option {
+	return func(m *Manager) {
+		m.skew = skew
+	}
+}
+
+// WithReplayCache sets the cache used to reject signed requests that have
+// already been seen within the allowed clock skew. This has no effect unless
+// keys are provided.
+//
+// The clock skew bounds how long signatures need to be remembered, so
+// NewManager panics if this is combined with a clock skew of zero, which
+// would otherwise disable replay protection.
+func WithReplayCache(cache ReplayCache) Option {
+	return func(m *Manager) {
+		m.replay = cache
+	}
+}
+
+// keyList is a KeyStore for a fixed list of keys.
+type keyList []types.RegistrationKey
+
+func (k keyList) GetKeys(ctx context.Context) ([]types.RegistrationKey, error) {
+	return k, nil
+}
+
+// WithProtocolV1 enables the ConfigurationId-based endpoints used by protocol
+// version 1.0 and 1.1 clients (e.g. WMF 4.0). The resolver maps each
+// ConfigurationId to a configuration name; if it is nil, the ConfigurationId
+// is used as the configuration name. Requests with a ConfigurationId that the
+// resolver doesn't know, including those for modules and reports, are
+// rejected.
+//
+// Version 1.0 and 1.1 clients don't sign their requests, so these endpoints
+// are not protected by the keys passed to WithKeys: anyone who knows a
+// ConfigurationId can fetch its configuration, and read and write its
+// reports. With a nil resolver, that includes any configuration whose name is
+// a GUID.
+func WithProtocolV1(resolver ConfigurationIDResolver) Option {
+	return func(m *Manager) {
+		m.v1 = true
+		m.configIDs = resolver
+	}
+}
+
+// WithAutoRegistration enables automatic registration of unknown agents. If
+// an agent that isn't registered calls GetDscAction with a request signed by
+// a valid registration key, it is registered using the information in its
+// ClientStatus instead of being told to re-register.
+//
+// The registration records the agent's TLS client certificate and IP
+// address, but not its NodeName, which the request doesn't contain; until
+// the agent registers itself, assignments by node name don't apply to it,
+// and templates can't use its NodeName. With WithClientCertificateBinding,
+// agents that don't present a certificate aren't registered automatically.
+//
+// This has no effect unless keys are provided with WithKeys.
+func WithAutoRegistration() Option {
+	return func(m *Manager) {
+		m.autoRegistration = true
+	}
+}
+
+// WithMetaConfiguration sets the repository of meta-configurations to push to
+// agents. Agents that haven't applied the current meta-configuration are told
+// to UpdateMetaConfiguration, and fetch it as a configuration named
+// types.MetaConfigurationName; an agent is considered to have applied it when
+// it next registers. Agents that registered with a configuration of that name
+// are served the configuration, and never pushed a meta-configuration.
+//
+// This has no effect unless the NodeStatus implements
+// MetaConfigurationTracker.
+func WithMetaConfiguration(meta MetaConfigurationRepository) Option {
+	return func(m *Manager) {
+		m.meta = meta
+	}
+}
+
+// WithClientCertificateBinding requires each request from an agent to be made
+// with the TLS client certificate that the agent registered with, as
+// identified by its thumbprint. Requests without a matching certificate, or
+// from agents that aren't registered, are rejected as unauthorized; agents
+// that aren't registered may still call GetDscAction, in order to be told to
+// register.
+//
+// Registered agents can only register again, or rotate their certificate,
+// using the certificate that they're registered with; so an agent that loses
+// its certificate can't register until its registration is removed.
+//
+// The server must request (but not verify, since the LCM uses a self-signed
+// certificate) client certificates; see tls.RequestClientCert. The NodeStatus
+// must implement RegistrationGetter.
+func WithClientCertificateBinding() Option {
+	return func(m *Manager) {
+		m.certBinding = true
 	}
 }
@@ -0,0 +1,363 @@
+package psd1
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+type parser struct {
+	s    string
+	pos  int
+	line int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return SyntaxError{Line: p.line, Msg: fmt.Sprintf(format, args...)}
+}
+
+func (p *parser) eof() bool {
+	return p.pos >= len(p.s)
+}
+
+func (p *parser) peek() byte {
+	if p.eof() {
+		return 0
+	}
+	return p.s[p.pos]
+}
+
+func (p *parser) peekN(n int) string {
+	end := p.pos + n
+	if end > len(p.s) {
+		end = len(p.s)
+	}
+	return p.s[p.pos:end]
+}
+
+func (p *parser) advance(n int) {
+	for i := 0; i < n && !p.eof(); i++ {
+		if p.s[p.pos] == '\n' {
+			p.line++
+		}
+		p.pos++
+	}
+}
+
+// consume advances past the given token if it's next, ignoring case.
+func (p *parser) consume(tok string) bool {
+	if strings.EqualFold(p.peekN(len(tok)), tok) {
+		p.advance(len(tok))
+		return true
+	}
+	return false
+}
+
+// skipSpace skips whitespace and comments. If newlines is false, it stops at
+// the first newline, since these separate statements.
+func (p *parser) skipSpace(newlines bool) {
+	for !p.eof() {
+		switch c := p.peek(); {
+		case c == '\n' && !newlines:
+			return
+		case c == ' ' || c == '\t' || c == '\r' || c == '\n':
+			p.advance(1)
+		case c == '`' && (strings.HasPrefix(p.s[p.pos+1:], "\n") || strings.HasPrefix(p.s[p.pos+1:], "\r\n")):
+			// Line continuation
+			p.advance(1)
+			p.consume("\r")
+			p.advance(1)
+		case strings.HasPrefix(p.s[p.pos:], "<#"):
+			end := strings.Index(p.s[p.pos:], "#>")
+			if end < 0 {
+				p.advance(len(p.s))
+				return
+			}
+			p.advance(end + 2)
+		case c == '#':
+			end := strings.IndexByte(p.s[p.pos:], '\n')
+			if end < 0 {
+				p.advance(len(p.s))
+				return
+			}
+			p.advance(end)
+		default:
+			return
+		}
+	}
+}
+
+// skipSeparators skips whitespace, comments, newlines and semicolons, and
+// returns whether there were any newlines or semicolons.
+func (p *parser) skipSeparators() bool {
+	found := false
+	for {
+		p.skipSpace(false)
+		switch p.peek() {
+		case '\n', ';':
+			found = true
+			p.advance(1)
+		default:
+			return found
+		}
+	}
+}
+
+// hashtable parses the contents of a hashtable, after the opening "@{".
+func (p *parser) hashtable() (*Hashtable, error) {
+	ht := newHashtable()
+	for {
+		p.skipSeparators()
+		if p.eof() {
+			return nil, p.errorf("unterminated hashtable")
+		}
+		if p.consume("}") {
+			return ht, nil
+		}
+
+		key, err := p.key()
+		if err != nil {
+			return nil, err
+		}
+		p.skipSpace(false)
+		if !p.consume("=") {
+			return nil, p.errorf("expected '=' after key %q", key)
+		}
+		p.skipSpace(true)
+
+		value, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		if err := ht.set(key, value); err != nil {
+			return nil, p.errorf("%s", err)
+		}
+
+		// Entries must be separated by a newline or semicolon.
+		if !p.skipSeparators() && p.peek() != '}' {
+			return nil, p.errorf("expected newline or ';' after value for %q", key)
+		}
+	}
+}
+
+func (p *parser) key() (string, error) {
+	switch p.peek() {
+	case '\'', '"':
+		return p.str()
+	}
+
+	start := p.pos
+	for !p.eof() && isBareword(p.peek()) {
+		p.advance(1)
+	}
+	if p.pos == start {
+		return "", p.errorf("expected key, found %q", p.peekN(10))
+	}
+	return p.s[start:p.pos], nil
+}
+
+func isBareword(c byte) bool {
+	return c == '_' || c == '-' || c == '.' ||
+		(c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9')
+}
+
+// pipeline parses a value, which may be a comma-separated list of values.
+func (p *parser) pipeline() (interface{}, error) {
+	first, err := p.value()
+	if err != nil {
+		return nil, err
+	}
+
+	p.skipSpace(false)
+	if p.peek() != ',' {
+		return first, nil
+	}
+
+	list := []interface{}{first}
+	for p.consume(",") {
+		p.skipSpace(true)
+		v, err := p.value()
+		if err != nil {
+			return nil, err
+		}
+		list = append(list, v)
+		p.skipSpace(false)
+	}
+	return list, nil
+}
+
+func (p *parser) value() (interface{}, error) {
+	switch {
+	case p.consume("@{"):
+		return p.hashtable()
+	case p.consume("@("):
+		return p.array()
+	case strings.HasPrefix(p.s[p.pos:], "@'"), strings.HasPrefix(p.s[p.pos:], `@"`):
+		return p.hereString()
+	case p.peek() == '\'' || p.peek() == '"':
+		return p.str()
+	case p.peek() == '[':
+		// A type cast, e.g. [version]'1.0'; the value is returned
+		// as-is.
+		end := strings.IndexByte(p.s[p.pos:], ']')
+		if end < 0 {
+			return nil, p.errorf("unterminated type name")
+		}
+		p.advance(end + 1)
+		p.skipSpace(false)
+		return p.value()
+	case p.peek() == '$':
+		return p.variable()
+	}
+	return p.number()
+}
+
+// array parses the contents of an array expression, after the opening "@(".
+func (p *parser) array() ([]interface{}, error) {
+	list := []interface{}{}
+	for {
+		p.skipSeparators()
+		if p.eof() {
+			return nil, p.errorf("unterminated array")
+		}
+		if p.consume(")") {
+			return list, nil
+		}
+
+		v, err := p.pipeline()
+		if err != nil {
+			return nil, err
+		}
+		if sub, ok := v.([]interface{}); ok {
+			list = append(list, sub...)
+		} else {
+			list = append(list, v)
+		}
+
+		if !p.skipSeparators() && p.peek() != ')' {
+			return nil, p.errorf("expected newline, ';' or ')' in array")
+		}
+	}
+}
+
+func (p *parser) variable() (interface{}, error) {
+	switch {
+	case p.consume("$true"):
+		return true, nil
+	case p.consume("$false"):
+		return false, nil
+	case p.consume("$null"):
+		return nil, nil
+	}
+	return nil, p.errorf("variables are not allowed in data files: %q", p.peekN(10))
+}
+
+func (p *parser) number() (interface{}, error) {
+	start := p.pos
+	if c := p.peek(); c == '-' || c == '+' {
+		p.advance(1)
+	}
+	for !p.eof() && (isBareword(p.peek())) {
+		p.advance(1)
+	}
+	tok := p.s[start:p.pos]
+	if tok == "" {
+		return nil, p.errorf("expected value, found %q", p.peekN(10))
+	}
+
+	if i, err := strconv.ParseInt(tok, 0, 64); err == nil {
+		return i, nil
+	}
+	if f, err := strconv.ParseFloat(tok, 64); err == nil {
+		return f, nil
+	}
+	return nil, p.errorf("invalid value %q", tok)
+}
+
+// str parses a single- or double-quoted string.
+func (p *parser) str() (string, error) {
+	quote := p.peek()
+	p.advance(1)
+
+	var sb strings.Builder
+	for {
+		if p.eof() {
+			return "", p.errorf("unterminated string")
+		}
+		c := p.peek()
+		switch {
+		case c == quote:
+			p.advance(1)
+			// A doubled quote is an escaped quote.
+			if p.peek() == quote {
+				sb.WriteByte(quote)
+				p.advance(1)
+				continue
+			}
+			return sb.String(), nil
+
+		case c == '`' && quote == '"':
+			p.advance(1)
+			if p.eof() {
+				return "", p.errorf("unterminated string")
+			}
+			sb.WriteString(unescape(p.peek()))
+			p.advance(1)
+
+		case c == '$' && quote == '"' && p.pos+1 < len(p.s) && (isBareword(p.s[p.pos+1]) || p.s[p.pos+1] == '{' || p.s[p.pos+1] == '('):
+			return "", p.errorf("expandable strings are not allowed in data files")
+
+		default:
+			sb.WriteByte(c)
+			p.advance(1)
+		}
+	}
+}
+
+// hereString parses a here-string, e.g.:
+//
+//     @'
+//     contents
+//     '@
+func (p *parser) hereString() (string, error) {
+	quote := p.s[p.pos+1]
+	p.advance(2)
+
+	// The opening quote must be followed by a newline.
+	p.skipSpace(false)
+	p.consume("\r")
+	if !p.consume("\n") {
+		return "", p.errorf("here-string must start on a new line")
+	}
+
+	terminator := "\n" + string(quote) + "@"
+	end := strings.Index(p.s[p.pos:], terminator)
+	if end < 0 {
+		return "", p.errorf("unterminated here-string")
+	}
+	contents := strings.TrimSuffix(p.s[p.pos:p.pos+end], "\r")
+	p.advance(end + len(terminator))
+	return contents, nil
+}
+
+func unescape(c byte) string {
+	switch c {
+	case '0':
+		return "\x00"
+	case 'a':
+		return "\a"
+	case 'b':
+		return "\b"
+	case 'f':
+		return "\f"
+	case 'n':
+		return "\n"
+	case 'r':
+		return "\r"
+	case 't':
+		return "\t"
+	case 'v':
+		return "\v"
+	}
+	return string(c)
+}
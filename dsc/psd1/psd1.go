@@ -0,0 +1,132 @@
+// Package psd1 parses PowerShell data files, such as module manifests
+// (".psd1" files). These contain a single hashtable literal, e.g.:
+//
+//     @{
+//         ModuleVersion = '1.0.0'
+//         RequiredModules = @('Foo', 'Bar')
+//         PrivateData = @{ PSData = @{ Tags = 'DSC' } }
+//     }
+//
+// Only the restricted language that is allowed in data files is supported:
+// strings, numbers, $true, $false and $null, arrays and nested hashtables.
+package psd1
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/textenc"
+)
+
+// Hashtable is a parsed hashtable. Like PowerShell hashtables, keys are
+// case-insensitive; the original order and case of keys are preserved.
+//
+// Values are one of: string, int64, float64, bool, nil, []interface{} or
+// *Hashtable.
+type Hashtable struct {
+	keys   []string
+	values map[string]interface{}
+}
+
+func newHashtable() *Hashtable {
+	return &Hashtable{values: make(map[string]interface{})}
+}
+
+// Keys returns the keys of the hashtable, in the order they were defined.
+func (h *Hashtable) Keys() []string {
+	return h.keys
+}
+
+// Get returns the value for a key, ignoring case.
+func (h *Hashtable) Get(key string) (interface{}, bool) {
+	v, ok := h.values[strings.ToLower(key)]
+	return v, ok
+}
+
+// String returns the value for a key if it is a string, and the empty string
+// otherwise.
+func (h *Hashtable) String(key string) string {
+	v, _ := h.Get(key)
+	s, _ := v.(string)
+	return s
+}
+
+// Strings returns the value for a key as a list of strings; a single string
+// is treated as a list with one element, and other values are ignored.
+func (h *Hashtable) Strings(key string) []string {
+	v, _ := h.Get(key)
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var ret []string
+		for _, elem := range v {
+			if s, ok := elem.(string); ok {
+				ret = append(ret, s)
+			}
+		}
+		return ret
+	}
+	return nil
+}
+
+// Hashtable returns the value for a key if it is a nested hashtable, and nil
+// otherwise.
+func (h *Hashtable) Hashtable(key string) *Hashtable {
+	v, _ := h.Get(key)
+	ht, _ := v.(*Hashtable)
+	return ht
+}
+
+func (h *Hashtable) set(key string, value interface{}) error {
+	lower := strings.ToLower(key)
+	if _, ok := h.values[lower]; ok {
+		return fmt.Errorf("duplicate key %q", key)
+	}
+	h.keys = append(h.keys, key)
+	h.values[lower] = value
+	return nil
+}
+
+// SyntaxError is returned when a data file can't be parsed.
+type SyntaxError struct {
+	Line int
+	Msg  string
+}
+
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("psd1: line %d: %s", e.Line, e.Msg)
+}
+
+// Parse parses a data file containing a single hashtable.
+func Parse(data []byte) (*Hashtable, error) {
+	// Files written by Windows PowerShell often start with a BOM, and
+	// New-ModuleManifest writes UTF-16.
+	s := textenc.Decode(data)
+
+	p := &parser{s: s, line: 1}
+	p.skipSpace(true)
+	if !p.consume("@{") {
+		return nil, p.errorf("expected '@{' at start of file")
+	}
+	ht, err := p.hashtable()
+	if err != nil {
+		return nil, err
+	}
+	p.skipSpace(true)
+	if !p.eof() {
+		return nil, p.errorf("unexpected %q after hashtable", p.peekN(10))
+	}
+	return ht, nil
+}
+
+// ParseReader is like Parse, but reads the data file from r.
+func ParseReader(r io.Reader) (*Hashtable, error) {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	return Parse(data)
+}
@@ -0,0 +1,79 @@
+package psd1
+
+import (
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+	"unicode/utf16"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseManifest(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/xExample.psd1")
+	require.NoError(t, err)
+
+	ht, err := Parse(data)
+	require.NoError(t, err)
+
+	assert.Equal(t, "xExample.psm1", ht.String("rootmodule"))
+	assert.Equal(t, "1.2.0.0", ht.String("ModuleVersion"))
+	assert.Equal(t, "O'Brien", ht.String("Author"))
+	assert.Equal(t, `Example "Corp"`, ht.String("CompanyName"))
+	assert.Equal(t, "Resources for\nmanaging examples.", ht.String("Description"))
+	assert.Equal(t, "4.0", ht.String("CLRVersion"))
+	assert.Equal(t, []string{"Get-TargetResource", "Set-TargetResource", "Test-TargetResource"}, ht.Strings("FunctionsToExport"))
+	assert.Equal(t, []string(nil), ht.Strings("CmdletsToExport"))
+	assert.Equal(t, []string{"*"}, ht.Strings("VariablesToExport"))
+	assert.Equal(t, []string{"xExampleResource", "xOtherResource"}, ht.Strings("DscResourcesToExport"))
+
+	v, ok := ht.Get("RequireLicenseAcceptance")
+	assert.True(t, ok)
+	assert.Equal(t, false, v)
+	v, ok = ht.Get("HelpInfoURI")
+	assert.True(t, ok)
+	assert.Nil(t, v)
+	v, _ = ht.Get("MaxCount")
+	assert.Equal(t, int64(16), v)
+
+	psdata := ht.Hashtable("PrivateData").Hashtable("PSData")
+	require.NotNil(t, psdata)
+	assert.Equal(t, []string{"DSC", "DesiredStateConfiguration"}, psdata.Strings("Tags"))
+	assert.Equal(t, "https://example.com/xExample", psdata.String("projecturi"))
+
+	assert.Equal(t, "RootModule", ht.Keys()[0])
+}
+
+func TestParseUTF16(t *testing.T) {
+	units := utf16.Encode([]rune("\ufeff@{ ModuleVersion = '2.0' }"))
+	data := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(data[2*i:], u)
+	}
+
+	ht, err := Parse(data)
+	require.NoError(t, err)
+	assert.Equal(t, "2.0", ht.String("ModuleVersion"))
+}
+
+func TestParseErrors(t *testing.T) {
+	testCases := []struct {
+		Name  string
+		Input string
+	}{
+		{"not a hashtable", `'foo'`},
+		{"unterminated", "@{ Foo = 'bar'"},
+		{"unterminated string", "@{ Foo = 'bar }"},
+		{"missing separator", "@{ Foo = 'bar' Baz = 'qux' }"},
+		{"duplicate key", "@{ Foo = 1; foo = 2 }"},
+		{"variable", "@{ Foo = $env:PATH }"},
+		{"expandable string", `@{ Foo = "$PSScriptRoot\foo" }`},
+		{"trailing content", "@{ Foo = 1 } @{}"},
+	}
+
+	for _, tc := range testCases {
+		_, err := Parse([]byte(tc.Input))
+		assert.IsType(t, SyntaxError{}, err, "test case %q", tc.Name)
+	}
+}
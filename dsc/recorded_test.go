@@ -0,0 +1,77 @@
+package dsc
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// recording is a sequence of HTTP exchanges between an LCM and a pull server,
+// captured as described in testdata/lcm/README.md.
+type recording struct {
+	// Where and how the exchanges were captured.
+	Source string
+
+	Exchanges []struct {
+		Request struct {
+			Method  string
+			Path    string
+			Headers map[string]string
+			Body    string
+		}
+		Response struct {
+			Status int
+
+			// Only these headers are compared.
+			Headers map[string]string
+
+			// If set, the response body is compared as JSON.
+			Body json.RawMessage
+		}
+	}
+}
+
+// TestRecordedLCMTraffic replays each recording in testdata/lcm against a
+// Manager, in order, checking that it responds as the pull server that the
+// LCM was recorded against did. Captured requests are signed with
+// registration keys that the test doesn't know, at times long past, so
+// signatures aren't checked.
+func TestRecordedLCMTraffic(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "lcm", "*.json"))
+	require.NoError(t, err)
+
+	for _, path := range paths {
+		path := path
+		t.Run(strings.TrimSuffix(filepath.Base(path), ".json"), func(t *testing.T) {
+			data, err := ioutil.ReadFile(path)
+			require.NoError(t, err)
+			var rec recording
+			require.NoError(t, json.Unmarshal(data, &rec))
+			require.NotEmpty(t, rec.Source, "recordings must say where they were captured")
+
+			mgr, _ := newTestManager()
+			for i, ex := range rec.Exchanges {
+				req := httptest.NewRequest(ex.Request.Method, ex.Request.Path, strings.NewReader(ex.Request.Body))
+				for k, v := range ex.Request.Headers {
+					req.Header.Set(k, v)
+				}
+				resp := httptest.NewRecorder()
+				mgr.ServeHTTP(resp, req)
+
+				assert.Equal(t, ex.Response.Status, resp.Code, "exchange %d: %s %s: %s", i, ex.Request.Method, ex.Request.Path, resp.Body.String())
+				for k, v := range ex.Response.Headers {
+					assert.Equal(t, v, resp.Header().Get(k), "exchange %d: header %s", i, k)
+				}
+				if len(ex.Response.Body) > 0 {
+					assert.JSONEq(t, string(ex.Response.Body), resp.Body.String(), "exchange %d", i)
+				}
+			}
+		})
+	}
+}
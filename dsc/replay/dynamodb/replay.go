@@ -0,0 +1,67 @@
+// Package dynamodb implements a ReplayCache in a DynamoDB table, so that every
+// instance of a server sees the signatures of requests made to the others.
+//
+// The table must have a string partition key named "Signature". Each item
+// also has a numeric "Expires" attribute, holding the Unix time after which
+// the signature can't be replayed; enable DynamoDB's time to live on that
+// attribute so that old items are deleted. DynamoDB deletes expired items
+// some time after they expire, so the cache doesn't rely on it: an item that
+// has expired is treated as unseen.
+package dynamodb
+
+import (
+	"context"
+	"strconv"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+)
+
+type ReplayCache struct {
+	table  *string
+	dynamo dynamodbiface.DynamoDBAPI
+	now    func() time.Time
+}
+
+func New(table string, dynamo dynamodbiface.DynamoDBAPI) *ReplayCache {
+	return &ReplayCache{
+		table:  &table,
+		dynamo: dynamo,
+		now:    time.Now,
+	}
+}
+
+func (c *ReplayCache) Seen(ctx context.Context, signature string, expires time.Time) (bool, error) {
+	// The put only succeeds if no unexpired item has the signature, so
+	// concurrent requests with the same signature can't both succeed.
+	_, err := c.dynamo.PutItemWithContext(ctx, &dynamodb.PutItemInput{
+		TableName: c.table,
+		Item: map[string]*dynamodb.AttributeValue{
+			"Signature": {S: aws.String(signature)},
+			"Expires":   {N: aws.String(strconv.FormatInt(ceilUnix(expires), 10))},
+		},
+		ConditionExpression: aws.String("attribute_not_exists(Signature) OR Expires <= :now"),
+		ExpressionAttributeValues: map[string]*dynamodb.AttributeValue{
+			":now": {N: aws.String(strconv.FormatInt(c.now().Unix(), 10))},
+		},
+	})
+	if err != nil {
+		if aerr, ok := err.(awserr.Error); ok && aerr.Code() == dynamodb.ErrCodeConditionalCheckFailedException {
+			return true, nil
+		}
+		return false, err
+	}
+	return false, nil
+}
+
+// ceilUnix returns t as a Unix time, rounded up to a whole second so that a
+// signature is never forgotten early.
+func ceilUnix(t time.Time) int64 {
+	if t.Nanosecond() > 0 {
+		return t.Unix() + 1
+	}
+	return t.Unix()
+}
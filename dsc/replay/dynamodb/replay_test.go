@@ -0,0 +1,90 @@
+package dynamodb
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	"github.com/aws/aws-sdk-go/service/dynamodb"
+	"github.com/aws/aws-sdk-go/service/dynamodb/dynamodbiface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// fakeDynamo is an in-memory DynamoDBAPI that only implements the conditional
+// put used by the ReplayCache.
+type fakeDynamo struct {
+	dynamodbiface.DynamoDBAPI
+
+	expires map[string]int64
+	err     error
+}
+
+func (f *fakeDynamo) PutItemWithContext(ctx aws.Context, in *dynamodb.PutItemInput, opts ...request.Option) (*dynamodb.PutItemOutput, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	if aws.StringValue(in.ConditionExpression) != "attribute_not_exists(Signature) OR Expires <= :now" {
+		return nil, errors.New("unexpected condition")
+	}
+
+	signature := aws.StringValue(in.Item["Signature"].S)
+	now, err := strconv.ParseInt(aws.StringValue(in.ExpressionAttributeValues[":now"].N), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	if expires, ok := f.expires[signature]; ok && expires > now {
+		return nil, awserr.New(dynamodb.ErrCodeConditionalCheckFailedException, "The conditional request failed", nil)
+	}
+
+	expires, err := strconv.ParseInt(aws.StringValue(in.Item["Expires"].N), 10, 64)
+	if err != nil {
+		return nil, err
+	}
+	f.expires[signature] = expires
+	return &dynamodb.PutItemOutput{}, nil
+}
+
+func TestReplayCache(t *testing.T) {
+	ctx := context.Background()
+	fake := &fakeDynamo{expires: make(map[string]int64)}
+	now := time.Unix(1000, 0)
+	cache := New("replay", fake)
+	cache.now = func() time.Time { return now }
+
+	// Instances share the table, so a signature seen by one is seen by
+	// the others.
+	other := New("replay", fake)
+	other.now = cache.now
+
+	seen, err := cache.Seen(ctx, "sig", now.Add(1500*time.Millisecond))
+	require.NoError(t, err)
+	assert.False(t, seen)
+	seen, err = other.Seen(ctx, "sig", now.Add(1500*time.Millisecond))
+	require.NoError(t, err)
+	assert.True(t, seen)
+
+	// Expiry is rounded up, so signatures aren't forgotten early...
+	assert.Equal(t, int64(1002), fake.expires["sig"])
+	now = now.Add(1600 * time.Millisecond)
+	seen, err = cache.Seen(ctx, "sig", now.Add(time.Second))
+	require.NoError(t, err)
+	assert.True(t, seen)
+
+	// ... but once they expire, they can be seen again, even if DynamoDB
+	// hasn't deleted them yet.
+	now = time.Unix(1002, 0)
+	seen, err = cache.Seen(ctx, "sig", now.Add(time.Second))
+	require.NoError(t, err)
+	assert.False(t, seen)
+
+	// Other errors are returned.
+	fake.err = errors.New("throttled")
+	_, err = cache.Seen(ctx, "other", now.Add(time.Second))
+	assert.Error(t, err)
+}
@@ -0,0 +1,79 @@
+package memory
+
+import (
+	"container/list"
+	"context"
+	"sync"
+	"time"
+)
+
+type entry struct {
+	signature string
+	expires   time.Time
+}
+
+// ReplayCache is an in-memory cache of recently seen request signatures. It
+// holds at most a fixed number of signatures; if more unexpired signatures
+// than that are seen within the clock-skew window, the oldest are forgotten
+// early, so the size should comfortably exceed the expected request rate
+// multiplied by the window.
+type ReplayCache struct {
+	size int
+
+	lock    sync.Mutex
+	entries map[string]*list.Element
+	order   *list.List // oldest first
+	now     func() time.Time
+}
+
+func New(size int) *ReplayCache {
+	return &ReplayCache{
+		size:    size,
+		entries: make(map[string]*list.Element),
+		order:   list.New(),
+		now:     time.Now,
+	}
+}
+
+func (c *ReplayCache) Seen(ctx context.Context, signature string, expires time.Time) (bool, error) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	now := c.now()
+	c.expire(now)
+
+	if el, ok := c.entries[signature]; ok {
+		if el.Value.(*entry).expires.After(now) {
+			return true, nil
+		}
+
+		// Expired, but not yet removed since it's behind a newer
+		// entry; treat it as unseen.
+		c.remove(el)
+	}
+
+	// Make room for the new entry.
+	for c.order.Len() >= c.size && c.order.Len() > 0 {
+		c.remove(c.order.Front())
+	}
+
+	c.entries[signature] = c.order.PushBack(&entry{signature, expires})
+	return false, nil
+}
+
+// expire removes expired entries from the front of the list. Entries are
+// added roughly in order of expiry, so this doesn't necessarily remove every
+// expired entry, but it keeps the cache from holding on to them.
+func (c *ReplayCache) expire(now time.Time) {
+	for el := c.order.Front(); el != nil; el = c.order.Front() {
+		if el.Value.(*entry).expires.After(now) {
+			return
+		}
+		c.remove(el)
+	}
+}
+
+func (c *ReplayCache) remove(el *list.Element) {
+	c.order.Remove(el)
+	delete(c.entries, el.Value.(*entry).signature)
+}
@@ -0,0 +1,75 @@
+package memory
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestReplayCache(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	cache := New(10)
+	cache.now = func() time.Time { return now }
+
+	seen := func(signature string, ttl time.Duration) bool {
+		ret, err := cache.Seen(ctx, signature, now.Add(ttl))
+		require.NoError(t, err)
+		return ret
+	}
+
+	assert.False(t, seen("a", time.Minute))
+	assert.True(t, seen("a", time.Minute))
+
+	// Expired signatures are removed, and can be seen again.
+	assert.False(t, seen("b", 2*time.Minute))
+	now = now.Add(time.Minute)
+	assert.False(t, seen("a", time.Minute))
+	assert.True(t, seen("b", time.Minute))
+}
+
+func TestReplayCacheExpiredBehindNewer(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	cache := New(10)
+	cache.now = func() time.Time { return now }
+
+	// A signature that expires before the one in front of it isn't
+	// removed by expire, but is still treated as unseen once it expires.
+	_, err := cache.Seen(ctx, "long", now.Add(time.Hour))
+	require.NoError(t, err)
+	_, err = cache.Seen(ctx, "short", now.Add(time.Minute))
+	require.NoError(t, err)
+
+	now = now.Add(2 * time.Minute)
+	seen, err := cache.Seen(ctx, "short", now.Add(time.Minute))
+	require.NoError(t, err)
+	assert.False(t, seen)
+	assert.Equal(t, 2, cache.order.Len())
+}
+
+func TestReplayCacheEviction(t *testing.T) {
+	ctx := context.Background()
+	now := time.Unix(1000, 0)
+	cache := New(2)
+	cache.now = func() time.Time { return now }
+
+	for _, signature := range []string{"a", "b", "c"} {
+		seen, err := cache.Seen(ctx, signature, now.Add(time.Hour))
+		require.NoError(t, err)
+		assert.False(t, seen)
+	}
+	assert.Equal(t, 2, cache.order.Len())
+
+	// The oldest signature was forgotten early to make room, so it's
+	// accepted again; the others aren't.
+	seen, err := cache.Seen(ctx, "a", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.False(t, seen)
+	seen, err = cache.Seen(ctx, "c", now.Add(time.Hour))
+	require.NoError(t, err)
+	assert.True(t, seen)
+}
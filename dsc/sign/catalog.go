@@ -0,0 +1,264 @@
+package sign
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/hex"
+	"io/ioutil"
+	"path"
+	"sort"
+	"strings"
+	"time"
+	"unicode/utf16"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/textenc"
+)
+
+// Object identifiers used in catalogs.
+var (
+	oidCTL                   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 10, 1}
+	oidCatalogList           = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 1, 1}
+	oidCatalogListMember2    = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 1, 3}
+	oidCatalogNameValue      = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 12, 2, 1}
+	oidCatalogNameValueFlags = 0x10010001
+)
+
+// Files that are hashed by the PowerShell SIP, rather than as flat files.
+var scriptExtensions = map[string]bool{
+	".ps1":    true,
+	".psm1":   true,
+	".psd1":   true,
+	".ps1xml": true,
+	".cdxml":  true,
+	".mof":    true,
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+type catalogAttribute struct {
+	Type  asn1.ObjectIdentifier
+	Value []asn1.RawValue `asn1:"set"`
+}
+
+type catalogMember struct {
+	Tag        []byte
+	Attributes []catalogAttribute `asn1:"set"`
+}
+
+type certificateTrustList struct {
+	SubjectUsage     []asn1.ObjectIdentifier
+	ListIdentifier   []byte
+	ThisUpdate       time.Time `asn1:"utc"`
+	SubjectAlgorithm algorithmIdentifier
+	Members          []catalogMember
+}
+
+type nameValue struct {
+	Name  asn1.RawValue
+	Flags int
+	Value []byte
+}
+
+// Catalog returns a signed catalog of the given files, keyed by their paths
+// relative to the catalog, in the format written by New-FileCatalog.
+// Test-FileCatalog fails if any files are added, removed or modified. The
+// catalog is dated modTime.
+func (s *Signer) Catalog(files map[string][]byte, modTime time.Time) ([]byte, error) {
+	var (
+		members []catalogMember
+		ids     []string
+	)
+	for name, content := range files {
+		member, err := catalogEntry(name, content)
+		if err != nil {
+			return nil, err
+		}
+		members = append(members, member)
+		ids = append(ids, string(member.Tag))
+	}
+	sort.Slice(members, func(i, j int) bool {
+		return bytes.Compare(members[i].Tag, members[j].Tag) < 0
+	})
+
+	// The list identifier only needs to be unique, so derive it from the
+	// contents to keep signing deterministic.
+	sort.Strings(ids)
+	listID := sha256.Sum256([]byte(strings.Join(ids, "\n")))
+
+	ctl, err := asn1.Marshal(certificateTrustList{
+		SubjectUsage:     []asn1.ObjectIdentifier{oidCatalogList},
+		ListIdentifier:   listID[:16],
+		ThisUpdate:       modTime.UTC(),
+		SubjectAlgorithm: algorithmIdentifier{Algorithm: oidCatalogListMember2, Parameters: asn1.NullRawValue},
+		Members:          members,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.sign(oidCTL, ctl)
+}
+
+// catalogEntry returns the catalog member for a file. Members are tagged with
+// the hex-encoded hash of the file, as a UTF-16 string.
+func catalogEntry(name string, content []byte) (catalogMember, error) {
+	var (
+		indirect []byte
+		digest   []byte
+		err      error
+	)
+	if scriptExtensions[strings.ToLower(path.Ext(name))] {
+		text := StripSignature(textenc.Decode(content))
+		indirect, err = scriptIndirectData(text)
+		if err != nil {
+			return catalogMember{}, err
+		}
+		digest, err = indirectDigest(indirect)
+	} else {
+		sum := sha256.Sum256(content)
+		digest = sum[:]
+		indirect, err = indirectData(oidSpcCabData, nil, digest)
+	}
+	if err != nil {
+		return catalogMember{}, err
+	}
+
+	fileName, err := asn1.Marshal(nameValue{
+		Name:  bmpString("File"),
+		Flags: oidCatalogNameValueFlags,
+		Value: utf16le(strings.Replace(name, "/", `\`, -1) + "\x00"),
+	})
+	if err != nil {
+		return catalogMember{}, err
+	}
+
+	return catalogMember{
+		Tag: utf16le(strings.ToUpper(hex.EncodeToString(digest))),
+		Attributes: []catalogAttribute{
+			{Type: oidSpcIndirectData, Value: []asn1.RawValue{{FullBytes: indirect}}},
+			{Type: oidCatalogNameValue, Value: []asn1.RawValue{{FullBytes: fileName}}},
+		},
+	}, nil
+}
+
+// indirectDigest returns the digest from a DER-encoded
+// SpcIndirectDataContent.
+func indirectDigest(indirect []byte) ([]byte, error) {
+	var content struct {
+		Data          asn1.RawValue
+		MessageDigest digestInfo
+	}
+	if _, err := asn1.Unmarshal(indirect, &content); err != nil {
+		return nil, err
+	}
+	return content.MessageDigest.Digest, nil
+}
+
+// bmpString returns s as an ASN.1 BMPString, which encoding/asn1 can't
+// marshal itself.
+func bmpString(s string) asn1.RawValue {
+	units := utf16.Encode([]rune(s))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.BigEndian.PutUint16(buf[2*i:], u)
+	}
+	return asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagBMPString, Bytes: buf}
+}
+
+func utf16le(s string) []byte {
+	units := utf16.Encode([]rune(s))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[2*i:], u)
+	}
+	return buf
+}
+
+// SignModule returns a copy of a module zip with a signed catalog of its
+// files added at its root, as "<name>.cat", cased like the module's manifest.
+// Any existing catalog is replaced.
+func (s *Signer) SignModule(content []byte, name string) ([]byte, error) {
+	zr, err := zip.NewReader(bytes.NewReader(content), int64(len(content)))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, f := range zr.File {
+		if base := strings.TrimSuffix(f.Name, ".psd1"); base != f.Name && strings.EqualFold(base, name) {
+			name = base
+			break
+		}
+	}
+	catalogName := name + ".cat"
+	var (
+		buf     bytes.Buffer
+		modTime time.Time
+	)
+	files := make(map[string][]byte)
+	zw := zip.NewWriter(&buf)
+	for _, f := range zr.File {
+		entryName := strings.Replace(f.Name, `\`, "/", -1)
+		if strings.EqualFold(entryName, catalogName) {
+			continue
+		}
+
+		data, err := readZipFile(f)
+		if err != nil {
+			return nil, err
+		}
+		if !strings.HasSuffix(entryName, "/") {
+			files[entryName] = data
+		}
+		if f.Modified.After(modTime) {
+			modTime = f.Modified
+		}
+
+		// The extra fields are dropped, since zip.Writer adds its own
+		// timestamp field, and copying them would grow the entry each
+		// time a module is signed.
+		header := f.FileHeader
+		header.Extra = nil
+		w, err := zw.CreateHeader(&header)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := w.Write(data); err != nil {
+			return nil, err
+		}
+	}
+
+	catalog, err := s.Catalog(files, modTime)
+	if err != nil {
+		return nil, err
+	}
+	w, err := zw.CreateHeader(&zip.FileHeader{
+		Name:     catalogName,
+		Method:   zip.Deflate,
+		Modified: modTime,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(catalog); err != nil {
+		return nil, err
+	}
+
+	if err := zw.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func readZipFile(f *zip.File) ([]byte, error) {
+	rc, err := f.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer rc.Close()
+	return ioutil.ReadAll(rc)
+}
@@ -0,0 +1,132 @@
+package sign
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/binary"
+	"strings"
+	"unicode/utf16"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/pkcs7"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/textenc"
+)
+
+// The GUID of the PowerShell subject interface package, which hashes and
+// verifies signatures of scripts, manifests and MOFs, in the mixed-endian
+// form used by Windows.
+var powershellSIP = []byte{
+	0x1F, 0xCC, 0x3B, 0x60, 0x59, 0x4B, 0x08, 0x4E,
+	0xB7, 0x24, 0xD2, 0xC6, 0x29, 0x7E, 0xF3, 0x51,
+}
+
+// Markers of the signature block in a MOF. PowerShell scripts use the same
+// block, but with line comments.
+const (
+	sigBegin = "/* SIG # Begin signature block */"
+	sigEnd   = "/* SIG # End signature block */"
+)
+
+type spcSipInfo struct {
+	Version   int
+	UUID      []byte
+	Reserved1 int
+	Reserved2 int
+	Reserved3 int
+	Reserved4 int
+	Reserved5 int
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+type digestInfo struct {
+	DigestAlgorithm asn1.RawValue
+	Digest          []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+// SignMOF signs a MOF document, returning it with a signature block
+// appended, encoded as UTF-8. Any existing signature is replaced.
+func (s *Signer) SignMOF(mof []byte) ([]byte, error) {
+	text := StripSignature(textenc.Decode(mof))
+	if !strings.HasSuffix(text, "\r\n") {
+		text = strings.TrimSuffix(text, "\n") + "\r\n"
+	}
+
+	indirect, err := scriptIndirectData(text)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := s.sign(oidSpcIndirectData, indirect)
+	if err != nil {
+		return nil, err
+	}
+
+	var sb strings.Builder
+	sb.WriteString(text)
+	sb.WriteString(sigBegin + "\r\n")
+	encoded := base64.StdEncoding.EncodeToString(sig)
+	for len(encoded) > 0 {
+		n := 64
+		if n > len(encoded) {
+			n = len(encoded)
+		}
+		sb.WriteString("/* " + encoded[:n] + " */\r\n")
+		encoded = encoded[n:]
+	}
+	sb.WriteString(sigEnd + "\r\n")
+	return []byte(sb.String()), nil
+}
+
+// StripSignature removes the signature block from a signed MOF, if it has
+// one.
+func StripSignature(text string) string {
+	i := strings.Index(text, sigBegin)
+	if i < 0 {
+		return text
+	}
+	return text[:i]
+}
+
+// scriptIndirectData returns the DER-encoded SpcIndirectDataContent for a
+// file that is hashed by the PowerShell SIP, which hashes the text of the
+// file as UTF-16, regardless of its encoding on disk.
+func scriptIndirectData(text string) ([]byte, error) {
+	units := utf16.Encode([]rune(text))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[2*i:], u)
+	}
+	digest := sha256.Sum256(buf)
+
+	sipInfo, err := asn1.Marshal(spcSipInfo{Version: 0x10000, UUID: powershellSIP})
+	if err != nil {
+		return nil, err
+	}
+	return indirectData(oidSpcSipInfo, sipInfo, digest[:])
+}
+
+// indirectData returns a DER-encoded SpcIndirectDataContent.
+func indirectData(dataType asn1.ObjectIdentifier, value []byte, digest []byte) ([]byte, error) {
+	algo, err := asn1.Marshal(pkcs7.SHA256)
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(spcIndirectDataContent{
+		Data: spcAttributeTypeAndOptionalValue{
+			Type:  dataType,
+			Value: asn1.RawValue{FullBytes: value},
+		},
+		MessageDigest: digestInfo{
+			DigestAlgorithm: asn1.RawValue{FullBytes: algo},
+			Digest:          digest,
+		},
+	})
+}
@@ -0,0 +1,151 @@
+// Package sign signs configurations and modules, so that nodes whose LCM is
+// configured with SignatureValidation can check that they came from a trusted
+// publisher. Configurations are signed with an Authenticode signature block,
+// like a PowerShell script, and modules by adding a signed catalog of their
+// files to the module zip, like New-FileCatalog and Set-AuthenticodeSignature.
+//
+// Signing the same content with the same certificate always produces the same
+// output, so that a configuration's checksum only changes when it does.
+//
+// The signatures follow the Authenticode and catalog formats as documented,
+// but haven't been verified by a Windows LCM, nor compared with signatures
+// made by Set-AuthenticodeSignature and New-FileCatalog; the tests only check
+// them with this package's own parsers.
+package sign
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/internal/pkcs7"
+)
+
+// Object identifiers used in Authenticode signatures and catalogs.
+var (
+	oidSpcIndirectData   = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidSpcStatementType  = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 11}
+	oidSpcSpOpusInfo     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 12}
+	oidIndividualSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 21}
+	oidSpcCabData        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 25}
+	oidSpcSipInfo        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 30}
+)
+
+// Signer signs configurations and modules with a code signing certificate.
+type Signer struct {
+	cert  *x509.Certificate
+	chain []*x509.Certificate
+	key   crypto.Signer
+}
+
+// New creates a Signer from a certificate, the certificates of any
+// intermediate CAs, and the certificate's private key, which must be an RSA
+// key.
+func New(cert *x509.Certificate, chain []*x509.Certificate, key crypto.Signer) (*Signer, error) {
+	pub, ok := key.Public().(*rsa.PublicKey)
+	if !ok {
+		return nil, errors.New("sign: only RSA keys are supported")
+	}
+	if certPub, ok := cert.PublicKey.(*rsa.PublicKey); !ok || certPub.N.Cmp(pub.N) != 0 || certPub.E != pub.E {
+		return nil, errors.New("sign: private key doesn't match certificate")
+	}
+	return &Signer{cert: cert, chain: chain, key: key}, nil
+}
+
+// Load creates a Signer from PEM files. The certificate file contains the
+// signing certificate, optionally followed by the certificates of any
+// intermediate CAs; the key file contains its private key, in PKCS #1 or
+// PKCS #8 format.
+func Load(certFile, keyFile string) (*Signer, error) {
+	certPEM, err := ioutil.ReadFile(certFile)
+	if err != nil {
+		return nil, err
+	}
+	keyPEM, err := ioutil.ReadFile(keyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	var certs []*x509.Certificate
+	for rest := certPEM; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("sign: %s: %s", certFile, err)
+		}
+		certs = append(certs, cert)
+	}
+	if len(certs) == 0 {
+		return nil, fmt.Errorf("sign: %s: no certificates found", certFile)
+	}
+
+	key, err := parseKey(keyPEM)
+	if err != nil {
+		return nil, fmt.Errorf("sign: %s: %s", keyFile, err)
+	}
+	return New(certs[0], certs[1:], key)
+}
+
+func parseKey(data []byte) (crypto.Signer, error) {
+	for rest := data; ; {
+		var block *pem.Block
+		block, rest = pem.Decode(rest)
+		if block == nil {
+			return nil, errors.New("no private key found")
+		}
+
+		switch block.Type {
+		case "RSA PRIVATE KEY":
+			key, err := x509.ParsePKCS1PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			return key, nil
+		case "PRIVATE KEY":
+			key, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			if signer, ok := key.(crypto.Signer); ok {
+				return signer, nil
+			}
+			return nil, fmt.Errorf("unsupported private key type %T", key)
+		}
+	}
+}
+
+// Certificate returns the signing certificate.
+func (s *Signer) Certificate() *x509.Certificate {
+	return s.cert
+}
+
+// sign returns a PKCS #7 SignedData message for content of the given type,
+// with the attributes of an Authenticode signature.
+func (s *Signer) sign(contentType asn1.ObjectIdentifier, content []byte) ([]byte, error) {
+	// An empty SpcSpOpusInfo, i.e. no program name or URL.
+	opusInfo, err := asn1.Marshal(struct{}{})
+	if err != nil {
+		return nil, err
+	}
+	statementType, err := asn1.Marshal([]asn1.ObjectIdentifier{oidIndividualSigning})
+	if err != nil {
+		return nil, err
+	}
+
+	return pkcs7.Sign(contentType, content, s.cert, s.chain, s.key, []pkcs7.Attribute{
+		{Type: oidSpcSpOpusInfo, Value: opusInfo},
+		{Type: oidSpcStatementType, Value: statementType},
+	})
+}
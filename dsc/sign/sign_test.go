@@ -0,0 +1,119 @@
+package sign
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/module"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/mof"
+)
+
+// newSigner returns a Signer for a new self-signed certificate, loaded from
+// PEM files as by Load.
+func newSigner(t *testing.T) *Signer {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "DSC Signing"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	require.NoError(t, err)
+
+	dir, err := ioutil.TempDir("", "dsc-sign")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	certFile := filepath.Join(dir, "cert.pem")
+	keyFile := filepath.Join(dir, "key.pem")
+	require.NoError(t, ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}), 0644))
+	require.NoError(t, ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)}), 0600))
+
+	signer, err := Load(certFile, keyFile)
+	require.NoError(t, err)
+	return signer
+}
+
+func TestSignMOF(t *testing.T) {
+	signer := newSigner(t)
+	content, err := ioutil.ReadFile("../mof/testdata/HelloWorld.mof")
+	require.NoError(t, err)
+
+	signed, err := signer.SignMOF(content)
+	require.NoError(t, err)
+	text := string(signed)
+	assert.Contains(t, text, sigBegin+"\r\n")
+	assert.True(t, strings.HasSuffix(text, sigEnd+"\r\n"))
+
+	// The signature block is a comment, so the document is unchanged.
+	want, err := mof.Parse(content)
+	require.NoError(t, err)
+	got, err := mof.Parse(signed)
+	require.NoError(t, err)
+	assert.Equal(t, want, got)
+
+	// Signing is deterministic, and re-signing replaces the signature.
+	again, err := signer.SignMOF(content)
+	require.NoError(t, err)
+	assert.Equal(t, signed, again)
+	resigned, err := signer.SignMOF(signed)
+	require.NoError(t, err)
+	assert.Equal(t, signed, resigned)
+}
+
+func TestSignModule(t *testing.T) {
+	signer := newSigner(t)
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for name, content := range map[string]string{
+		"xExample.psd1":                       "@{ ModuleVersion = '1.2.0.0' }",
+		"xExample.psm1":                       "",
+		"DSCResources/xExample/xExample.psm1": "function Get-TargetResource {}",
+	} {
+		w, err := zw.Create(name)
+		require.NoError(t, err)
+		w.Write([]byte(content))
+	}
+	require.NoError(t, zw.Close())
+
+	// The catalog is named like the manifest, whatever the case of the
+	// requested name.
+	signed, err := signer.SignModule(buf.Bytes(), "xexample")
+	require.NoError(t, err)
+	require.NoError(t, module.Validate(bytes.NewReader(signed), int64(len(signed)), "xExample", "1.2.0.0"))
+
+	zr, err := zip.NewReader(bytes.NewReader(signed), int64(len(signed)))
+	require.NoError(t, err)
+	var names []string
+	for _, f := range zr.File {
+		names = append(names, f.Name)
+	}
+	assert.Contains(t, names, "xExample.cat")
+	assert.Len(t, names, 4)
+
+	// Re-signing replaces the catalog, and is deterministic.
+	again, err := signer.SignModule(signed, "xExample")
+	require.NoError(t, err)
+	assert.Equal(t, signed, again)
+}
@@ -2,7 +2,7 @@ package status
 
 import (
 	"context"
-	"fmt"
+	"strings"
 
 	"github.com/stripe-archive/simple-powershell-dsc/dsc"
 	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
@@ -31,60 +31,6 @@ func ReconcileDscStatus(
 		return ret, nil
 	}
 
-	// If we get a single query...
-	if len(req.Body.ClientStatus) == 1 {
-		// If it has an empty checksum, it's because this
-		// client hasn't run a consistency check yet; just return
-		// "GetConfiguration" for every configuration that was registered,
-		// rather than trying to check hashes, match names, etc.
-		checksum := req.Body.ClientStatus[0].Checksum
-		if checksum == "" {
-			for _, reg := range registered {
-				resp = append(resp, types.GetDscActionResponseBodyDetail{
-					ConfigurationName: reg,
-					Status:            "GetConfiguration",
-				})
-			}
-
-			ret := &types.GetDscActionResponse{
-				Body: types.GetDscActionResponseBody{
-					Details:    resp,
-					NodeStatus: "GetConfiguration",
-				},
-			}
-			return ret, nil
-		}
-
-		// Otherwise, we should only have a single registered
-		// configuration; check the hash and return.
-		if len(registered) != 1 {
-			return nil, fmt.Errorf("dsc/status: have %d registered configurations but client is only requesting 1", len(registered))
-		}
-
-		expected, _, err := util.GetConfigHash(ctx, repo, req.AgentID, registered[0])
-		if err != nil {
-			return nil, err
-		}
-
-		var statusStr string
-		if expected != checksum {
-			statusStr = "GetConfiguration"
-		} else {
-			statusStr = "OK"
-		}
-
-		ret := &types.GetDscActionResponse{
-			Body: types.GetDscActionResponseBody{
-				NodeStatus: statusStr,
-				Details: []types.GetDscActionResponseBodyDetail{{
-					ConfigurationName: registered[0],
-					Status:            statusStr,
-				}},
-			},
-		}
-		return ret, nil
-	}
-
 	// As a heuristic, if we have a ConfigurationName in the request, it is
 	// because the client is using PartialConfigurations and is requesting
 	// the status of configurations with those names. Look up each of the
@@ -101,7 +47,7 @@ func ReconcileDscStatus(
 			}
 
 			var statusStr string
-			if expected != status.Checksum {
+			if !checksumsEqual(status.Checksum, expected) {
 				updates++
 				statusStr = "GetConfiguration"
 			} else {
@@ -132,9 +78,99 @@ func ReconcileDscStatus(
 		return ret, nil
 	}
 
-	// If we get here, we have a request with more than one configuration,
-	// but no names for them. We could assume that the order matches that
-	// of the registration request, but while that appears to be true in my
-	// tests, it isn't documented and feels fragile. Let's return an error.
-	return nil, fmt.Errorf("dsc/status: don't support multiple (%d) non-partial configurations", len(req.Body.ClientStatus))
+	// Otherwise, the client isn't using named (partial) configurations.
+	return reconcileUnnamed(ctx, repo, registered, req)
+}
+
+// reconcileUnnamed handles a request where the ClientStatus entries don't
+// have a ConfigurationName. In this case, the entries are matched against the
+// configurations that the agent registered with: first by position, since the
+// client sends them in the order of its ConfigurationNames, and then by
+// checksum, in case the order has changed.
+func reconcileUnnamed(
+	ctx context.Context,
+	repo dsc.ConfigurationRepository,
+	registered []string,
+	req *types.GetDscActionRequest,
+) (*types.GetDscActionResponse, error) {
+	// If every entry has an empty checksum, it's because this client
+	// hasn't run a consistency check yet; just return "GetConfiguration"
+	// for every configuration that was registered, rather than trying to
+	// check hashes.
+	empty := true
+	for _, status := range req.Body.ClientStatus {
+		if status.Checksum != "" {
+			empty = false
+			break
+		}
+	}
+
+	var (
+		resp    []types.GetDscActionResponseBodyDetail
+		updates int
+		used    = make([]bool, len(req.Body.ClientStatus))
+	)
+	for i, name := range registered {
+		statusStr := "GetConfiguration"
+
+		if !empty {
+			expected, _, err := util.GetConfigHash(ctx, repo, req.AgentID, name)
+			if err != nil {
+				return nil, err
+			}
+
+			if match := matchChecksum(req.Body.ClientStatus, used, i, expected); match >= 0 {
+				used[match] = true
+				statusStr = "OK"
+			}
+		}
+
+		if statusStr != "OK" {
+			updates++
+		}
+		resp = append(resp, types.GetDscActionResponseBodyDetail{
+			ConfigurationName: name,
+			Status:            statusStr,
+		})
+	}
+
+	// The top-level NodeStatus depends on whether any of the
+	// configurations need to be updated. A client that sends more entries
+	// than it registered with has configurations that we don't know
+	// about, so it should fetch its configurations again too.
+	var status string
+	if updates > 0 || len(req.Body.ClientStatus) > len(registered) {
+		status = "GetConfiguration"
+	} else {
+		status = "OK"
+	}
+
+	ret := &types.GetDscActionResponse{
+		Body: types.GetDscActionResponseBody{
+			Details:    resp,
+			NodeStatus: status,
+		},
+	}
+	return ret, nil
+}
+
+// matchChecksum returns the index of an unused ClientStatus entry with the
+// expected checksum, preferring the entry at the given position, or -1 if
+// there is none.
+func matchChecksum(statuses []types.ClientStatusItem, used []bool, pos int, expected string) int {
+	if pos < len(statuses) && !used[pos] && checksumsEqual(statuses[pos].Checksum, expected) {
+		return pos
+	}
+
+	for i, status := range statuses {
+		if !used[i] && checksumsEqual(status.Checksum, expected) {
+			return i
+		}
+	}
+	return -1
+}
+
+// checksumsEqual compares two hex-encoded checksums.
+func checksumsEqual(actual, expected string) bool {
+	return actual != "" && strings.EqualFold(actual, expected)
 }
@@ -0,0 +1,81 @@
+package status
+
+import (
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// checksumRepo is a ConfigurationRepository that only knows the checksums of
+// its configurations.
+type checksumRepo map[string]string
+
+func (c checksumRepo) RegisterDscAgent(ctx context.Context, req types.RegisterDscAgentRequest) (*types.RegisterDscAgentResponse, error) {
+	return nil, nil
+}
+
+func (c checksumRepo) GetConfiguration(ctx context.Context, req types.GetConfigurationRequest) (*types.GetConfigurationResponse, error) {
+	sum, ok := c[req.ConfigurationName]
+	if !ok {
+		return nil, types.ConfigurationNotFoundError{AgentID: req.AgentID, Name: req.ConfigurationName}
+	}
+	return &types.GetConfigurationResponse{
+		Content:           strings.NewReader(""),
+		Checksum:          sum,
+		ChecksumAlgorithm: "SHA-256",
+	}, nil
+}
+
+func (c checksumRepo) GetModule(ctx context.Context, req types.GetModuleRequest) (*types.GetModuleResponse, error) {
+	return nil, types.ModuleNotFoundError{AgentID: req.AgentID, Name: req.Name, Version: req.Version}
+}
+
+// TestReconcileDscStatus runs each test case in testdata/reconcile. Each case
+// contains the configurations an agent registered with, the checksums of
+// those configurations on the server, a GetDscAction request body, and the
+// expected response body; see the README there for where the bodies came
+// from.
+func TestReconcileDscStatus(t *testing.T) {
+	paths, err := filepath.Glob(filepath.Join("testdata", "reconcile", "*.json"))
+	require.NoError(t, err)
+	require.NotEmpty(t, paths)
+
+	for _, path := range paths {
+		name := strings.TrimSuffix(filepath.Base(path), ".json")
+		t.Run(name, func(t *testing.T) {
+			f, err := os.Open(path)
+			require.NoError(t, err)
+			defer f.Close()
+
+			var tc struct {
+				Source     string
+				Registered []string
+				Checksums  map[string]string
+				Request    types.GetDscActionRequestBody
+				Expected   types.GetDscActionResponseBody
+			}
+			require.NoError(t, json.NewDecoder(f).Decode(&tc))
+			require.NotEmpty(t, tc.Source, "test cases must say where their request body came from")
+
+			resp, err := ReconcileDscStatus(
+				context.Background(),
+				checksumRepo(tc.Checksums),
+				tc.Registered,
+				&types.GetDscActionRequest{
+					AgentID: "B1F28971-2CEB-46D5-9DCB-79C044395F81",
+					Body:    tc.Request,
+				},
+			)
+			require.NoError(t, err)
+			assert.Equal(t, tc.Expected, resp.Body)
+		})
+	}
+}
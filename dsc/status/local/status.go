@@ -7,6 +7,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"strings"
+	"sync"
 
 	"github.com/natefinch/atomic"
 
@@ -18,9 +20,13 @@ import (
 type NodeStatus struct {
 	path   string
 	config dsc.ConfigurationRepository
+	opts   status.Options
+
+	// Serializes read-modify-write updates to registration files.
+	updateLock sync.Mutex
 }
 
-func New(config dsc.ConfigurationRepository, path string) (*NodeStatus, error) {
+func New(config dsc.ConfigurationRepository, path string, opts ...status.Option) (*NodeStatus, error) {
 	st, err := os.Stat(path)
 	if err != nil {
 		return nil, err
@@ -33,6 +39,7 @@ func New(config dsc.ConfigurationRepository, path string) (*NodeStatus, error) {
 	ret := &NodeStatus{
 		path:   path,
 		config: config,
+		opts:   status.NewOptions(opts...),
 	}
 	return ret, nil
 }
@@ -41,14 +48,23 @@ func (s *NodeStatus) RegisterDscAgent(
 	ctx context.Context,
 	req types.RegisterDscAgentRequest,
 ) (*types.RegisterDscAgentResponse, error) {
-	// Atomically write registrations to file.
-	body, err := json.Marshal(&req.Body.ConfigurationNames)
-	if err != nil {
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	reg := &status.Registration{RegisterDscAgentRequestBody: req.Body}
+
+	// Preserve any state that isn't part of the registration request.
+	existing, err := s.readRegistration(req.AgentID)
+	switch err.(type) {
+	case nil:
+		reg = existing.Reregister(req.Body)
+	case types.AgentNotRegisteredError:
+		// Nothing to preserve
+	default:
 		return nil, err
 	}
 
-	err = atomic.WriteFile(filepath.Join(s.path, req.AgentID+".json"), bytes.NewReader(body))
-	if err != nil {
+	if err := s.writeRegistration(req.AgentID, reg); err != nil {
 		return nil, err
 	}
 
@@ -60,22 +76,135 @@ func (s *NodeStatus) GetDscAction(
 	ctx context.Context,
 	req types.GetDscActionRequest,
 ) (*types.GetDscActionResponse, error) {
+	reg, err := s.readRegistration(req.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := s.opts.ConfigurationNames(ctx, req.AgentID, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	return status.ReconcileDscStatus(ctx, s.config, names, &req)
+}
+
+func (s *NodeStatus) ResolveConfigurationName(
+	ctx context.Context,
+	agentId, name string,
+) (string, error) {
+	reg, err := s.readRegistration(agentId)
+	if err != nil {
+		return "", err
+	}
+
+	return s.opts.ResolveConfigurationName(ctx, agentId, reg, name)
+}
+
+func (s *NodeStatus) RotateCertificate(
+	ctx context.Context,
+	req types.CertificateRotationRequest,
+) (*types.CertificateRotationResponse, error) {
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	reg, err := s.readRegistration(req.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the certificate captured at registration time.
+	reg.RegistrationInformation.CertificateInformation = req.Body.RotationInformation.CertificateInformation
+	if err := s.writeRegistration(req.AgentID, reg); err != nil {
+		return nil, err
+	}
+
+	return &types.CertificateRotationResponse{}, nil
+}
+
+func (s *NodeStatus) GetRegistration(
+	ctx context.Context,
+	agentId string,
+) (*types.RegisterDscAgentRequestBody, error) {
+	reg, err := s.readRegistration(agentId)
+	if err != nil {
+		return nil, err
+	}
+	return &reg.RegisterDscAgentRequestBody, nil
+}
+
+func (s *NodeStatus) GetMetaConfigurationChecksum(
+	ctx context.Context,
+	agentId string,
+) (string, error) {
+	reg, err := s.readRegistration(agentId)
+	if err != nil {
+		return "", err
+	}
+	return reg.MetaConfigurationChecksum, nil
+}
+
+func (s *NodeStatus) SetServedMetaConfigurationChecksum(
+	ctx context.Context,
+	agentId, checksum string,
+) error {
+	s.updateLock.Lock()
+	defer s.updateLock.Unlock()
+
+	reg, err := s.readRegistration(agentId)
+	if err != nil {
+		return err
+	}
+
+	reg.ServedMetaConfigurationChecksum = checksum
+	return s.writeRegistration(agentId, reg)
+}
+
+// registrationPath returns the path of an agent's registration. Agent IDs
+// come from clients, so any that could name a file outside the directory are
+// rejected.
+func (s *NodeStatus) registrationPath(agentId string) (string, error) {
+	if agentId == "" || agentId == "." || agentId == ".." || strings.ContainsAny(agentId, `/\`) {
+		return "", fmt.Errorf("invalid agent ID %q", agentId)
+	}
+	return filepath.Join(s.path, agentId+".json"), nil
+}
+
+func (s *NodeStatus) readRegistration(agentId string) (*status.Registration, error) {
+	path, err := s.registrationPath(agentId)
+	if err != nil {
+		return nil, err
+	}
+
 	// Read the file from on disk
-	f, err := os.Open(filepath.Join(s.path, req.AgentID+".json"))
+	f, err := os.Open(path)
 	if err != nil {
 		// If the file doesn't exist, the agent isn't registered
 		if os.IsNotExist(err) {
-			return nil, types.AgentNotRegisteredError{req.AgentID}
+			return nil, types.AgentNotRegisteredError{AgentID: agentId}
 		}
 
 		return nil, err
 	}
 	defer f.Close()
 
-	var regs []string
-	if err = json.NewDecoder(f).Decode(&regs); err != nil {
+	var reg status.Registration
+	if err = json.NewDecoder(f).Decode(&reg); err != nil {
 		return nil, err
 	}
+	return &reg, nil
+}
+
+func (s *NodeStatus) writeRegistration(agentId string, reg *status.Registration) error {
+	// Atomically write registrations to file.
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
 
-	return status.ReconcileDscStatus(ctx, s.config, regs, &req)
+	path, err := s.registrationPath(agentId)
+	if err != nil {
+		return err
+	}
+	return atomic.WriteFile(path, bytes.NewReader(body))
 }
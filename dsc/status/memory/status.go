@@ -10,15 +10,17 @@ import (
 )
 
 type NodeStatus struct {
-	regs     map[string][]string
+	regs     map[string]status.Registration
 	regsLock sync.RWMutex
 	config   dsc.ConfigurationRepository
+	opts     status.Options
 }
 
-func New(config dsc.ConfigurationRepository) *NodeStatus {
+func New(config dsc.ConfigurationRepository, opts ...status.Option) *NodeStatus {
 	return &NodeStatus{
-		regs:   make(map[string][]string),
+		regs:   make(map[string]status.Registration),
 		config: config,
+		opts:   status.NewOptions(opts...),
 	}
 }
 
@@ -26,10 +28,11 @@ func (s *NodeStatus) RegisterDscAgent(
 	ctx context.Context,
 	req types.RegisterDscAgentRequest,
 ) (*types.RegisterDscAgentResponse, error) {
-	// Save the list of configuration names for this node.
+	// Save the registration for this node.
 	s.regsLock.Lock()
 	defer s.regsLock.Unlock()
-	s.regs[req.AgentID] = req.Body.ConfigurationNames
+	existing := s.regs[req.AgentID]
+	s.regs[req.AgentID] = *existing.Reregister(req.Body)
 
 	// No response needed
 	return nil, nil
@@ -40,13 +43,97 @@ func (s *NodeStatus) GetDscAction(
 	req types.GetDscActionRequest,
 ) (*types.GetDscActionResponse, error) {
 	s.regsLock.RLock()
-	regs, ok := s.regs[req.AgentID]
+	reg, ok := s.regs[req.AgentID]
 	s.regsLock.RUnlock()
 
 	// Validate we've seen a registration
 	if !ok {
-		return nil, types.AgentNotRegisteredError{req.AgentID}
+		return nil, types.AgentNotRegisteredError{AgentID: req.AgentID}
 	}
 
-	return status.ReconcileDscStatus(ctx, s.config, regs, &req)
+	names, err := s.opts.ConfigurationNames(ctx, req.AgentID, &reg)
+	if err != nil {
+		return nil, err
+	}
+
+	return status.ReconcileDscStatus(ctx, s.config, names, &req)
+}
+
+func (s *NodeStatus) ResolveConfigurationName(
+	ctx context.Context,
+	agentId, name string,
+) (string, error) {
+	s.regsLock.RLock()
+	reg, ok := s.regs[agentId]
+	s.regsLock.RUnlock()
+
+	if !ok {
+		return "", types.AgentNotRegisteredError{AgentID: agentId}
+	}
+
+	return s.opts.ResolveConfigurationName(ctx, agentId, &reg, name)
+}
+
+func (s *NodeStatus) RotateCertificate(
+	ctx context.Context,
+	req types.CertificateRotationRequest,
+) (*types.CertificateRotationResponse, error) {
+	s.regsLock.Lock()
+	defer s.regsLock.Unlock()
+
+	reg, ok := s.regs[req.AgentID]
+	if !ok {
+		return nil, types.AgentNotRegisteredError{AgentID: req.AgentID}
+	}
+
+	// Replace the certificate captured at registration time.
+	reg.RegistrationInformation.CertificateInformation = req.Body.RotationInformation.CertificateInformation
+	s.regs[req.AgentID] = reg
+
+	return &types.CertificateRotationResponse{}, nil
+}
+
+func (s *NodeStatus) GetMetaConfigurationChecksum(
+	ctx context.Context,
+	agentId string,
+) (string, error) {
+	s.regsLock.RLock()
+	defer s.regsLock.RUnlock()
+
+	reg, ok := s.regs[agentId]
+	if !ok {
+		return "", types.AgentNotRegisteredError{AgentID: agentId}
+	}
+	return reg.MetaConfigurationChecksum, nil
+}
+
+func (s *NodeStatus) SetServedMetaConfigurationChecksum(
+	ctx context.Context,
+	agentId, checksum string,
+) error {
+	s.regsLock.Lock()
+	defer s.regsLock.Unlock()
+
+	reg, ok := s.regs[agentId]
+	if !ok {
+		return types.AgentNotRegisteredError{AgentID: agentId}
+	}
+
+	reg.ServedMetaConfigurationChecksum = checksum
+	s.regs[agentId] = reg
+	return nil
+}
+
+func (s *NodeStatus) GetRegistration(
+	ctx context.Context,
+	agentId string,
+) (*types.RegisterDscAgentRequestBody, error) {
+	s.regsLock.RLock()
+	defer s.regsLock.RUnlock()
+
+	reg, ok := s.regs[agentId]
+	if !ok {
+		return nil, types.AgentNotRegisteredError{AgentID: agentId}
+	}
+	return &reg.RegisterDscAgentRequestBody, nil
 }
@@ -0,0 +1,93 @@
+package status
+
+import (
+	"context"
+	"strings"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Options contains configuration that is common to all NodeStatus
+// implementations.
+type Options struct {
+	// Assignments, if set, is consulted for the configurations to serve
+	// to a node before falling back to the ConfigurationNames that the
+	// node sent when registering.
+	Assignments dsc.AssignmentStore
+}
+
+// Option is the type of functional options that can be passed to the
+// constructors of NodeStatus implementations.
+type Option func(*Options)
+
+// WithAssignments sets the AssignmentStore to consult for server-side
+// configuration assignments.
+func WithAssignments(a dsc.AssignmentStore) Option {
+	return func(o *Options) {
+		o.Assignments = a
+	}
+}
+
+// NewOptions applies the given functional options and returns the result.
+func NewOptions(opts ...Option) Options {
+	var ret Options
+	for _, opt := range opts {
+		opt(&ret)
+	}
+	return ret
+}
+
+// ConfigurationNames returns the names of the configurations that should be
+// applied to the given registered agent. A server-side assignment takes
+// precedence over the ConfigurationNames the agent sent when registering.
+func (o *Options) ConfigurationNames(ctx context.Context, agentId string, reg *Registration) ([]string, error) {
+	if o.Assignments == nil {
+		return reg.ConfigurationNames, nil
+	}
+
+	req := types.GetAssignmentRequest{
+		AgentID: agentId,
+	}
+	if reg.AgentInformation.NodeName != nil {
+		req.NodeName = *reg.AgentInformation.NodeName
+	}
+	if key, ok := dsc.RegistrationKeyFromContext(ctx); ok {
+		req.RegistrationKeyID = key.ID
+	}
+
+	resp, err := o.Assignments.GetAssignment(ctx, req)
+	if err != nil {
+		return nil, err
+	}
+	if resp == nil || len(resp.ConfigurationNames) == 0 {
+		return reg.ConfigurationNames, nil
+	}
+	return resp.ConfigurationNames, nil
+}
+
+// ResolveConfigurationName returns the name of the configuration to serve
+// when the given registered agent requests the named configuration.
+//
+// Clients request configurations by the names in their meta-configuration. If
+// a client has a single configuration that was replaced by a server-side
+// assignment of a single configuration, the assigned configuration is served
+// in its place; otherwise, the requested name is returned as-is.
+func (o *Options) ResolveConfigurationName(ctx context.Context, agentId string, reg *Registration, name string) (string, error) {
+	assigned, err := o.ConfigurationNames(ctx, agentId, reg)
+	if err != nil {
+		return "", err
+	}
+
+	for _, a := range assigned {
+		if strings.EqualFold(a, name) {
+			return name, nil
+		}
+	}
+
+	if len(reg.ConfigurationNames) == 1 && len(assigned) == 1 &&
+		strings.EqualFold(reg.ConfigurationNames[0], name) {
+		return assigned[0], nil
+	}
+	return name, nil
+}
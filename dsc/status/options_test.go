@@ -0,0 +1,91 @@
+package status
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/assign/memory"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+func TestConfigurationNames(t *testing.T) {
+	ctx := context.Background()
+	nodeName := "WEB01"
+	reg := &Registration{
+		RegisterDscAgentRequestBody: types.RegisterDscAgentRequestBody{
+			AgentInformation:   types.RegisterAgentInformation{NodeName: &nodeName},
+			ConfigurationNames: []string{"Requested"},
+		},
+	}
+
+	// Without an AssignmentStore, the registered names are used.
+	var opts Options
+	names, err := opts.ConfigurationNames(ctx, "agent", reg)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Requested"}, names)
+
+	store := memory.New()
+	opts = NewOptions(WithAssignments(store))
+
+	// Assignments take precedence over the registered names, and
+	// assignments by agent ID over those by node name.
+	steps := []struct {
+		Name     string
+		Update   func()
+		Expected []string
+	}{
+		{"unassigned", func() {}, []string{"Requested"}},
+		{"node", func() { store.AssignNode("web01", "ByNode") }, []string{"ByNode"}},
+		{"agent", func() { store.AssignAgent("AGENT", "ByAgent") }, []string{"ByAgent"}},
+		{"agent removed", func() { store.AssignAgent("AGENT") }, []string{"ByNode"}},
+		{"node removed", func() { store.AssignNode("web01") }, []string{"Requested"}},
+	}
+	for _, step := range steps {
+		step.Update()
+		names, err := opts.ConfigurationNames(ctx, "agent", reg)
+		require.NoError(t, err)
+		assert.Equal(t, step.Expected, names, "step %q", step.Name)
+	}
+}
+
+func TestResolveConfigurationName(t *testing.T) {
+	ctx := context.Background()
+	store := memory.New()
+	opts := NewOptions(WithAssignments(store))
+
+	single := &Registration{
+		RegisterDscAgentRequestBody: types.RegisterDscAgentRequestBody{
+			ConfigurationNames: []string{"Requested"},
+		},
+	}
+	partial := &Registration{
+		RegisterDscAgentRequestBody: types.RegisterDscAgentRequestBody{
+			ConfigurationNames: []string{"Base", "App"},
+		},
+	}
+
+	resolve := func(agentId string, reg *Registration, name string) string {
+		ret, err := opts.ResolveConfigurationName(ctx, agentId, reg, name)
+		require.NoError(t, err)
+		return ret
+	}
+
+	// Unassigned agents get what they asked for.
+	assert.Equal(t, "Requested", resolve("single", single, "Requested"))
+
+	// A single configuration is replaced by a single assignment...
+	store.AssignAgent("single", "Assigned")
+	assert.Equal(t, "Assigned", resolve("single", single, "requested"))
+
+	// ... but an assigned name is always served as-is, as are names that
+	// the agent didn't register with.
+	assert.Equal(t, "assigned", resolve("single", single, "assigned"))
+	assert.Equal(t, "Other", resolve("single", single, "Other"))
+
+	// Agents with several configurations can't be mapped.
+	store.AssignAgent("partial", "Other")
+	assert.Equal(t, "Base", resolve("partial", partial, "Base"))
+}
@@ -0,0 +1,58 @@
+package status
+
+import (
+	"encoding/json"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// Registration is the information that NodeStatus implementations persist
+// for each registered agent. The registration request body is embedded so
+// that the serialized form is a superset of the request that the client sent.
+type Registration struct {
+	types.RegisterDscAgentRequestBody
+
+	// The checksum of the meta-configuration that the agent last applied.
+	MetaConfigurationChecksum string `json:",omitempty"`
+
+	// The checksum of a meta-configuration that has been served to the
+	// agent, but not yet applied.
+	ServedMetaConfigurationChecksum string `json:",omitempty"`
+}
+
+// Reregister returns the registration that replaces r when the agent
+// registers again with the given request body. The LCM registers when it
+// applies a meta-configuration, so a meta-configuration that was served to
+// the agent is now considered applied.
+func (r *Registration) Reregister(body types.RegisterDscAgentRequestBody) *Registration {
+	ret := &Registration{
+		RegisterDscAgentRequestBody: body,
+		MetaConfigurationChecksum:   r.MetaConfigurationChecksum,
+	}
+	if r.ServedMetaConfigurationChecksum != "" {
+		ret.MetaConfigurationChecksum = r.ServedMetaConfigurationChecksum
+	}
+	return ret
+}
+
+// UnmarshalJSON implements the json.Unmarshaler interface. Older versions of
+// this package only stored the list of configuration names for an agent, so
+// we accept a bare JSON array in addition to a full registration.
+func (r *Registration) UnmarshalJSON(data []byte) error {
+	var names []string
+	if err := json.Unmarshal(data, &names); err == nil {
+		*r = Registration{}
+		r.ConfigurationNames = names
+		return nil
+	}
+
+	// Decode into an alias type to avoid recursing into this method.
+	type registration Registration
+	var reg registration
+	if err := json.Unmarshal(data, &reg); err != nil {
+		return err
+	}
+
+	*r = Registration(reg)
+	return nil
+}
@@ -0,0 +1,254 @@
+package status_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/aws/aws-sdk-go/aws"
+	"github.com/aws/aws-sdk-go/aws/awserr"
+	"github.com/aws/aws-sdk-go/aws/request"
+	awss3 "github.com/aws/aws-sdk-go/service/s3"
+	"github.com/aws/aws-sdk-go/service/s3/s3iface"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/stripe-archive/simple-powershell-dsc/dsc"
+	memoryassign "github.com/stripe-archive/simple-powershell-dsc/dsc/assign/memory"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/status"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/status/local"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/status/memory"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/status/s3"
+	"github.com/stripe-archive/simple-powershell-dsc/dsc/types"
+)
+
+// fakeS3 is an in-memory S3API that only implements the methods used by the
+// NodeStatus.
+type fakeS3 struct {
+	s3iface.S3API
+
+	objects map[string][]byte
+}
+
+func (f *fakeS3) GetObjectWithContext(ctx aws.Context, in *awss3.GetObjectInput, opts ...request.Option) (*awss3.GetObjectOutput, error) {
+	data, ok := f.objects[*in.Key]
+	if !ok {
+		return nil, awserr.New(awss3.ErrCodeNoSuchKey, "no such key", nil)
+	}
+	return &awss3.GetObjectOutput{Body: ioutil.NopCloser(bytes.NewReader(data))}, nil
+}
+
+func (f *fakeS3) PutObjectWithContext(ctx aws.Context, in *awss3.PutObjectInput, opts ...request.Option) (*awss3.PutObjectOutput, error) {
+	data, err := ioutil.ReadAll(in.Body)
+	if err != nil {
+		return nil, err
+	}
+	f.objects[*in.Key] = data
+	return &awss3.PutObjectOutput{}, nil
+}
+
+// nodeStatus is the set of interfaces implemented by every NodeStatus in
+// this repository.
+type nodeStatus interface {
+	dsc.NodeStatus
+	dsc.CertificateRotator
+	dsc.RegistrationGetter
+	dsc.MetaConfigurationTracker
+	dsc.ConfigurationNameResolver
+}
+
+func backends(t *testing.T, opts ...status.Option) map[string]nodeStatus {
+	dir, err := ioutil.TempDir("", "dsc-status")
+	require.NoError(t, err)
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	localStatus, err := local.New(nil, dir, opts...)
+	require.NoError(t, err)
+
+	return map[string]nodeStatus{
+		"memory": memory.New(nil, opts...),
+		"local":  localStatus,
+		"s3":     s3.New(nil, "bucket", &fakeS3{objects: make(map[string][]byte)}, opts...),
+	}
+}
+
+func TestRotateCertificate(t *testing.T) {
+	ctx := context.Background()
+
+	for name, st := range backends(t) {
+		st := st
+		t.Run(name, func(t *testing.T) {
+			_, err := st.RotateCertificate(ctx, rotation("unknown", "NEW"))
+			assert.IsType(t, types.AgentNotRegisteredError{}, err)
+
+			_, err = st.RegisterDscAgent(ctx, types.RegisterDscAgentRequest{
+				AgentID: "agent",
+				Body: types.RegisterDscAgentRequestBody{
+					AgentInformation:   types.RegisterAgentInformation{NodeName: aws.String("web01")},
+					ConfigurationNames: []string{"Web"},
+					RegistrationInformation: types.RegistrationInformation{
+						CertificateInformation: types.CertificateInformation{
+							Thumbprint: aws.String("OLD"),
+							Subject:    aws.String("CN=old"),
+						},
+					},
+				},
+			})
+			require.NoError(t, err)
+			require.NoError(t, st.SetServedMetaConfigurationChecksum(ctx, "agent", "META"))
+
+			_, err = st.RotateCertificate(ctx, rotation("agent", "NEW"))
+			require.NoError(t, err)
+
+			// Only the certificate is replaced.
+			reg, err := st.GetRegistration(ctx, "agent")
+			require.NoError(t, err)
+			cert := reg.RegistrationInformation.CertificateInformation
+			assert.Equal(t, "NEW", *cert.Thumbprint)
+			assert.Nil(t, cert.Subject)
+			assert.Equal(t, []string{"Web"}, reg.ConfigurationNames)
+			assert.Equal(t, "web01", *reg.AgentInformation.NodeName)
+
+			// As is the served meta-configuration, which is applied
+			// when the agent next registers.
+			_, err = st.RegisterDscAgent(ctx, types.RegisterDscAgentRequest{AgentID: "agent"})
+			require.NoError(t, err)
+			sum, err := st.GetMetaConfigurationChecksum(ctx, "agent")
+			require.NoError(t, err)
+			assert.Equal(t, "META", sum)
+		})
+	}
+}
+
+func TestMetaConfigurationChecksum(t *testing.T) {
+	ctx := context.Background()
+	register := func(t *testing.T, st nodeStatus) {
+		_, err := st.RegisterDscAgent(ctx, types.RegisterDscAgentRequest{
+			AgentID: "agent",
+			Body:    types.RegisterDscAgentRequestBody{ConfigurationNames: []string{"Web"}},
+		})
+		require.NoError(t, err)
+	}
+	applied := func(t *testing.T, st nodeStatus) string {
+		sum, err := st.GetMetaConfigurationChecksum(ctx, "agent")
+		require.NoError(t, err)
+		return sum
+	}
+
+	for name, st := range backends(t) {
+		t.Run(name, func(t *testing.T) {
+			_, err := st.GetMetaConfigurationChecksum(ctx, "agent")
+			assert.IsType(t, types.AgentNotRegisteredError{}, err)
+			err = st.SetServedMetaConfigurationChecksum(ctx, "agent", "ONE")
+			assert.IsType(t, types.AgentNotRegisteredError{}, err)
+
+			register(t, st)
+			assert.Equal(t, "", applied(t, st))
+
+			// A served meta-configuration isn't applied until the
+			// agent registers again.
+			require.NoError(t, st.SetServedMetaConfigurationChecksum(ctx, "agent", "ONE"))
+			assert.Equal(t, "", applied(t, st))
+			register(t, st)
+			assert.Equal(t, "ONE", applied(t, st))
+
+			// Registering again without being served another one
+			// keeps it.
+			register(t, st)
+			assert.Equal(t, "ONE", applied(t, st))
+
+			require.NoError(t, st.SetServedMetaConfigurationChecksum(ctx, "agent", "TWO"))
+			assert.Equal(t, "ONE", applied(t, st))
+			register(t, st)
+			assert.Equal(t, "TWO", applied(t, st))
+		})
+	}
+}
+
+func rotation(agentId, thumbprint string) types.CertificateRotationRequest {
+	return types.CertificateRotationRequest{
+		AgentID: agentId,
+		Body: types.RotateCertificateRequestBody{
+			RotationInformation: types.RotationInformation{
+				CertificateInformation: types.CertificateInformation{
+					Thumbprint: aws.String(thumbprint),
+				},
+			},
+		},
+	}
+}
+
+func TestRegistrationUnmarshalJSON(t *testing.T) {
+	var reg status.Registration
+	require.NoError(t, json.Unmarshal([]byte(`["Web", "Base"]`), &reg))
+	assert.Equal(t, []string{"Web", "Base"}, reg.ConfigurationNames)
+	assert.Nil(t, reg.RegistrationInformation.CertificateInformation.Thumbprint)
+
+	data := `{
+		"ConfigurationNames": ["Web"],
+		"RegistrationInformation": {"CertificateInformation": {"Thumbprint": "ABC"}},
+		"MetaConfigurationChecksum": "META"
+	}`
+	reg = status.Registration{}
+	require.NoError(t, json.Unmarshal([]byte(data), &reg))
+	assert.Equal(t, []string{"Web"}, reg.ConfigurationNames)
+	assert.Equal(t, "ABC", *reg.RegistrationInformation.CertificateInformation.Thumbprint)
+	assert.Equal(t, "META", reg.MetaConfigurationChecksum)
+
+	assert.Error(t, json.Unmarshal([]byte(`"Web"`), &reg))
+}
+
+// Registrations written by older versions of the local NodeStatus are read,
+// and upgraded when the agent's certificate is rotated.
+func TestLegacyRegistrationFile(t *testing.T) {
+	ctx := context.Background()
+	dir, err := ioutil.TempDir("", "dsc-status")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "agent.json"), []byte(`["Web"]`), 0644))
+	st, err := local.New(nil, dir)
+	require.NoError(t, err)
+
+	reg, err := st.GetRegistration(ctx, "agent")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Web"}, reg.ConfigurationNames)
+
+	_, err = st.RotateCertificate(ctx, rotation("agent", "NEW"))
+	require.NoError(t, err)
+	reg, err = st.GetRegistration(ctx, "agent")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"Web"}, reg.ConfigurationNames)
+	assert.Equal(t, "NEW", *reg.RegistrationInformation.CertificateInformation.Thumbprint)
+}
+
+func TestResolveConfigurationNameBackends(t *testing.T) {
+	ctx := context.Background()
+	assignments := memoryassign.New()
+	assignments.AssignNode("web01", "WebServer")
+
+	for name, st := range backends(t, status.WithAssignments(assignments)) {
+		st := st
+		t.Run(name, func(t *testing.T) {
+			_, err := st.ResolveConfigurationName(ctx, "agent", "Requested")
+			assert.IsType(t, types.AgentNotRegisteredError{}, err)
+
+			_, err = st.RegisterDscAgent(ctx, types.RegisterDscAgentRequest{
+				AgentID: "agent",
+				Body: types.RegisterDscAgentRequestBody{
+					AgentInformation:   types.RegisterAgentInformation{NodeName: aws.String("WEB01")},
+					ConfigurationNames: []string{"Requested"},
+				},
+			})
+			require.NoError(t, err)
+
+			resolved, err := st.ResolveConfigurationName(ctx, "agent", "Requested")
+			require.NoError(t, err)
+			assert.Equal(t, "WebServer", resolved)
+		})
+	}
+}
@@ -21,13 +21,15 @@ type NodeStatus struct {
 	bucket *string
 	s3     s3iface.S3API
 	config dsc.ConfigurationRepository
+	opts   status.Options
 }
 
-func New(config dsc.ConfigurationRepository, bucket string, s3 s3iface.S3API) *NodeStatus {
+func New(config dsc.ConfigurationRepository, bucket string, s3 s3iface.S3API, opts ...status.Option) *NodeStatus {
 	return &NodeStatus{
 		bucket: &bucket,
 		s3:     s3,
 		config: config,
+		opts:   status.NewOptions(opts...),
 	}
 }
 
@@ -35,21 +37,20 @@ func (s *NodeStatus) RegisterDscAgent(
 	ctx context.Context,
 	req types.RegisterDscAgentRequest,
 ) (*types.RegisterDscAgentResponse, error) {
-	key := fmt.Sprintf("registrations/%s.json", strings.ToLower(req.AgentID))
+	reg := &status.Registration{RegisterDscAgentRequestBody: req.Body}
 
-	body, err := json.Marshal(&req.Body)
-	if err != nil {
+	// Preserve any state that isn't part of the registration request.
+	existing, err := s.readRegistration(ctx, req.AgentID)
+	switch err.(type) {
+	case nil:
+		reg = existing.Reregister(req.Body)
+	case types.AgentNotRegisteredError:
+		// Nothing to preserve
+	default:
 		return nil, err
 	}
 
-	_, err = s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
-		Bucket:      s.bucket,
-		Key:         aws.String(key),
-		Body:        bytes.NewReader(body),
-		ACL:         aws.String("private"),
-		ContentType: aws.String("application/json"),
-	})
-	if err != nil {
+	if err := s.writeRegistration(ctx, req.AgentID, reg); err != nil {
 		return nil, err
 	}
 
@@ -61,7 +62,95 @@ func (s *NodeStatus) GetDscAction(
 	ctx context.Context,
 	req types.GetDscActionRequest,
 ) (*types.GetDscActionResponse, error) {
-	key := fmt.Sprintf("registrations/%s.json", strings.ToLower(req.AgentID))
+	reg, err := s.readRegistration(ctx, req.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	names, err := s.opts.ConfigurationNames(ctx, req.AgentID, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	return status.ReconcileDscStatus(ctx, s.config, names, &req)
+}
+
+func (s *NodeStatus) ResolveConfigurationName(
+	ctx context.Context,
+	agentId, name string,
+) (string, error) {
+	reg, err := s.readRegistration(ctx, agentId)
+	if err != nil {
+		return "", err
+	}
+
+	return s.opts.ResolveConfigurationName(ctx, agentId, reg, name)
+}
+
+func (s *NodeStatus) RotateCertificate(
+	ctx context.Context,
+	req types.CertificateRotationRequest,
+) (*types.CertificateRotationResponse, error) {
+	// NOTE: S3 doesn't give us a way to do a conditional write, so this
+	// read-modify-write is racy against a concurrent re-registration of the
+	// same agent. Since both requests come from the same client, this is
+	// acceptable.
+	reg, err := s.readRegistration(ctx, req.AgentID)
+	if err != nil {
+		return nil, err
+	}
+
+	// Replace the certificate captured at registration time.
+	reg.RegistrationInformation.CertificateInformation = req.Body.RotationInformation.CertificateInformation
+	if err := s.writeRegistration(ctx, req.AgentID, reg); err != nil {
+		return nil, err
+	}
+
+	return &types.CertificateRotationResponse{}, nil
+}
+
+func (s *NodeStatus) GetRegistration(
+	ctx context.Context,
+	agentId string,
+) (*types.RegisterDscAgentRequestBody, error) {
+	reg, err := s.readRegistration(ctx, agentId)
+	if err != nil {
+		return nil, err
+	}
+	return &reg.RegisterDscAgentRequestBody, nil
+}
+
+func (s *NodeStatus) GetMetaConfigurationChecksum(
+	ctx context.Context,
+	agentId string,
+) (string, error) {
+	reg, err := s.readRegistration(ctx, agentId)
+	if err != nil {
+		return "", err
+	}
+	return reg.MetaConfigurationChecksum, nil
+}
+
+func (s *NodeStatus) SetServedMetaConfigurationChecksum(
+	ctx context.Context,
+	agentId, checksum string,
+) error {
+	// As with RotateCertificate, this read-modify-write isn't atomic.
+	reg, err := s.readRegistration(ctx, agentId)
+	if err != nil {
+		return err
+	}
+
+	reg.ServedMetaConfigurationChecksum = checksum
+	return s.writeRegistration(ctx, agentId, reg)
+}
+
+func registrationKey(agentId string) string {
+	return fmt.Sprintf("registrations/%s.json", strings.ToLower(agentId))
+}
+
+func (s *NodeStatus) readRegistration(ctx context.Context, agentId string) (*status.Registration, error) {
+	key := registrationKey(agentId)
 
 	// Get registration from S3
 	result, err := s.s3.GetObjectWithContext(ctx, &s3.GetObjectInput{
@@ -72,7 +161,7 @@ func (s *NodeStatus) GetDscAction(
 		if aerr, ok := err.(awserr.Error); ok {
 			switch aerr.Code() {
 			case s3.ErrCodeNoSuchKey:
-				return nil, types.AgentNotRegisteredError{req.AgentID}
+				return nil, types.AgentNotRegisteredError{AgentID: agentId}
 			}
 		}
 
@@ -81,11 +170,26 @@ func (s *NodeStatus) GetDscAction(
 	}
 	defer result.Body.Close()
 
-	// Deserialize into body
-	var registerBody types.RegisterDscAgentRequestBody
-	if err := json.NewDecoder(result.Body).Decode(&registerBody); err != nil {
+	// Deserialize into registration
+	var reg status.Registration
+	if err := json.NewDecoder(result.Body).Decode(&reg); err != nil {
 		return nil, err
 	}
+	return &reg, nil
+}
 
-	return status.ReconcileDscStatus(ctx, s.config, registerBody.ConfigurationNames, &req)
+func (s *NodeStatus) writeRegistration(ctx context.Context, agentId string, reg *status.Registration) error {
+	body, err := json.Marshal(reg)
+	if err != nil {
+		return err
+	}
+
+	_, err = s.s3.PutObjectWithContext(ctx, &s3.PutObjectInput{
+		Bucket:      s.bucket,
+		Key:         aws.String(registrationKey(agentId)),
+		Body:        bytes.NewReader(body),
+		ACL:         aws.String("private"),
+		ContentType: aws.String("application/json"),
+	})
+	return err
 }
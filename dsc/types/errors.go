@@ -3,8 +3,26 @@ package types
 import (
 	//"errors"
 	"fmt"
+	"net/http"
 )
 
+// HTTPError is implemented by errors that should be reported to clients with a
+// specific HTTP status code. Backends can return their own errors that
+// implement it; any other error is reported as an internal server error.
+type HTTPError interface {
+	error
+
+	// The HTTP status code of the response.
+	StatusCode() int
+
+	// A short, machine-readable code for the error, e.g.
+	// "ConfigurationNotFound", that is sent in the response body.
+	ErrorCode() string
+}
+
+// ConfigurationNotFoundError is returned when a configuration doesn't exist.
+// It's reported as a 404, except from GetDscAction, where a 404 tells the
+// agent to register again; there it's reported as a server error.
 type ConfigurationNotFoundError struct {
 	AgentID string
 	Name    string
@@ -14,6 +32,9 @@ func (e ConfigurationNotFoundError) Error() string {
 	return fmt.Sprintf("dsc: configuration %q not found", e.Name)
 }
 
+func (e ConfigurationNotFoundError) StatusCode() int   { return http.StatusNotFound }
+func (e ConfigurationNotFoundError) ErrorCode() string { return "ConfigurationNotFound" }
+
 type ModuleNotFoundError struct {
 	AgentID string
 	Name    string
@@ -24,6 +45,41 @@ func (e ModuleNotFoundError) Error() string {
 	return fmt.Sprintf("dsc: module %q (version: %q) not found", e.Name, e.Version)
 }
 
+func (e ModuleNotFoundError) StatusCode() int   { return http.StatusNotFound }
+func (e ModuleNotFoundError) ErrorCode() string { return "ModuleNotFound" }
+
+// InvalidModuleError is returned when a module exists, but isn't a valid
+// module with the requested name and version; serving it would only cause it
+// to fail to install on the node.
+type InvalidModuleError struct {
+	AgentID string
+	Name    string
+	Version string
+	Reason  string
+}
+
+func (e InvalidModuleError) Error() string {
+	return fmt.Sprintf("dsc: module %q (version: %q) is invalid: %s", e.Name, e.Version, e.Reason)
+}
+
+func (e InvalidModuleError) StatusCode() int   { return http.StatusInternalServerError }
+func (e InvalidModuleError) ErrorCode() string { return "InvalidModule" }
+
+// ChecksumMismatchError is returned when a file's content doesn't match the
+// checksum that was recorded for it, e.g. in a ".checksum" file.
+type ChecksumMismatchError struct {
+	Path     string
+	Expected string
+	Actual   string
+}
+
+func (e ChecksumMismatchError) Error() string {
+	return fmt.Sprintf("dsc: %q has checksum %q, expected %q", e.Path, e.Actual, e.Expected)
+}
+
+func (e ChecksumMismatchError) StatusCode() int   { return http.StatusInternalServerError }
+func (e ChecksumMismatchError) ErrorCode() string { return "ChecksumMismatch" }
+
 type ReportNotFoundError struct {
 	AgentID string
 	JobID   string
@@ -33,6 +89,9 @@ func (e ReportNotFoundError) Error() string {
 	return fmt.Sprintf("dsc: job %q for agent %q not found", e.JobID, e.AgentID)
 }
 
+func (e ReportNotFoundError) StatusCode() int   { return http.StatusNotFound }
+func (e ReportNotFoundError) ErrorCode() string { return "ReportNotFound" }
+
 type AgentNotRegisteredError struct {
 	AgentID string
 }
@@ -40,3 +99,36 @@ type AgentNotRegisteredError struct {
 func (e AgentNotRegisteredError) Error() string {
 	return fmt.Sprintf("dsc: agent %q not registered", e.AgentID)
 }
+
+// The LCM sends a new RegisterDscAgent request in response to a 404, but just
+// retries (and fails) on any other client error.
+func (e AgentNotRegisteredError) StatusCode() int   { return http.StatusNotFound }
+func (e AgentNotRegisteredError) ErrorCode() string { return "AgentNotRegistered" }
+
+type ConfigurationNotAllowedError struct {
+	AgentID string
+	KeyID   string
+	Name    string
+}
+
+func (e ConfigurationNotAllowedError) Error() string {
+	return fmt.Sprintf("dsc: configuration %q not allowed for registration key %q", e.Name, e.KeyID)
+}
+
+func (e ConfigurationNotAllowedError) StatusCode() int   { return http.StatusForbidden }
+func (e ConfigurationNotAllowedError) ErrorCode() string { return "ConfigurationNotAllowed" }
+
+type ClientCertificateMismatchError struct {
+	AgentID    string
+	Thumbprint string
+}
+
+func (e ClientCertificateMismatchError) Error() string {
+	if e.Thumbprint == "" {
+		return fmt.Sprintf("dsc: no client certificate presented for agent %q", e.AgentID)
+	}
+	return fmt.Sprintf("dsc: client certificate %q does not match agent %q", e.Thumbprint, e.AgentID)
+}
+
+func (e ClientCertificateMismatchError) StatusCode() int   { return http.StatusUnauthorized }
+func (e ClientCertificateMismatchError) ErrorCode() string { return "ClientCertificateMismatch" }
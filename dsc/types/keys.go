@@ -0,0 +1,60 @@
+package types
+
+import (
+	"strings"
+	"time"
+)
+
+// RegistrationKey is a shared secret that clients use to sign their
+// requests, along with the metadata that scopes what it can be used for.
+type RegistrationKey struct {
+	// ID identifies the key in logs and server-side assignments, without
+	// revealing the key itself.
+	ID string `json:"ID"`
+
+	// Description is a human-readable description of the key, e.g. the
+	// team that it was issued to.
+	Description string `json:"Description,omitempty"`
+
+	// Key is the shared secret that the client signs its requests with.
+	Key string `json:"Key"`
+
+	// AllowedConfigurations is the list of configuration names that
+	// clients using this key may register with or fetch. If it is empty,
+	// any configuration is allowed.
+	AllowedConfigurations []string `json:"AllowedConfigurations,omitempty"`
+
+	// Expires is the time after which this key can no longer be used. If
+	// it is the zero time, the key doesn't expire.
+	Expires time.Time `json:"Expires"`
+
+	// Revoked marks a key that can no longer be used.
+	Revoked bool `json:"Revoked,omitempty"`
+}
+
+// Usable returns whether the key can be used at the given time.
+func (k *RegistrationKey) Usable(now time.Time) bool {
+	if k.Revoked {
+		return false
+	}
+	if !k.Expires.IsZero() && now.After(k.Expires) {
+		return false
+	}
+	return true
+}
+
+// AllowsConfiguration returns whether clients using this key may register
+// with or fetch the named configuration. Configuration names are compared
+// case-insensitively.
+func (k *RegistrationKey) AllowsConfiguration(name string) bool {
+	if len(k.AllowedConfigurations) == 0 {
+		return true
+	}
+
+	for _, allowed := range k.AllowedConfigurations {
+		if strings.EqualFold(allowed, name) {
+			return true
+		}
+	}
+	return false
+}
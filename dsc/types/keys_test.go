@@ -0,0 +1,43 @@
+package types
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRegistrationKeyUsable(t *testing.T) {
+	now := time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC)
+
+	testCases := []struct {
+		Name     string
+		Key      RegistrationKey
+		Expected bool
+	}{
+		{"no expiry", RegistrationKey{}, true},
+		{"not yet expired", RegistrationKey{Expires: now.Add(time.Second)}, true},
+		{"expires now", RegistrationKey{Expires: now}, true},
+		{"expired", RegistrationKey{Expires: now.Add(-time.Second)}, false},
+		{"revoked", RegistrationKey{Revoked: true}, false},
+		{"revoked before expiry", RegistrationKey{Revoked: true, Expires: now.Add(time.Hour)}, false},
+	}
+
+	for _, tc := range testCases {
+		assert.Equal(t, tc.Expected, tc.Key.Usable(now), "test case %q", tc.Name)
+	}
+}
+
+func TestRegistrationKeyAllowsConfiguration(t *testing.T) {
+	var unscoped RegistrationKey
+	assert.True(t, unscoped.AllowsConfiguration("WebServer"))
+	assert.True(t, unscoped.AllowsConfiguration(""))
+
+	scoped := RegistrationKey{AllowedConfigurations: []string{"WebServer", "Base"}}
+	assert.True(t, scoped.AllowsConfiguration("WebServer"))
+	assert.True(t, scoped.AllowsConfiguration("webserver"))
+	assert.True(t, scoped.AllowsConfiguration("BASE"))
+	assert.False(t, scoped.AllowsConfiguration("Database"))
+	assert.False(t, scoped.AllowsConfiguration("WebServer2"))
+	assert.False(t, scoped.AllowsConfiguration(""))
+}
@@ -1,6 +1,7 @@
 package types
 
 import (
+	"encoding/json"
 	"fmt"
 	"net"
 	"strings"
@@ -167,3 +168,29 @@ func (r SendReportRequestBody) Normalized() (*SendReportRequestBodyNorm, error)
 
 	return ret, nil
 }
+
+// 3.3.5.1.1.1: The GetAction request body for protocol versions 1.0 and 1.1
+// is used by the client to transfer the following data fields:
+type GetActionV1RequestBody struct {
+	// Checksum: The checksum of the configuration currently applied on
+	// the client.
+	Checksum string `json:"Checksum,omitempty"`
+
+	// ChecksumAlgorithm: The algorithm used to calculate Checksum.
+	ChecksumAlgorithm string `json:"ChecksumAlgorithm,omitempty"`
+
+	// NodeCompliant: Whether the client is in the desired state. Clients
+	// send this as either a JSON boolean or a string, so it's kept as-is.
+	NodeCompliant json.RawMessage `json:"NodeCompliant,omitempty"`
+
+	// StatusCode: The status code of the last consistency check on the
+	// client.
+	StatusCode int `json:"StatusCode,omitempty"`
+}
+
+// 3.3.5.1.1.2: The GetAction response body for protocol versions 1.0 and 1.1
+// contains a single value, which MUST be either GetConfiguration, Retry, or
+// OK.
+type GetActionV1ResponseBody struct {
+	Value string `json:"value"`
+}
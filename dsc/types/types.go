@@ -28,6 +28,10 @@ type GetConfigurationResponse struct {
 	// as specified in section 2.2.2.3.
 	ChecksumAlgorithm string
 
+	// Optionally, a description of where the content came from (e.g. a
+	// commit ID), which is logged by the server.
+	Source string
+
 	//ConfigurationName string
 }
 
@@ -38,6 +42,22 @@ type GetModuleRequest struct {
 	Version string
 }
 
+// ListModuleVersionsRequest asks for the available versions of a module, in
+// order to serve a GetModule request with an empty ModuleVersion.
+type ListModuleVersionsRequest struct {
+	AgentID string
+	Name    string
+}
+
+// ListModuleVersionsResponse is a response to a ListModuleVersionsRequest
+type ListModuleVersionsResponse struct {
+	// All available versions of the module.
+	Versions []string
+
+	// Optionally, the version to serve instead of the latest one.
+	Default string
+}
+
 // GetModuleResponse is a response to a GetModuleRequest
 type GetModuleResponse struct {
 	// 3.7.5.1.1.2: ModuleData represents a BLOB.
@@ -54,6 +74,10 @@ type GetModuleResponse struct {
 	// The server MUST send the ChecksumAlgorithm in the response headers
 	// as specified in section 2.2.2.3.
 	ChecksumAlgorithm string
+
+	// Optionally, a description of where the content came from (e.g. a
+	// commit ID), which is logged by the server.
+	Source string
 }
 
 // 3.8: The GetDscAction request SHOULD<12> get the action, as specified in
@@ -115,3 +139,44 @@ type CertificateRotationRequest struct {
 
 // 3.12.5.1.1.2 ResponseBody: None.
 type CertificateRotationResponse struct{}
+
+// GetAssignmentRequest describes a node whose server-side configuration
+// assignment should be looked up.
+type GetAssignmentRequest struct {
+	AgentID  string
+	NodeName string
+
+	// The ID of the registration key that the node signed its request
+	// with, if known.
+	RegistrationKeyID string
+}
+
+// GetAssignmentResponse is a response to a GetAssignmentRequest
+type GetAssignmentResponse struct {
+	ConfigurationNames []string
+}
+
+// MetaConfigurationName is the configuration name under which a client
+// fetches its meta-configuration after being told to UpdateMetaConfiguration.
+// Agents that registered with a configuration of this name are never told to
+// UpdateMetaConfiguration, so that configuration is still served to them.
+const MetaConfigurationName = "MetaConfiguration"
+
+// GetMetaConfigurationRequest is a request for the meta-configuration that an
+// agent should have applied.
+type GetMetaConfigurationRequest struct {
+	AgentID string
+}
+
+// GetMetaConfigurationResponse is a response to a GetMetaConfigurationRequest
+type GetMetaConfigurationResponse struct {
+	// The meta-configuration MOF document.
+	Content io.Reader
+
+	// The checksum of the content, in the same format as the checksum of
+	// a configuration.
+	Checksum string
+
+	// The algorithm used to calculate the checksum.
+	ChecksumAlgorithm string
+}